@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/request"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/response"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// apiKeyPrefix marks secret API keys, so they are easy to recognise, e.g. by secret scanners
+const apiKeyPrefix = "ssk_"
+
+// minBootstrapAPIKeyLength is the minimum length of an API key configured with BOOTSTRAP_API_KEY
+const minBootstrapAPIKeyLength = 32
+
+// ErrInvalidAPIKeyRequest is returned when the scopes or attributes of a new API key are invalid
+var ErrInvalidAPIKeyRequest = domain.NewError(domain.KindInvalid, "invalid_api_key_request", "invalid api key request")
+
+// ErrUnauthenticated is returned for missing, unknown or revoked credentials
+var ErrUnauthenticated = domain.NewError(domain.KindUnauthenticated, "unauthenticated", "missing or invalid credentials")
+
+// ErrOperatorRequired is returned when a caller who is not an operator manages the API keys of another
+// tenant or creates an operator key
+var ErrOperatorRequired = domain.NewError(domain.KindForbidden, "operator_required", "only operators may manage the api keys of other tenants")
+
+// APIKeyService implements the API key service
+type APIKeyService struct {
+	store persistence.DeviceRepository
+}
+
+// NewAPIKeyService function to create a new API key service
+func NewAPIKeyService(store persistence.DeviceRepository) APIKeyServiceInterface {
+	return &APIKeyService{store: store}
+}
+
+// hashAPIKey returns the hash an API key is stored and looked up by. The keys are random, so a fast
+// hash is sufficient; a slow password hash would only slow down every request.
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// toAPIKeyResponse maps an API key to its API response
+func toAPIKeyResponse(key *domain.APIKey) *response.APIKeyResponse {
+	scopes := make([]string, 0, len(key.GetScopes()))
+	for _, scope := range key.GetScopes() {
+		scopes = append(scopes, string(scope))
+	}
+
+	var revokedAt *time.Time
+	if key.IsRevoked() {
+		at := key.GetRevokedAt()
+		revokedAt = &at
+	}
+
+	return &response.APIKeyResponse{
+		ID:        key.GetID(),
+		TenantID:  key.GetTenantID(),
+		ClientID:  key.GetClientID(),
+		Name:      key.GetName(),
+		Scopes:    scopes,
+		CreatedAt: key.GetCreatedAt(),
+		RevokedAt: revokedAt,
+	}
+}
+
+// CreateAPIKey creates an API key with a random secret; only the hash of the secret is stored
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, req *request.APIKeyRequest) (*response.CreatedAPIKeyResponse, error) {
+	if len(req.Scopes) == 0 {
+		return nil, invalidField(ErrInvalidAPIKeyRequest, "scopes", "must contain at least one scope")
+	}
+	scopes, err := domain.ParseScopes(req.Scopes)
+	if err != nil {
+		return nil, domain.NewValidationError(fmt.Errorf("%w: %w", ErrInvalidAPIKeyRequest, err), domain.FieldError{Field: "scopes", Message: err.Error()})
+	}
+	if len(req.Name) > maxLabelLength {
+		return nil, invalidField(ErrInvalidAPIKeyRequest, "name", fmt.Sprintf("must not be longer than %d characters", maxLabelLength))
+	}
+	if len(req.ClientID) > maxLabelLength {
+		return nil, invalidField(ErrInvalidAPIKeyRequest, "clientId", fmt.Sprintf("must not be longer than %d characters", maxLabelLength))
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, fmt.Errorf("api key ID generation failed: %w", err)
+	}
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("api key generation failed: %w", err)
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	clientID := req.ClientID
+	if clientID == "" {
+		clientID = id.String()
+	}
+
+	apiKey := domain.NewAPIKey(id.String(), tenantOrDefault(req.TenantID), clientID, req.Name, hashAPIKey(key), scopes, time.Now().UTC())
+	if err = s.store.CreateAPIKey(ctx, apiKey); err != nil {
+		return nil, fmt.Errorf("failed to add api key: %w", err)
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "api key created", slog.Any("key", apiKey))
+
+	return &response.CreatedAPIKeyResponse{
+		APIKeyResponse: *toAPIKeyResponse(apiKey),
+		Key:            key,
+	}, nil
+}
+
+// ListAPIKeys retrieves the API keys of a tenant, including revoked ones
+func (s *APIKeyService) ListAPIKeys(ctx context.Context, tenantID string) ([]*response.APIKeyResponse, error) {
+	keys, err := s.store.ListAPIKeys(ctx, tenantOrDefault(tenantID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+
+	keyResponses := make([]*response.APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		keyResponses = append(keyResponses, toAPIKeyResponse(key))
+	}
+	return keyResponses, nil
+}
+
+// RevokeAPIKey revokes an API key of a tenant; revoking it again has no effect. Keys of other tenants
+// are not found.
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, tenantID, keyID string) (*response.APIKeyResponse, error) {
+	tenantID = tenantOrDefault(tenantID)
+	if err := s.store.RevokeAPIKey(ctx, tenantID, keyID, time.Now().UTC()); err != nil {
+		if errors.Is(err, domain.ErrAPIKeyNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to revoke api key: %w", err)
+	}
+
+	key, err := s.store.GetAPIKey(ctx, tenantID, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revoked api key: %w", err)
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "api key revoked", slog.Any("key", key))
+	return toAPIKeyResponse(key), nil
+}
+
+// AuthenticateAPIKey resolves a secret API key to the identity of its client
+func (s *APIKeyService) AuthenticateAPIKey(ctx context.Context, key string) (*Identity, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, ErrUnauthenticated
+	}
+
+	apiKey, err := s.store.GetAPIKeyByHash(ctx, hashAPIKey(key))
+	if errors.Is(err, domain.ErrAPIKeyNotFound) {
+		return nil, ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up api key: %w", err)
+	}
+	if apiKey.IsRevoked() {
+		return nil, ErrUnauthenticated
+	}
+
+	return &Identity{
+		TenantID: apiKey.GetTenantID(),
+		ClientID: apiKey.GetClientID(),
+		Scopes:   apiKey.GetScopes(),
+		Operator: isOperatorKey(apiKey.GetTenantID(), apiKey.GetScopes()),
+	}, nil
+}
+
+// isOperatorKey reports whether an API key of a tenant with the given scopes belongs to an operator: admin
+// keys of the default tenant, like the bootstrap key, manage the API keys of all tenants. Admin keys of
+// other tenants, JWTs and client certificates only manage the keys of their own tenant.
+func isOperatorKey(tenantID string, scopes []domain.Scope) bool {
+	return tenantOrDefault(tenantID) == domain.DefaultTenantID && slices.Contains(scopes, domain.ScopeAdmin)
+}
+
+// BootstrapAPIKey registers an operator-chosen admin key for the default tenant unless it already exists,
+// so the first API keys can be created through the API
+func BootstrapAPIKey(ctx context.Context, store persistence.DeviceRepository, key string) error {
+	if !strings.HasPrefix(key, apiKeyPrefix) || len(key) < minBootstrapAPIKeyLength {
+		return fmt.Errorf("api keys must start with %q and be at least %d characters long", apiKeyPrefix, minBootstrapAPIKeyLength)
+	}
+	if _, err := store.GetAPIKeyByHash(ctx, hashAPIKey(key)); err == nil {
+		return nil
+	}
+
+	scopes := []domain.Scope{domain.ScopeAdmin, domain.ScopeDevicesRead, domain.ScopeDevicesWrite, domain.ScopeSign}
+	return store.CreateAPIKey(ctx, domain.NewAPIKey(uuid.NewString(), domain.DefaultTenantID, "bootstrap", "bootstrap", hashAPIKey(key), scopes, time.Now().UTC()))
+}
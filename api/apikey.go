@@ -0,0 +1,152 @@
+package api
+
+import (
+	"fmt"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/request"
+	"net/http"
+)
+
+// CreateAPIKeyHandler API handler for creating an API key
+// @Summary Create an API key
+// @Description Create an API key for a tenant with the given scopes (devices:read, devices:write, sign, admin).
+// @Description The secret key is only returned in this response; only its hash is stored.
+// @Tags api-keys
+// @Accept json
+// @Produce json
+// @Param key body APIKeyRequest true "API key attributes"
+// @Param Authorization header string true "Bearer API key with the admin scope"
+// @Success 201 {object} CreatedAPIKeyResponse "Successful response"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 403 {object} ErrorResponse "Missing admin scope, or keys of another tenant without an operator key"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v0/api-keys [post]
+func (s *Server) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	// Ensure the request method is POST
+	if r.Method != http.MethodPost {
+		WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req request.APIKeyRequest
+	// Decode the incoming request body into req
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	// Keys are created for the tenant of the caller unless an operator names another one
+	if err := authorizeNewAPIKey(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	// Create the key using the API key service
+	keyResponse, err := s.apiKeyService.CreateAPIKey(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	// Set the response header and encode the response to JSON
+	WriteAPIResponse(w, http.StatusCreated, keyResponse)
+}
+
+// ListAPIKeysHandler API handler for listing the API keys of a tenant
+// @Summary List API keys
+// @Description Retrieve the API keys of a tenant, including revoked ones. Secret keys are never returned.
+// @Tags api-keys
+// @Produce json
+// @Param tenantId query string false "Tenant whose keys are listed, the tenant of the caller by default"
+// @Param Authorization header string true "Bearer API key with the admin scope"
+// @Success 200 {array} APIKeyResponse "Successful response"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 403 {object} ErrorResponse "Missing admin scope, or keys of another tenant without an operator key"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v0/api-keys [get]
+func (s *Server) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	// Ensure the request method is GET
+	if r.Method != http.MethodGet {
+		WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	tenantID, err := apiKeyTenant(r, r.URL.Query().Get("tenantId"))
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	// Retrieve the keys using the API key service
+	keyResponses, err := s.apiKeyService.ListAPIKeys(r.Context(), tenantID)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	// Set the response header and encode the response to JSON
+	WriteAPIResponse(w, http.StatusOK, keyResponses)
+}
+
+// RevokeAPIKeyHandler API handler for revoking an API key
+// @Summary Revoke an API key
+// @Description Revoke an API key, so it can no longer be used. Revoking a key again has no effect.
+// @Tags api-keys
+// @Produce json
+// @Param id path string true "API key ID"
+// @Param tenantId query string false "Tenant of the key, the tenant of the caller by default"
+// @Param Authorization header string true "Bearer API key with the admin scope"
+// @Success 200 {object} APIKeyResponse "Successful response"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 403 {object} ErrorResponse "Missing admin scope, or keys of another tenant without an operator key"
+// @Failure 404 {object} ErrorResponse "API key not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v0/api-keys/{id} [delete]
+func (s *Server) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	// Ensure the request method is DELETE
+	if r.Method != http.MethodDelete {
+		WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	tenantID, err := apiKeyTenant(r, r.URL.Query().Get("tenantId"))
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	// Revoke the key using the API key service
+	keyResponse, err := s.apiKeyService.RevokeAPIKey(r.Context(), tenantID, r.PathValue("id"))
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	// Set the response header and encode the response to JSON
+	WriteAPIResponse(w, http.StatusOK, keyResponse)
+}
+
+// apiKeyTenant returns the tenant whose API keys a request manages: the requested one, or the tenant of the
+// caller if none is requested. Only operators manage the keys of other tenants, so an admin of one tenant
+// can't issue itself keys for the devices of another.
+func apiKeyTenant(r *http.Request, requested string) (string, error) {
+	tenantID := tenantOrDefault(tenantFromRequest(r))
+	if requested == "" || requested == tenantID {
+		return tenantID, nil
+	}
+	if identity := identityFromRequest(r); identity == nil || !identity.Operator {
+		return "", ErrOperatorRequired
+	}
+	return requested, nil
+}
+
+// authorizeNewAPIKey sets the tenant of a new API key and checks that the caller may create it. Keys that
+// would be operators can only be created by operators.
+func authorizeNewAPIKey(r *http.Request, req *request.APIKeyRequest) error {
+	tenantID, err := apiKeyTenant(r, req.TenantID)
+	if err != nil {
+		return err
+	}
+	req.TenantID = tenantID
+	// Unknown scopes are rejected by the API key service
+	scopes, _ := domain.ParseScopes(req.Scopes)
+	if identity := identityFromRequest(r); isOperatorKey(tenantID, scopes) && (identity == nil || !identity.Operator) {
+		return fmt.Errorf("%w: admin keys of the %s tenant are operator keys", ErrOperatorRequired, domain.DefaultTenantID)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	// TenantID is the tenant the caller acts for
+	TenantID string
+	// ClientID identifies the caller, e.g. in the allowed clients of device policies
+	ClientID string
+	// Scopes are the permissions granted to the caller
+	Scopes []domain.Scope
+	// Operator callers manage the API keys of all tenants; only admin API keys of the default tenant are operators
+	Operator bool
+	// Certificate is set for callers authenticated by client certificate, which only sign with the devices bound to them
+	Certificate bool
+}
+
+// HasScope reports whether the caller was granted the given scope
+func (identity *Identity) HasScope(scope domain.Scope) bool {
+	return slices.Contains(identity.Scopes, scope)
+}
+
+// Authenticator derives the identity of the caller from the credentials of a request
+type Authenticator interface {
+	// Authenticate returns ErrUnauthenticated if the request carries no valid credentials, and other errors
+	// if the credentials could not be checked, e.g. because the key store is unavailable
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// APIKeyAuthenticator authenticates requests by the API key in the Authorization (Bearer) or X-API-Key header
+type APIKeyAuthenticator struct {
+	keys APIKeyServiceInterface
+}
+
+// NewAPIKeyAuthenticator creates an authenticator checking API keys with the given service
+func NewAPIKeyAuthenticator(keys APIKeyServiceInterface) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{keys: keys}
+}
+
+// Authenticate resolves the API key of a request to the identity of its client
+func (authenticator *APIKeyAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	key := r.Header.Get("X-API-Key")
+	if scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " "); found && strings.EqualFold(scheme, "Bearer") {
+		key = token
+	}
+	if key == "" {
+		return nil, ErrUnauthenticated
+	}
+	return authenticator.keys.AuthenticateAPIKey(r.Context(), key)
+}
+
+// identityKey is the context key of the Identity of a request
+type identityKey struct{}
+
+// RequireScope wraps a handler so it is only called for authenticated requests granted the given scope.
+// The identity of the caller is passed on in the request context.
+func RequireScope(authenticator Authenticator, scope domain.Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := authenticator.Authenticate(r)
+		if errors.Is(err, ErrUnauthenticated) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="signing-service"`)
+			WriteError(w, r, ErrUnauthenticated)
+			return
+		}
+		if err != nil {
+			// Valid credentials may be behind the failure, so the client is not told they are wrong
+			WriteError(w, r, fmt.Errorf("failed to authenticate request: %w", err))
+			return
+		}
+		if !identity.HasScope(scope) {
+			WriteError(w, r, fmt.Errorf("%w %s", ErrMissingScope, scope))
+			return
+		}
+		r = withIdentityLogging(r, identity)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
+	})
+}
+
+// identityFromRequest returns the identity RequireScope authenticated, or nil for handlers called without it
+func identityFromRequest(r *http.Request) *Identity {
+	identity, _ := r.Context().Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// tenantFromRequest returns the tenant of the caller. Requests without an identity act for the default
+// tenant, which holds all devices created before tenants were introduced.
+func tenantFromRequest(r *http.Request) string {
+	if identity := identityFromRequest(r); identity != nil {
+		return identity.TenantID
+	}
+	return ""
+}
+
+// clientFromRequest returns the identity of the client making the request, empty if unknown
+func clientFromRequest(r *http.Request) string {
+	if identity := identityFromRequest(r); identity != nil {
+		return identity.ClientID
+	}
+	return ""
+}
+
+// certificateFromRequest reports whether the caller authenticated by client certificate
+func certificateFromRequest(r *http.Request) bool {
+	if identity := identityFromRequest(r); identity != nil {
+		return identity.Certificate
+	}
+	return false
+}
+
+// ChainAuthenticator tries several authenticators in turn, e.g. API keys and JWTs
+type ChainAuthenticator struct {
+	authenticators []Authenticator
+}
+
+// NewChainAuthenticator creates an authenticator accepting the credentials any of the given ones accepts
+func NewChainAuthenticator(authenticators ...Authenticator) *ChainAuthenticator {
+	return &ChainAuthenticator{authenticators: authenticators}
+}
+
+// Authenticate returns the identity of the first authenticator accepting the request. If none does and one
+// of them failed to check the credentials, its error is returned instead of ErrUnauthenticated.
+func (chain *ChainAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	var failure error
+	for _, authenticator := range chain.authenticators {
+		identity, err := authenticator.Authenticate(r)
+		if err == nil {
+			return identity, nil
+		}
+		if failure == nil && !errors.Is(err, ErrUnauthenticated) {
+			failure = err
+		}
+	}
+	if failure != nil {
+		return nil, failure
+	}
+	return nil, ErrUnauthenticated
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/signeddata"
+	"net/http"
+)
+
+// CanonicalizeHandler API handler returning the canonical (RFC 8785) form of a JSON payload
+// @Summary Canonicalize a JSON payload
+// @Description Returns the exact canonical bytes (JSON Canonicalization Scheme, RFC 8785) that are signed for a JSON payload,
+// @Description so verifiers can reproduce the signed data.
+// @Tags transactions
+// @Accept json
+// @Produce json
+// @Param payload body object true "JSON payload"
+// @Success 200 {object} object "Canonical JSON"
+// @Failure 400 {object} ErrorResponse "Invalid JSON"
+// @Router /api/v0/canonicalize [post]
+func (s *Server) CanonicalizeHandler(w http.ResponseWriter, r *http.Request) {
+	// Ensure the request method is POST
+	if r.Method != http.MethodPost {
+		WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	canonical, err := signeddata.CanonicalizeJSON(body)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	// The canonical bytes are written as they are, without the response envelope
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(canonical)
+}
@@ -1,65 +1,27 @@
 package api
 
 import (
-	"encoding/json"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/request"
-	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
-	"github.com/joho/godotenv"
-	"log"
 	"net/http"
-	"os"
-	"regexp"
+	"strconv"
+	"time"
 )
 
-// The store variable for interacting with the data layer (DeviceRepositoryInterface)
-var store persistence.DeviceRepository
-
-// Create deviceService to use the device service
-var deviceService DeviceServiceInterface
-
-// Initialize the repository and service
-func init() {
-	// Regex to match the current working directory
-	re := regexp.MustCompile(`^(.*` + "signing-service-challenge-go" + `)`)
-	cwd, _ := os.Getwd()
-	rootPath := re.Find([]byte(cwd))
-
-	// Load environment variables from .env file
-	if err := godotenv.Load(string(rootPath) + `/.env`); err != nil {
-		log.Fatalf("Error loading .env file")
-	}
-
-	// Get the DATA_STORE environment variable
-	dataStore := os.Getenv("DATA_STORE")
-
-	var err error
-	// Initialize the store based on the DATA_STORE value
-	switch dataStore {
-	case "db":
-		dataSourceName := "devices.db"
-		store, err = persistence.NewSQLiteDeviceRepository(dataSourceName)
-		if err != nil {
-			log.Fatalf("failed to create SQLite repository: %v", err)
-		}
-	case "memory":
-		store = persistence.NewInMemoryDeviceRepository()
-	default:
-		log.Fatalf("Invalid DATA_STORE value: %v. Use 'memory' or 'db'", dataStore)
-	}
-
-	// Initialize the device service with the store
-	deviceService = NewDeviceService(store)
-}
-
 // CreateSignatureDeviceHandler API handler for creating a signature device
 // @Summary Create a new signature device
-// @Description Create a new signature device with a specified ID, label, and algorithm
+// @Description Create a new signature device with a label, algorithm and optional signed-data format (v1 or v2).
+// @Description The ID is optional; without one the service generates a UUID.
+// @Description An initial signing policy may be attached; it is audited with the client ID of the caller.
 // @Tags devices
 // @Accept json
 // @Produce json
 // @Param device body DeviceRequest true "Device information"
+// @Param Authorization header string true "Bearer API key"
 // @Success 200 {object} DeviceResponse "Successful response"
 // @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 403 {object} ErrorResponse "Client certificate callers can't bind devices"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /api/v0/create-signature-device [post]
 func (s *Server) CreateSignatureDeviceHandler(w http.ResponseWriter, r *http.Request) {
@@ -71,23 +33,17 @@ func (s *Server) CreateSignatureDeviceHandler(w http.ResponseWriter, r *http.Req
 
 	var req request.DeviceRequest
 	// Decode the incoming request body into req
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+	if !decodeRequest(w, r, &req) {
 		return
 	}
+	req.ClientID = clientFromRequest(r)
+	req.TenantID = tenantFromRequest(r)
+	req.ClientCertificate = certificateFromRequest(r)
 	// Create the signature device using the device service
-	deviceResponse, err := deviceService.CreateSignatureDevice(&req)
+	deviceResponse, err := s.deviceService.CreateSignatureDevice(r.Context(), &req)
 	if err != nil {
-		if err.Error() == "device with this ID already exists" {
-			WriteErrorResponse(w, http.StatusConflict, err.Error())
-			return
-		} else if err.Error() == "invalid algorithm" {
-			WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
-			return
-		} else {
-			WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
-			return
-		}
+		WriteError(w, r, err)
+		return
 	}
 	// Set the response header and encode the response to JSON
 	WriteAPIResponse(w, http.StatusOK, deviceResponse)
@@ -95,14 +51,24 @@ func (s *Server) CreateSignatureDeviceHandler(w http.ResponseWriter, r *http.Req
 
 // SignTransactionHandler API handler for signing a transaction
 // @Summary Sign a transaction
-// @Description Sign the transaction data with the specified device
+// @Description Sign the transaction data with the specified device. Data may be sent as utf8, base64 or hex
+// @Description (dataEncoding) and either as raw data or as a pre-computed SHA-256 digest (mode).
+// @Description Alternatively a JSON object can be sent as payload, which is signed in its canonical (RFC 8785) form.
 // @Tags transactions
 // @Accept json
 // @Produce json
 // @Param transaction body SignTransactionRequest true "Transaction data"
+// @Param Idempotency-Key header string false "Key making retries return the original signature"
+// @Param Authorization header string true "Bearer API key"
 // @Success 200 {object} SignTransactionResponse "Successful response"
 // @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 403 {object} ErrorResponse "Device outside its validity window, signature quota exhausted, policy violated or not bound to the client certificate"
 // @Failure 404 {object} ErrorResponse "Device not found"
+// @Failure 409 {object} ErrorResponse "Device is not active"
+// @Failure 410 {object} ErrorResponse "Device has been deleted"
+// @Failure 422 {object} ErrorResponse "Idempotency key reused with a different request"
+// @Failure 429 {object} ErrorResponse "Daily signature quota exhausted or rate limit exceeded, see the Retry-After header"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /api/v0/sign-transaction [post]
 func (s *Server) SignTransactionHandler(w http.ResponseWriter, r *http.Request) {
@@ -114,32 +80,50 @@ func (s *Server) SignTransactionHandler(w http.ResponseWriter, r *http.Request)
 
 	var req request.SignTransactionRequest
 	// Decode the incoming request body into req
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+	if !decodeRequest(w, r, &req) {
 		return
 	}
+	// Retries carrying the same Idempotency-Key return the original signature
+	req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	req.ClientID = clientFromRequest(r)
+	req.TenantID = tenantFromRequest(r)
+	req.ClientCertificate = certificateFromRequest(r)
 	// Sign the transaction using the device service
-	signResponse, err := deviceService.SignTransaction(&req)
+	signResponse, err := s.deviceService.SignTransaction(r.Context(), &req)
 	if err != nil {
-		if err.Error() == "device not found" {
-			WriteErrorResponse(w, http.StatusNotFound, err.Error())
-			return
-		} else {
-			WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
-			return
-		}
+		WriteError(w, r, err)
+		return
+	}
+	if signResponse.Replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
 	}
 	// Set the response header and encode the response to JSON
 	WriteAPIResponse(w, http.StatusOK, signResponse)
 }
 
 // ListSignatureDevicesHandler API handler for listing signature devices
-// @Summary List all signature devices
-// @Description Retrieve a list of all signature devices
+// @Summary List signature devices
+// @Description Retrieve a page of signature devices, filtered and sorted by the query parameters. The cursor for the
+// @Description next page is returned in the X-Next-Cursor header. Deleted devices are only listed with includeDeleted=true.
 // @Tags devices
 // @Accept json
 // @Produce json
+// @Param algorithm query string false "Only list devices using this algorithm (RSA or ECC)"
+// @Param labelPrefix query string false "Only list devices whose label starts with this prefix"
+// @Param status query string false "Only list devices in this lifecycle state"
+// @Param createdFrom query string false "Only list devices created at or after this time (RFC 3339)"
+// @Param createdTo query string false "Only list devices created before this time (RFC 3339)"
+// @Param includeDeleted query bool false "Also list the tombstones of deleted devices"
+// @Param sort query string false "Sort field: id, label or createdAt (default)"
+// @Param order query string false "Sort order: asc (default) or desc"
+// @Param cursor query string false "Cursor from the X-Next-Cursor header of the previous page"
+// @Param limit query int false "Page size, 100 by default and at most 1000"
+// @Param Authorization header string true "Bearer API key"
 // @Success 200 {array} DeviceResponse "Successful response"
+// @Header 200 {string} X-Next-Cursor "Cursor for the next page, absent on the last page"
+// @Failure 400 {object} ErrorResponse "Invalid query parameter"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /api/v0/devices [get]
 func (s *Server) ListSignatureDevicesHandler(w http.ResponseWriter, r *http.Request) {
@@ -148,14 +132,60 @@ func (s *Server) ListSignatureDevicesHandler(w http.ResponseWriter, r *http.Requ
 		WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
-	// Retrieve the list of devices from the device service
-	devices, err := deviceService.ListSignatureDevices()
+	// Read the filters, sort order and cursor from the query parameters
+	req, err := parseListDevicesRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	// Retrieve the page of devices from the device service
+	page, err := s.deviceService.QuerySignatureDevices(r.Context(), req)
 	if err != nil {
-		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		WriteError(w, r, err)
 		return
 	}
+	if page.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
 	// Set the response header and encode the response to JSON
-	WriteAPIResponse(w, http.StatusOK, devices)
+	WriteAPIResponse(w, http.StatusOK, page.Devices)
+}
+
+// parseListDevicesRequest reads the query parameters of a device listing
+func parseListDevicesRequest(r *http.Request) (*request.ListDevicesRequest, error) {
+	query := r.URL.Query()
+	req := &request.ListDevicesRequest{
+		TenantID:    tenantFromRequest(r),
+		Algorithm:   query.Get("algorithm"),
+		LabelPrefix: query.Get("labelPrefix"),
+		Status:      query.Get("status"),
+		SortBy:      query.Get("sort"),
+		Order:       query.Get("order"),
+		Cursor:      query.Get("cursor"),
+	}
+
+	var err error
+	if value := query.Get("createdFrom"); value != "" {
+		if req.CreatedFrom, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, invalidField(ErrInvalidListRequest, "createdFrom", "must be an RFC 3339 timestamp")
+		}
+	}
+	if value := query.Get("createdTo"); value != "" {
+		if req.CreatedTo, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, invalidField(ErrInvalidListRequest, "createdTo", "must be an RFC 3339 timestamp")
+		}
+	}
+	if value := query.Get("includeDeleted"); value != "" {
+		if req.IncludeDeleted, err = strconv.ParseBool(value); err != nil {
+			return nil, invalidField(ErrInvalidListRequest, "includeDeleted", "must be true or false")
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if req.Limit, err = strconv.Atoi(value); err != nil || req.Limit <= 0 {
+			return nil, invalidField(ErrInvalidListRequest, "limit", "must be a positive number")
+		}
+	}
+	return req, nil
 }
 
 // GetSignatureDeviceByIdHandler API handler for retrieving information about a specific device by ID
@@ -165,9 +195,12 @@ func (s *Server) ListSignatureDevicesHandler(w http.ResponseWriter, r *http.Requ
 // @Accept json
 // @Produce json
 // @Param id query string true "Device ID"
+// @Param Authorization header string true "Bearer API key"
 // @Success 200 {object} DeviceResponse "Successful response"
 // @Failure 400 {object} ErrorResponse "Device ID is required"
 // @Failure 404 {object} ErrorResponse "Device not found"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /api/v0/device [get]
 func (s *Server) GetSignatureDeviceByIdHandler(w http.ResponseWriter, r *http.Request) {
@@ -183,11 +216,227 @@ func (s *Server) GetSignatureDeviceByIdHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 	// Retrieve the device information using the device service
-	deviceResponse, err := deviceService.GetSignatureDeviceById(deviceID)
+	deviceResponse, err := s.deviceService.GetSignatureDeviceById(r.Context(), tenantFromRequest(r), deviceID)
 	if err != nil {
-		WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		WriteError(w, r, err)
 		return
 	}
 	// Set the response header and encode the response to JSON
 	WriteAPIResponse(w, http.StatusOK, deviceResponse)
 }
+
+// UpdateSignatureDeviceHandler API handler for updating the label and metadata of a device
+// @Summary Update a signature device
+// @Description Update the label and signing limits and merge the free-form metadata of a signature device.
+// @Description Metadata keys set to null are removed; limits are replaced as a whole.
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param device body UpdateDeviceRequest true "Label, metadata and signing limits"
+// @Param Authorization header string true "Bearer API key"
+// @Success 200 {object} DeviceResponse "Successful response"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 403 {object} ErrorResponse "Client certificate callers can't bind devices"
+// @Failure 404 {object} ErrorResponse "Device not found"
+// @Failure 410 {object} ErrorResponse "Device has been deleted"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v0/devices/{id} [patch]
+func (s *Server) UpdateSignatureDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	// Ensure the request method is PATCH
+	if r.Method != http.MethodPatch {
+		WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req request.UpdateDeviceRequest
+	// Decode the incoming request body into req
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	req.ClientCertificate = certificateFromRequest(r)
+	// Update the device using the device service
+	deviceResponse, err := s.deviceService.UpdateSignatureDevice(r.Context(), tenantFromRequest(r), r.PathValue("id"), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	// Set the response header and encode the response to JSON
+	WriteAPIResponse(w, http.StatusOK, deviceResponse)
+}
+
+// DeleteSignatureDeviceHandler API handler for deleting a device
+// @Summary Delete a signature device
+// @Description Destroy the private key of a signature device. A tombstone with the public key and the final
+// @Description signature counter is kept so historic signatures remain verifiable, and the ID cannot be reused.
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param Authorization header string true "Bearer API key"
+// @Success 200 {object} DeviceResponse "The tombstone of the deleted device"
+// @Failure 404 {object} ErrorResponse "Device not found"
+// @Failure 410 {object} ErrorResponse "Device has already been deleted"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v0/devices/{id} [delete]
+func (s *Server) DeleteSignatureDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	// Ensure the request method is DELETE
+	if r.Method != http.MethodDelete {
+		WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	// Delete the device using the device service
+	deviceResponse, err := s.deviceService.DeleteSignatureDevice(r.Context(), tenantFromRequest(r), r.PathValue("id"))
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	// Set the response header and encode the response to JSON
+	WriteAPIResponse(w, http.StatusOK, deviceResponse)
+}
+
+// ChangeDeviceStatusHandler API handler for changing the lifecycle state of a device
+// @Summary Change the status of a signature device
+// @Description Apply a lifecycle action (activate, suspend, resume, decommission) to a signature device. Only active devices can sign.
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param status body DeviceStatusRequest true "Lifecycle action"
+// @Param Authorization header string true "Bearer API key"
+// @Success 200 {object} DeviceStatusResponse "Successful response"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 404 {object} ErrorResponse "Device not found"
+// @Failure 409 {object} ErrorResponse "Transition not allowed in the current state"
+// @Failure 410 {object} ErrorResponse "Device has been deleted"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v0/devices/{id}/status [post]
+func (s *Server) ChangeDeviceStatusHandler(w http.ResponseWriter, r *http.Request) {
+	// Ensure the request method is POST
+	if r.Method != http.MethodPost {
+		WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req request.DeviceStatusRequest
+	// Decode the incoming request body into req
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	// Apply the action using the device service
+	statusResponse, err := s.deviceService.ChangeDeviceStatus(r.Context(), tenantFromRequest(r), r.PathValue("id"), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	// Set the response header and encode the response to JSON
+	WriteAPIResponse(w, http.StatusOK, statusResponse)
+}
+
+// GetDeviceStatusHandler API handler for retrieving the lifecycle state of a device and its history
+// @Summary Get the status of a signature device
+// @Description Retrieve the lifecycle state of a signature device and the history of its transitions
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param Authorization header string true "Bearer API key"
+// @Success 200 {object} DeviceStatusResponse "Successful response"
+// @Failure 404 {object} ErrorResponse "Device not found"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v0/devices/{id}/status [get]
+func (s *Server) GetDeviceStatusHandler(w http.ResponseWriter, r *http.Request) {
+	// Ensure the request method is GET
+	if r.Method != http.MethodGet {
+		WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	// Retrieve the status using the device service
+	statusResponse, err := s.deviceService.GetDeviceStatus(r.Context(), tenantFromRequest(r), r.PathValue("id"))
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	// Set the response header and encode the response to JSON
+	WriteAPIResponse(w, http.StatusOK, statusResponse)
+}
+
+// SetDevicePolicyHandler API handler for replacing the signing policy of a device
+// @Summary Set the signing policy of a signature device
+// @Description Replace the policy restricting what a device may sign: maximum payload size, a regular expression
+// @Description or JSON schema the data must match, allowed hours of the day and allowed client identities.
+// @Description A null or empty policy removes all rules. Every change is audited.
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param policy body DevicePolicyRequest true "Policy document"
+// @Param Authorization header string true "Bearer API key"
+// @Success 200 {object} DevicePolicyResponse "Successful response"
+// @Failure 400 {object} ErrorResponse "Invalid policy"
+// @Failure 404 {object} ErrorResponse "Device not found"
+// @Failure 410 {object} ErrorResponse "Device has been deleted"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v0/devices/{id}/policy [put]
+func (s *Server) SetDevicePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	// Ensure the request method is PUT
+	if r.Method != http.MethodPut {
+		WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req request.DevicePolicyRequest
+	// Decode the incoming request body into req
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	req.ChangedBy = clientFromRequest(r)
+	// Replace the policy using the device service
+	policyResponse, err := s.deviceService.SetDevicePolicy(r.Context(), tenantFromRequest(r), r.PathValue("id"), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	// Set the response header and encode the response to JSON
+	WriteAPIResponse(w, http.StatusOK, policyResponse)
+}
+
+// GetDevicePolicyHandler API handler for retrieving the signing policy of a device and its change history
+// @Summary Get the signing policy of a signature device
+// @Description Retrieve the signing policy of a signature device and the audit trail of its changes
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param Authorization header string true "Bearer API key"
+// @Success 200 {object} DevicePolicyResponse "Successful response"
+// @Failure 404 {object} ErrorResponse "Device not found"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v0/devices/{id}/policy [get]
+func (s *Server) GetDevicePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	// Ensure the request method is GET
+	if r.Method != http.MethodGet {
+		WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	// Retrieve the policy using the device service
+	policyResponse, err := s.deviceService.GetDevicePolicy(r.Context(), tenantFromRequest(r), r.PathValue("id"))
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	// Set the response header and encode the response to JSON
+	WriteAPIResponse(w, http.StatusOK, policyResponse)
+}
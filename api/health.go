@@ -1,6 +1,11 @@
 package api
 
-import "net/http"
+import (
+	"encoding/json"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/buildinfo"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/health"
+	"net/http"
+)
 
 // HealthResponse defines the response for health check
 // swagger:model
@@ -12,22 +17,70 @@ type HealthResponse struct {
 // Health evaluates the health of the service and writes a standardized response.
 // swagger:route GET /health health checkHealth
 //
-// Evaluates the health of the service.
+// Evaluates the health of the service by running the readiness checks.
 //
 // Responses:
 //
 //	200: HealthResponse
 //	405: ErrorResponse
+//	503: HealthResponse
 func (s *Server) Health(response http.ResponseWriter, request *http.Request) {
 	if request.Method != http.MethodGet {
 		WriteErrorResponse(response, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
 		return
 	}
 
-	health := HealthResponse{
-		Status:  "pass",
-		Version: "v0",
+	report := s.health.Run(request.Context(), health.Readiness)
+	status := HealthResponse{
+		Status:  string(report.Status),
+		Version: buildinfo.Version(),
 	}
 
-	WriteAPIResponse(response, http.StatusOK, health)
+	WriteAPIResponse(response, healthStatusCode(report.Status), status)
+}
+
+// LivezHandler API handler for the liveness probe
+// @Summary Liveness probe
+// @Description Runs the liveness checks; the service must be restarted if they fail.
+// @Tags health
+// @Produce application/health+json
+// @Success 200 {object} health.Report "The service is alive"
+// @Failure 503 {object} health.Report "The service must be restarted"
+// @Router /livez [get]
+func (s *Server) LivezHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, s.health.Run(r.Context(), health.Liveness))
+}
+
+// ReadyzHandler API handler for the readiness probe
+// @Summary Readiness probe
+// @Description Runs every check, including the repository, the crypto self-test and the disk space; the service must not receive requests if they fail.
+// @Tags health
+// @Produce application/health+json
+// @Success 200 {object} health.Report "The service is ready, possibly with warnings"
+// @Failure 503 {object} health.Report "The service cannot handle requests"
+// @Router /readyz [get]
+func (s *Server) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, s.health.Run(r.Context(), health.Readiness))
+}
+
+// writeHealthReport writes a health report with the build version in the application/health+json format
+func writeHealthReport(w http.ResponseWriter, report health.Report) {
+	report.Version = buildinfo.Version()
+	report.ReleaseID = buildinfo.Revision()
+	report.Description = "signing service"
+
+	w.Header().Set("Content-Type", health.ContentType)
+	// Probes must see the current health, never a cached one
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(healthStatusCode(report.Status))
+	json.NewEncoder(w).Encode(report)
+}
+
+// healthStatusCode returns 503 Service Unavailable for failing services and 200 OK otherwise, as warnings
+// don't keep the service from handling requests
+func healthStatusCode(status health.Status) int {
+	if status == health.StatusFail {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
 }
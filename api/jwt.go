@@ -0,0 +1,90 @@
+package api
+
+import (
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/jwtauth"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// DefaultTenantClaim is the claim holding the tenant of a JWT caller
+const DefaultTenantClaim = "tenant_id"
+
+// JWTAuthenticator authenticates requests by a JWT bearer token, e.g. issued by an OIDC provider.
+// The tenant is read from a configurable claim, the scopes from the "scope" (space-separated) or
+// "scp" (array) claim and the client from the "client_id", "azp" or "sub" claim.
+type JWTAuthenticator struct {
+	verifier    *jwtauth.Verifier
+	tenantClaim string
+}
+
+// NewJWTAuthenticator creates an authenticator checking tokens with the given verifier, reading the tenant
+// from the given claim (DefaultTenantClaim if empty)
+func NewJWTAuthenticator(verifier *jwtauth.Verifier, tenantClaim string) *JWTAuthenticator {
+	if tenantClaim == "" {
+		tenantClaim = DefaultTenantClaim
+	}
+	return &JWTAuthenticator{verifier: verifier, tenantClaim: tenantClaim}
+}
+
+// Authenticate verifies the bearer token of a request and maps its claims to the identity of the caller
+func (authenticator *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrUnauthenticated
+	}
+	claims, err := authenticator.verifier.Verify(r.Context(), token)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+
+	// Tokens must name a tenant, a token for no tenant in particular must not act for the default tenant
+	tenantID, _ := claims[authenticator.tenantClaim].(string)
+	if tenantID == "" {
+		return nil, ErrUnauthenticated
+	}
+	return &Identity{
+		TenantID: tenantID,
+		ClientID: clientClaim(claims),
+		Scopes:   scopeClaims(claims),
+	}, nil
+}
+
+// clientClaim returns the client a token was issued to
+func clientClaim(claims jwt.MapClaims) string {
+	for _, name := range []string{"client_id", "azp", "sub"} {
+		if client, _ := claims[name].(string); client != "" {
+			return client
+		}
+	}
+	return ""
+}
+
+// scopeClaims returns the scopes granted by a token. Scopes of other services, which providers often
+// put in the same claim, are ignored.
+func scopeClaims(claims jwt.MapClaims) []domain.Scope {
+	var names []string
+	if scope, ok := claims["scope"].(string); ok {
+		names = strings.Fields(scope)
+	}
+	switch scp := claims["scp"].(type) {
+	case string:
+		names = append(names, strings.Fields(scp)...)
+	case []interface{}:
+		for _, name := range scp {
+			if name, ok := name.(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+
+	var scopes []domain.Scope
+	for _, name := range names {
+		if parsed, err := domain.ParseScopes([]string{name}); err == nil && !slices.Contains(scopes, parsed[0]) {
+			scopes = append(scopes, parsed[0])
+		}
+	}
+	return scopes
+}
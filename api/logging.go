@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// quietPaths are polled by orchestrators and monitoring; their requests are only logged at debug level
+var quietPaths = map[string]bool{
+	"/livez":         true,
+	"/readyz":        true,
+	"/metrics":       true,
+	"/api/v0/health": true,
+	"/api/v1/health": true,
+}
+
+// accessLogKey is the context key of the accessLogEntry of a request
+type accessLogKey struct{}
+
+// accessLogEntry collects what the handlers of a request know about it for its access log record
+type accessLogEntry struct {
+	tenantID string
+	clientID string
+}
+
+// RequestID wraps a handler so every request has an ID: the X-Request-ID header of the client if it is
+// valid, a generated one otherwise. The ID is echoed in the response and passed on in the request context,
+// together with a logger adding it to every record.
+func RequestID(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(logging.RequestIDHeader)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(logging.RequestIDHeader, id)
+
+		// Records of traced requests carry the trace and span ID, so the trace of a record can be found
+		requestLogger := logger.With(slog.String("request_id", id))
+		if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+			requestLogger = requestLogger.With(slog.String("trace_id", spanContext.TraceID().String()), slog.String("span_id", spanContext.SpanID().String()))
+		}
+		ctx := logging.WithRequestID(r.Context(), id)
+		ctx = logging.WithLogger(ctx, requestLogger)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AccessLog wraps a handler so every request is logged once it has been served, with the logger of its
+// context. Only the method and path are logged, never the query, headers or bodies, which may hold
+// credentials or transaction data.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		entry := &accessLogEntry{}
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), accessLogKey{}, entry)))
+
+		level := slog.LevelInfo
+		switch {
+		case recorder.status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case quietPaths[r.URL.Path]:
+			level = slog.LevelDebug
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Int64("bytes", recorder.bytes),
+			slog.Duration("duration", time.Since(started)),
+			slog.String("remote_ip", remoteIP(r)),
+			slog.String("user_agent", r.UserAgent()),
+		}
+		if entry.clientID != "" || entry.tenantID != "" {
+			attrs = append(attrs, slog.String("tenant_id", entry.tenantID), slog.String("client_id", entry.clientID))
+		}
+		logging.FromContext(r.Context()).LogAttrs(r.Context(), level, "request served", attrs...)
+	})
+}
+
+// withIdentityLogging returns a request whose logger adds the tenant and client of an authenticated
+// caller to every record, and records them for the access log
+func withIdentityLogging(r *http.Request, identity *Identity) *http.Request {
+	if entry, ok := r.Context().Value(accessLogKey{}).(*accessLogEntry); ok {
+		entry.tenantID, entry.clientID = identity.TenantID, identity.ClientID
+	}
+	logger := logging.FromContext(r.Context()).With(slog.String("tenant_id", identity.TenantID), slog.String("client_id", identity.ClientID))
+	return r.WithContext(logging.WithLogger(r.Context(), logger))
+}
+
+// remoteIP returns the IP address of the client connection, without its port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// responseRecorder remembers the status code and the size of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap gives http.ResponseController access to the wrapped writer, e.g. to flush it
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/policy"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// problemContentType is the media type of RFC 7807 problem details
+const problemContentType = "application/problem+json"
+
+// problemTypePrefix is prepended to the error code to form the type URI of a problem
+const problemTypePrefix = "urn:signing-service:problem:"
+
+// ErrorResponse is the error API response, an RFC 7807 problem details object.
+type ErrorResponse struct {
+	// Type is a URI identifying the problem type, derived from Code
+	Type string `json:"type"`
+	// Title is a short summary of the problem type
+	Title string `json:"title"`
+	// Status is the HTTP status code of the response
+	Status int `json:"status"`
+	// Detail explains this occurrence of the problem
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request that failed
+	Instance string `json:"instance,omitempty"`
+	// Code is the stable error code clients switch on, e.g. device_not_found
+	Code string `json:"code"`
+	// InvalidParams lists the rejected fields of an invalid request
+	InvalidParams []InvalidParam `json:"invalidParams,omitempty"`
+	// Violations lists the rules of the device policy a sign request breaks
+	Violations []policy.Violation `json:"violations,omitempty"`
+}
+
+// InvalidParam describes a rejected field of a request
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// Errors of requests rejected before they reach a service
+var (
+	// ErrMalformedRequest is returned for request bodies that are not valid JSON
+	ErrMalformedRequest = domain.NewError(domain.KindInvalid, "malformed_request", "malformed request")
+	// ErrMissingScope is returned for authenticated callers not granted the scope of a route
+	ErrMissingScope = domain.NewError(domain.KindForbidden, "insufficient_scope", "missing scope")
+	// ErrRequestTooLarge is returned for request bodies exceeding the maximum body size of the server
+	ErrRequestTooLarge = domain.NewError(domain.KindTooLarge, "request_too_large", "request body too large")
+	// ErrRateLimited is returned when a client or device has used up its rate limit
+	ErrRateLimited = domain.NewError(domain.KindExhausted, "rate_limited", "rate limit exceeded")
+)
+
+// statusByKind maps the kinds of domain errors to HTTP status codes
+var statusByKind = map[domain.ErrorKind]int{
+	domain.KindInvalid:         http.StatusBadRequest,
+	domain.KindUnprocessable:   http.StatusUnprocessableEntity,
+	domain.KindUnauthenticated: http.StatusUnauthorized,
+	domain.KindForbidden:       http.StatusForbidden,
+	domain.KindNotFound:        http.StatusNotFound,
+	domain.KindConflict:        http.StatusConflict,
+	domain.KindGone:            http.StatusGone,
+	domain.KindExhausted:       http.StatusTooManyRequests,
+	domain.KindTooLarge:        http.StatusRequestEntityTooLarge,
+}
+
+// StatusForError returns the HTTP status code of an error, 500 for errors without a kind
+func StatusForError(err error) int {
+	if status, ok := statusByKind[domain.KindOf(err)]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// codeForStatus returns the error code of a problem that has no domain error, e.g. method_not_allowed
+func codeForStatus(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// WriteError writes an error as problem details, with the status code and error code of its kind.
+// Internal errors are reported without their message, which may contain details of the storage.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	status := StatusForError(err)
+	problem := ErrorResponse{Status: status, Code: domain.CodeOf(err), Detail: err.Error(), Instance: r.URL.Path}
+	if status == http.StatusInternalServerError {
+		// The client learns nothing about the cause, so it is logged with the request ID of the response
+		logging.FromContext(r.Context()).ErrorContext(r.Context(), "request failed", "error", err.Error())
+		problem.Code, problem.Detail = "", ""
+	}
+	if problem.Code == "" {
+		problem.Code = codeForStatus(status)
+	}
+	for _, field := range domain.FieldErrorsOf(err) {
+		problem.InvalidParams = append(problem.InvalidParams, InvalidParam{Name: field.Field, Reason: field.Message})
+	}
+	if violationErr := (*policy.ViolationError)(nil); errors.As(err, &violationErr) {
+		problem.Violations = violationErr.Violations
+	}
+	if errors.Is(err, domain.ErrDailySignatureQuotaExhausted) {
+		// The daily quota is replenished at midnight UTC
+		retryAfter := time.Until(domain.StartOfDay(time.Now()).Add(24 * time.Hour))
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+	}
+	writeProblem(w, problem)
+}
+
+// WriteErrorResponse writes an error message with an HTTP status code as problem details.
+// Errors of the services are written with WriteError, which adds their error code.
+func WriteErrorResponse(w http.ResponseWriter, code int, error string) {
+	writeProblem(w, ErrorResponse{Status: code, Code: codeForStatus(code), Detail: error})
+}
+
+// writeProblem completes the type and title of problem details and writes them as an HTTP response
+func writeProblem(w http.ResponseWriter, problem ErrorResponse) {
+	problem.Type = problemTypePrefix + problem.Code
+	problem.Title = http.StatusText(problem.Status)
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(problem.Status)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		WriteInternalError(w)
+	}
+}
+
+// decodeRequest decodes a JSON request body, writing a 400 response if it is malformed
+func decodeRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		WriteError(w, r, bodyError(err))
+		return false
+	}
+	return true
+}
+
+// readBody reads the whole request body, writing the error response and returning false if it fails
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteError(w, r, bodyError(err))
+		return nil, false
+	}
+	return body, true
+}
+
+// bodyError wraps an error reading or decoding a request body in ErrRequestTooLarge if the body exceeds
+// the maximum body size, and in ErrMalformedRequest otherwise
+func bodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("%w: limit is %d bytes", ErrRequestTooLarge, tooLarge.Limit)
+	}
+	return fmt.Errorf("%w: %w", ErrMalformedRequest, err)
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ratelimit"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Default rate limits, in requests per second and burst size
+var (
+	DefaultClientRateLimit = ratelimit.Limit{Rate: 50, Burst: 100}
+	DefaultDeviceRateLimit = ratelimit.Limit{Rate: 20, Burst: 40}
+)
+
+// RateLimiter throttles requests with token buckets per client and per target device, so a single
+// misbehaving client or a hot device can't saturate the service for everybody
+type RateLimiter struct {
+	store  ratelimit.Store
+	client ratelimit.Limit
+	device ratelimit.Limit
+}
+
+// NewRateLimiter creates a rate limiter keeping its buckets in the given store. Limits that are not
+// enabled don't throttle requests.
+func NewRateLimiter(store ratelimit.Store, client, device ratelimit.Limit) *RateLimiter {
+	return &RateLimiter{store: store, client: client, device: device}
+}
+
+// LimitClient wraps a handler so requests are limited per authenticated client. It must be wrapped by
+// RequireScope, which provides the identity of the client.
+func (limiter *RateLimiter) LimitClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := identityFromRequest(r)
+		if identity == nil || !limiter.client.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := "client:" + tenantOrDefault(identity.TenantID) + "/" + identity.ClientID
+		if limiter.take(w, r, key, limiter.client) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// LimitDevice wraps a handler so requests are limited per target device, across all clients of a tenant.
+// Requests without a device ID are not limited.
+func (limiter *RateLimiter) LimitDevice(deviceID func(r *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := deviceID(r)
+		if id == "" || !limiter.device.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Buckets are kept per tenant, so requests for devices of other tenants can't exhaust them
+		key := "device:" + tenantOrDefault(tenantFromRequest(r)) + "/" + id
+		if limiter.take(w, r, key, limiter.device) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// take takes a token from a bucket and writes the rate limit headers, or a 429 response if the bucket is empty.
+// Of several buckets applying to a request, the headers describe the one with the fewest remaining requests.
+func (limiter *RateLimiter) take(w http.ResponseWriter, r *http.Request, key string, limit ratelimit.Limit) bool {
+	decision := limiter.store.Take(key, limit, time.Now())
+
+	if remaining, err := strconv.Atoi(w.Header().Get("RateLimit-Remaining")); err != nil || decision.Remaining < remaining || !decision.Allowed {
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+	}
+	if !decision.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(decision.RetryAfter))))
+		WriteError(w, r, ErrRateLimited)
+	}
+	return decision.Allowed
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
+
+// devicePathID returns the device ID of routes like /api/v0/devices/{id}
+func devicePathID(r *http.Request) string {
+	return r.PathValue("id")
+}
+
+// deviceQueryID returns the device ID of routes like /api/v0/device?id=
+func deviceQueryID(r *http.Request) string {
+	return r.URL.Query().Get("id")
+}
+
+// deviceBodyID returns the deviceId of a JSON request body. The body is restored for the handler.
+func deviceBodyID(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		// The handler reads the same error, e.g. that the body is too large, after what was read
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), &errorReader{err}))
+		return ""
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var target struct {
+		DeviceID string `json:"deviceId"`
+	}
+	// Invalid bodies are rejected by the handler
+	_ = json.Unmarshal(body, &target)
+	return target.DeviceID
+}
+
+// errorReader is a reader failing with an error
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
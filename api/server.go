@@ -1,54 +1,381 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
 	_ "github.com/fiskaly/coding-challenges/signing-service-challenge/docs"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/health"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/metrics"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ratelimit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/tracing"
 	httpSwagger "github.com/swaggo/http-swagger"
+	"log/slog"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 )
 
+// DefaultServerTimeouts are the timeouts of servers created without WithTimeouts
+var DefaultServerTimeouts = ServerTimeouts{
+	Read:       15 * time.Second,
+	ReadHeader: 5 * time.Second,
+	Write:      30 * time.Second,
+	Idle:       120 * time.Second,
+	Shutdown:   30 * time.Second,
+}
+
+// DefaultServerLimits are the limits of servers created without WithLimits
+var DefaultServerLimits = ServerLimits{
+	MaxHeaderBytes: 64 << 10,
+	MaxBodyBytes:   1 << 20,
+}
+
 // Response is the generic API response container.
 type Response struct {
 	Data interface{} `json:"data"`
 }
 
-// ErrorResponse is the generic error API response container.
-type ErrorResponse struct {
-	Errors []string `json:"errors"`
-}
-
 // Server manages HTTP requests and dispatches them to the appropriate services.
 type Server struct {
 	listenAddress string
+	// tlsConfig makes the server listen for HTTPS instead of plain HTTP if set
+	tlsConfig *tls.Config
+	// store is the repository of the services that are not given explicitly
+	store persistence.DeviceRepository
+	// deviceService handles the device and signing routes
+	deviceService DeviceServiceInterface
+	// apiKeyService handles the API key routes
+	apiKeyService APIKeyServiceInterface
+	// authenticator checks the credentials of every route but the health check
+	authenticator Authenticator
+	// rateLimiter throttles requests per client and per device
+	rateLimiter *RateLimiter
+	// timeouts bound how long connections may take to send requests and receive responses
+	timeouts ServerTimeouts
+	// limits bound the size of requests
+	limits ServerLimits
+	// health runs the liveness and readiness checks
+	health *health.Registry
+	// healthChecks are registered in addition to the default checks
+	healthChecks []healthCheck
+	// metrics records the requests of every route and is served at /metrics
+	metrics *metrics.Metrics
+	// logger writes the access log; each request logs with a copy carrying its request ID
+	logger *slog.Logger
+}
+
+// healthCheck is a check given with WithHealthCheck
+type healthCheck struct {
+	name    string
+	probe   health.Probe
+	checker health.Checker
+}
+
+// ServerTimeouts are the timeouts of the HTTP server, see http.Server; zero means no timeout
+type ServerTimeouts struct {
+	Read       time.Duration
+	ReadHeader time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+	// Shutdown is how long in-flight requests may take to complete when the server stops
+	Shutdown time.Duration
+}
+
+// ServerLimits are the size limits of requests; zero means the defaults of http.Server for headers and
+// no limit for bodies
+type ServerLimits struct {
+	MaxHeaderBytes int
+	// MaxBodyBytes rejects larger request bodies with 413 Request Entity Too Large
+	MaxBodyBytes int64
+}
+
+// ServerOption configures optional settings of a Server
+type ServerOption func(*Server)
+
+// WithTLS makes the server listen for HTTPS with the given configuration, see NewTLSConfig
+func WithTLS(config *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.tlsConfig = config
+	}
+}
+
+// WithTimeouts sets the timeouts of the HTTP server
+func WithTimeouts(timeouts ServerTimeouts) ServerOption {
+	return func(s *Server) {
+		s.timeouts = timeouts
+	}
+}
+
+// WithLimits sets the size limits of requests
+func WithLimits(limits ServerLimits) ServerOption {
+	return func(s *Server) {
+		s.limits = limits
+	}
+}
+
+// WithHealthCheck adds a liveness or readiness check, replacing a default check of the same name. By
+// default the uptime, the connectivity of the repository and a crypto self-test are checked.
+func WithHealthCheck(name string, probe health.Probe, checker health.Checker) ServerOption {
+	return func(s *Server) {
+		s.healthChecks = append(s.healthChecks, healthCheck{name, probe, checker})
+	}
+}
+
+// WithServerMetrics sets the metrics requests are recorded in and served from at /metrics. The services the
+// server creates record into them too; services given with WithDeviceService need the WithMetrics
+// DeviceServiceOption. By default the server has metrics of its own.
+func WithServerMetrics(m *metrics.Metrics) ServerOption {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
+// WithLogger sets the logger of the access log and of the requests. By default the server logs with the
+// default logger.
+func WithLogger(logger *slog.Logger) ServerOption {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithRepository sets the repository the device and API key services are created on, unless they are given
+// with WithDeviceService and WithAPIKeyService. Without it the services keep their data in memory.
+func WithRepository(store persistence.DeviceRepository) ServerOption {
+	return func(s *Server) {
+		s.store = store
+	}
+}
+
+// WithDeviceService sets the service handling the device and signing routes
+func WithDeviceService(service DeviceServiceInterface) ServerOption {
+	return func(s *Server) {
+		s.deviceService = service
+	}
+}
+
+// WithAPIKeyService sets the service handling the API key routes and, unless WithAuthenticator is given,
+// checking the API keys of requests
+func WithAPIKeyService(service APIKeyServiceInterface) ServerOption {
+	return func(s *Server) {
+		s.apiKeyService = service
+	}
+}
+
+// WithAuthenticator sets how the credentials of requests are checked. By default API keys of the API key
+// service and verified TLS client certificates, granted DefaultCertificateScopes, are accepted.
+func WithAuthenticator(authenticator Authenticator) ServerOption {
+	return func(s *Server) {
+		s.authenticator = authenticator
+	}
+}
+
+// WithRateLimiter sets the rate limiter of the server. By default the DefaultClientRateLimit and
+// DefaultDeviceRateLimit are enforced in memory.
+func WithRateLimiter(limiter *RateLimiter) ServerOption {
+	return func(s *Server) {
+		s.rateLimiter = limiter
+	}
 }
 
-// NewServer is a factory to instantiate a new Server.
-func NewServer(listenAddress string) *Server {
-	return &Server{
+// NewServer is a factory to instantiate a new Server. Dependencies that are not given as options are
+// created with their defaults, so NewServer(":8080") serves an in-memory service.
+func NewServer(listenAddress string, options ...ServerOption) *Server {
+	s := &Server{
 		listenAddress: listenAddress,
-		// TODO: add services / further dependencies here ...
+		timeouts:      DefaultServerTimeouts,
+		limits:        DefaultServerLimits,
+	}
+	for _, option := range options {
+		option(s)
+	}
+
+	if s.metrics == nil {
+		s.metrics = metrics.New()
+	}
+	if s.logger == nil {
+		s.logger = slog.Default()
+	}
+	if s.store == nil && (s.deviceService == nil || s.apiKeyService == nil) {
+		s.store = persistence.NewInMemoryDeviceRepository()
+	}
+	if s.store != nil {
+		s.metrics.TrackDevices(s.store)
+	}
+	if s.deviceService == nil {
+		s.deviceService = NewDeviceService(s.observedStore(), WithMetrics(s.metrics))
+	}
+	if s.apiKeyService == nil {
+		s.apiKeyService = NewAPIKeyService(s.observedStore())
+	}
+	if s.authenticator == nil {
+		s.authenticator = NewChainAuthenticator(NewAPIKeyAuthenticator(s.apiKeyService), NewCertificateAuthenticator(DefaultCertificateScopes))
+	}
+	if s.rateLimiter == nil {
+		s.rateLimiter = NewRateLimiter(ratelimit.NewMemoryStore(), DefaultClientRateLimit, DefaultDeviceRateLimit)
+	}
+
+	s.health = health.NewRegistry()
+	s.health.Register("uptime", health.Liveness, health.Uptime(time.Now()))
+	if s.store != nil {
+		s.health.Register("datastore:connectivity", health.Readiness, health.Connectivity(s.store))
+	}
+	s.health.Register("crypto:selfTest", health.Readiness, health.CryptoSelfTest(crypto.NewKeyPairFactory(), domain.ECC, domain.RSA))
+	s.health.Register("crypto:keyGeneration", health.Readiness, health.KeyGeneration(crypto.NewKeyPairFactory(), health.DefaultKeyGenerationThreshold, domain.ECC, domain.RSA))
+	for _, check := range s.healthChecks {
+		s.health.Register(check.name, check.probe, check.checker)
+	}
+	return s
+}
+
+// observedStore wraps the store of the server so its operations are recorded in the metrics, logged and traced
+func (s *Server) observedStore() persistence.DeviceRepository {
+	return persistence.NewObservedRepository(s.store, s.metrics.ObserveRepositoryOperation, logging.RepositoryOperation, tracing.RepositoryOperation)
+}
+
+// Run starts the Server and serves requests until ctx is done. It then stops accepting connections and
+// waits for in-flight requests to complete, at most for the shutdown timeout, before it returns.
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.listenAddress)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve is Run on an existing listener, which is closed when Serve returns
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{
+		Handler:           s.Handler(),
+		TLSConfig:         s.tlsConfig,
+		ReadTimeout:       s.timeouts.Read,
+		ReadHeaderTimeout: s.timeouts.ReadHeader,
+		WriteTimeout:      s.timeouts.Write,
+		IdleTimeout:       s.timeouts.Idle,
+		MaxHeaderBytes:    s.limits.MaxHeaderBytes,
+		// Errors of connections, e.g. failed TLS handshakes, are logged as errors of the server
+		ErrorLog: slog.NewLogLogger(s.logger.Handler(), slog.LevelError),
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		if s.tlsConfig == nil {
+			served <- server.Serve(listener)
+			return
+		}
+		// The certificate is part of the TLS configuration
+		served <- server.ServeTLS(listener, "", "")
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx := context.Background()
+	if s.timeouts.Shutdown > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, s.timeouts.Shutdown)
+		defer cancel()
 	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		// Connections still open at the deadline are dropped
+		server.Close()
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
-// Run registers all HandlerFuncs for the existing HTTP routes and starts the Server.
-func (s *Server) Run() error {
+// Handler registers all HandlerFuncs for the existing HTTP routes. Every route but the health checks and
+// the API documentation requires credentials granting the scope of the route: an API key, a JWT or a
+// verified TLS client certificate.
+func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
+	// handle registers a handler whose requests are recorded in the metrics and traced under the path of its pattern
+	handle := func(pattern string, handler http.Handler) {
+		route := pattern
+		if i := strings.IndexByte(pattern, ' '); i >= 0 {
+			route = pattern[i+1:]
+		}
+		mux.Handle(pattern, s.metrics.InstrumentHandler(route, traceRoute(route, handler)))
+	}
+	// scoped wraps a handler so it requires credentials granting the given scope, rate limited per client
+	scoped := func(scope domain.Scope, handler http.Handler) http.Handler {
+		return RequireScope(s.authenticator, scope, s.rateLimiter.LimitClient(handler))
+	}
+	// perDevice rate limits a handler per target device, identified by the given function
+	perDevice := func(deviceID func(r *http.Request) string, handler http.HandlerFunc) http.Handler {
+		return s.rateLimiter.LimitDevice(deviceID, handler)
+	}
 
-	mux.Handle("/api/v0/health", http.HandlerFunc(s.Health))
+	handle("/api/v0/health", http.HandlerFunc(s.Health))
+	// Register the liveness and readiness probes
+	handle("GET /livez", http.HandlerFunc(s.LivezHandler))
+	handle("GET /readyz", http.HandlerFunc(s.ReadyzHandler))
 
-	// TODO: register further HandlerFuncs here ...
 	// Register the endpoint for creating a signature device
-	mux.Handle("/api/v0/create-signature-device", http.HandlerFunc(s.CreateSignatureDeviceHandler))
+	handle("/api/v0/create-signature-device", scoped(domain.ScopeDevicesWrite, http.HandlerFunc(s.CreateSignatureDeviceHandler)))
 	// Register the endpoint for signing a transaction
-	mux.Handle("/api/v0/sign-transaction", http.HandlerFunc(s.SignTransactionHandler))
+	handle("/api/v0/sign-transaction", scoped(domain.ScopeSign, perDevice(deviceBodyID, s.SignTransactionHandler)))
 	// Register the endpoint for listing all signature devices
-	mux.Handle("/api/v0/devices", http.HandlerFunc(s.ListSignatureDevicesHandler))
+	handle("/api/v0/devices", scoped(domain.ScopeDevicesRead, http.HandlerFunc(s.ListSignatureDevicesHandler)))
 	// Register the endpoint for getting a specific signature device by ID
-	mux.Handle("/api/v0/device", http.HandlerFunc(s.GetSignatureDeviceByIdHandler))
+	handle("/api/v0/device", scoped(domain.ScopeDevicesRead, perDevice(deviceQueryID, s.GetSignatureDeviceByIdHandler)))
+	// Register the endpoint for updating the label and metadata of a device
+	handle("PATCH /api/v0/devices/{id}", scoped(domain.ScopeDevicesWrite, perDevice(devicePathID, s.UpdateSignatureDeviceHandler)))
+	// Register the endpoint for deleting a device
+	handle("DELETE /api/v0/devices/{id}", scoped(domain.ScopeDevicesWrite, perDevice(devicePathID, s.DeleteSignatureDeviceHandler)))
+	// Register the endpoints for changing and reading the lifecycle state of a device
+	handle("POST /api/v0/devices/{id}/status", scoped(domain.ScopeDevicesWrite, perDevice(devicePathID, s.ChangeDeviceStatusHandler)))
+	handle("GET /api/v0/devices/{id}/status", scoped(domain.ScopeDevicesRead, perDevice(devicePathID, s.GetDeviceStatusHandler)))
+	// Register the endpoints for replacing and reading the signing policy of a device
+	handle("PUT /api/v0/devices/{id}/policy", scoped(domain.ScopeDevicesWrite, perDevice(devicePathID, s.SetDevicePolicyHandler)))
+	handle("GET /api/v0/devices/{id}/policy", scoped(domain.ScopeDevicesRead, perDevice(devicePathID, s.GetDevicePolicyHandler)))
+	// Register the endpoint for canonicalizing JSON payloads, which prepares sign requests
+	handle("/api/v0/canonicalize", scoped(domain.ScopeSign, http.HandlerFunc(s.CanonicalizeHandler)))
+	// Register the endpoints for managing API keys
+	handle("POST /api/v0/api-keys", scoped(domain.ScopeAdmin, http.HandlerFunc(s.CreateAPIKeyHandler)))
+	handle("GET /api/v0/api-keys", scoped(domain.ScopeAdmin, http.HandlerFunc(s.ListAPIKeysHandler)))
+	handle("DELETE /api/v0/api-keys/{id}", scoped(domain.ScopeAdmin, http.HandlerFunc(s.RevokeAPIKeyHandler)))
+	// Register the v1 API, which addresses devices by path and wraps responses in the Response envelope
+	handle("GET /api/v1/health", http.HandlerFunc(s.HealthV1Handler))
+	handle("POST /api/v1/devices", scoped(domain.ScopeDevicesWrite, http.HandlerFunc(s.CreateDeviceV1Handler)))
+	handle("GET /api/v1/devices", scoped(domain.ScopeDevicesRead, http.HandlerFunc(s.ListDevicesV1Handler)))
+	handle("GET /api/v1/devices/{id}", scoped(domain.ScopeDevicesRead, perDevice(devicePathID, s.GetDeviceV1Handler)))
+	handle("PATCH /api/v1/devices/{id}", scoped(domain.ScopeDevicesWrite, perDevice(devicePathID, s.UpdateDeviceV1Handler)))
+	handle("DELETE /api/v1/devices/{id}", scoped(domain.ScopeDevicesWrite, perDevice(devicePathID, s.DeleteDeviceV1Handler)))
+	handle("POST /api/v1/devices/{id}/signatures", scoped(domain.ScopeSign, perDevice(devicePathID, s.CreateSignatureV1Handler)))
+	handle("POST /api/v1/devices/{id}/status", scoped(domain.ScopeDevicesWrite, perDevice(devicePathID, s.ChangeDeviceStatusV1Handler)))
+	handle("GET /api/v1/devices/{id}/status", scoped(domain.ScopeDevicesRead, perDevice(devicePathID, s.GetDeviceStatusV1Handler)))
+	handle("PUT /api/v1/devices/{id}/policy", scoped(domain.ScopeDevicesWrite, perDevice(devicePathID, s.SetDevicePolicyV1Handler)))
+	handle("GET /api/v1/devices/{id}/policy", scoped(domain.ScopeDevicesRead, perDevice(devicePathID, s.GetDevicePolicyV1Handler)))
+	handle("POST /api/v1/canonicalize", scoped(domain.ScopeSign, http.HandlerFunc(s.CanonicalizeV1Handler)))
+	handle("POST /api/v1/api-keys", scoped(domain.ScopeAdmin, http.HandlerFunc(s.CreateAPIKeyV1Handler)))
+	handle("GET /api/v1/api-keys", scoped(domain.ScopeAdmin, http.HandlerFunc(s.ListAPIKeysV1Handler)))
+	handle("DELETE /api/v1/api-keys/{id}", scoped(domain.ScopeAdmin, http.HandlerFunc(s.RevokeAPIKeyV1Handler)))
+
 	// Register the Swagger UI for API documentation
 	mux.Handle("/swagger/", httpSwagger.WrapHandler)
 
-	return http.ListenAndServe(s.listenAddress, mux)
+	// Register the metrics in the Prometheus text format
+	mux.Handle("GET /metrics", s.metrics.Handler())
+
+	var handler http.Handler = mux
+	if s.limits.MaxBodyBytes > 0 {
+		handler = http.MaxBytesHandler(handler, s.limits.MaxBodyBytes)
+	}
+	return s.metrics.InstrumentInFlight(Trace(RequestID(s.logger, AccessLog(handler))))
 }
 
 // WriteInternalError writes a default internal error message as an HTTP response.
@@ -57,18 +384,6 @@ func WriteInternalError(w http.ResponseWriter) {
 	w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
 }
 
-// WriteErrorResponse takes an HTTP status code and a slice of errors
-// and writes those as an HTTP error response in a structured format.
-func WriteErrorResponse(w http.ResponseWriter, code int, error string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	if err := json.NewEncoder(w).Encode(error); err != nil {
-		WriteInternalError(w)
-
-	}
-
-}
-
 // WriteAPIResponse takes an HTTP status code and a generic data struct
 // and writes those as an HTTP response in a structured format.
 func WriteAPIResponse(w http.ResponseWriter, code int, data interface{}) {
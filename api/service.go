@@ -1,18 +1,46 @@
 package api
 
 import (
+	"context"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/request"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/response"
 )
 
-// DeviceServiceInterface defines the interface for device services
+// DeviceServiceInterface defines the interface for device services. Every method acts on behalf of
+// a tenant and only sees the devices owned by it.
 type DeviceServiceInterface interface {
 	// CreateSignatureDevice creates a new signature device.
-	CreateSignatureDevice(req *request.DeviceRequest) (*response.DeviceResponse, error)
+	CreateSignatureDevice(ctx context.Context, req *request.DeviceRequest) (*response.DeviceResponse, error)
 	// SignTransaction signs a transaction with a specified signature device.
-	SignTransaction(req *request.SignTransactionRequest) (*response.SignTransactionResponse, error)
+	SignTransaction(ctx context.Context, req *request.SignTransactionRequest) (*response.SignTransactionResponse, error)
 	// ListSignatureDevices retrieves a list of all available signature devices.
-	ListSignatureDevices() ([]*response.DeviceResponse, error)
+	ListSignatureDevices(ctx context.Context, tenantID string) ([]*response.DeviceResponse, error)
+	// QuerySignatureDevices retrieves a filtered, sorted page of signature devices.
+	QuerySignatureDevices(ctx context.Context, req *request.ListDevicesRequest) (*response.DeviceListResponse, error)
 	// GetSignatureDeviceById retrieves a specific signature device by its ID.
-	GetSignatureDeviceById(deviceID string) (*response.DeviceResponse, error)
+	GetSignatureDeviceById(ctx context.Context, tenantID, deviceID string) (*response.DeviceResponse, error)
+	// UpdateSignatureDevice updates the label, metadata and signing limits of a device.
+	UpdateSignatureDevice(ctx context.Context, tenantID, deviceID string, req *request.UpdateDeviceRequest) (*response.DeviceResponse, error)
+	// DeleteSignatureDevice destroys the private key of a device and keeps its tombstone.
+	DeleteSignatureDevice(ctx context.Context, tenantID, deviceID string) (*response.DeviceResponse, error)
+	// ChangeDeviceStatus applies a lifecycle action (activate, suspend, resume, decommission) to a device.
+	ChangeDeviceStatus(ctx context.Context, tenantID, deviceID string, req *request.DeviceStatusRequest) (*response.DeviceStatusResponse, error)
+	// GetDeviceStatus retrieves the lifecycle state of a device and its transition history.
+	GetDeviceStatus(ctx context.Context, tenantID, deviceID string) (*response.DeviceStatusResponse, error)
+	// SetDevicePolicy replaces the signing policy of a device and records the change.
+	SetDevicePolicy(ctx context.Context, tenantID, deviceID string, req *request.DevicePolicyRequest) (*response.DevicePolicyResponse, error)
+	// GetDevicePolicy retrieves the signing policy of a device and its change history.
+	GetDevicePolicy(ctx context.Context, tenantID, deviceID string) (*response.DevicePolicyResponse, error)
+}
+
+// APIKeyServiceInterface defines the interface for managing and checking API keys
+type APIKeyServiceInterface interface {
+	// CreateAPIKey creates an API key and returns its secret, which cannot be retrieved again.
+	CreateAPIKey(ctx context.Context, req *request.APIKeyRequest) (*response.CreatedAPIKeyResponse, error)
+	// ListAPIKeys retrieves the API keys of a tenant.
+	ListAPIKeys(ctx context.Context, tenantID string) ([]*response.APIKeyResponse, error)
+	// RevokeAPIKey revokes an API key of a tenant, so it can no longer be used.
+	RevokeAPIKey(ctx context.Context, tenantID, keyID string) (*response.APIKeyResponse, error)
+	// AuthenticateAPIKey resolves a secret API key to the identity of its client.
+	AuthenticateAPIKey(ctx context.Context, key string) (*Identity, error)
 }
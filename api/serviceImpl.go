@@ -1,188 +1,980 @@
 package api
 
 import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/metrics"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/request"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/response"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/policy"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/signeddata"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/tracing"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/utils"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// DefaultIdempotencyRetention is how long idempotency keys are remembered unless configured otherwise
+const DefaultIdempotencyRetention = 24 * time.Hour
+
+// idempotencyPurgeInterval is the minimum time between two purges of expired idempotency keys
+const idempotencyPurgeInterval = time.Minute
+
+// ErrInvalidDeviceAttributes is returned when a label or metadata exceeds its limits
+var ErrInvalidDeviceAttributes = domain.NewError(domain.KindInvalid, "invalid_device_attributes", "invalid device attributes")
+
+// ErrInvalidDeviceRequest is returned when a required attribute of a new device is missing or malformed
+var ErrInvalidDeviceRequest = domain.NewError(domain.KindInvalid, "invalid_device_request", "invalid device request")
+
+// ErrInvalidAlgorithm is returned when a device is created with an unsupported algorithm
+var ErrInvalidAlgorithm = domain.NewError(domain.KindUnprocessable, "invalid_algorithm", "invalid algorithm")
+
+// ErrInvalidSignedDataFormat is returned when a device is created with an unsupported signed data format
+var ErrInvalidSignedDataFormat = domain.NewError(domain.KindUnprocessable, "invalid_signed_data_format", "invalid signed data format")
+
+// ErrInvalidInitialStatus is returned when a device is created in a state other than active or inactive
+var ErrInvalidInitialStatus = domain.NewError(domain.KindUnprocessable, "invalid_initial_status", "initial status must be active or inactive")
+
+// ErrInvalidSignRequest is returned when a required field of a sign request is missing or malformed
+var ErrInvalidSignRequest = domain.NewError(domain.KindInvalid, "invalid_sign_request", "invalid sign request")
+
+// ErrInvalidListRequest is returned when the filters, sort order or cursor of a device listing are invalid
+var ErrInvalidListRequest = domain.NewError(domain.KindInvalid, "invalid_list_request", "invalid list request")
+
+// ErrIdempotencyKeyReused is returned when an idempotency key is replayed with a different request
+var ErrIdempotencyKeyReused = domain.NewError(domain.KindUnprocessable, "idempotency_key_reused", "idempotency key was already used with a different request")
+
+// ErrDeviceNotBound is returned when a caller authenticated by client certificate signs with a device that is
+// not bound to its certificate
+var ErrDeviceNotBound = domain.NewError(domain.KindForbidden, "device_not_bound", "device is not bound to the client certificate")
+
+// ErrClientBindingForbidden is returned when a caller authenticated by client certificate binds a device
+var ErrClientBindingForbidden = domain.NewError(domain.KindForbidden, "client_binding_forbidden", "devices cannot be bound with a client certificate")
+
+// Limits for the user-editable attributes of a device
+const (
+	maxLabelLength         = 255
+	maxBoundClientLength   = 255
+	maxMetadataEntries     = 50
+	maxMetadataKeyLength   = 64
+	maxMetadataValueLength = 1024
+)
+
+// Versions of the UUIDs generated for devices created without an ID
+const (
+	// DeviceIDVersion4 generates random UUIDs
+	DeviceIDVersion4 = "v4"
+	// DeviceIDVersion7 generates time-ordered UUIDs, which keep new devices together in indexes
+	DeviceIDVersion7 = "v7"
+)
+
+// deviceLockStripes is the number of locks the signatures and changes of all devices are serialized by.
+// Devices sharing a stripe wait for each other, but the locks don't grow with the device IDs requested.
+const deviceLockStripes = 256
+
 // DeviceService implements the service
 type DeviceService struct {
 	store persistence.DeviceRepository
+	// locks serialize the signatures and changes of a device; each device uses the stripe its ID hashes to
+	locks [deviceLockStripes]sync.Mutex
+	// idempotencyRetention is how long idempotency keys are remembered
+	idempotencyRetention time.Duration
+	// lastIdempotencyPurge holds the Unix time in nanoseconds of the last purge of expired idempotency keys
+	lastIdempotencyPurge atomic.Int64
+	// deviceIDVersion is the UUID version generated for devices created without an ID
+	deviceIDVersion string
+	// keyPairs generates the keys of new devices and the signers of existing ones
+	keyPairs *crypto.KeyPairFactory
+	// defaultSignedDataFormat is the signed data format of devices created without one
+	defaultSignedDataFormat string
+	// metrics records device creations, signatures and the time spent on crypto; nil records nothing
+	metrics *metrics.Metrics
+}
+
+// DeviceServiceOption configures optional settings of the DeviceService
+type DeviceServiceOption func(*DeviceService)
+
+// WithIdempotencyRetention sets how long idempotency keys are remembered
+func WithIdempotencyRetention(retention time.Duration) DeviceServiceOption {
+	return func(s *DeviceService) {
+		s.idempotencyRetention = retention
+	}
+}
+
+// WithDeviceIDVersion sets the UUID version, DeviceIDVersion4 or DeviceIDVersion7, generated for devices created without an ID
+func WithDeviceIDVersion(version string) DeviceServiceOption {
+	return func(s *DeviceService) {
+		s.deviceIDVersion = version
+	}
+}
+
+// WithKeyPairFactory sets the factory generating the keys of new devices, e.g. to change the RSA key size
+func WithKeyPairFactory(factory *crypto.KeyPairFactory) DeviceServiceOption {
+	return func(s *DeviceService) {
+		s.keyPairs = factory
+	}
+}
+
+// WithDefaultSignedDataFormat sets the signed data format of devices created without one
+func WithDefaultSignedDataFormat(format string) DeviceServiceOption {
+	return func(s *DeviceService) {
+		s.defaultSignedDataFormat = format
+	}
+}
+
+// WithMetrics records device creations, signatures and the duration of key generation and signing
+func WithMetrics(m *metrics.Metrics) DeviceServiceOption {
+	return func(s *DeviceService) {
+		s.metrics = m
+	}
 }
 
 // NewDeviceService function to create a new service
-func NewDeviceService(store persistence.DeviceRepository) DeviceServiceInterface {
-	return &DeviceService{store: store}
+func NewDeviceService(store persistence.DeviceRepository, options ...DeviceServiceOption) DeviceServiceInterface {
+	service := &DeviceService{
+		store:                   store,
+		idempotencyRetention:    DefaultIdempotencyRetention,
+		deviceIDVersion:         DeviceIDVersion7,
+		keyPairs:                crypto.NewKeyPairFactory(),
+		defaultSignedDataFormat: signeddata.DefaultFormat,
+	}
+	for _, option := range options {
+		option(service)
+	}
+	return service
 }
 
 // ValidateDeviceRequest validates the DeviceRequest
 func (s *DeviceService) ValidateDeviceRequest(r *request.DeviceRequest) error {
-	if r.ID == "" {
-		return errors.New("ID is required")
-	}
 	if r.Algorithm == "" {
-		return errors.New("algorithm is required")
+		return invalidField(ErrInvalidDeviceRequest, "algorithm", "is required")
+	}
+	// The ID is optional; the service generates one if it is left out
+	if r.ID != "" {
+		if _, err := uuid.Parse(r.ID); err != nil {
+			return invalidField(ErrInvalidDeviceRequest, "id", "must be a UUID")
+		}
+	}
+	if _, err := signeddata.NewFormatterFactory().GetFormatter(r.SignedDataFormat); err != nil {
+		return domain.NewValidationError(ErrInvalidSignedDataFormat, domain.FieldError{Field: "signedDataFormat", Message: "is not supported"})
+	}
+	if r.Status != "" && r.Status != string(domain.StatusActive) && r.Status != string(domain.StatusInactive) {
+		return domain.NewValidationError(ErrInvalidInitialStatus, domain.FieldError{Field: "status", Message: "must be active or inactive"})
+	}
+	if r.BoundClient != "" {
+		if err := checkClientBinding(r.BoundClient, r.ClientCertificate); err != nil {
+			return err
+		}
 	}
-	if _, err := uuid.Parse(r.ID); err != nil {
-		return errors.New("invalid UUID")
+	return validateLabelAndMetadata(r.Label, r.Metadata)
+}
+
+// checkClientBinding checks the client a request binds a device to. Callers authenticated by client certificate
+// can't change bindings, or a register could bind the devices of other registers to itself.
+func checkClientBinding(boundClient string, certificate bool) error {
+	if certificate {
+		return ErrClientBindingForbidden
+	}
+	if len(boundClient) > maxBoundClientLength {
+		return invalidField(ErrInvalidDeviceAttributes, "boundClient", fmt.Sprintf("must not be longer than %d characters", maxBoundClientLength))
 	}
 	return nil
 }
 
+// validateLabelAndMetadata checks the user-editable attributes of a device against their limits
+func validateLabelAndMetadata(label string, metadata map[string]string) error {
+	if len(label) > maxLabelLength {
+		return invalidField(ErrInvalidDeviceAttributes, "label", fmt.Sprintf("must not be longer than %d characters", maxLabelLength))
+	}
+	if len(metadata) > maxMetadataEntries {
+		return invalidField(ErrInvalidDeviceAttributes, "metadata", fmt.Sprintf("must not have more than %d entries", maxMetadataEntries))
+	}
+	for key, value := range metadata {
+		if key == "" || len(key) > maxMetadataKeyLength {
+			return invalidField(ErrInvalidDeviceAttributes, "metadata", fmt.Sprintf("keys must have between 1 and %d characters", maxMetadataKeyLength))
+		}
+		if len(value) > maxMetadataValueLength {
+			return invalidField(ErrInvalidDeviceAttributes, "metadata."+key, fmt.Sprintf("must not be longer than %d characters", maxMetadataValueLength))
+		}
+	}
+	return nil
+}
+
+// invalidField returns a validation error for a single field of a request, e.g.
+// "invalid device attributes: label must not be longer than 255 characters"
+func invalidField(err error, field, message string) error {
+	return domain.NewValidationError(fmt.Errorf("%w: %s %s", err, field, message), domain.FieldError{Field: field, Message: message})
+}
+
+// newDeviceID generates the ID of a device created without one
+func (s *DeviceService) newDeviceID() (string, error) {
+	var id uuid.UUID
+	var err error
+	switch s.deviceIDVersion {
+	case DeviceIDVersion4:
+		id, err = uuid.NewRandom()
+	case DeviceIDVersion7:
+		id, err = uuid.NewV7()
+	default:
+		return "", fmt.Errorf("unsupported device ID version %q", s.deviceIDVersion)
+	}
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// tenantOrDefault returns the tenant a request acts for; callers without a tenant act for the default tenant
+func tenantOrDefault(tenantID string) string {
+	if tenantID == "" {
+		return domain.DefaultTenantID
+	}
+	return tenantID
+}
+
+// getTenantDevice retrieves a device owned by the given tenant; devices of other tenants are not found
+func (s *DeviceService) getTenantDevice(ctx context.Context, tenantID, deviceID string) (*domain.SignatureDevice, error) {
+	device, err := s.store.GetTenantDevice(ctx, tenantOrDefault(tenantID), deviceID)
+	if errors.Is(err, domain.ErrDeviceNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device: %w", err)
+	}
+	return device, nil
+}
+
+// lockDevice locks the given device for signing and returns the function releasing the lock
+func (s *DeviceService) lockDevice(deviceID string) func() {
+	hash := fnv.New32a()
+	hash.Write([]byte(deviceID))
+	mutex := &s.locks[hash.Sum32()%deviceLockStripes]
+	mutex.Lock()
+	return mutex.Unlock
+}
+
+// lockTenantDevice locks a device of a tenant once it has been found and returns it as read under the lock,
+// together with the function releasing the lock. IDs of missing devices or of other tenants are never locked.
+func (s *DeviceService) lockTenantDevice(ctx context.Context, tenantID, deviceID string) (*domain.SignatureDevice, func(), error) {
+	found, err := s.getTenantDevice(ctx, tenantID, deviceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	found.ClearPrivateKey()
+	unlock := s.lockDevice(deviceID)
+	// The device may have changed while waiting for the lock
+	device, err := s.getTenantDevice(ctx, tenantID, deviceID)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	return device, unlock, nil
+}
+
+// signingLimitsFromRequest validates and converts the signing limits of a request
+func signingLimitsFromRequest(req *request.SigningLimitsRequest) (domain.SigningLimits, error) {
+	if req == nil {
+		return domain.SigningLimits{}, nil
+	}
+
+	var notBefore, notAfter time.Time
+	if req.NotBefore != nil {
+		notBefore = req.NotBefore.UTC()
+	}
+	if req.NotAfter != nil {
+		notAfter = req.NotAfter.UTC()
+	}
+	if !notBefore.IsZero() && !notAfter.IsZero() && !notAfter.After(notBefore) {
+		return domain.SigningLimits{}, invalidField(ErrInvalidDeviceAttributes, "limits.notAfter", "must be after notBefore")
+	}
+	return domain.NewSigningLimits(req.MaxSignatures, req.DailyLimit, notBefore, notAfter), nil
+}
+
+// signaturesToday counts the signatures a device created since the start of the day, if it has a daily limit
+func (s *DeviceService) signaturesToday(ctx context.Context, device *domain.SignatureDevice, now time.Time) (uint64, error) {
+	if device.GetSigningLimits().GetDailyLimit() == 0 {
+		return 0, nil
+	}
+	return s.store.CountSignatureRecords(ctx, device.GetID(), domain.StartOfDay(now))
+}
+
+// policyDocument validates a policy document from a request and returns its normalized form,
+// which is empty for a policy without rules
+func policyDocument(document json.RawMessage) (string, error) {
+	signingPolicy, err := policy.Parse(document)
+	if err != nil {
+		return "", err
+	}
+	return string(signingPolicy.Document()), nil
+}
+
+// rawPolicy returns a stored policy document for a response, nil if the device has no policy
+func rawPolicy(document string) json.RawMessage {
+	if document == "" {
+		return nil
+	}
+	return json.RawMessage(document)
+}
+
+// checkPolicy evaluates the signing policy of a device against a sign request
+func checkPolicy(device *domain.SignatureDevice, req *request.SignTransactionRequest, payload []byte, at time.Time) error {
+	if device.GetPolicy() == "" {
+		return nil
+	}
+	signingPolicy, err := policy.Parse([]byte(device.GetPolicy()))
+	if err != nil {
+		return fmt.Errorf("failed to load device policy: %w", err)
+	}
+
+	// Digests hide the transaction data from content rules
+	data := payload
+	if req.Mode == signeddata.ModeDigest {
+		data = nil
+	}
+	return signingPolicy.Evaluate(policy.Input{ClientID: req.ClientID, Data: data, At: at})
+}
+
+// toDeviceResponse maps a signature device to its API response, including its remaining quotas
+func (s *DeviceService) toDeviceResponse(ctx context.Context, device *domain.SignatureDevice) (*response.DeviceResponse, error) {
+	signedToday, err := s.signaturesToday(ctx, device, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to count signatures: %w", err)
+	}
+	signedDataFormat := device.GetSignedDataFormat()
+	if signedDataFormat == "" {
+		signedDataFormat = signeddata.DefaultFormat
+	}
+
+	var deletedAt *time.Time
+	if device.IsDeleted() {
+		at := device.GetDeletedAt()
+		deletedAt = &at
+	}
+
+	limits := device.GetSigningLimits()
+	limitsResponse := response.SigningLimitsResponse{
+		MaxSignatures: limits.GetMaxSignatures(),
+		DailyLimit:    limits.GetDailyLimit(),
+	}
+	if notBefore := limits.GetNotBefore(); !notBefore.IsZero() {
+		limitsResponse.NotBefore = &notBefore
+	}
+	if notAfter := limits.GetNotAfter(); !notAfter.IsZero() {
+		limitsResponse.NotAfter = &notAfter
+	}
+
+	var remainingSignatures, remainingSignaturesToday *uint64
+	if remaining, limited := device.RemainingSignatures(); limited {
+		remainingSignatures = &remaining
+	}
+	if remaining, limited := device.RemainingSignaturesToday(signedToday); limited {
+		remainingSignaturesToday = &remaining
+	}
+
+	return &response.DeviceResponse{
+		ID:                       device.GetID(),
+		TenantID:                 device.GetTenantID(),
+		PublicKey:                device.GetPublicKey(),
+		Label:                    device.GetLabel(),
+		SignatureCount:           device.GetSignatureCount(),
+		SignedDataFormat:         signedDataFormat,
+		Status:                   string(device.GetStatus()),
+		Metadata:                 device.GetMetadata(),
+		Limits:                   limitsResponse,
+		Policy:                   rawPolicy(device.GetPolicy()),
+		BoundClient:              device.GetBoundClient(),
+		RemainingSignatures:      remainingSignatures,
+		RemainingSignaturesToday: remainingSignaturesToday,
+		CreatedAt:                device.GetCreatedAt(),
+		UpdatedAt:                device.GetUpdatedAt(),
+		DeletedAt:                deletedAt,
+	}, nil
+}
+
 // CreateSignatureDevice creates a new signature device
-func (s *DeviceService) CreateSignatureDevice(req *request.DeviceRequest) (*response.DeviceResponse, error) {
+func (s *DeviceService) CreateSignatureDevice(ctx context.Context, req *request.DeviceRequest) (created *response.DeviceResponse, err error) {
 	var publicKey, privateKey []byte
-	var err error
+	ctx, span := tracer.Start(ctx, "DeviceService.CreateSignatureDevice", trace.WithAttributes(attribute.String("signing.algorithm", req.Algorithm)))
+	defer func() {
+		s.metrics.DeviceCreated(domain.AlgorithmType(req.Algorithm), err)
+		tracing.End(span, err)
+	}()
 
 	// Validate the request
 	if err = s.ValidateDeviceRequest(req); err != nil {
 		return nil, err
 	}
+	limits, err := signingLimitsFromRequest(req.Limits)
+	if err != nil {
+		return nil, err
+	}
+	document, err := policyDocument(req.Policy)
+	if err != nil {
+		return nil, err
+	}
 
 	// Generate key pair based on the algorithm using the factory.
-	factory := crypto.NewKeyPairFactory()
-	keyGenerator, err := factory.GetKeyPair(domain.AlgorithmType(req.Algorithm))
+	keyGenerator, err := s.keyPairs.GetKeyPair(domain.AlgorithmType(req.Algorithm))
 	if err != nil {
-		return nil, errors.New("invalid algorithm")
+		return nil, domain.NewValidationError(ErrInvalidAlgorithm, domain.FieldError{Field: "algorithm", Message: "must be RSA or ECC"})
 	}
 
-	publicKey, privateKey, err = keyGenerator.GenerateKeyPair()
+	started := time.Now()
+	publicKey, privateKey, err = keyGenerator.GenerateKeyPair(ctx)
+	s.metrics.ObserveKeyGeneration(domain.AlgorithmType(req.Algorithm), time.Since(started))
 	if err != nil {
-		return nil, errors.New("key generation failed")
+		return nil, fmt.Errorf("key generation failed: %w", err)
+	}
+
+	signedDataFormat := req.SignedDataFormat
+	if signedDataFormat == "" {
+		signedDataFormat = s.defaultSignedDataFormat
+	}
+
+	deviceID := req.ID
+	if deviceID == "" {
+		if deviceID, err = s.newDeviceID(); err != nil {
+			return nil, fmt.Errorf("device ID generation failed: %w", err)
+		}
 	}
 
-	// Create and store the device
-	device, err := s.store.AddDevice(req.ID, req.Label, domain.AlgorithmType(req.Algorithm), string(publicKey), string(privateKey), "")
+	span.SetAttributes(attribute.String("signing.device_id", deviceID))
+
+	// Create and store the device; the generated key bytes are wiped once the store holds its own copy
+	device := domain.NewSignatureDevice(deviceID, req.Label, domain.AlgorithmType(req.Algorithm), string(publicKey), privateKey, "")
+	defer device.ClearPrivateKey()
+	device.SetTenantID(tenantOrDefault(req.TenantID))
+	device.SetSignedDataFormat(signedDataFormat)
+	if req.Status == string(domain.StatusInactive) {
+		device.SetStatus(domain.StatusInactive)
+	}
+	device.SetMetadata(req.Metadata)
+	device.SetSigningLimits(limits)
+	device.SetBoundClient(req.BoundClient)
+	// The policy is stored with the device, so it applies from the first signature on
+	device.SetPolicy(document)
+	err = s.store.CreateDevice(ctx, device)
+	if errors.Is(err, domain.ErrDeviceAlreadyExists) {
+		return nil, err
+	}
 	if err != nil {
+		return nil, fmt.Errorf("failed to add device: %w", err)
+	}
 
-		if err.Error() == "device with this ID already exists" {
-			return nil, errors.New("device with this ID already exists")
+	// The initial policy is audited like any later change
+	if document != "" {
+		change := domain.NewPolicyChange(deviceID, "", document, req.ClientID, device.GetCreatedAt())
+		if err = s.store.UpdateDevicePolicy(ctx, change); err != nil {
+			return nil, fmt.Errorf("failed to record device policy: %w", err)
 		}
-		return nil, errors.New("failed to add device")
 	}
 
+	logging.FromContext(ctx).InfoContext(ctx, "device created", slog.Any("device", device))
+
 	// Return response
-	return &response.DeviceResponse{
-		ID:             device.GetID(),
-		PublicKey:      device.GetPublicKey(),
-		Label:          device.GetLabel(),
-		SignatureCount: device.GetSignatureCount(),
-	}, nil
+	return s.toDeviceResponse(ctx, device)
 }
 
 // ValidateSignTransactionRequest validates the SignTransactionRequest
 func (s *DeviceService) ValidateSignTransactionRequest(req *request.SignTransactionRequest) error {
 	if req.DeviceID == "" {
-		return errors.New("DeviceID is required")
+		return invalidField(ErrInvalidSignRequest, "deviceId", "is required")
+	}
+	if req.Data == "" && len(req.Payload) == 0 {
+		return invalidField(ErrInvalidSignRequest, "data", "is required")
 	}
-	if req.Data == "" {
-		return errors.New("data is required")
+	if req.Data != "" && len(req.Payload) != 0 {
+		return signeddata.ErrAmbiguousPayload
 	}
 	if _, err := uuid.Parse(req.DeviceID); err != nil {
-		return errors.New("invalid UUID for DeviceID")
+		return invalidField(ErrInvalidSignRequest, "deviceId", "must be a UUID")
+	}
+	if len(req.IdempotencyKey) > 255 {
+		return invalidField(ErrInvalidSignRequest, "Idempotency-Key", "must not be longer than 255 characters")
 	}
 	return nil
 }
 
+// requestHash identifies the content of a sign request, so replays of an idempotency key can be compared
+func requestHash(req *request.SignTransactionRequest, data, dataEncoding string) string {
+	// JSON payloads are compared in their canonical form, passed in as data
+	fields, _ := json.Marshal([]string{req.DeviceID, data, dataEncoding, req.Mode})
+	return hex.EncodeToString(utils.HashData(string(fields)))
+}
+
+// replayResponse rebuilds the response of a previously created signature
+func replayResponse(record *domain.SignatureRecord) *response.SignTransactionResponse {
+	return &response.SignTransactionResponse{
+		Signature:          record.GetSignature(),
+		SignedData:         record.GetSignedData(),
+		SignedDataEncoding: record.GetSignedDataEncoding(),
+		FormatVersion:      record.GetFormatVersion(),
+		Replayed:           true,
+	}
+}
+
+// purgeExpiredIdempotencyKeys drops expired idempotency keys, at most once per idempotencyPurgeInterval
+func (s *DeviceService) purgeExpiredIdempotencyKeys(ctx context.Context, now time.Time) {
+	last := s.lastIdempotencyPurge.Load()
+	if now.UnixNano()-last < int64(idempotencyPurgeInterval) || !s.lastIdempotencyPurge.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	// Lookups ignore expired keys anyway, so a failed purge is retried with the next one
+	if err := s.store.ExpireIdempotencyKeys(ctx, now.Add(-s.idempotencyRetention)); err != nil {
+		logging.FromContext(ctx).WarnContext(ctx, "failed to purge expired idempotency keys", "error", err.Error())
+	}
+}
+
 // SignTransaction signs the transaction data with the specified device
-func (s *DeviceService) SignTransaction(req *request.SignTransactionRequest) (*response.SignTransactionResponse, error) {
+func (s *DeviceService) SignTransaction(ctx context.Context, req *request.SignTransactionRequest) (signed *response.SignTransactionResponse, err error) {
+	// The algorithm is unknown until the device has been loaded
+	var algorithm domain.AlgorithmType
+	ctx, span := tracer.Start(ctx, "DeviceService.SignTransaction", trace.WithAttributes(attribute.String("signing.device_id", req.DeviceID)))
+	defer func() {
+		s.metrics.TransactionSigned(algorithm, err)
+		tracing.End(span, err)
+	}()
 
 	// Validate the request
 	if err := s.ValidateSignTransactionRequest(req); err != nil {
 		return nil, err
 	}
 
-	// Retrieve the signature device
-	device, err := s.store.GetDevice(req.DeviceID)
+	// Decode the transaction data and check the signing mode
+	data, dataEncoding := req.Data, req.DataEncoding
+	if len(req.Payload) != 0 {
+		// JSON payloads are signed in their canonical form
+		canonical, err := canonicalizePayload(req)
+		if err != nil {
+			return nil, err
+		}
+		data, dataEncoding = string(canonical), signeddata.EncodingUTF8
+	}
+	payload, err := signeddata.DecodePayload(data, dataEncoding)
 	if err != nil {
-		return nil, errors.New("device not found")
+		return nil, err
+	}
+	if err := signeddata.ValidateMode(req.Mode, payload); err != nil {
+		return nil, err
 	}
 
-	// Variable to hold the signed data
-	var signedData string
+	// Signatures of one device are created one at a time to keep the counter gapless
+	device, unlock, err := s.lockTenantDevice(ctx, req.TenantID, req.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	defer device.ClearPrivateKey()
+	algorithm = device.GetAlgorithm()
+	span.SetAttributes(attribute.String("signing.algorithm", string(algorithm)))
+
+	// Registers authenticated by client certificate only sign with the devices bound to them
+	if req.ClientCertificate && !device.IsBoundTo(req.ClientID) {
+		return nil, ErrDeviceNotBound
+	}
+
+	// Return the original signature when an idempotency key is replayed, even if the device can no longer
+	// sign, so clients retrying after a timeout get the result of the request that went through
+	timestamp := time.Now().UTC()
+	var hash string
+	if req.IdempotencyKey != "" {
+		s.purgeExpiredIdempotencyKeys(ctx, timestamp)
+
+		hash = requestHash(req, data, dataEncoding)
+		record, err := s.store.GetSignatureRecordByIdempotencyKey(ctx, req.DeviceID, req.IdempotencyKey, timestamp.Add(-s.idempotencyRetention))
+		if err == nil {
+			if record.GetRequestHash() != hash {
+				return nil, ErrIdempotencyKeyReused
+			}
+			span.SetAttributes(attribute.Bool("signing.replayed", true))
+			logging.FromContext(ctx).InfoContext(ctx, "signature replayed", slog.Any("signature", record))
+			return replayResponse(record), nil
+		}
+		if !errors.Is(err, domain.ErrSignatureNotFound) {
+			return nil, fmt.Errorf("failed to look up idempotency key: %w", err)
+		}
+	}
 
-	// Prepare signed data using either the last signature or the device ID
+	// Deleted devices have no private key left
+	if device.IsDeleted() {
+		return nil, domain.ErrDeviceDeleted
+	}
+
+	// Only active devices can sign
+	if !device.CanSign() {
+		return nil, domain.ErrDeviceNotActive
+	}
+
+	// Enforce the validity window and quotas; the device lock makes the check and the signature atomic
+	signedToday, err := s.signaturesToday(ctx, device, timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count signatures: %w", err)
+	}
+	if err := device.CheckSigningLimits(timestamp, signedToday); err != nil {
+		return nil, err
+	}
+
+	// Enforce the signing policy of the device before anything is signed
+	if err := checkPolicy(device, req, payload, timestamp); err != nil {
+		return nil, err
+	}
+
+	// Chain reference: the last signature, or the base64 encoded device ID for the first transaction
+	chainReference := device.GetLastSignature()
 	if device.GetSignatureCount() == 0 {
-		// First transaction - use the device ID instead of the last signature
-		signedData = fmt.Sprintf("%d_%s_%s", device.GetSignatureCount(), req.Data, utils.Base64Encode(device.GetID()))
-	} else {
-		// Use the last saved signature
-		signedData = fmt.Sprintf("%d_%s_%s", device.GetSignatureCount(), req.Data, device.GetLastSignature())
+		chainReference = utils.Base64Encode(device.GetID())
 	}
 
-	// Choose the signing algorithm based on the device's private key using the factory.
-	factory := crypto.NewKeyPairFactory()
-	keyGenerator, err := factory.GetKeyPair(device.GetAlgorithm())
+	// Build the signed data with the format selected for the device
+	formatter, err := signeddata.NewFormatterFactory().GetFormatter(device.GetSignedDataFormat())
 	if err != nil {
-		return nil, errors.New("invalid algorithm")
+		return nil, err
 	}
 
-	signer, err := keyGenerator.UnmarshalPrivateKey([]byte(device.GetPrivateKey()))
+	counter := device.GetSignatureCount()
+	span.SetAttributes(attribute.Int64("signing.counter", int64(counter)))
+	signedData, signedDataEncoding, err := formatter.Format(signeddata.Input{
+		DeviceID:       device.GetID(),
+		Counter:        counter,
+		Data:           data,
+		DataEncoding:   dataEncoding,
+		Mode:           req.Mode,
+		Payload:        payload,
+		ChainReference: chainReference,
+		Timestamp:      timestamp,
+	})
 	if err != nil {
-		return nil, errors.New("failed to unmarshal private key")
+		return nil, fmt.Errorf("failed to build signed data: %w", err)
 	}
 
-	signature, err := signer.Sign([]byte(signedData))
+	// Choose the signing algorithm based on the device's private key using the factory.
+	keyGenerator, err := s.keyPairs.GetKeyPair(device.GetAlgorithm())
 	if err != nil {
-		return nil, errors.New("signing failed")
+		return nil, fmt.Errorf("invalid algorithm of the device: %w", err)
 	}
 
-	// Update the last signature with the new signature
-	err = s.store.UpdateLastSignature(req.DeviceID, utils.Base64Encode(string(signature)))
+	privateKey := device.GetPrivateKey()
+	signer, err := keyGenerator.UnmarshalPrivateKey(privateKey)
+	clear(privateKey)
 	if err != nil {
-		return nil, errors.New("failed to update last signature")
+		return nil, fmt.Errorf("failed to unmarshal private key: %w", err)
 	}
 
-	// Increment the signature count
-	err = s.store.IncrementSignatureCount(req.DeviceID)
+	started := time.Now()
+	signature, err := signer.Sign(ctx, signedData)
+	s.metrics.ObserveSigning(algorithm, time.Since(started))
 	if err != nil {
-		return nil, errors.New("failed to increment signature count")
+		return nil, fmt.Errorf("signing failed: %w", err)
+	}
+
+	// Binary signed data is returned base64 encoded
+	encodedSignedData := string(signedData)
+	if signedDataEncoding == signeddata.EncodingBase64 {
+		encodedSignedData = utils.Base64Encode(encodedSignedData)
 	}
+	encodedSignature := utils.Base64Encode(string(signature))
+
+	// Record the signature together with the format version; it becomes the last signature of the device
+	// and advances its counter in the same step, so a failure leaves the device as it was
+	record := domain.NewSignatureRecord(device.GetID(), counter, encodedSignature, encodedSignedData, signedDataEncoding, formatter.Version(), timestamp)
+	record.SetIdempotencyKey(req.IdempotencyKey, hash)
+	err = s.store.AppendSignature(ctx, record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record signature: %w", err)
+	}
+
+	logging.FromContext(ctx).InfoContext(ctx, "transaction signed", slog.Any("signature", record))
 
 	return &response.SignTransactionResponse{
-		Signature:  utils.Base64Encode(string(signature)),
-		SignedData: signedData,
+		Signature:          encodedSignature,
+		SignedData:         encodedSignedData,
+		SignedDataEncoding: signedDataEncoding,
+		FormatVersion:      formatter.Version(),
 	}, nil
 }
 
+// canonicalizePayload returns the canonical form of a JSON object payload
+func canonicalizePayload(req *request.SignTransactionRequest) ([]byte, error) {
+	if req.DataEncoding != "" || req.Mode == signeddata.ModeDigest {
+		return nil, signeddata.ErrPayloadEncoding
+	}
+
+	canonical, err := signeddata.CanonicalizeJSON(req.Payload)
+	if err != nil {
+		return nil, err
+	}
+	if canonical[0] != '{' {
+		return nil, signeddata.ErrNotJSONObject
+	}
+	return canonical, nil
+}
+
 // ListSignatureDevices method to list signature devices
-func (s *DeviceService) ListSignatureDevices() ([]*response.DeviceResponse, error) {
-	devices, _ := s.store.ListDevices()
+func (s *DeviceService) ListSignatureDevices(ctx context.Context, tenantID string) ([]*response.DeviceResponse, error) {
+	deviceResponses := []*response.DeviceResponse{}
 
-	if len(devices) == 0 {
-		return []*response.DeviceResponse{}, nil
+	req := &request.ListDevicesRequest{TenantID: tenantID, Limit: persistence.MaxPageSize}
+	for {
+		page, err := s.QuerySignatureDevices(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		deviceResponses = append(deviceResponses, page.Devices...)
+		if page.NextCursor == "" {
+			return deviceResponses, nil
+		}
+		req.Cursor = page.NextCursor
 	}
+}
 
-	var deviceResponses []*response.DeviceResponse
-	for _, device := range devices {
-		deviceResponses = append(deviceResponses, &response.DeviceResponse{
-			ID:             device.GetID(),
-			PublicKey:      device.GetPublicKey(),
-			Label:          device.GetLabel(),
-			SignatureCount: device.GetSignatureCount(),
-		})
+// QuerySignatureDevices method to list a page of the signature devices matching the request
+func (s *DeviceService) QuerySignatureDevices(ctx context.Context, req *request.ListDevicesRequest) (*response.DeviceListResponse, error) {
+	if req.Order != "" && req.Order != "asc" && req.Order != "desc" {
+		return nil, invalidField(ErrInvalidListRequest, "order", "must be asc or desc")
+	}
+	if req.Limit < 0 || req.Limit > persistence.MaxPageSize {
+		return nil, invalidField(ErrInvalidListRequest, "limit", fmt.Sprintf("must be between 1 and %d", persistence.MaxPageSize))
+	}
+
+	page, err := s.store.QueryDevices(ctx, persistence.DeviceQuery{
+		TenantID:       tenantOrDefault(req.TenantID),
+		Algorithm:      domain.AlgorithmType(req.Algorithm),
+		LabelPrefix:    req.LabelPrefix,
+		Status:         domain.DeviceStatus(req.Status),
+		CreatedFrom:    req.CreatedFrom,
+		CreatedTo:      req.CreatedTo,
+		IncludeDeleted: req.IncludeDeleted,
+		SortBy:         req.SortBy,
+		Descending:     req.Order == "desc",
+		Cursor:         req.Cursor,
+		Limit:          req.Limit,
+	})
+	if err != nil {
+		if errors.Is(err, persistence.ErrInvalidCursor) || errors.Is(err, persistence.ErrInvalidSortField) {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidListRequest, err)
+		}
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	deviceResponses := make([]*response.DeviceResponse, 0, len(page.Devices))
+	for _, device := range page.Devices {
+		deviceResponse, err := s.toDeviceResponse(ctx, device)
+		if err != nil {
+			return nil, err
+		}
+		deviceResponses = append(deviceResponses, deviceResponse)
 	}
-	return deviceResponses, nil
+	return &response.DeviceListResponse{
+		Devices:    deviceResponses,
+		NextCursor: page.NextCursor,
+	}, nil
 }
 
 // GetSignatureDeviceById method to retrieve the specified device's information by ID
-func (s *DeviceService) GetSignatureDeviceById(deviceID string) (*response.DeviceResponse, error) {
-	device, err := s.store.GetDevice(deviceID)
+func (s *DeviceService) GetSignatureDeviceById(ctx context.Context, tenantID, deviceID string) (*response.DeviceResponse, error) {
+	device, err := s.getTenantDevice(ctx, tenantID, deviceID)
 	if err != nil {
-		return nil, errors.New("device not found")
+		return nil, err
 	}
 
-	return &response.DeviceResponse{
-		ID:             device.GetID(),
-		PublicKey:      device.GetPublicKey(),
-		Label:          device.GetLabel(),
-		SignatureCount: device.GetSignatureCount(),
+	return s.toDeviceResponse(ctx, device)
+}
+
+// UpdateSignatureDevice updates the label and signing limits and merges the metadata of a device
+func (s *DeviceService) UpdateSignatureDevice(ctx context.Context, tenantID, deviceID string, req *request.UpdateDeviceRequest) (*response.DeviceResponse, error) {
+	// Updates of the same device are applied one at a time so metadata merges are not lost
+	device, unlock, err := s.lockTenantDevice(ctx, tenantID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if device.IsDeleted() {
+		return nil, domain.ErrDeviceDeleted
+	}
+
+	if req.Label != nil {
+		device.SetLabel(*req.Label)
+	}
+	if req.BoundClient != nil {
+		if err := checkClientBinding(*req.BoundClient, req.ClientCertificate); err != nil {
+			return nil, err
+		}
+		device.SetBoundClient(*req.BoundClient)
+	}
+	metadata := device.GetMetadata()
+	for key, value := range req.Metadata {
+		if value == nil {
+			delete(metadata, key)
+		} else {
+			metadata[key] = *value
+		}
+	}
+	device.SetMetadata(metadata)
+	if req.Limits != nil {
+		limits, err := signingLimitsFromRequest(req.Limits)
+		if err != nil {
+			return nil, err
+		}
+		device.SetSigningLimits(limits)
+	}
+	device.SetUpdatedAt(time.Now().UTC())
+
+	if err = validateLabelAndMetadata(device.GetLabel(), metadata); err != nil {
+		return nil, err
+	}
+
+	if err = s.store.UpdateDevice(ctx, device); err != nil {
+		return nil, fmt.Errorf("failed to update device: %w", err)
+	}
+
+	return s.toDeviceResponse(ctx, device)
+}
+
+// DeleteSignatureDevice destroys the private key of a device and keeps a tombstone with its public key
+// and final counter, so that the signatures it created remain verifiable
+func (s *DeviceService) DeleteSignatureDevice(ctx context.Context, tenantID, deviceID string) (*response.DeviceResponse, error) {
+	// Deletion waits for signatures in progress
+	device, unlock, err := s.lockTenantDevice(ctx, tenantID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err = device.Delete(time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	if err = s.store.DeleteDevice(ctx, deviceID, device.GetDeletedAt()); err != nil {
+		if errors.Is(err, domain.ErrDeviceDeleted) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to delete device: %w", err)
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "device deleted", slog.Any("device", device))
+
+	return s.toDeviceResponse(ctx, device)
+}
+
+// ChangeDeviceStatus applies a lifecycle action to a device and records the transition
+func (s *DeviceService) ChangeDeviceStatus(ctx context.Context, tenantID, deviceID string, req *request.DeviceStatusRequest) (*response.DeviceStatusResponse, error) {
+	// Status changes wait for signatures in progress
+	device, unlock, err := s.lockTenantDevice(ctx, tenantID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	transition, err := device.ApplyStatusAction(domain.StatusAction(req.Action), req.Reason, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.store.UpdateDeviceStatus(ctx, transition); err != nil {
+		if errors.Is(err, domain.ErrInvalidStatusTransition) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to update device status: %w", err)
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "device status changed", slog.String("device_id", deviceID),
+		slog.String("from", string(transition.GetFrom())), slog.String("to", string(transition.GetTo())))
+
+	return s.GetDeviceStatus(ctx, tenantID, deviceID)
+}
+
+// GetDeviceStatus retrieves the lifecycle state of a device and its transition history
+func (s *DeviceService) GetDeviceStatus(ctx context.Context, tenantID, deviceID string) (*response.DeviceStatusResponse, error) {
+	device, err := s.getTenantDevice(ctx, tenantID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	transitions, err := s.store.ListStatusTransitions(ctx, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list status transitions: %w", err)
+	}
+
+	history := make([]*response.StatusTransitionResponse, 0, len(transitions))
+	for _, transition := range transitions {
+		history = append(history, &response.StatusTransitionResponse{
+			From:   string(transition.GetFrom()),
+			To:     string(transition.GetTo()),
+			Action: string(transition.GetAction()),
+			Reason: transition.GetReason(),
+			At:     transition.GetAt(),
+		})
+	}
+
+	return &response.DeviceStatusResponse{
+		ID:      device.GetID(),
+		Status:  string(device.GetStatus()),
+		History: history,
+	}, nil
+}
+
+// SetDevicePolicy replaces the signing policy of a device and records the change
+func (s *DeviceService) SetDevicePolicy(ctx context.Context, tenantID, deviceID string, req *request.DevicePolicyRequest) (*response.DevicePolicyResponse, error) {
+	// Policy changes wait for signatures in progress
+	device, unlock, err := s.lockTenantDevice(ctx, tenantID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if device.IsDeleted() {
+		return nil, domain.ErrDeviceDeleted
+	}
+
+	document, err := policyDocument(req.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	change := domain.NewPolicyChange(deviceID, device.GetPolicy(), document, req.ChangedBy, time.Now().UTC())
+	if err = s.store.UpdateDevicePolicy(ctx, change); err != nil {
+		return nil, fmt.Errorf("failed to update device policy: %w", err)
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "device policy changed", slog.String("device_id", deviceID), slog.String("changed_by", req.ChangedBy))
+
+	return s.GetDevicePolicy(ctx, tenantID, deviceID)
+}
+
+// GetDevicePolicy retrieves the signing policy of a device and its change history
+func (s *DeviceService) GetDevicePolicy(ctx context.Context, tenantID, deviceID string) (*response.DevicePolicyResponse, error) {
+	device, err := s.getTenantDevice(ctx, tenantID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := s.store.ListPolicyChanges(ctx, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list policy changes: %w", err)
+	}
+
+	history := make([]*response.PolicyChangeResponse, 0, len(changes))
+	for _, change := range changes {
+		history = append(history, &response.PolicyChangeResponse{
+			PreviousPolicy: rawPolicy(change.GetPreviousPolicy()),
+			Policy:         rawPolicy(change.GetPolicy()),
+			ChangedBy:      change.GetChangedBy(),
+			At:             change.GetAt(),
+		})
+	}
+
+	return &response.DevicePolicyResponse{
+		ID:      device.GetID(),
+		Policy:  rawPolicy(device.GetPolicy()),
+		History: history,
 	}, nil
 }
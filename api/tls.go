@@ -0,0 +1,140 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"net/http"
+	"os"
+)
+
+// Client authentication modes of TLSSettings
+const (
+	// ClientAuthNone doesn't ask clients for certificates
+	ClientAuthNone = "none"
+	// ClientAuthOptional verifies client certificates if clients send one
+	ClientAuthOptional = "optional"
+	// ClientAuthRequire rejects connections without a valid client certificate
+	ClientAuthRequire = "require"
+)
+
+// DefaultCertificateScopes are the scopes granted to clients authenticated by a TLS client certificate
+// unless the server is given another authenticator
+var DefaultCertificateScopes = []domain.Scope{domain.ScopeDevicesRead, domain.ScopeSign}
+
+// Cipher policies of TLSSettings
+const (
+	// CipherPolicyModern allows only forward-secret AEAD cipher suites for TLS 1.2
+	CipherPolicyModern = "modern"
+	// CipherPolicyCompatible allows the default cipher suites of Go
+	CipherPolicyCompatible = "compatible"
+)
+
+// modernCipherSuites are the TLS 1.2 cipher suites of CipherPolicyModern. TLS 1.3 suites are always secure
+// and not configurable.
+var modernCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// TLSSettings configures the TLS listener of the server
+type TLSSettings struct {
+	// CertFile and KeyFile are the PEM files of the server certificate (chain) and its private key
+	CertFile string
+	KeyFile  string
+	// MinVersion is the minimum TLS version, "1.2" (default) or "1.3"
+	MinVersion string
+	// CipherPolicy is CipherPolicyModern (default) or CipherPolicyCompatible
+	CipherPolicy string
+	// ClientCAFile is the PEM bundle of the CAs client certificates are verified against
+	ClientCAFile string
+	// ClientAuth is ClientAuthNone (default), ClientAuthOptional or ClientAuthRequire
+	ClientAuth string
+}
+
+// NewTLSConfig loads the certificates of the settings into a TLS configuration
+func NewTLSConfig(settings TLSSettings) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %v", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{certificate}}
+
+	switch settings.MinVersion {
+	case "", "1.2":
+		config.MinVersion = tls.VersionTLS12
+	case "1.3":
+		config.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("invalid minimum TLS version %q. Use '1.2' or '1.3'", settings.MinVersion)
+	}
+
+	switch settings.CipherPolicy {
+	case "", CipherPolicyModern:
+		config.CipherSuites = modernCipherSuites
+	case CipherPolicyCompatible:
+	default:
+		return nil, fmt.Errorf("invalid cipher policy %q. Use 'modern' or 'compatible'", settings.CipherPolicy)
+	}
+
+	switch settings.ClientAuth {
+	case "", ClientAuthNone:
+		return config, nil
+	case ClientAuthOptional:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid client authentication %q. Use 'none', 'optional' or 'require'", settings.ClientAuth)
+	}
+
+	if settings.ClientCAFile == "" {
+		return nil, errors.New("a client CA bundle is required to verify client certificates")
+	}
+	bundle, err := os.ReadFile(settings.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client CA bundle: %v", err)
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(bundle) {
+		return nil, errors.New("client CA bundle contains no certificates")
+	}
+	return config, nil
+}
+
+// CertificateAuthenticator authenticates requests by the verified TLS client certificate of the connection.
+// The first organization of the subject is the tenant and the common name the client ID, which devices are
+// bound to with their boundClient; callers authenticated this way only sign with the devices bound to them.
+type CertificateAuthenticator struct {
+	scopes []domain.Scope
+}
+
+// NewCertificateAuthenticator creates an authenticator granting the given scopes to clients with a verified certificate
+func NewCertificateAuthenticator(scopes []domain.Scope) *CertificateAuthenticator {
+	return &CertificateAuthenticator{scopes: scopes}
+}
+
+// Authenticate maps the subject of the client certificate to the identity of the caller
+func (authenticator *CertificateAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	// Only chains verified against the client CAs count; certificates are not verified without them
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, ErrUnauthenticated
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+
+	clientID := subject.CommonName
+	if clientID == "" {
+		clientID = subject.String()
+	}
+	// Certificates must name their tenant; the default tenant holds the devices of all legacy clients
+	if len(subject.Organization) == 0 || subject.Organization[0] == "" {
+		return nil, ErrUnauthenticated
+	}
+	return &Identity{TenantID: subject.Organization[0], ClientID: clientID, Scopes: authenticator.scopes, Certificate: true}, nil
+}
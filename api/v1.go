@@ -0,0 +1,457 @@
+package api
+
+import (
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/buildinfo"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/health"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/request"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/v1"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/signeddata"
+	"net/http"
+)
+
+// The v1 API addresses devices by path, uses camelCase JSON and wraps every successful response in the
+// Response envelope. Errors are problem details like in the v0 API, see WriteError.
+
+// WriteV1Response writes data in the Response envelope
+func WriteV1Response(w http.ResponseWriter, code int, data interface{}) {
+	WriteAPIResponse(w, code, Response{Data: data})
+}
+
+// HealthV1Handler API handler for the health check of the v1 API
+// @Summary Health check
+// @Description Evaluates the health of the service.
+// @Tags health
+// @Produce json
+// @Success 200 {object} Response{data=HealthResponse} "Successful response"
+// @Failure 503 {object} Response{data=HealthResponse} "A readiness check failed"
+// @Router /api/v1/health [get]
+func (s *Server) HealthV1Handler(w http.ResponseWriter, r *http.Request) {
+	report := s.health.Run(r.Context(), health.Readiness)
+	WriteV1Response(w, healthStatusCode(report.Status), HealthResponse{Status: string(report.Status), Version: buildinfo.Version()})
+}
+
+// CreateDeviceV1Handler API handler for creating a signature device
+// @Summary Create a new signature device
+// @Description Create a new signature device with a label, algorithm and optional signed-data format (v1 or v2).
+// @Description The ID is optional; without one the service generates a UUID. The URL of the device is returned in the Location header.
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param device body DeviceRequest true "Device information"
+// @Param Authorization header string true "Bearer API key"
+// @Success 201 {object} Response{data=v1.DeviceResponse} "Successful response"
+// @Header 201 {string} Location "URL of the device"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 409 {object} ErrorResponse "Device ID already exists"
+// @Failure 422 {object} ErrorResponse "Invalid algorithm, signed-data format or initial status"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v1/devices [post]
+func (s *Server) CreateDeviceV1Handler(w http.ResponseWriter, r *http.Request) {
+	var req request.DeviceRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	req.ClientID = clientFromRequest(r)
+	req.TenantID = tenantFromRequest(r)
+	req.ClientCertificate = certificateFromRequest(r)
+	deviceResponse, err := s.deviceService.CreateSignatureDevice(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/devices/"+deviceResponse.ID)
+	WriteV1Response(w, http.StatusCreated, v1.NewDeviceResponse(deviceResponse))
+}
+
+// ListDevicesV1Handler API handler for listing signature devices
+// @Summary List signature devices
+// @Description Retrieve a page of signature devices, filtered and sorted by the query parameters. The cursor for the
+// @Description next page is returned as nextCursor. Deleted devices are only listed with includeDeleted=true.
+// @Tags devices
+// @Produce json
+// @Param algorithm query string false "Only list devices using this algorithm (RSA or ECC)"
+// @Param labelPrefix query string false "Only list devices whose label starts with this prefix"
+// @Param status query string false "Only list devices in this lifecycle state"
+// @Param createdFrom query string false "Only list devices created at or after this time (RFC 3339)"
+// @Param createdTo query string false "Only list devices created before this time (RFC 3339)"
+// @Param includeDeleted query bool false "Also list the tombstones of deleted devices"
+// @Param sort query string false "Sort field: id, label or createdAt (default)"
+// @Param order query string false "Sort order: asc (default) or desc"
+// @Param cursor query string false "nextCursor of the previous page"
+// @Param limit query int false "Page size, 100 by default and at most 1000"
+// @Param Authorization header string true "Bearer API key"
+// @Success 200 {object} Response{data=v1.DeviceListResponse} "Successful response"
+// @Failure 400 {object} ErrorResponse "Invalid query parameter"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v1/devices [get]
+func (s *Server) ListDevicesV1Handler(w http.ResponseWriter, r *http.Request) {
+	req, err := parseListDevicesRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	page, err := s.deviceService.QuerySignatureDevices(r.Context(), req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	WriteV1Response(w, http.StatusOK, v1.NewDeviceListResponse(page))
+}
+
+// GetDeviceV1Handler API handler for retrieving a signature device
+// @Summary Get a signature device
+// @Description Retrieve information about a specific signature device using its ID
+// @Tags devices
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param Authorization header string true "Bearer API key"
+// @Success 200 {object} Response{data=v1.DeviceResponse} "Successful response"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 404 {object} ErrorResponse "Device not found"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v1/devices/{id} [get]
+func (s *Server) GetDeviceV1Handler(w http.ResponseWriter, r *http.Request) {
+	deviceResponse, err := s.deviceService.GetSignatureDeviceById(r.Context(), tenantFromRequest(r), r.PathValue("id"))
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	WriteV1Response(w, http.StatusOK, v1.NewDeviceResponse(deviceResponse))
+}
+
+// UpdateDeviceV1Handler API handler for updating a signature device
+// @Summary Update a signature device
+// @Description Update the label and signing limits and merge the free-form metadata of a signature device.
+// @Description Metadata keys set to null are removed; limits are replaced as a whole.
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param device body UpdateDeviceRequest true "Label, metadata and signing limits"
+// @Param Authorization header string true "Bearer API key"
+// @Success 200 {object} Response{data=v1.DeviceResponse} "Successful response"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 404 {object} ErrorResponse "Device not found"
+// @Failure 410 {object} ErrorResponse "Device has been deleted"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v1/devices/{id} [patch]
+func (s *Server) UpdateDeviceV1Handler(w http.ResponseWriter, r *http.Request) {
+	var req request.UpdateDeviceRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	req.ClientCertificate = certificateFromRequest(r)
+	deviceResponse, err := s.deviceService.UpdateSignatureDevice(r.Context(), tenantFromRequest(r), r.PathValue("id"), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	WriteV1Response(w, http.StatusOK, v1.NewDeviceResponse(deviceResponse))
+}
+
+// DeleteDeviceV1Handler API handler for deleting a signature device
+// @Summary Delete a signature device
+// @Description Destroy the private key of a signature device. A tombstone with the public key and the final
+// @Description signature counter is kept so historic signatures remain verifiable, and the ID cannot be reused.
+// @Tags devices
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param Authorization header string true "Bearer API key"
+// @Success 200 {object} Response{data=v1.DeviceResponse} "The tombstone of the deleted device"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 404 {object} ErrorResponse "Device not found"
+// @Failure 410 {object} ErrorResponse "Device has already been deleted"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v1/devices/{id} [delete]
+func (s *Server) DeleteDeviceV1Handler(w http.ResponseWriter, r *http.Request) {
+	deviceResponse, err := s.deviceService.DeleteSignatureDevice(r.Context(), tenantFromRequest(r), r.PathValue("id"))
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	WriteV1Response(w, http.StatusOK, v1.NewDeviceResponse(deviceResponse))
+}
+
+// CreateSignatureV1Handler API handler for signing a transaction with a device
+// @Summary Sign a transaction
+// @Description Sign the transaction data with the device of the path. Data may be sent as utf8, base64 or hex
+// @Description (dataEncoding) and either as raw data or as a pre-computed SHA-256 digest (mode).
+// @Description Alternatively a JSON object can be sent as payload, which is signed in its canonical (RFC 8785) form.
+// @Tags transactions
+// @Accept json
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param transaction body SignTransactionRequest true "Transaction data; deviceId may be omitted"
+// @Param Idempotency-Key header string false "Key making retries return the original signature"
+// @Param Authorization header string true "Bearer API key"
+// @Success 201 {object} Response{data=v1.SignatureResponse} "Successful response"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 403 {object} ErrorResponse "Device outside its validity window, signature quota exhausted or policy violated"
+// @Failure 404 {object} ErrorResponse "Device not found"
+// @Failure 409 {object} ErrorResponse "Device is not active"
+// @Failure 410 {object} ErrorResponse "Device has been deleted"
+// @Failure 422 {object} ErrorResponse "Idempotency key reused with a different request"
+// @Failure 429 {object} ErrorResponse "Daily signature quota exhausted or rate limit exceeded, see the Retry-After header"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v1/devices/{id}/signatures [post]
+func (s *Server) CreateSignatureV1Handler(w http.ResponseWriter, r *http.Request) {
+	var req request.SignTransactionRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	// The device is addressed by the path; a deviceId in the body must not contradict it
+	if req.DeviceID != "" && req.DeviceID != r.PathValue("id") {
+		WriteError(w, r, invalidField(ErrInvalidSignRequest, "deviceId", "does not match the device of the path"))
+		return
+	}
+	req.DeviceID = r.PathValue("id")
+	req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	req.ClientID = clientFromRequest(r)
+	req.TenantID = tenantFromRequest(r)
+	req.ClientCertificate = certificateFromRequest(r)
+	signResponse, err := s.deviceService.SignTransaction(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	if signResponse.Replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
+	WriteV1Response(w, http.StatusCreated, v1.NewSignatureResponse(signResponse))
+}
+
+// ChangeDeviceStatusV1Handler API handler for changing the lifecycle state of a device
+// @Summary Change the status of a signature device
+// @Description Apply a lifecycle action (activate, suspend, resume, decommission) to a signature device. Only active devices can sign.
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param status body DeviceStatusRequest true "Lifecycle action"
+// @Param Authorization header string true "Bearer API key"
+// @Success 200 {object} Response{data=v1.DeviceStatusResponse} "Successful response"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 404 {object} ErrorResponse "Device not found"
+// @Failure 409 {object} ErrorResponse "Transition not allowed in the current state"
+// @Failure 410 {object} ErrorResponse "Device has been deleted"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v1/devices/{id}/status [post]
+func (s *Server) ChangeDeviceStatusV1Handler(w http.ResponseWriter, r *http.Request) {
+	var req request.DeviceStatusRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	statusResponse, err := s.deviceService.ChangeDeviceStatus(r.Context(), tenantFromRequest(r), r.PathValue("id"), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	WriteV1Response(w, http.StatusOK, v1.NewDeviceStatusResponse(statusResponse))
+}
+
+// GetDeviceStatusV1Handler API handler for retrieving the lifecycle state of a device and its history
+// @Summary Get the status of a signature device
+// @Description Retrieve the lifecycle state of a signature device and the history of its transitions
+// @Tags devices
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param Authorization header string true "Bearer API key"
+// @Success 200 {object} Response{data=v1.DeviceStatusResponse} "Successful response"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 404 {object} ErrorResponse "Device not found"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v1/devices/{id}/status [get]
+func (s *Server) GetDeviceStatusV1Handler(w http.ResponseWriter, r *http.Request) {
+	statusResponse, err := s.deviceService.GetDeviceStatus(r.Context(), tenantFromRequest(r), r.PathValue("id"))
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	WriteV1Response(w, http.StatusOK, v1.NewDeviceStatusResponse(statusResponse))
+}
+
+// SetDevicePolicyV1Handler API handler for replacing the signing policy of a device
+// @Summary Set the signing policy of a signature device
+// @Description Replace the policy restricting what a device may sign. A null or empty policy removes all rules. Every change is audited.
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param policy body DevicePolicyRequest true "Policy document"
+// @Param Authorization header string true "Bearer API key"
+// @Success 200 {object} Response{data=v1.DevicePolicyResponse} "Successful response"
+// @Failure 400 {object} ErrorResponse "Invalid policy"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 404 {object} ErrorResponse "Device not found"
+// @Failure 410 {object} ErrorResponse "Device has been deleted"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v1/devices/{id}/policy [put]
+func (s *Server) SetDevicePolicyV1Handler(w http.ResponseWriter, r *http.Request) {
+	var req request.DevicePolicyRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	req.ChangedBy = clientFromRequest(r)
+	policyResponse, err := s.deviceService.SetDevicePolicy(r.Context(), tenantFromRequest(r), r.PathValue("id"), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	WriteV1Response(w, http.StatusOK, v1.NewDevicePolicyResponse(policyResponse))
+}
+
+// GetDevicePolicyV1Handler API handler for retrieving the signing policy of a device and its change history
+// @Summary Get the signing policy of a signature device
+// @Description Retrieve the signing policy of a signature device and the audit trail of its changes
+// @Tags devices
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param Authorization header string true "Bearer API key"
+// @Success 200 {object} Response{data=v1.DevicePolicyResponse} "Successful response"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 404 {object} ErrorResponse "Device not found"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v1/devices/{id}/policy [get]
+func (s *Server) GetDevicePolicyV1Handler(w http.ResponseWriter, r *http.Request) {
+	policyResponse, err := s.deviceService.GetDevicePolicy(r.Context(), tenantFromRequest(r), r.PathValue("id"))
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	WriteV1Response(w, http.StatusOK, v1.NewDevicePolicyResponse(policyResponse))
+}
+
+// CanonicalizeV1Handler API handler returning the canonical (RFC 8785) form of a JSON payload
+// @Summary Canonicalize a JSON payload
+// @Description Returns the exact canonical bytes (JSON Canonicalization Scheme, RFC 8785) that are signed for a JSON payload
+// @Description as a string, so verifiers can reproduce the signed data.
+// @Tags transactions
+// @Accept json
+// @Produce json
+// @Param payload body object true "JSON payload"
+// @Param Authorization header string true "Bearer API key"
+// @Success 200 {object} Response{data=v1.CanonicalResponse} "Successful response"
+// @Failure 400 {object} ErrorResponse "Invalid JSON"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Router /api/v1/canonicalize [post]
+func (s *Server) CanonicalizeV1Handler(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	canonical, err := signeddata.CanonicalizeJSON(body)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	WriteV1Response(w, http.StatusOK, v1.CanonicalResponse{Canonical: string(canonical)})
+}
+
+// CreateAPIKeyV1Handler API handler for creating an API key
+// @Summary Create an API key
+// @Description Create an API key for a tenant with the given scopes (devices:read, devices:write, sign, admin).
+// @Description The secret key is only returned in this response; only its hash is stored.
+// @Tags api-keys
+// @Accept json
+// @Produce json
+// @Param key body APIKeyRequest true "API key attributes"
+// @Param Authorization header string true "Bearer API key with the admin scope"
+// @Success 201 {object} Response{data=v1.CreatedAPIKeyResponse} "Successful response"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 403 {object} ErrorResponse "Missing admin scope, or keys of another tenant without an operator key"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v1/api-keys [post]
+func (s *Server) CreateAPIKeyV1Handler(w http.ResponseWriter, r *http.Request) {
+	var req request.APIKeyRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	// Keys are created for the tenant of the caller unless an operator names another one
+	if err := authorizeNewAPIKey(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	keyResponse, err := s.apiKeyService.CreateAPIKey(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	WriteV1Response(w, http.StatusCreated, v1.NewCreatedAPIKeyResponse(keyResponse))
+}
+
+// ListAPIKeysV1Handler API handler for listing the API keys of a tenant
+// @Summary List API keys
+// @Description Retrieve the API keys of a tenant, including revoked ones. Secret keys are never returned.
+// @Tags api-keys
+// @Produce json
+// @Param tenantId query string false "Tenant whose keys are listed, the tenant of the caller by default"
+// @Param Authorization header string true "Bearer API key with the admin scope"
+// @Success 200 {object} Response{data=[]v1.APIKeyResponse} "Successful response"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 403 {object} ErrorResponse "Missing admin scope, or keys of another tenant without an operator key"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v1/api-keys [get]
+func (s *Server) ListAPIKeysV1Handler(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := apiKeyTenant(r, r.URL.Query().Get("tenantId"))
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	keyResponses, err := s.apiKeyService.ListAPIKeys(r.Context(), tenantID)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	keys := make([]*v1.APIKeyResponse, 0, len(keyResponses))
+	for _, key := range keyResponses {
+		keys = append(keys, v1.NewAPIKeyResponse(key))
+	}
+	WriteV1Response(w, http.StatusOK, keys)
+}
+
+// RevokeAPIKeyV1Handler API handler for revoking an API key
+// @Summary Revoke an API key
+// @Description Revoke an API key, so it can no longer be used. Revoking a key again has no effect.
+// @Tags api-keys
+// @Produce json
+// @Param id path string true "API key ID"
+// @Param tenantId query string false "Tenant of the key, the tenant of the caller by default"
+// @Param Authorization header string true "Bearer API key with the admin scope"
+// @Success 200 {object} Response{data=v1.APIKeyResponse} "Successful response"
+// @Failure 401 {object} ErrorResponse "Missing or invalid API key"
+// @Failure 403 {object} ErrorResponse "Missing admin scope, or keys of another tenant without an operator key"
+// @Failure 404 {object} ErrorResponse "API key not found"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v1/api-keys/{id} [delete]
+func (s *Server) RevokeAPIKeyV1Handler(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := apiKeyTenant(r, r.URL.Query().Get("tenantId"))
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	keyResponse, err := s.apiKeyService.RevokeAPIKey(r.Context(), tenantID, r.PathValue("id"))
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	WriteV1Response(w, http.StatusOK, v1.NewAPIKeyResponse(keyResponse))
+}
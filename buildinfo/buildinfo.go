@@ -0,0 +1,42 @@
+package buildinfo
+
+import "runtime/debug"
+
+// version is the release of the service, set when building it:
+//
+//	go build -ldflags "-X github.com/fiskaly/coding-challenges/signing-service-challenge/buildinfo.version=1.2.3"
+var version string
+
+// Version returns the release of the service: the version set when building it, the module version if
+// it was installed with go install, or "dev"
+func Version() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// Revision returns the VCS revision the service was built from, with a "-dirty" suffix for uncommitted
+// changes, or "" if it is unknown
+func Revision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		}
+	}
+	if revision != "" && modified == "true" {
+		revision += "-dirty"
+	}
+	return revision
+}
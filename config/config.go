@@ -0,0 +1,298 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/signeddata"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/tracing"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// Storage backends
+const (
+	// StorageMemory keeps all data in memory; it is lost when the service stops
+	StorageMemory = "memory"
+	// StorageSQLite keeps all data in the SQLite database given as DSN
+	StorageSQLite = "sqlite"
+)
+
+// Log formats
+const (
+	// LogFormatText writes human-readable key=value lines
+	LogFormatText = "text"
+	// LogFormatJSON writes one JSON object per line
+	LogFormatJSON = "json"
+)
+
+// redacted replaces secrets when the configuration is printed
+const redacted = "REDACTED"
+
+// Config is the configuration of the signing service. It is merged from Default, a YAML or JSON
+// configuration file, environment variables and command-line flags, in ascending precedence.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	TLS       TLSConfig       `yaml:"tls"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Crypto    CryptoConfig    `yaml:"crypto"`
+	Auth      AuthConfig      `yaml:"auth"`
+	RateLimit RateLimitConfig `yaml:"rateLimit"`
+	Log       LogConfig       `yaml:"log"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+}
+
+// ServerConfig configures the HTTP server
+type ServerConfig struct {
+	// Address is the host and port to listen on, e.g. :8080
+	Address string `yaml:"address"`
+	// ReadTimeout limits the time to read a whole request, including the body
+	ReadTimeout time.Duration `yaml:"readTimeout"`
+	// ReadHeaderTimeout limits the time to read the request headers
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout"`
+	// WriteTimeout limits the time from the end of the request headers to the end of the response
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+	// IdleTimeout limits the time a keep-alive connection waits for the next request
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+	// ShutdownTimeout limits the time in-flight requests may take to complete on SIGINT or SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// MaxHeaderBytes limits the size of the request headers
+	MaxHeaderBytes int `yaml:"maxHeaderBytes"`
+	// MaxBodyBytes limits the size of request bodies
+	MaxBodyBytes int64 `yaml:"maxBodyBytes"`
+}
+
+// TLSConfig configures HTTPS; the server listens for plain HTTP without a certificate
+type TLSConfig struct {
+	CertFile     string `yaml:"certFile"`
+	KeyFile      string `yaml:"keyFile"`
+	MinVersion   string `yaml:"minVersion"`
+	CipherPolicy string `yaml:"cipherPolicy"`
+	ClientAuth   string `yaml:"clientAuth"`
+	ClientCAFile string `yaml:"clientCAFile"`
+}
+
+// StorageConfig configures the repository of devices, signatures and API keys
+type StorageConfig struct {
+	// Backend is StorageMemory or StorageSQLite
+	Backend string `yaml:"backend"`
+	// DSN is the data source name of the database, the file name for SQLite
+	DSN string `yaml:"dsn"`
+	// IdempotencyRetention is how long idempotency keys are remembered
+	IdempotencyRetention time.Duration `yaml:"idempotencyRetention"`
+	// MinFreeBytes is the disk space below which the readiness probe of the sqlite backend fails; it
+	// warns below twice the value
+	MinFreeBytes uint64 `yaml:"minFreeBytes"`
+}
+
+// CryptoConfig configures the defaults of new devices
+type CryptoConfig struct {
+	// RSAKeyBits is the size of the keys of new RSA devices
+	RSAKeyBits int `yaml:"rsaKeyBits"`
+	// SignedDataFormat is the signed data format of devices created without one
+	SignedDataFormat string `yaml:"signedDataFormat"`
+	// DeviceIDVersion is the UUID version, v4 or v7, generated for devices created without an ID
+	DeviceIDVersion string `yaml:"deviceIdVersion"`
+	// KeyGenerationThreshold is the key generation latency above which the readiness probe warns
+	KeyGenerationThreshold time.Duration `yaml:"keyGenerationThreshold"`
+}
+
+// AuthConfig configures how callers are authenticated
+type AuthConfig struct {
+	// BootstrapAPIKey is registered as the first admin key of the default tenant
+	BootstrapAPIKey string `yaml:"bootstrapApiKey"`
+	// JWT enables JWT bearer tokens if a JWKS is given
+	JWT JWTConfig `yaml:"jwt"`
+	// CertificateScopes are granted to clients authenticated by a TLS client certificate
+	CertificateScopes []string `yaml:"certificateScopes"`
+}
+
+// JWTConfig configures the verification of JWT bearer tokens
+type JWTConfig struct {
+	// JWKS is the file path or URL of the keys tokens are verified with
+	JWKS        string `yaml:"jwks"`
+	Issuer      string `yaml:"issuer"`
+	Audience    string `yaml:"audience"`
+	TenantClaim string `yaml:"tenantClaim"`
+}
+
+// RateLimitConfig configures the request rate limits
+type RateLimitConfig struct {
+	Client Limit `yaml:"client"`
+	Device Limit `yaml:"device"`
+}
+
+// Limit is a token bucket; a rate of 0 disables it
+type Limit struct {
+	// Rate is the number of requests per second
+	Rate float64 `yaml:"rate"`
+	// Burst is the number of requests that may be sent at once
+	Burst int `yaml:"burst"`
+}
+
+// LogConfig configures the log output
+type LogConfig struct {
+	// Level is debug, info, warn or error
+	Level string `yaml:"level"`
+	// Format is LogFormatText or LogFormatJSON
+	Format string `yaml:"format"`
+}
+
+// TracingConfig configures the export of the spans of requests
+type TracingConfig struct {
+	// Exporter is tracing.ExporterNone, tracing.ExporterStdout or tracing.ExporterFile
+	Exporter string `yaml:"exporter"`
+	// File is the file the file exporter appends the spans to
+	File string `yaml:"file"`
+	// SampleRatio is the share of the traces started by the service that are recorded, from 0 to 1
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// Default returns the configuration used for settings that are not given
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Address:           ":8080",
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			ShutdownTimeout:   30 * time.Second,
+			MaxHeaderBytes:    64 << 10,
+			MaxBodyBytes:      1 << 20,
+		},
+		TLS: TLSConfig{
+			MinVersion:   "1.2",
+			CipherPolicy: "modern",
+			ClientAuth:   "none",
+		},
+		Storage: StorageConfig{
+			Backend:              StorageMemory,
+			DSN:                  "devices.db",
+			IdempotencyRetention: 24 * time.Hour,
+			MinFreeBytes:         100 << 20,
+		},
+		Crypto: CryptoConfig{
+			RSAKeyBits:             512,
+			SignedDataFormat:       signeddata.DefaultFormat,
+			DeviceIDVersion:        "v7",
+			KeyGenerationThreshold: time.Second,
+		},
+		Auth: AuthConfig{
+			JWT:               JWTConfig{TenantClaim: "tenant_id"},
+			CertificateScopes: []string{string(domain.ScopeDevicesRead), string(domain.ScopeSign)},
+		},
+		RateLimit: RateLimitConfig{
+			Client: Limit{Rate: 50, Burst: 100},
+			Device: Limit{Rate: 20, Burst: 40},
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: LogFormatJSON,
+		},
+		Tracing: TracingConfig{
+			Exporter:    tracing.ExporterNone,
+			SampleRatio: 1,
+		},
+	}
+}
+
+// LoadFile merges a YAML or JSON configuration file into the configuration. Settings the file leaves
+// out keep their values; unknown settings are rejected.
+func (c *Config) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// JSON is a subset of YAML, so both are read by the YAML decoder
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err = decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks every setting and returns all problems found
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Address != "", "server.address is required")
+	for name, timeout := range map[string]time.Duration{
+		"readTimeout": c.Server.ReadTimeout, "readHeaderTimeout": c.Server.ReadHeaderTimeout,
+		"writeTimeout": c.Server.WriteTimeout, "idleTimeout": c.Server.IdleTimeout, "shutdownTimeout": c.Server.ShutdownTimeout,
+	} {
+		check(timeout >= 0, "server.%s must not be negative", name)
+	}
+	check(c.Server.MaxHeaderBytes >= 1024, "server.maxHeaderBytes must be at least 1024")
+	check(c.Server.MaxBodyBytes >= 1024, "server.maxBodyBytes must be at least 1024")
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls.certFile and tls.keyFile must be given together")
+	check(slices.Contains([]string{"1.2", "1.3"}, c.TLS.MinVersion), "tls.minVersion must be 1.2 or 1.3")
+	check(slices.Contains([]string{"modern", "compatible"}, c.TLS.CipherPolicy), "tls.cipherPolicy must be modern or compatible")
+	check(slices.Contains([]string{"none", "optional", "require"}, c.TLS.ClientAuth), "tls.clientAuth must be none, optional or require")
+	check(c.TLS.ClientAuth == "none" || c.TLS.ClientCAFile != "", "tls.clientCAFile is required to verify client certificates")
+	check(c.TLS.ClientAuth == "none" || c.TLS.CertFile != "", "tls.certFile is required to verify client certificates")
+
+	check(c.Storage.Backend == StorageMemory || c.Storage.Backend == StorageSQLite, "storage.backend must be memory or sqlite")
+	check(c.Storage.Backend != StorageSQLite || c.Storage.DSN != "", "storage.dsn is required for sqlite")
+	check(c.Storage.IdempotencyRetention > 0, "storage.idempotencyRetention must be positive")
+
+	check(c.Crypto.RSAKeyBits >= 512 && c.Crypto.RSAKeyBits <= 8192, "crypto.rsaKeyBits must be between 512 and 8192")
+	_, err := signeddata.NewFormatterFactory().GetFormatter(c.Crypto.SignedDataFormat)
+	check(c.Crypto.SignedDataFormat != "" && err == nil, "crypto.signedDataFormat %q is not supported", c.Crypto.SignedDataFormat)
+	check(c.Crypto.DeviceIDVersion == "v4" || c.Crypto.DeviceIDVersion == "v7", "crypto.deviceIdVersion must be v4 or v7")
+	check(c.Crypto.KeyGenerationThreshold > 0, "crypto.keyGenerationThreshold must be positive")
+
+	check(c.Auth.JWT.JWKS == "" || (c.Auth.JWT.Issuer != "" && c.Auth.JWT.Audience != ""), "auth.jwt.issuer and auth.jwt.audience are required with auth.jwt.jwks")
+	_, err = domain.ParseScopes(c.Auth.CertificateScopes)
+	check(err == nil, "auth.certificateScopes: %v", err)
+
+	for name, limit := range map[string]Limit{"client": c.RateLimit.Client, "device": c.RateLimit.Device} {
+		check(limit.Rate >= 0, "rateLimit.%s.rate must not be negative", name)
+		check(limit.Burst >= 1, "rateLimit.%s.burst must be at least 1", name)
+	}
+
+	check(slices.Contains([]string{"debug", "info", "warn", "error"}, c.Log.Level), "log.level must be debug, info, warn or error")
+	check(c.Log.Format == LogFormatText || c.Log.Format == LogFormatJSON, "log.format must be text or json")
+
+	check(slices.Contains([]string{tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterFile}, c.Tracing.Exporter), "tracing.exporter must be none, stdout or file")
+	check(c.Tracing.Exporter != tracing.ExporterFile || c.Tracing.File != "", "tracing.file is required with the file exporter")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sampleRatio must be between 0 and 1")
+
+	// Maps are iterated in random order; sort the problems so they are reported the same way every time
+	slices.SortFunc(errs, func(a, b error) int {
+		if a.Error() < b.Error() {
+			return -1
+		}
+		if a.Error() > b.Error() {
+			return 1
+		}
+		return 0
+	})
+	return errors.Join(errs...)
+}
+
+// Write prints the configuration as YAML, which can be used as a configuration file. Secrets are redacted.
+func (c *Config) Write(w io.Writer) error {
+	printed := *c
+	if printed.Auth.BootstrapAPIKey != "" {
+		printed.Auth.BootstrapAPIKey = redacted
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&printed); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options are command-line flags that control loading instead of configuring the service
+type Options struct {
+	// ConfigFile is the YAML or JSON configuration file given by --config or CONFIG_FILE
+	ConfigFile string
+	// PrintConfig is set by --print-config to print the merged configuration and exit
+	PrintConfig bool
+}
+
+// setting is a configuration value that can be set by an environment variable and a flag. The flag name
+// is the lower-case environment variable name with dashes, e.g. --listen-address for LISTEN_ADDRESS.
+type setting struct {
+	env   string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+// flagName returns the name of the command-line flag of the setting
+func (s setting) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(s.env), "_", "-")
+}
+
+// settings lists every value that can be set by environment variables and flags, in the order they are applied
+var settings = []setting{
+	{"PORT", "port to listen on, shorthand for LISTEN_ADDRESS=:<port>", func(c *Config, v string) error {
+		if _, err := strconv.ParseUint(v, 10, 16); err != nil {
+			return fmt.Errorf("invalid port %q", v)
+		}
+		c.Server.Address = ":" + v
+		return nil
+	}},
+	{"LISTEN_ADDRESS", "host and port to listen on", setString(func(c *Config) *string { return &c.Server.Address })},
+	{"SERVER_READ_TIMEOUT", "maximum duration to read a request", setDuration(func(c *Config) *time.Duration { return &c.Server.ReadTimeout })},
+	{"SERVER_READ_HEADER_TIMEOUT", "maximum duration to read the request headers", setDuration(func(c *Config) *time.Duration { return &c.Server.ReadHeaderTimeout })},
+	{"SERVER_WRITE_TIMEOUT", "maximum duration to write a response", setDuration(func(c *Config) *time.Duration { return &c.Server.WriteTimeout })},
+	{"SERVER_IDLE_TIMEOUT", "maximum duration to wait for the next request on a keep-alive connection", setDuration(func(c *Config) *time.Duration { return &c.Server.IdleTimeout })},
+	{"SERVER_SHUTDOWN_TIMEOUT", "maximum duration to wait for in-flight requests on shutdown", setDuration(func(c *Config) *time.Duration { return &c.Server.ShutdownTimeout })},
+	{"SERVER_MAX_HEADER_BYTES", "maximum size of the request headers", setInt(func(c *Config) *int { return &c.Server.MaxHeaderBytes })},
+	{"SERVER_MAX_BODY_BYTES", "maximum size of request bodies", setInt64(func(c *Config) *int64 { return &c.Server.MaxBodyBytes })},
+
+	{"TLS_CERT_FILE", "PEM certificate chain; enables HTTPS", setString(func(c *Config) *string { return &c.TLS.CertFile })},
+	{"TLS_KEY_FILE", "PEM private key of the certificate", setString(func(c *Config) *string { return &c.TLS.KeyFile })},
+	{"TLS_MIN_VERSION", "minimum TLS version (1.2 or 1.3)", setString(func(c *Config) *string { return &c.TLS.MinVersion })},
+	{"TLS_CIPHER_POLICY", "TLS 1.2 cipher suites (modern or compatible)", setString(func(c *Config) *string { return &c.TLS.CipherPolicy })},
+	{"TLS_CLIENT_AUTH", "client certificate verification (none, optional or require)", setString(func(c *Config) *string { return &c.TLS.ClientAuth })},
+	{"TLS_CLIENT_CA_FILE", "PEM CA certificates client certificates are verified with", setString(func(c *Config) *string { return &c.TLS.ClientCAFile })},
+	{"TLS_CLIENT_SCOPES", "space-separated scopes granted to clients with a certificate", func(c *Config, v string) error {
+		c.Auth.CertificateScopes = strings.Fields(v)
+		return nil
+	}},
+
+	{"DATA_STORE", "storage backend (memory or sqlite)", func(c *Config, v string) error {
+		// db is the name the backend was configured with before the configuration file was introduced
+		if v == "db" {
+			v = StorageSQLite
+		}
+		c.Storage.Backend = v
+		return nil
+	}},
+	{"STORAGE_DSN", "data source name of the database, the file name for sqlite", setString(func(c *Config) *string { return &c.Storage.DSN })},
+	{"STORAGE_MIN_FREE_BYTES", "free disk space below which the sqlite backend is not ready", func(c *Config, v string) error {
+		number, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", v)
+		}
+		c.Storage.MinFreeBytes = number
+		return nil
+	}},
+	{"IDEMPOTENCY_KEY_RETENTION", "how long idempotency keys are remembered", setDuration(func(c *Config) *time.Duration { return &c.Storage.IdempotencyRetention })},
+
+	{"RSA_KEY_BITS", "size of the keys of new RSA devices", setInt(func(c *Config) *int { return &c.Crypto.RSAKeyBits })},
+	{"SIGNED_DATA_FORMAT", "signed data format of devices created without one", setString(func(c *Config) *string { return &c.Crypto.SignedDataFormat })},
+	{"DEVICE_ID_VERSION", "UUID version of generated device IDs (v4 or v7)", setString(func(c *Config) *string { return &c.Crypto.DeviceIDVersion })},
+	{"KEY_GENERATION_THRESHOLD", "key generation latency above which the readiness probe warns", setDuration(func(c *Config) *time.Duration { return &c.Crypto.KeyGenerationThreshold })},
+
+	{"BOOTSTRAP_API_KEY", "API key registered as the first admin key", setString(func(c *Config) *string { return &c.Auth.BootstrapAPIKey })},
+	{"JWT_JWKS", "file path or URL of the keys JWTs are verified with; enables JWTs", setString(func(c *Config) *string { return &c.Auth.JWT.JWKS })},
+	{"JWT_ISSUER", "required issuer of JWTs", setString(func(c *Config) *string { return &c.Auth.JWT.Issuer })},
+	{"JWT_AUDIENCE", "required audience of JWTs", setString(func(c *Config) *string { return &c.Auth.JWT.Audience })},
+	{"JWT_TENANT_CLAIM", "JWT claim holding the tenant", setString(func(c *Config) *string { return &c.Auth.JWT.TenantClaim })},
+
+	{"RATE_LIMIT_CLIENT_RATE", "requests per second per client, 0 disables the limit", setFloat(func(c *Config) *float64 { return &c.RateLimit.Client.Rate })},
+	{"RATE_LIMIT_CLIENT_BURST", "requests a client may send at once", setInt(func(c *Config) *int { return &c.RateLimit.Client.Burst })},
+	{"RATE_LIMIT_DEVICE_RATE", "signatures per second per device, 0 disables the limit", setFloat(func(c *Config) *float64 { return &c.RateLimit.Device.Rate })},
+	{"RATE_LIMIT_DEVICE_BURST", "signatures a device may create at once", setInt(func(c *Config) *int { return &c.RateLimit.Device.Burst })},
+
+	{"LOG_LEVEL", "minimum log level (debug, info, warn or error)", setString(func(c *Config) *string { return &c.Log.Level })},
+	{"LOG_FORMAT", "log format (text or json)", setString(func(c *Config) *string { return &c.Log.Format })},
+
+	{"TRACING_EXPORTER", "exporter of the spans (none, stdout or file)", setString(func(c *Config) *string { return &c.Tracing.Exporter })},
+	{"TRACING_FILE", "file the file exporter appends the spans to", setString(func(c *Config) *string { return &c.Tracing.File })},
+	{"TRACING_SAMPLE_RATIO", "share of the traces started by the service that are recorded (0 to 1)", setFloat(func(c *Config) *float64 { return &c.Tracing.SampleRatio })},
+}
+
+func setString(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func setDuration(field func(c *Config) *time.Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*field(c) = duration
+		return nil
+	}
+}
+
+func setInt(field func(c *Config) *int) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*field(c) = number
+		return nil
+	}
+}
+
+func setInt64(field func(c *Config) *int64) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*field(c) = number
+		return nil
+	}
+}
+
+func setFloat(field func(c *Config) *float64) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		*field(c) = number
+		return nil
+	}
+}
+
+// flagValue collects a flag until the configuration file and environment variables are applied
+type flagValue struct {
+	value string
+	set   bool
+}
+
+func (f *flagValue) String() string { return f.value }
+
+func (f *flagValue) Set(value string) error {
+	f.value, f.set = value, true
+	return nil
+}
+
+// Load merges the defaults, the configuration file, the environment variables read with getenv and the
+// command-line arguments, in ascending precedence, and validates the result. flag.ErrHelp is returned
+// if the arguments ask for help.
+func Load(args []string, getenv func(string) string, output io.Writer) (*Config, Options, error) {
+	var options Options
+	flags := flag.NewFlagSet("signing-service", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&options.ConfigFile, "config", getenv("CONFIG_FILE"), "YAML or JSON configuration `file` (env CONFIG_FILE)")
+	flags.BoolVar(&options.PrintConfig, "print-config", false, "print the merged configuration and exit")
+
+	values := make([]flagValue, len(settings))
+	for i, s := range settings {
+		flags.Var(&values[i], s.flagName(), fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, options, err
+	}
+	if flags.NArg() > 0 {
+		return nil, options, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	config := Default()
+	if options.ConfigFile != "" {
+		if err := config.LoadFile(options.ConfigFile); err != nil {
+			return nil, options, err
+		}
+	}
+	for _, s := range settings {
+		if value := getenv(s.env); value != "" {
+			if err := s.set(config, value); err != nil {
+				return nil, options, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	for i, s := range settings {
+		if values[i].set {
+			if err := s.set(config, values[i].value); err != nil {
+				return nil, options, fmt.Errorf("--%s: %w", s.flagName(), err)
+			}
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, options, err
+	}
+	return config, options, nil
+}
@@ -7,13 +7,23 @@ import (
 	"crypto/rsa"
 )
 
+// DefaultRSAKeyBits is the size of generated RSA keys unless configured otherwise.
+// Security has been ignored for the sake of simplicity.
+const DefaultRSAKeyBits = 512
+
 // RSAGenerator generates a RSA key pair.
-type RSAGenerator struct{}
+type RSAGenerator struct {
+	// Bits is the size of the generated keys, DefaultRSAKeyBits if zero.
+	Bits int
+}
 
 // Generate generates a new RSAKeyPair.
 func (g *RSAGenerator) Generate() (*RSAKeyPair, error) {
-	// Security has been ignored for the sake of simplicity.
-	key, err := rsa.GenerateKey(rand.Reader, 512)
+	bits := g.Bits
+	if bits == 0 {
+		bits = DefaultRSAKeyBits
+	}
+	key, err := rsa.GenerateKey(rand.Reader, bits)
 	if err != nil {
 		return nil, err
 	}
@@ -1,29 +1,68 @@
 package crypto
 
 import (
+	"context"
 	"errors"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer creates the spans of key generation and signing
+var tracer = otel.Tracer("github.com/fiskaly/coding-challenges/signing-service-challenge/crypto")
+
+// startSpan starts the span of a crypto operation with the key algorithm as attribute
+func startSpan(ctx context.Context, name string, algorithm domain.AlgorithmType, attributes ...attribute.KeyValue) trace.Span {
+	_, span := tracer.Start(ctx, name, trace.WithAttributes(append(attributes, attribute.String("signing.algorithm", string(algorithm)))...))
+	return span
+}
+
+// endSpan ends the span of a crypto operation, marking it as failed if err is not nil
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
 // KeyPairGenerator defines an interface for key generation and private key unmarshaling.
 type KeyPairGenerator interface {
-	GenerateKeyPair() ([]byte, []byte, error)   // Generates the public and private keys.
-	UnmarshalPrivateKey([]byte) (Signer, error) // Converts the private key to a Signer.
+	GenerateKeyPair(ctx context.Context) ([]byte, []byte, error) // Generates the public and private keys.
+	UnmarshalPrivateKey([]byte) (Signer, error)                  // Converts the private key to a Signer.
 }
 
 // KeyPairFactory is a factory for generating key pairs and signers based on the algorithm.
-type KeyPairFactory struct{}
+type KeyPairFactory struct {
+	rsaKeyBits int
+}
+
+// KeyPairFactoryOption configures optional settings of a KeyPairFactory.
+type KeyPairFactoryOption func(*KeyPairFactory)
+
+// WithRSAKeyBits sets the size of generated RSA keys.
+func WithRSAKeyBits(bits int) KeyPairFactoryOption {
+	return func(f *KeyPairFactory) {
+		f.rsaKeyBits = bits
+	}
+}
 
 // NewKeyPairFactory creates a new KeyPairFactory.
-func NewKeyPairFactory() *KeyPairFactory {
-	return &KeyPairFactory{}
+func NewKeyPairFactory(options ...KeyPairFactoryOption) *KeyPairFactory {
+	factory := &KeyPairFactory{rsaKeyBits: DefaultRSAKeyBits}
+	for _, option := range options {
+		option(factory)
+	}
+	return factory
 }
 
 // GetKeyPair returns a key pair generator for the given algorithm.
 func (f *KeyPairFactory) GetKeyPair(algorithm domain.AlgorithmType) (KeyPairGenerator, error) {
 	switch algorithm {
 	case domain.RSA:
-		return &RSAKeyPairGenerator{}, nil
+		return &RSAKeyPairGenerator{Bits: f.rsaKeyBits}, nil
 	case domain.ECC:
 		return &ECCKeyPairGenerator{}, nil
 	default:
@@ -32,18 +71,28 @@ func (f *KeyPairFactory) GetKeyPair(algorithm domain.AlgorithmType) (KeyPairGene
 }
 
 // RSAKeyPairGenerator handles RSA key generation and signing.
-type RSAKeyPairGenerator struct{}
+type RSAKeyPairGenerator struct {
+	// Bits is the size of the generated keys, DefaultRSAKeyBits if zero.
+	Bits int
+}
 
 // GenerateKeyPair generates an RSA key pair and returns the public and private keys.
-func (g *RSAKeyPairGenerator) GenerateKeyPair() ([]byte, []byte, error) {
-	rsaGen := RSAGenerator{}
+func (g *RSAKeyPairGenerator) GenerateKeyPair(ctx context.Context) (publicKey, privateKey []byte, err error) {
+	bits := g.Bits
+	if bits == 0 {
+		bits = DefaultRSAKeyBits
+	}
+	span := startSpan(ctx, "KeyPairGenerator.GenerateKeyPair", domain.RSA, attribute.Int("signing.key_bits", bits))
+	defer func() { endSpan(span, err) }()
+
+	rsaGen := RSAGenerator{Bits: g.Bits}
 	keyPair, err := rsaGen.Generate()
 	if err != nil {
 		return nil, nil, err
 	}
 
 	marshaler := RSAMarshaler{}
-	publicKey, privateKey, err := marshaler.Marshal(*keyPair)
+	publicKey, privateKey, err = marshaler.Marshal(*keyPair)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -63,7 +112,10 @@ func (g *RSAKeyPairGenerator) UnmarshalPrivateKey(privateKeyBytes []byte) (Signe
 type ECCKeyPairGenerator struct{}
 
 // GenerateKeyPair generates an ECC key pair and returns the public and private keys.
-func (g *ECCKeyPairGenerator) GenerateKeyPair() ([]byte, []byte, error) {
+func (g *ECCKeyPairGenerator) GenerateKeyPair(ctx context.Context) (publicKey, privateKey []byte, err error) {
+	span := startSpan(ctx, "KeyPairGenerator.GenerateKeyPair", domain.ECC)
+	defer func() { endSpan(span, err) }()
+
 	eccGen := ECCGenerator{}
 	keyPair, err := eccGen.Generate()
 	if err != nil {
@@ -71,7 +123,7 @@ func (g *ECCKeyPairGenerator) GenerateKeyPair() ([]byte, []byte, error) {
 	}
 
 	marshaler := ECCMarshaler{}
-	publicKey, privateKey, err := marshaler.Encode(*keyPair)
+	publicKey, privateKey, err = marshaler.Encode(*keyPair)
 	if err != nil {
 		return nil, nil, err
 	}
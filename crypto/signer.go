@@ -1,17 +1,19 @@
 package crypto
 
 import (
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"fmt"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
 )
 
 // Signer defines a contract for different types of signing implementations.
 type Signer interface {
-	Sign(dataToBeSigned []byte) ([]byte, error)
+	Sign(ctx context.Context, dataToBeSigned []byte) ([]byte, error)
 }
 
 // TODO: implement RSA and ECDSA signing ...
@@ -27,12 +29,15 @@ func NewRSASigner(keyPair RSAKeyPair) *RSASigner {
 }
 
 // Sign signs the given data using the RSA private key.
-func (s *RSASigner) Sign(dataToBeSigned []byte) ([]byte, error) {
+func (s *RSASigner) Sign(ctx context.Context, dataToBeSigned []byte) (signature []byte, err error) {
+	span := startSpan(ctx, "Signer.Sign", domain.RSA)
+	defer func() { endSpan(span, err) }()
+
 	// Hash the data using SHA-256
 	hashed := sha256.Sum256(dataToBeSigned)
 
 	// Sign the data using the RSA private key and specify SHA-256 as the hash function
-	signature, err := rsa.SignPKCS1v15(rand.Reader, s.keyPair.Private, crypto.SHA256, hashed[:])
+	signature, err = rsa.SignPKCS1v15(rand.Reader, s.keyPair.Private, crypto.SHA256, hashed[:])
 	if err != nil {
 		return nil, fmt.Errorf("RSA signing failed: %w", err)
 	}
@@ -51,7 +56,10 @@ func NewECDSASigner(keyPair ECCKeyPair) *ECDSASigner {
 }
 
 // Sign signs the given data using the ECDSA private key.
-func (s *ECDSASigner) Sign(dataToBeSigned []byte) ([]byte, error) {
+func (s *ECDSASigner) Sign(ctx context.Context, dataToBeSigned []byte) (signature []byte, err error) {
+	span := startSpan(ctx, "Signer.Sign", domain.ECC)
+	defer func() { endSpan(span, err) }()
+
 	// Hash the data using SHA-256
 	hashed := sha256.Sum256(dataToBeSigned)
 
@@ -66,7 +74,7 @@ func (s *ECDSASigner) Sign(dataToBeSigned []byte) ([]byte, error) {
 	sBytes := sigS.Bytes()
 
 	// Combine r and s into a single byte slice
-	signature := append(rBytes, sBytes...)
+	signature = append(rBytes, sBytes...)
 
 	return signature, nil
 }
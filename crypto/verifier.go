@@ -0,0 +1,62 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"math/big"
+)
+
+// ErrInvalidSignature is returned if a signature does not match the signed data and public key.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Verify checks a signature created by the Signer of the algorithm with the private key of the given
+// PEM-encoded public key.
+func Verify(algorithm domain.AlgorithmType, publicKey, data, signature []byte) error {
+	block, _ := pem.Decode(publicKey)
+	if block == nil {
+		return errors.New("invalid public key")
+	}
+	hashed := sha256.Sum256(data)
+
+	switch algorithm {
+	case domain.RSA:
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], signature) != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	case domain.ECC:
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		key, ok := parsed.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("not an ECDSA public key")
+		}
+		// The ECDSASigner concatenates r and s without leading zeros, so the split is tried at every
+		// position that leaves both within the size of the curve
+		size := (key.Curve.Params().BitSize + 7) / 8
+		for split := len(signature) - size; split <= size; split++ {
+			if split < 1 || split >= len(signature) {
+				continue
+			}
+			r, s := new(big.Int).SetBytes(signature[:split]), new(big.Int).SetBytes(signature[split:])
+			if ecdsa.Verify(key, hashed[:], r, s) {
+				return nil
+			}
+		}
+		return ErrInvalidSignature
+	default:
+		return errors.New("unsupported algorithm")
+	}
+}
@@ -0,0 +1,133 @@
+package domain
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// ErrUnknownScope is returned for scopes that are not one of the Scope constants
+var ErrUnknownScope = NewError(KindInvalid, "unknown_scope", "unknown scope")
+
+// Scope is a permission granted to an API key
+type Scope string
+
+// Scopes that can be granted to API keys
+const (
+	// ScopeDevicesRead allows listing and reading devices, their status and their policy
+	ScopeDevicesRead Scope = "devices:read"
+	// ScopeDevicesWrite allows creating, updating and deleting devices and changing their status and policy
+	ScopeDevicesWrite Scope = "devices:write"
+	// ScopeSign allows signing transactions
+	ScopeSign Scope = "sign"
+	// ScopeAdmin allows managing the API keys of all tenants
+	ScopeAdmin Scope = "admin"
+)
+
+// ParseScopes converts scope names to scopes, rejecting unknown ones
+func ParseScopes(names []string) ([]Scope, error) {
+	scopes := make([]Scope, 0, len(names))
+	for _, name := range names {
+		scope := Scope(name)
+		switch scope {
+		case ScopeDevicesRead, ScopeDevicesWrite, ScopeSign, ScopeAdmin:
+		default:
+			return nil, ErrUnknownScope
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// APIKey is a credential of a client acting for a tenant. Only the hash of the secret key is kept.
+type APIKey struct {
+	id       string
+	tenantID string
+	// clientID identifies the client the key was issued to, e.g. in the allowed clients of device policies
+	clientID  string
+	name      string
+	hash      string
+	scopes    []Scope
+	createdAt time.Time
+	// revokedAt is set once the key has been revoked and can no longer be used
+	revokedAt time.Time
+}
+
+// NewAPIKey creates a new API key record from the hash of its secret
+func NewAPIKey(id, tenantID, clientID, name, hash string, scopes []Scope, createdAt time.Time) *APIKey {
+	return &APIKey{
+		id:        id,
+		tenantID:  tenantID,
+		clientID:  clientID,
+		name:      name,
+		hash:      hash,
+		scopes:    slices.Clone(scopes),
+		createdAt: createdAt,
+	}
+}
+
+// GetID returns the ID of the key
+func (key *APIKey) GetID() string {
+	return key.id
+}
+
+// GetTenantID returns the tenant the key acts for
+func (key *APIKey) GetTenantID() string {
+	return key.tenantID
+}
+
+// GetClientID returns the identity of the client the key was issued to
+func (key *APIKey) GetClientID() string {
+	return key.clientID
+}
+
+// GetName returns the human-readable name of the key
+func (key *APIKey) GetName() string {
+	return key.name
+}
+
+// GetHash returns the hash of the secret key
+func (key *APIKey) GetHash() string {
+	return key.hash
+}
+
+// GetScopes returns a copy of the scopes granted to the key
+func (key *APIKey) GetScopes() []Scope {
+	return slices.Clone(key.scopes)
+}
+
+// HasScope reports whether the key grants the given scope
+func (key *APIKey) HasScope(scope Scope) bool {
+	return slices.Contains(key.scopes, scope)
+}
+
+// GetCreatedAt returns when the key was created
+func (key *APIKey) GetCreatedAt() time.Time {
+	return key.createdAt
+}
+
+// GetRevokedAt returns when the key was revoked, or the zero time if it is still valid
+func (key *APIKey) GetRevokedAt() time.Time {
+	return key.revokedAt
+}
+
+// SetRevokedAt sets when the key was revoked
+func (key *APIKey) SetRevokedAt(revokedAt time.Time) {
+	key.revokedAt = revokedAt
+}
+
+// IsRevoked reports whether the key has been revoked
+func (key *APIKey) IsRevoked() bool {
+	return !key.revokedAt.IsZero()
+}
+
+// LogValue logs the key by its ID, tenant and client, leaving out the hash of its secret
+func (key *APIKey) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", key.id),
+		slog.String("tenant_id", key.tenantID),
+		slog.String("client_id", key.clientID),
+	)
+}
@@ -1,28 +1,68 @@
 package domain
 
+import (
+	"bytes"
+	"log/slog"
+	"time"
+)
+
+// ErrDeviceDeleted is returned when a deleted device is changed or used for signing
+var ErrDeviceDeleted = NewError(KindGone, "device_deleted", "device has been deleted")
+
+// DefaultTenantID is the tenant of devices created without one, including those created before tenants were introduced
+const DefaultTenantID = "default"
+
 // TODO: signature device domain model ...
 
 // SignatureDevice represents a signature device with public/private keys
 type SignatureDevice struct {
-	id             string 
-	publicKey      string
-	privateKey     string 
+	id        string
+	publicKey string
+	// privateKey is owned by the device, so it can be zeroed in place when the device is deleted
+	privateKey     []byte
 	label          string
 	signatureCount uint64
 	algorithm      AlgorithmType
 	lastSignature  string
+	// tenantID is the organisation owning the device; devices are only visible to their own tenant
+	tenantID string
+	// signedDataFormat is the version of the signed-data format used by the device
+	signedDataFormat string
+	// status is the lifecycle state of the device
+	status DeviceStatus
+	// metadata holds free-form attributes such as the store location or the register serial
+	metadata map[string]string
+	// policy is the JSON document of the signing policy of the device, empty if it has none
+	policy string
+	// boundClient is the client ID of the client certificate the device is bound to, empty if it is unbound.
+	// Callers authenticated by certificate can only sign with the devices bound to them.
+	boundClient string
+	// signingLimits restrict how many signatures the device can create and when
+	signingLimits SigningLimits
+	// createdAt is when the device was created
+	createdAt time.Time
+	// updatedAt is when the label, metadata, status or deletion state of the device last changed
+	updatedAt time.Time
+	// deletedAt is set once the device has been deleted and only its tombstone is kept
+	deletedAt time.Time
 }
 
-// NewSignatureDevice creates a new signature device with generated keys and an initial label
-func NewSignatureDevice(id, label string, algorithm AlgorithmType, publicKey, privateKey, lastSignature string) *SignatureDevice {
+// NewSignatureDevice creates a new signature device with generated keys and an initial label. The device
+// takes ownership of privateKey and zeroes it when the device is deleted.
+func NewSignatureDevice(id, label string, algorithm AlgorithmType, publicKey string, privateKey []byte, lastSignature string) *SignatureDevice {
+	now := time.Now().UTC()
 	return &SignatureDevice{
 		id:             id,
+		tenantID:       DefaultTenantID,
 		label:          label,
 		algorithm:      algorithm,
 		publicKey:      publicKey,
 		privateKey:     privateKey,
 		signatureCount: 0,
 		lastSignature:  lastSignature,
+		status:         StatusActive,
+		createdAt:      now,
+		updatedAt:      now,
 	}
 }
 
@@ -31,6 +71,16 @@ func (device *SignatureDevice) GetID() string {
 	return device.id
 }
 
+// GetTenantID returns the ID of the tenant owning the device
+func (device *SignatureDevice) GetTenantID() string {
+	return device.tenantID
+}
+
+// SetTenantID sets the tenant owning the device
+func (device *SignatureDevice) SetTenantID(tenantID string) {
+	device.tenantID = tenantID
+}
+
 // GetSignatureCount returns the current signature count
 func (device *SignatureDevice) GetSignatureCount() uint64 {
 	return device.signatureCount
@@ -55,9 +105,9 @@ func (device *SignatureDevice) GetPublicKey() string {
 	return device.publicKey
 }
 
-// GetPrivateKey returns the private key of the device
-func (device *SignatureDevice) GetPrivateKey() string {
-	return device.privateKey
+// GetPrivateKey returns a copy of the private key of the device, which the caller should clear after use
+func (device *SignatureDevice) GetPrivateKey() []byte {
+	return bytes.Clone(device.privateKey)
 }
 
 // SetLabel sets the label of the device
@@ -70,11 +120,18 @@ func (device *SignatureDevice) SetPublicKey(publicKey string) {
 	device.publicKey = publicKey
 }
 
-// SetPrivateKey sets the private key of the device
-func (device *SignatureDevice) SetPrivateKey(privateKey string) {
+// SetPrivateKey sets the private key of the device, taking ownership of it; the previous key is zeroed
+func (device *SignatureDevice) SetPrivateKey(privateKey []byte) {
+	clear(device.privateKey)
 	device.privateKey = privateKey
 }
 
+// ClearPrivateKey zeroes the private key of the device in place and drops it
+func (device *SignatureDevice) ClearPrivateKey() {
+	clear(device.privateKey)
+	device.privateKey = nil
+}
+
 func (device *SignatureDevice) SetSignatureCount(count uint64) {
 	device.signatureCount = count
 }
@@ -83,3 +140,121 @@ func (device *SignatureDevice) SetSignatureCount(count uint64) {
 func (device *SignatureDevice) SetLastSignature(lastSignature string) {
 	device.lastSignature = lastSignature
 }
+
+// GetSignedDataFormat returns the signed-data format version of the device
+func (device *SignatureDevice) GetSignedDataFormat() string {
+	return device.signedDataFormat
+}
+
+// SetSignedDataFormat sets the signed-data format version of the device
+func (device *SignatureDevice) SetSignedDataFormat(signedDataFormat string) {
+	device.signedDataFormat = signedDataFormat
+}
+
+// GetStatus returns the lifecycle state of the device
+func (device *SignatureDevice) GetStatus() DeviceStatus {
+	return device.status
+}
+
+// SetStatus sets the lifecycle state of the device, bypassing the transition rules
+func (device *SignatureDevice) SetStatus(status DeviceStatus) {
+	device.status = status
+}
+
+// GetMetadata returns a copy of the free-form metadata of the device
+func (device *SignatureDevice) GetMetadata() map[string]string {
+	metadata := make(map[string]string, len(device.metadata))
+	for key, value := range device.metadata {
+		metadata[key] = value
+	}
+	return metadata
+}
+
+// SetMetadata replaces the free-form metadata of the device
+func (device *SignatureDevice) SetMetadata(metadata map[string]string) {
+	device.metadata = make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		device.metadata[key] = value
+	}
+}
+
+// Clone returns a copy of the device that can be changed without affecting the original
+func (device *SignatureDevice) Clone() *SignatureDevice {
+	clone := *device
+	clone.metadata = device.GetMetadata()
+	clone.privateKey = device.GetPrivateKey()
+	return &clone
+}
+
+// GetBoundClient returns the client ID of the client certificate the device is bound to, empty if unbound
+func (device *SignatureDevice) GetBoundClient() string {
+	return device.boundClient
+}
+
+// SetBoundClient binds the device to the client certificate with the given client ID, or unbinds it if empty
+func (device *SignatureDevice) SetBoundClient(clientID string) {
+	device.boundClient = clientID
+}
+
+// IsBoundTo reports whether the device is bound to the client certificate with the given client ID
+func (device *SignatureDevice) IsBoundTo(clientID string) bool {
+	return device.boundClient != "" && device.boundClient == clientID
+}
+
+// GetCreatedAt returns when the device was created
+func (device *SignatureDevice) GetCreatedAt() time.Time {
+	return device.createdAt
+}
+
+// SetCreatedAt sets when the device was created
+func (device *SignatureDevice) SetCreatedAt(createdAt time.Time) {
+	device.createdAt = createdAt
+}
+
+// GetUpdatedAt returns when the label, metadata, status or deletion state of the device last changed
+func (device *SignatureDevice) GetUpdatedAt() time.Time {
+	return device.updatedAt
+}
+
+// SetUpdatedAt sets when the device last changed
+func (device *SignatureDevice) SetUpdatedAt(updatedAt time.Time) {
+	device.updatedAt = updatedAt
+}
+
+// GetDeletedAt returns when the device was deleted, or the zero time if it was not
+func (device *SignatureDevice) GetDeletedAt() time.Time {
+	return device.deletedAt
+}
+
+// SetDeletedAt sets when the device was deleted
+func (device *SignatureDevice) SetDeletedAt(deletedAt time.Time) {
+	device.deletedAt = deletedAt
+}
+
+// IsDeleted reports whether the device has been deleted
+func (device *SignatureDevice) IsDeleted() bool {
+	return !device.deletedAt.IsZero()
+}
+
+// Delete turns the device into a tombstone. The private key is zeroed, while the public key
+// and the signature counter are kept so that historic signatures remain verifiable.
+func (device *SignatureDevice) Delete(at time.Time) error {
+	if device.IsDeleted() {
+		return ErrDeviceDeleted
+	}
+	device.ClearPrivateKey()
+	device.deletedAt = at
+	device.updatedAt = at
+	return nil
+}
+
+// LogValue logs the device by its ID, tenant, algorithm and status, so its private key never reaches the logs
+func (device *SignatureDevice) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", device.id),
+		slog.String("tenant_id", device.tenantID),
+		slog.String("algorithm", string(device.algorithm)),
+		slog.String("status", string(device.status)),
+		slog.Uint64("signature_count", device.signatureCount),
+	)
+}
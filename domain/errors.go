@@ -0,0 +1,135 @@
+package domain
+
+import (
+	"errors"
+	"slices"
+)
+
+// ErrorKind classifies errors by how a caller can react to them; the API maps each kind to a status code
+type ErrorKind int
+
+// Kinds of errors
+const (
+	// KindInternal is the kind of all errors that are not an *Error
+	KindInternal ErrorKind = iota
+	// KindInvalid errors reject malformed requests
+	KindInvalid
+	// KindUnprocessable errors reject well-formed requests with unsupported values or that conflict with earlier requests
+	KindUnprocessable
+	// KindUnauthenticated errors reject requests without valid credentials
+	KindUnauthenticated
+	// KindForbidden errors reject requests the caller or the device is not allowed to make
+	KindForbidden
+	// KindNotFound errors report that a resource does not exist
+	KindNotFound
+	// KindConflict errors reject requests conflicting with the current state of a resource
+	KindConflict
+	// KindGone errors report that a resource has been deleted
+	KindGone
+	// KindExhausted errors reject requests exceeding a quota or rate limit until it replenishes
+	KindExhausted
+	// KindTooLarge errors reject requests exceeding a size limit
+	KindTooLarge
+)
+
+// Error is an error with a stable code clients can switch on. Errors are compared by identity, so they
+// are declared once as sentinels and wrapped with %w to add details.
+type Error struct {
+	kind    ErrorKind
+	code    string
+	message string
+}
+
+// NewError creates an error of the given kind; the code must not change once clients rely on it
+func NewError(kind ErrorKind, code, message string) *Error {
+	return &Error{kind: kind, code: code, message: message}
+}
+
+// Error returns the message of the error
+func (err *Error) Error() string {
+	return err.message
+}
+
+// Kind returns the kind of the error
+func (err *Error) Kind() ErrorKind {
+	return err.kind
+}
+
+// Code returns the stable code of the error, e.g. device_not_found
+func (err *Error) Code() string {
+	return err.code
+}
+
+// KindOf returns the kind of the first *Error in the chain of err, KindInternal if there is none
+func KindOf(err error) ErrorKind {
+	var domainErr *Error
+	if errors.As(err, &domainErr) {
+		return domainErr.kind
+	}
+	return KindInternal
+}
+
+// CodeOf returns the code of the first *Error in the chain of err, empty if there is none
+func CodeOf(err error) string {
+	var domainErr *Error
+	if errors.As(err, &domainErr) {
+		return domainErr.code
+	}
+	return ""
+}
+
+// FieldError describes why a single field of a request was rejected
+type FieldError struct {
+	// Field is the JSON name or query parameter of the field, e.g. limits.notAfter
+	Field   string
+	Message string
+}
+
+// ValidationError adds the rejected fields of a request to an error
+type ValidationError struct {
+	err    error
+	fields []FieldError
+}
+
+// NewValidationError attaches field details to an error, which keeps its message, kind and code
+func NewValidationError(err error, fields ...FieldError) *ValidationError {
+	return &ValidationError{err: err, fields: slices.Clone(fields)}
+}
+
+// Error returns the message of the wrapped error
+func (err *ValidationError) Error() string {
+	return err.err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (err *ValidationError) Unwrap() error {
+	return err.err
+}
+
+// Fields returns the rejected fields
+func (err *ValidationError) Fields() []FieldError {
+	return slices.Clone(err.fields)
+}
+
+// FieldErrorsOf returns the rejected fields of the first *ValidationError in the chain of err
+func FieldErrorsOf(err error) []FieldError {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Fields()
+	}
+	return nil
+}
+
+// Errors of resources that don't exist or already exist
+var (
+	// ErrDeviceNotFound is returned for devices that don't exist or belong to another tenant
+	ErrDeviceNotFound = NewError(KindNotFound, "device_not_found", "device not found")
+	// ErrDeviceAlreadyExists is returned when a device is created with the ID of an existing one
+	ErrDeviceAlreadyExists = NewError(KindConflict, "device_already_exists", "device with this ID already exists")
+	// ErrSignatureNotFound is returned when no signature matches an idempotency key
+	ErrSignatureNotFound = NewError(KindNotFound, "signature_not_found", "signature not found")
+	// ErrAPIKeyNotFound is returned for API keys that don't exist
+	ErrAPIKeyNotFound = NewError(KindNotFound, "api_key_not_found", "api key not found")
+	// ErrAPIKeyAlreadyExists is returned when an API key is created with the ID or hash of an existing one
+	ErrAPIKeyAlreadyExists = NewError(KindConflict, "api_key_already_exists", "api key with this ID already exists")
+)
@@ -0,0 +1,116 @@
+package domain
+
+import (
+	"time"
+)
+
+var (
+	// ErrDeviceNotYetValid is returned when a device signs before the start of its validity window
+	ErrDeviceNotYetValid = NewError(KindForbidden, "device_not_yet_valid", "device is not valid yet")
+	// ErrDeviceExpired is returned when a device signs after the end of its validity window
+	ErrDeviceExpired = NewError(KindForbidden, "device_expired", "device has expired")
+	// ErrSignatureQuotaExhausted is returned when a device has created its maximum number of signatures
+	ErrSignatureQuotaExhausted = NewError(KindForbidden, "signature_quota_exhausted", "signature quota of the device is exhausted")
+	// ErrDailySignatureQuotaExhausted is returned when a device has created its maximum number of signatures for the day
+	ErrDailySignatureQuotaExhausted = NewError(KindExhausted, "daily_signature_quota_exhausted", "daily signature quota of the device is exhausted")
+)
+
+// SigningLimits restrict how many signatures a device can create and when. Zero values mean no limit.
+type SigningLimits struct {
+	// maxSignatures is the maximum number of signatures over the lifetime of the device
+	maxSignatures uint64
+	// dailyLimit is the maximum number of signatures per calendar day (UTC)
+	dailyLimit uint64
+	// notBefore and notAfter bound the validity window of the device, both inclusive
+	notBefore time.Time
+	notAfter  time.Time
+}
+
+// NewSigningLimits creates signing limits; pass zero values for limits that do not apply
+func NewSigningLimits(maxSignatures, dailyLimit uint64, notBefore, notAfter time.Time) SigningLimits {
+	return SigningLimits{
+		maxSignatures: maxSignatures,
+		dailyLimit:    dailyLimit,
+		notBefore:     notBefore,
+		notAfter:      notAfter,
+	}
+}
+
+// GetMaxSignatures returns the maximum number of signatures, or 0 if unlimited
+func (limits SigningLimits) GetMaxSignatures() uint64 {
+	return limits.maxSignatures
+}
+
+// GetDailyLimit returns the maximum number of signatures per day, or 0 if unlimited
+func (limits SigningLimits) GetDailyLimit() uint64 {
+	return limits.dailyLimit
+}
+
+// GetNotBefore returns the start of the validity window, or the zero time if unbounded
+func (limits SigningLimits) GetNotBefore() time.Time {
+	return limits.notBefore
+}
+
+// GetNotAfter returns the end of the validity window, or the zero time if unbounded
+func (limits SigningLimits) GetNotAfter() time.Time {
+	return limits.notAfter
+}
+
+// StartOfDay returns the start of the calendar day (UTC) daily limits are counted in
+func StartOfDay(at time.Time) time.Time {
+	return at.UTC().Truncate(24 * time.Hour)
+}
+
+// GetSigningLimits returns the signing limits of the device
+func (device *SignatureDevice) GetSigningLimits() SigningLimits {
+	return device.signingLimits
+}
+
+// SetSigningLimits replaces the signing limits of the device
+func (device *SignatureDevice) SetSigningLimits(limits SigningLimits) {
+	device.signingLimits = limits
+}
+
+// CheckSigningLimits reports whether the device may create another signature at the given time,
+// given the number of signatures it created since the start of that day
+func (device *SignatureDevice) CheckSigningLimits(at time.Time, signedToday uint64) error {
+	limits := device.signingLimits
+	if !limits.notBefore.IsZero() && at.Before(limits.notBefore) {
+		return ErrDeviceNotYetValid
+	}
+	if !limits.notAfter.IsZero() && at.After(limits.notAfter) {
+		return ErrDeviceExpired
+	}
+	if remaining, limited := device.RemainingSignatures(); limited && remaining == 0 {
+		return ErrSignatureQuotaExhausted
+	}
+	if remaining, limited := device.RemainingSignaturesToday(signedToday); limited && remaining == 0 {
+		return ErrDailySignatureQuotaExhausted
+	}
+	return nil
+}
+
+// RemainingSignatures returns how many more signatures the device may create, and whether that is limited at all
+func (device *SignatureDevice) RemainingSignatures() (uint64, bool) {
+	maxSignatures := device.signingLimits.maxSignatures
+	if maxSignatures == 0 {
+		return 0, false
+	}
+	if device.signatureCount >= maxSignatures {
+		return 0, true
+	}
+	return maxSignatures - device.signatureCount, true
+}
+
+// RemainingSignaturesToday returns how many more signatures the device may create today, given the number
+// it created since the start of the day, and whether that is limited at all
+func (device *SignatureDevice) RemainingSignaturesToday(signedToday uint64) (uint64, bool) {
+	dailyLimit := device.signingLimits.dailyLimit
+	if dailyLimit == 0 {
+		return 0, false
+	}
+	if signedToday >= dailyLimit {
+		return 0, true
+	}
+	return dailyLimit - signedToday, true
+}
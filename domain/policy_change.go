@@ -0,0 +1,58 @@
+package domain
+
+import "time"
+
+// PolicyChange is an audit record of a change to the signing policy of a device
+type PolicyChange struct {
+	deviceID       string
+	previousPolicy string
+	policy         string
+	changedBy      string
+	at             time.Time
+}
+
+// NewPolicyChange creates a new policy change record; policies are JSON documents, empty if the device has none
+func NewPolicyChange(deviceID, previousPolicy, policy, changedBy string, at time.Time) *PolicyChange {
+	return &PolicyChange{
+		deviceID:       deviceID,
+		previousPolicy: previousPolicy,
+		policy:         policy,
+		changedBy:      changedBy,
+		at:             at,
+	}
+}
+
+// GetDeviceID returns the ID of the device
+func (change *PolicyChange) GetDeviceID() string {
+	return change.deviceID
+}
+
+// GetPreviousPolicy returns the policy document before the change
+func (change *PolicyChange) GetPreviousPolicy() string {
+	return change.previousPolicy
+}
+
+// GetPolicy returns the policy document after the change
+func (change *PolicyChange) GetPolicy() string {
+	return change.policy
+}
+
+// GetChangedBy returns the identity of the client that made the change, empty if unknown
+func (change *PolicyChange) GetChangedBy() string {
+	return change.changedBy
+}
+
+// GetAt returns the time of the change
+func (change *PolicyChange) GetAt() time.Time {
+	return change.at
+}
+
+// GetPolicy returns the signing policy document of the device, empty if it has none
+func (device *SignatureDevice) GetPolicy() string {
+	return device.policy
+}
+
+// SetPolicy sets the signing policy document of the device
+func (device *SignatureDevice) SetPolicy(policy string) {
+	device.policy = policy
+}
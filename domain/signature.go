@@ -0,0 +1,97 @@
+package domain
+
+import (
+	"log/slog"
+	"time"
+)
+
+// ErrSignatureCounterConflict is returned when a signature is stored with a counter the device has moved past
+var ErrSignatureCounterConflict = NewError(KindConflict, "signature_counter_conflict", "signature counter of the device has changed")
+
+// SignatureRecord represents a signature created by a signature device
+type SignatureRecord struct {
+	deviceID           string
+	counter            uint64
+	signature          string
+	signedData         string
+	signedDataEncoding string
+	formatVersion      string
+	createdAt          time.Time
+	// idempotencyKey is the client-supplied key the signature was requested with, if any
+	idempotencyKey string
+	// requestHash identifies the request body the idempotency key was first used with
+	requestHash string
+}
+
+// NewSignatureRecord creates a new signature record
+func NewSignatureRecord(deviceID string, counter uint64, signature, signedData, signedDataEncoding, formatVersion string, createdAt time.Time) *SignatureRecord {
+	return &SignatureRecord{
+		deviceID:           deviceID,
+		counter:            counter,
+		signature:          signature,
+		signedData:         signedData,
+		signedDataEncoding: signedDataEncoding,
+		formatVersion:      formatVersion,
+		createdAt:          createdAt,
+	}
+}
+
+// GetDeviceID returns the ID of the device that created the signature
+func (record *SignatureRecord) GetDeviceID() string {
+	return record.deviceID
+}
+
+// GetCounter returns the signature counter the signature was created with
+func (record *SignatureRecord) GetCounter() uint64 {
+	return record.counter
+}
+
+// GetSignature returns the base64 encoded signature
+func (record *SignatureRecord) GetSignature() string {
+	return record.signature
+}
+
+// GetSignedData returns the signed data as returned to the client
+func (record *SignatureRecord) GetSignedData() string {
+	return record.signedData
+}
+
+// GetSignedDataEncoding returns the encoding of the signed data (utf8 or base64)
+func (record *SignatureRecord) GetSignedDataEncoding() string {
+	return record.signedDataEncoding
+}
+
+// GetFormatVersion returns the version of the signed-data format
+func (record *SignatureRecord) GetFormatVersion() string {
+	return record.formatVersion
+}
+
+// GetCreatedAt returns the time the signature was created
+func (record *SignatureRecord) GetCreatedAt() time.Time {
+	return record.createdAt
+}
+
+// GetIdempotencyKey returns the idempotency key the signature was requested with
+func (record *SignatureRecord) GetIdempotencyKey() string {
+	return record.idempotencyKey
+}
+
+// GetRequestHash returns the hash of the request the idempotency key was first used with
+func (record *SignatureRecord) GetRequestHash() string {
+	return record.requestHash
+}
+
+// SetIdempotencyKey sets the idempotency key and the hash of the request it was used with
+func (record *SignatureRecord) SetIdempotencyKey(idempotencyKey, requestHash string) {
+	record.idempotencyKey = idempotencyKey
+	record.requestHash = requestHash
+}
+
+// LogValue logs the record by its device and counter, leaving out the signed transaction data
+func (record *SignatureRecord) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("device_id", record.deviceID),
+		slog.Uint64("counter", record.counter),
+		slog.String("format_version", record.formatVersion),
+	)
+}
@@ -0,0 +1,148 @@
+package domain
+
+import (
+	"time"
+)
+
+// DeviceStatus is the lifecycle state of a signature device
+type DeviceStatus string
+
+const (
+	// StatusInactive devices have been created but cannot sign until they are activated
+	StatusInactive DeviceStatus = "inactive"
+	// StatusActive devices can sign transactions
+	StatusActive DeviceStatus = "active"
+	// StatusSuspended devices are temporarily blocked from signing, e.g. while a register is missing
+	StatusSuspended DeviceStatus = "suspended"
+	// StatusDecommissioned devices are permanently retired
+	StatusDecommissioned DeviceStatus = "decommissioned"
+)
+
+// StatusAction is an action moving a device from one lifecycle state to another
+type StatusAction string
+
+const (
+	ActionActivate     StatusAction = "activate"
+	ActionSuspend      StatusAction = "suspend"
+	ActionResume       StatusAction = "resume"
+	ActionDecommission StatusAction = "decommission"
+)
+
+var (
+	// ErrInvalidStatusTransition is returned when an action is not allowed in the current state
+	ErrInvalidStatusTransition = NewError(KindConflict, "invalid_status_transition", "status transition not allowed")
+	// ErrUnknownStatusAction is returned for actions that do not exist
+	ErrUnknownStatusAction = NewError(KindInvalid, "unknown_status_action", "unknown status action")
+	// ErrDeviceNotActive is returned when a device that is not active is used for signing
+	ErrDeviceNotActive = NewError(KindConflict, "device_not_active", "device is not active")
+)
+
+// statusTransitions lists the states each action can be applied to and the state it leads to
+var statusTransitions = map[StatusAction]struct {
+	from []DeviceStatus
+	to   DeviceStatus
+}{
+	ActionActivate:     {from: []DeviceStatus{StatusInactive}, to: StatusActive},
+	ActionSuspend:      {from: []DeviceStatus{StatusActive}, to: StatusSuspended},
+	ActionResume:       {from: []DeviceStatus{StatusSuspended}, to: StatusActive},
+	ActionDecommission: {from: []DeviceStatus{StatusInactive, StatusActive, StatusSuspended}, to: StatusDecommissioned},
+}
+
+// StatusTransition records a change of the lifecycle state of a device
+type StatusTransition struct {
+	deviceID string
+	from     DeviceStatus
+	to       DeviceStatus
+	action   StatusAction
+	reason   string
+	at       time.Time
+}
+
+// NewStatusTransition creates a new status transition record
+func NewStatusTransition(deviceID string, from, to DeviceStatus, action StatusAction, reason string, at time.Time) *StatusTransition {
+	return &StatusTransition{
+		deviceID: deviceID,
+		from:     from,
+		to:       to,
+		action:   action,
+		reason:   reason,
+		at:       at,
+	}
+}
+
+// GetDeviceID returns the ID of the device
+func (transition *StatusTransition) GetDeviceID() string {
+	return transition.deviceID
+}
+
+// GetFrom returns the state before the transition
+func (transition *StatusTransition) GetFrom() DeviceStatus {
+	return transition.from
+}
+
+// GetTo returns the state after the transition
+func (transition *StatusTransition) GetTo() DeviceStatus {
+	return transition.to
+}
+
+// GetAction returns the action that caused the transition
+func (transition *StatusTransition) GetAction() StatusAction {
+	return transition.action
+}
+
+// GetReason returns the reason given for the transition
+func (transition *StatusTransition) GetReason() string {
+	return transition.reason
+}
+
+// GetAt returns the time of the transition
+func (transition *StatusTransition) GetAt() time.Time {
+	return transition.at
+}
+
+// Activate allows an inactive device to sign
+func (device *SignatureDevice) Activate(reason string, at time.Time) (*StatusTransition, error) {
+	return device.ApplyStatusAction(ActionActivate, reason, at)
+}
+
+// Suspend temporarily blocks an active device from signing
+func (device *SignatureDevice) Suspend(reason string, at time.Time) (*StatusTransition, error) {
+	return device.ApplyStatusAction(ActionSuspend, reason, at)
+}
+
+// Resume allows a suspended device to sign again
+func (device *SignatureDevice) Resume(reason string, at time.Time) (*StatusTransition, error) {
+	return device.ApplyStatusAction(ActionResume, reason, at)
+}
+
+// Decommission permanently retires a device
+func (device *SignatureDevice) Decommission(reason string, at time.Time) (*StatusTransition, error) {
+	return device.ApplyStatusAction(ActionDecommission, reason, at)
+}
+
+// ApplyStatusAction moves the device to the state the action leads to and returns the transition,
+// or fails if the action is not allowed in the current state
+func (device *SignatureDevice) ApplyStatusAction(action StatusAction, reason string, at time.Time) (*StatusTransition, error) {
+	rule, exists := statusTransitions[action]
+	if !exists {
+		return nil, ErrUnknownStatusAction
+	}
+	if device.IsDeleted() {
+		return nil, ErrDeviceDeleted
+	}
+
+	for _, from := range rule.from {
+		if device.status == from {
+			transition := NewStatusTransition(device.id, device.status, rule.to, action, reason, at)
+			device.status = rule.to
+			device.updatedAt = at
+			return transition, nil
+		}
+	}
+	return nil, ErrInvalidStatusTransition
+}
+
+// CanSign reports whether the device is allowed to sign transactions
+func (device *SignatureDevice) CanSign() bool {
+	return device.status == StatusActive && !device.IsDeleted()
+}
@@ -0,0 +1,201 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"slices"
+	"sync"
+	"time"
+)
+
+// selfTestData is signed by the crypto self-test
+var selfTestData = []byte("signing-service self-test")
+
+// Pinger is a dependency that can be checked for connectivity, like persistence.DeviceRepository
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Uptime reports how long the service has been running since start. It always passes.
+func Uptime(start time.Time) Checker {
+	return CheckerFunc(func(ctx context.Context) []Result {
+		return []Result{{
+			ComponentType: "system",
+			ObservedValue: time.Since(start).Seconds(),
+			ObservedUnit:  "s",
+			Status:        StatusPass,
+		}}
+	})
+}
+
+// Connectivity pings a datastore and reports the latency in milliseconds
+func Connectivity(pinger Pinger) Checker {
+	return CheckerFunc(func(ctx context.Context) []Result {
+		started := time.Now()
+		err := pinger.Ping(ctx)
+		result := Result{
+			ComponentType: "datastore",
+			ObservedValue: float64(time.Since(started).Microseconds()) / 1000,
+			ObservedUnit:  "ms",
+			Status:        StatusPass,
+		}
+		if err != nil {
+			result.Status, result.Output = StatusFail, err.Error()
+		}
+		return []Result{result}
+	})
+}
+
+// CryptoSelfTest signs and verifies data with a key of every algorithm, reporting one result per
+// algorithm. The keys are generated by the factory on the first check and reused afterwards, as
+// generating large RSA keys on every probe would be too slow.
+func CryptoSelfTest(factory *crypto.KeyPairFactory, algorithms ...domain.AlgorithmType) Checker {
+	test := &selfTest{factory: factory, keys: make(map[domain.AlgorithmType]selfTestKey)}
+	return CheckerFunc(func(ctx context.Context) []Result {
+		results := make([]Result, 0, len(algorithms))
+		for _, algorithm := range algorithms {
+			result := Result{ComponentID: string(algorithm), ComponentType: "component", Status: StatusPass}
+			if err := test.run(ctx, algorithm); err != nil {
+				result.Status, result.Output = StatusFail, err.Error()
+			}
+			results = append(results, result)
+		}
+		return results
+	})
+}
+
+// selfTestKey is a key pair of the crypto self-test
+type selfTestKey struct {
+	publicKey []byte
+	signer    crypto.Signer
+}
+
+// selfTest holds the keys of the crypto self-test
+type selfTest struct {
+	factory *crypto.KeyPairFactory
+	mu      sync.Mutex
+	keys    map[domain.AlgorithmType]selfTestKey
+}
+
+// run signs and verifies the self-test data with the key of an algorithm
+func (t *selfTest) run(ctx context.Context, algorithm domain.AlgorithmType) error {
+	key, err := t.key(ctx, algorithm)
+	if err != nil {
+		return err
+	}
+	signature, err := key.signer.Sign(ctx, selfTestData)
+	if err != nil {
+		return err
+	}
+	return crypto.Verify(algorithm, key.publicKey, selfTestData, signature)
+}
+
+// key returns the key of an algorithm, generating it if there is none yet
+func (t *selfTest) key(ctx context.Context, algorithm domain.AlgorithmType) (selfTestKey, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if key, ok := t.keys[algorithm]; ok {
+		return key, nil
+	}
+
+	generator, err := t.factory.GetKeyPair(algorithm)
+	if err != nil {
+		return selfTestKey{}, err
+	}
+	publicKey, privateKey, err := generator.GenerateKeyPair(ctx)
+	if err != nil {
+		return selfTestKey{}, fmt.Errorf("key generation failed: %w", err)
+	}
+	signer, err := generator.UnmarshalPrivateKey(privateKey)
+	if err != nil {
+		return selfTestKey{}, err
+	}
+	key := selfTestKey{publicKey: publicKey, signer: signer}
+	t.keys[algorithm] = key
+	return key, nil
+}
+
+// DefaultKeyGenerationThreshold is the key generation latency above which the KeyGeneration check warns
+const DefaultKeyGenerationThreshold = time.Second
+
+// keyGenerationInterval is how long the key generation check reports its last measurement before
+// generating keys again
+const keyGenerationInterval = time.Minute
+
+// KeyGeneration generates a key of every algorithm with the factory and reports how long it took in
+// milliseconds, one result per algorithm. It warns when generating a key takes longer than threshold,
+// as new devices are then slow to create, and fails when no key can be generated. The keys are
+// generated at most once a minute and the last measurement is reported in between.
+func KeyGeneration(factory *crypto.KeyPairFactory, threshold time.Duration, algorithms ...domain.AlgorithmType) Checker {
+	check := &keyGeneration{factory: factory, threshold: threshold, algorithms: algorithms}
+	return CheckerFunc(check.check)
+}
+
+// keyGeneration holds the last measurement of the key generation check
+type keyGeneration struct {
+	factory    *crypto.KeyPairFactory
+	threshold  time.Duration
+	algorithms []domain.AlgorithmType
+	mu         sync.Mutex
+	measured   time.Time
+	results    []Result
+}
+
+// check reports the last measurement, measuring again if it is older than the interval
+func (g *keyGeneration) check(ctx context.Context) []Result {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.results == nil || time.Since(g.measured) >= keyGenerationInterval {
+		results := make([]Result, 0, len(g.algorithms))
+		for _, algorithm := range g.algorithms {
+			results = append(results, g.measure(ctx, algorithm))
+		}
+		g.results, g.measured = results, time.Now()
+	}
+	return slices.Clone(g.results)
+}
+
+// measure generates a key of an algorithm and compares the latency with the threshold
+func (g *keyGeneration) measure(ctx context.Context, algorithm domain.AlgorithmType) Result {
+	result := Result{ComponentID: string(algorithm), ComponentType: "component", Status: StatusPass, Time: time.Now().UTC()}
+	generator, err := g.factory.GetKeyPair(algorithm)
+	if err != nil {
+		result.Status, result.Output = StatusFail, err.Error()
+		return result
+	}
+
+	started := time.Now()
+	_, privateKey, err := generator.GenerateKeyPair(ctx)
+	latency := time.Since(started)
+	clear(privateKey)
+	if err != nil {
+		result.Status, result.Output = StatusFail, fmt.Sprintf("key generation failed: %v", err)
+		return result
+	}
+	result.ObservedValue, result.ObservedUnit = float64(latency.Microseconds())/1000, "ms"
+	if latency > g.threshold {
+		result.Status, result.Output = StatusWarn, fmt.Sprintf("key generation took longer than %s", g.threshold)
+	}
+	return result
+}
+
+// DiskSpace reports the free space of the file system holding path in bytes. It warns when less than
+// twice minFree is left and fails when less than minFree is left.
+func DiskSpace(path string, minFree uint64) Checker {
+	return CheckerFunc(func(ctx context.Context) []Result {
+		free, err := freeSpace(path)
+		if err != nil {
+			return []Result{{ComponentType: "system", Status: StatusWarn, Output: err.Error()}}
+		}
+		result := Result{ComponentType: "system", ObservedValue: free, ObservedUnit: "bytes", Status: StatusPass}
+		switch {
+		case free < minFree:
+			result.Status, result.Output = StatusFail, fmt.Sprintf("less than %d bytes free", minFree)
+		case free < 2*minFree:
+			result.Status, result.Output = StatusWarn, fmt.Sprintf("less than %d bytes free", 2*minFree)
+		}
+		return []Result{result}
+	})
+}
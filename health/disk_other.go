@@ -0,0 +1,10 @@
+//go:build !unix
+
+package health
+
+import "errors"
+
+// freeSpace is not supported on this platform
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.New("disk space is not supported on this platform")
+}
@@ -0,0 +1,203 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ContentType is the media type of health reports, see https://datatracker.ietf.org/doc/html/draft-inadarei-api-health-check
+const ContentType = "application/health+json"
+
+// DefaultTimeout is how long a check may take before it is reported as failed
+const DefaultTimeout = 5 * time.Second
+
+// Status is the health of a service or one of its components
+type Status string
+
+// Statuses, from best to worst
+const (
+	// StatusPass is a healthy component
+	StatusPass Status = "pass"
+	// StatusWarn is a healthy component with a concern, e.g. little disk space left
+	StatusWarn Status = "warn"
+	// StatusFail is an unhealthy component
+	StatusFail Status = "fail"
+)
+
+// severity orders the statuses from best to worst
+var severity = map[Status]int{StatusPass: 0, StatusWarn: 1, StatusFail: 2}
+
+// worse returns the worse of two statuses
+func worse(a, b Status) Status {
+	if severity[b] > severity[a] {
+		return b
+	}
+	return a
+}
+
+// Result is the outcome of a check of one component
+type Result struct {
+	// ComponentID identifies the component if a check covers several, e.g. the algorithm of a self-test
+	ComponentID string `json:"componentId,omitempty"`
+	// ComponentType is the kind of the component, e.g. datastore or system
+	ComponentType string `json:"componentType,omitempty"`
+	// ObservedValue is what the check measured, in ObservedUnit
+	ObservedValue interface{} `json:"observedValue,omitempty"`
+	ObservedUnit  string      `json:"observedUnit,omitempty"`
+	Status        Status      `json:"status"`
+	// Time is when the check was run
+	Time time.Time `json:"time"`
+	// Output describes why a check did not pass; it is empty for passing checks
+	Output string `json:"output,omitempty"`
+}
+
+// Checker checks the health of one or more components
+type Checker interface {
+	Check(ctx context.Context) []Result
+}
+
+// CheckerFunc adapts a function to a Checker
+type CheckerFunc func(ctx context.Context) []Result
+
+// Check calls the function
+func (f CheckerFunc) Check(ctx context.Context) []Result {
+	return f(ctx)
+}
+
+// Probe is the question a check answers
+type Probe int
+
+// Probes
+const (
+	// Readiness checks tell whether the service can handle requests, e.g. whether its database is reachable
+	Readiness Probe = iota
+	// Liveness checks tell whether the service must be restarted. They are also part of the readiness checks.
+	Liveness
+)
+
+// Report is the health of the service in the application/health+json format
+type Report struct {
+	Status Status `json:"status"`
+	// Version is the public version of the service
+	Version string `json:"version,omitempty"`
+	// ReleaseID is the revision the service was built from
+	ReleaseID   string `json:"releaseId,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Checks are the results by check name, in the format component:measurement, e.g. datastore:connectivity
+	Checks map[string][]Result `json:"checks,omitempty"`
+}
+
+// check is a registered Checker
+type check struct {
+	name    string
+	probe   Probe
+	checker Checker
+}
+
+// Registry holds the checks of the service
+type Registry struct {
+	mu      sync.RWMutex
+	checks  []check
+	timeout time.Duration
+}
+
+// NewRegistry creates a registry without checks, whose checks time out after DefaultTimeout
+func NewRegistry() *Registry {
+	return &Registry{timeout: DefaultTimeout}
+}
+
+// SetTimeout sets how long a check may take before it is reported as failed
+func (r *Registry) SetTimeout(timeout time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timeout = timeout
+}
+
+// Register adds a check under a name in the format component:measurement. A check registered again under
+// the same name replaces the earlier one.
+func (r *Registry) Register(name string, probe Probe, checker Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.checks {
+		if r.checks[i].name == name {
+			r.checks[i] = check{name, probe, checker}
+			return
+		}
+	}
+	r.checks = append(r.checks, check{name, probe, checker})
+}
+
+// Run runs the checks of a probe concurrently and reports the worst status of their results. The
+// readiness probe runs every check.
+func (r *Registry) Run(ctx context.Context, probe Probe) Report {
+	r.mu.RLock()
+	var checks []check
+	for _, c := range r.checks {
+		if probe == Readiness || c.probe == probe {
+			checks = append(checks, c)
+		}
+	}
+	timeout := r.timeout
+	r.mu.RUnlock()
+
+	results := make([][]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c check) {
+			defer wg.Done()
+			results[i] = run(ctx, c.checker, timeout)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusPass}
+	if len(checks) > 0 {
+		report.Checks = make(map[string][]Result, len(checks))
+	}
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+		for _, result := range results[i] {
+			report.Status = worse(report.Status, result.Status)
+		}
+	}
+	return report
+}
+
+// run runs a check, failing it if it takes longer than the timeout or returns no results
+func run(ctx context.Context, checker Checker, timeout time.Duration) []Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	started := time.Now().UTC()
+	done := make(chan []Result, 1)
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				done <- []Result{{Status: StatusFail, Output: fmt.Sprintf("check panicked: %v", recovered)}}
+			}
+		}()
+		done <- checker.Check(ctx)
+	}()
+
+	var results []Result
+	select {
+	case results = <-done:
+	case <-ctx.Done():
+		results = []Result{{Status: StatusFail, Output: fmt.Sprintf("check did not complete: %v", ctx.Err())}}
+	}
+	if len(results) == 0 {
+		results = []Result{{Status: StatusFail, Output: "check returned no results"}}
+	}
+	for i := range results {
+		if results[i].Status == "" {
+			results[i].Status = StatusFail
+		}
+		if results[i].Time.IsZero() {
+			results[i].Time = started
+		}
+	}
+	return results
+}
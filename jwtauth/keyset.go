@@ -0,0 +1,275 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownKey is returned when a token is signed with a key that is not in the key set
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// KeySource provides the public keys tokens are verified with
+type KeySource interface {
+	// Key returns the public key with the given key ID and the algorithm it is restricted to, if any.
+	// Sources fetching keys give up when ctx is done.
+	Key(ctx context.Context, kid string) (crypto.PublicKey, string, error)
+}
+
+// KeySet is a parsed JSON Web Key Set (RFC 7517). It holds RSA, EC (P-256) and Ed25519 public keys.
+type KeySet struct {
+	keys map[string]publicKey
+}
+
+// publicKey is a key of a KeySet
+type publicKey struct {
+	key crypto.PublicKey
+	// alg is the algorithm the key may be used with, empty if not restricted
+	alg string
+}
+
+// jsonWebKey is the JSON form of a key; only the members of public signing keys are read
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseKeySet parses a JWKS document. Keys that are not meant for signatures are skipped.
+func ParseKeySet(document []byte) (*KeySet, error) {
+	var raw struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(document, &raw); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %v", err)
+	}
+
+	set := &KeySet{keys: make(map[string]publicKey, len(raw.Keys))}
+	for _, jwk := range raw.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %q: %v", jwk.Kid, err)
+		}
+		set.keys[jwk.Kid] = publicKey{key: key, alg: jwk.Alg}
+	}
+	return set, nil
+}
+
+// Key returns the public key with the given key ID and the algorithm it is restricted to, if any
+func (set *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, string, error) {
+	key, exists := set.keys[kid]
+	if !exists {
+		return nil, "", ErrUnknownKey
+	}
+	return key.key, key.alg, nil
+}
+
+// publicKey decodes the public key of a JWK
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if n.BitLen() < 2048 || !e.IsInt64() {
+			return nil, errors.New("RSA keys must have at least 2048 bits")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+// decodeBigInt decodes a base64url encoded big-endian integer
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(bytes) == 0 {
+		return nil, errors.New("invalid integer")
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
+
+// LoadKeySource loads a JWKS from a file, or from a URL if the location starts with http:// or https://
+func LoadKeySource(ctx context.Context, location string) (KeySource, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return NewRemoteKeySet(ctx, location, &http.Client{Timeout: DefaultFetchTimeout}, DefaultRefreshInterval)
+	}
+
+	document, err := os.ReadFile(location)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeySet(document)
+}
+
+// DefaultRefreshInterval is how often a RemoteKeySet is refreshed, and the minimum time between two
+// refreshes triggered by unknown key IDs
+const DefaultRefreshInterval = 5 * time.Minute
+
+// DefaultFetchTimeout is how long fetching a key set may take, so a hanging JWKS endpoint can't hold up
+// the requests waiting for it
+const DefaultFetchTimeout = 10 * time.Second
+
+// RemoteKeySet is a JWKS fetched from a URL. It is refreshed periodically and when a token is signed
+// with an unknown key, so rotated keys are picked up without a restart. Refreshes run in the background
+// while the cached keys keep being served.
+type RemoteKeySet struct {
+	url    string
+	client *http.Client
+	// refreshInterval is the maximum age of the cached keys and the minimum time between two refreshes
+	refreshInterval time.Duration
+
+	mu          sync.Mutex
+	keys        *KeySet
+	refreshedAt time.Time
+	// refreshing is closed when the refresh in progress completes, nil if none is in progress
+	refreshing chan struct{}
+	// refreshErr is the error of the last refresh
+	refreshErr error
+}
+
+// NewRemoteKeySet creates a key set fetched from a URL and refreshed in the given interval
+// (DefaultRefreshInterval if zero); the keys are fetched once right away
+func NewRemoteKeySet(ctx context.Context, url string, client *http.Client, refreshInterval time.Duration) (*RemoteKeySet, error) {
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultRefreshInterval
+	}
+	set := &RemoteKeySet{url: url, client: client, refreshInterval: refreshInterval, refreshedAt: time.Now()}
+	keys, err := set.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	set.keys = keys
+	return set, nil
+}
+
+// Key returns the public key with the given key ID. Stale keys are served while the key set is refreshed
+// in the background; unknown key IDs wait for a refresh as long as ctx allows.
+func (set *RemoteKeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, string, error) {
+	set.mu.Lock()
+	if time.Since(set.refreshedAt) >= set.refreshInterval {
+		// A failed refresh keeps the previous keys until the next attempt
+		set.startRefresh()
+	}
+	key, alg, err := set.keys.Key(ctx, kid)
+	if errors.Is(err, ErrUnknownKey) && time.Since(set.refreshedAt) >= set.refreshInterval/5 {
+		set.startRefresh()
+	}
+	done := set.refreshing
+	set.mu.Unlock()
+	if !errors.Is(err, ErrUnknownKey) || done == nil {
+		return key, alg, err
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, "", ctx.Err()
+	}
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if set.refreshErr != nil {
+		return nil, "", set.refreshErr
+	}
+	return set.keys.Key(ctx, kid)
+}
+
+// startRefresh refreshes the key set in the background unless a refresh is in progress; callers hold the lock
+func (set *RemoteKeySet) startRefresh() {
+	if set.refreshing != nil {
+		return
+	}
+	done := make(chan struct{})
+	set.refreshing, set.refreshedAt = done, time.Now()
+
+	go func() {
+		// The refresh is shared by all requests waiting for it, so it is only bounded by the fetch timeout
+		keys, err := set.fetch(context.Background())
+		set.mu.Lock()
+		if err == nil {
+			set.keys = keys
+		}
+		set.refreshErr, set.refreshing = err, nil
+		set.mu.Unlock()
+		close(done)
+	}()
+}
+
+// fetch downloads and parses the key set, giving up after DefaultFetchTimeout
+func (set *RemoteKeySet) fetch(ctx context.Context) (*KeySet, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultFetchTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, set.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+
+	response, err := set.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: %s", response.Status)
+	}
+
+	// Key sets are small; anything larger is not a key set
+	document, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	return ParseKeySet(document)
+}
@@ -0,0 +1,66 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"time"
+)
+
+// ErrInvalidToken is returned for tokens that are malformed, expired, not issued for this service or
+// not signed by a key of the key set
+var ErrInvalidToken = errors.New("invalid token")
+
+// SupportedAlgorithms are the signature algorithms tokens may be signed with
+var SupportedAlgorithms = []string{"RS256", "ES256", "EdDSA"}
+
+// DefaultLeeway is the clock skew tolerated when checking the expiry and not-before times of tokens
+const DefaultLeeway = 30 * time.Second
+
+// Verifier checks the signature, issuer, audience and expiry of JWTs
+type Verifier struct {
+	keys   KeySource
+	parser *jwt.Parser
+}
+
+// NewVerifier creates a verifier accepting tokens signed by a key of the key source that were issued by
+// the given issuer for the given audience. Tokens must carry an expiry time.
+func NewVerifier(keys KeySource, issuer, audience string) *Verifier {
+	return &Verifier{
+		keys: keys,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(SupportedAlgorithms),
+			jwt.WithIssuer(issuer),
+			jwt.WithAudience(audience),
+			jwt.WithExpirationRequired(),
+			jwt.WithLeeway(DefaultLeeway),
+		),
+	}
+}
+
+// Verify verifies a token and returns its claims; looking up its key gives up when ctx is done
+func (verifier *Verifier) Verify(ctx context.Context, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		return verifier.key(ctx, token)
+	}
+	if _, err := verifier.parser.ParseWithClaims(token, claims, keyFunc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
+
+// key looks up the key a token was signed with by its key ID. The signing method checks that the key
+// type fits the algorithm, so an RSA key can't be used to verify an EdDSA token and vice versa.
+func (verifier *Verifier) key(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, alg, err := verifier.keys.Key(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+	if alg != "" && alg != token.Method.Alg() {
+		return nil, fmt.Errorf("key %q is not used with %s", kid, token.Method.Alg())
+	}
+	return key, nil
+}
@@ -0,0 +1,128 @@
+package logging
+
+import (
+	"context"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// RequestIDHeader is the header carrying the ID of a request, accepted from clients and echoed in responses
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the maximum length of request IDs accepted from clients
+const maxRequestIDLength = 128
+
+// Formats of the log output
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Redacted replaces the values of sensitive attributes
+const Redacted = "REDACTED"
+
+// sensitiveKeys are the attribute keys, lower case without separators, whose values are never logged:
+// key material, credentials and the transaction data clients sign
+var sensitiveKeys = map[string]bool{
+	"privatekey":    true,
+	"apikey":        true,
+	"secret":        true,
+	"password":      true,
+	"token":         true,
+	"authorization": true,
+	"data":          true,
+	"payload":       true,
+	"signeddata":    true,
+}
+
+// contextKey is the type of the context keys of the package
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	loggerKey
+)
+
+// New creates a logger writing to w in a format, FormatText or FormatJSON, that leaves out records below
+// level and redacts sensitive attributes
+func New(w io.Writer, format string, level slog.Level) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: Redact}
+	if format == FormatText {
+		return slog.New(slog.NewTextHandler(w, options))
+	}
+	return slog.New(slog.NewJSONHandler(w, options))
+}
+
+// Redact replaces the value of attributes with sensitive keys, e.g. privateKey or data, with Redacted.
+// It is used as slog.HandlerOptions.ReplaceAttr, so it also applies to attributes added by dependencies.
+func Redact(groups []string, attr slog.Attr) slog.Attr {
+	key := strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(attr.Key))
+	if sensitiveKeys[key] {
+		return slog.String(attr.Key, Redacted)
+	}
+	return attr
+}
+
+// NewRequestID generates the ID of a request that arrived without a valid one
+func NewRequestID() string {
+	return uuid.NewString()
+}
+
+// ValidRequestID reports whether a request ID from a client can be used: it must not be longer than 128
+// characters and only contain letters, digits and . _ : -, so it can't forge log lines
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == ':', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WithRequestID returns a context carrying the ID of a request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the ID of the request a context belongs to, empty outside of requests
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithLogger returns a context carrying a logger, typically one that adds the request ID to every record
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext returns the logger of a context, or the default logger if it carries none
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// RepositoryOperation logs a repository operation at debug level, or at warn level if it failed with an
+// error that is not a domain error like a missing device. Its signature matches persistence.OperationObserver.
+func RepositoryOperation(ctx context.Context, operation string, duration time.Duration, err error) {
+	logger := FromContext(ctx)
+	if err == nil {
+		logger.LogAttrs(ctx, slog.LevelDebug, "repository operation", slog.String("operation", operation), slog.Duration("duration", duration))
+		return
+	}
+	level := slog.LevelDebug
+	if domain.CodeOf(err) == "" {
+		level = slog.LevelWarn
+	}
+	logger.LogAttrs(ctx, level, "repository operation failed", slog.String("operation", operation), slog.Duration("duration", duration), slog.String("error", err.Error()))
+}
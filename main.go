@@ -1,35 +1,226 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"github.com/joho/godotenv"
-	"log"
+	"io/fs"
+	"log/slog"
 	"os"
-	"regexp"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/api"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/config"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/health"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/jwtauth"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/metrics"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ratelimit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/tracing"
 )
 
 func main() {
+	// Log JSON until the configured logger replaces the default one
+	slog.SetDefault(logging.New(os.Stderr, logging.FormatJSON, slog.LevelInfo))
 
-	// Regex to match the current working directory
-	re := regexp.MustCompile(`^(.*` + "signing-service-challenge-go" + `)`)
-	cwd, _ := os.Getwd()
-	rootPath := re.Find([]byte(cwd))
+	// Load environment variables from the optional .env file of the working directory; variables that are
+	// already set take precedence
+	if err := godotenv.Load(".env"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fatal("failed to load .env file", err)
+	}
+
+	// Merge the defaults, the configuration file, the environment variables and the flags
+	cfg, options, err := config.Load(os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("invalid configuration", err)
+	}
+	if options.PrintConfig {
+		if err = cfg.Write(os.Stdout); err != nil {
+			fatal("failed to print configuration", err)
+		}
+		return
+	}
+	slog.SetDefault(newLogger(cfg.Log))
+
+	// Trace requests with the configured exporter; incoming W3C trace context is propagated in any case
+	shutdownTracing, err := tracing.Setup(tracing.Settings{
+		Exporter:    cfg.Tracing.Exporter,
+		File:        cfg.Tracing.File,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		fatal("failed to set up tracing", err)
+	}
+
+	store := newRepository(cfg.Storage)
+	server := api.NewServer(cfg.Server.Address, serverOptions(cfg, store)...)
+
+	// Stop accepting requests on SIGINT or SIGTERM and let the in-flight ones complete
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Load environment variables from .env file
-	if err := godotenv.Load(string(rootPath) + `/.env`); err != nil {
-		log.Fatalf("Error loading .env file")
+	slog.Info("starting server", "address", cfg.Server.Address, "tls", cfg.TLS.CertFile != "", "storage", cfg.Storage.Backend)
+	err = server.Run(ctx)
+	if err != nil {
+		slog.Error("server stopped", "error", err)
+	} else {
+		slog.Info("server stopped")
 	}
 
-	// Get the DATA_STORE environment variable
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080" // default port
+	// The repository is closed after the last request completed
+	if closeErr := store.Close(); closeErr != nil {
+		slog.Error("failed to close repository", "error", closeErr)
 	}
-	listenAddress := ":" + port
-	server := api.NewServer(listenAddress)
 
-	if err := server.Run(); err != nil {
-		log.Fatal("Could not start server on ", listenAddress)
+	// Export the spans of the last requests
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if flushErr := shutdownTracing(flushCtx); flushErr != nil {
+		slog.Error("failed to export spans", "error", flushErr)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// newRepository creates the repository of the storage backend
+func newRepository(settings config.StorageConfig) persistence.DeviceRepository {
+	if settings.Backend == config.StorageSQLite {
+		store, err := persistence.NewSQLiteDeviceRepository(settings.DSN)
+		if err != nil {
+			fatal("failed to create SQLite repository", err)
+		}
+		return store
+	}
+	return persistence.NewInMemoryDeviceRepository()
+}
+
+// newLogger creates the logger configured by the log settings
+func newLogger(settings config.LogConfig) *slog.Logger {
+	var level slog.Level
+	// The level is validated by the configuration
+	_ = level.UnmarshalText([]byte(settings.Level))
+	return logging.New(os.Stderr, settings.Format, level)
+}
+
+// fatal logs an error that keeps the service from starting and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// serverOptions creates the services, authenticators and rate limiter described by the configuration on the store
+func serverOptions(cfg *config.Config, store persistence.DeviceRepository) []api.ServerOption {
+	// Record the devices, signatures, requests and repository operations in the metrics, and log and trace
+	// the repository operations of every request
+	m := metrics.New()
+	m.TrackDevices(store)
+	observed := persistence.NewObservedRepository(store, m.ObserveRepositoryOperation, logging.RepositoryOperation, tracing.RepositoryOperation)
+
+	// Initialize the device service with the store
+	keyPairs := crypto.NewKeyPairFactory(crypto.WithRSAKeyBits(cfg.Crypto.RSAKeyBits))
+	deviceService := api.NewDeviceService(observed,
+		api.WithMetrics(m),
+		api.WithIdempotencyRetention(cfg.Storage.IdempotencyRetention),
+		api.WithDeviceIDVersion(cfg.Crypto.DeviceIDVersion),
+		api.WithKeyPairFactory(keyPairs),
+		api.WithDefaultSignedDataFormat(cfg.Crypto.SignedDataFormat),
+	)
+
+	// Initialize the API key service; the optional bootstrap key is registered as the first admin key
+	apiKeyService := api.NewAPIKeyService(observed)
+	if cfg.Auth.BootstrapAPIKey != "" {
+		if err := api.BootstrapAPIKey(context.Background(), store, cfg.Auth.BootstrapAPIKey); err != nil {
+			fatal("invalid bootstrap API key", err)
+		}
+	}
+	var authenticator api.Authenticator = api.NewAPIKeyAuthenticator(apiKeyService)
+
+	// JWTs signed by the keys of the JWKS are accepted alongside API keys if they were issued by the
+	// configured issuer for the configured audience
+	if jwt := cfg.Auth.JWT; jwt.JWKS != "" {
+		keys, err := jwtauth.LoadKeySource(context.Background(), jwt.JWKS)
+		if err != nil {
+			fatal("invalid JWKS", err)
+		}
+		verifier := jwtauth.NewVerifier(keys, jwt.Issuer, jwt.Audience)
+		authenticator = api.NewChainAuthenticator(authenticator, api.NewJWTAuthenticator(verifier, jwt.TenantClaim))
+	}
+
+	// Clients with a verified TLS client certificate are granted the certificate scopes, if they send no
+	// other credentials; the scopes are validated by the configuration
+	clientScopes, _ := domain.ParseScopes(cfg.Auth.CertificateScopes)
+	authenticator = api.NewChainAuthenticator(authenticator, api.NewCertificateAuthenticator(clientScopes))
+
+	// A rate of 0 disables a limit
+	rateLimiter := api.NewRateLimiter(ratelimit.NewMemoryStore(),
+		ratelimit.Limit{Rate: cfg.RateLimit.Client.Rate, Burst: cfg.RateLimit.Client.Burst},
+		ratelimit.Limit{Rate: cfg.RateLimit.Device.Rate, Burst: cfg.RateLimit.Device.Burst},
+	)
+
+	options := []api.ServerOption{
+		api.WithRepository(store),
+		api.WithServerMetrics(m),
+		api.WithLogger(slog.Default()),
+		api.WithDeviceService(deviceService),
+		api.WithAPIKeyService(apiKeyService),
+		api.WithAuthenticator(authenticator),
+		api.WithRateLimiter(rateLimiter),
+		api.WithTimeouts(api.ServerTimeouts{
+			Read:       cfg.Server.ReadTimeout,
+			ReadHeader: cfg.Server.ReadHeaderTimeout,
+			Write:      cfg.Server.WriteTimeout,
+			Idle:       cfg.Server.IdleTimeout,
+			Shutdown:   cfg.Server.ShutdownTimeout,
+		}),
+		api.WithLimits(api.ServerLimits{
+			MaxHeaderBytes: cfg.Server.MaxHeaderBytes,
+			MaxBodyBytes:   cfg.Server.MaxBodyBytes,
+		}),
+	}
+
+	// The crypto checks generate keys of the configured size; the sqlite backend needs disk space
+	options = append(options, api.WithHealthCheck("crypto:selfTest", health.Readiness, health.CryptoSelfTest(keyPairs, domain.ECC, domain.RSA)))
+	options = append(options, api.WithHealthCheck("crypto:keyGeneration", health.Readiness, health.KeyGeneration(keyPairs, cfg.Crypto.KeyGenerationThreshold, domain.ECC, domain.RSA)))
+	if cfg.Storage.Backend == config.StorageSQLite {
+		options = append(options, api.WithHealthCheck("disk:free", health.Readiness, health.DiskSpace(dataDirectory(cfg.Storage.DSN), cfg.Storage.MinFreeBytes)))
+	}
+
+	// Serve HTTPS if a certificate is configured
+	if cfg.TLS.CertFile != "" {
+		tlsConfig, err := api.NewTLSConfig(api.TLSSettings{
+			CertFile:     cfg.TLS.CertFile,
+			KeyFile:      cfg.TLS.KeyFile,
+			MinVersion:   cfg.TLS.MinVersion,
+			CipherPolicy: cfg.TLS.CipherPolicy,
+			ClientCAFile: cfg.TLS.ClientCAFile,
+			ClientAuth:   cfg.TLS.ClientAuth,
+		})
+		if err != nil {
+			fatal("invalid TLS configuration", err)
+		}
+		options = append(options, api.WithTLS(tlsConfig))
+	}
+	return options
+}
+
+// dataDirectory returns the directory of the database file of a SQLite data source name, which may be a
+// file: URI with parameters
+func dataDirectory(dataSourceName string) string {
+	path := strings.TrimPrefix(dataSourceName, "file:")
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
 	}
+	return filepath.Dir(path)
 }
@@ -0,0 +1,269 @@
+package metrics
+
+import (
+	"context"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/buildinfo"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// namespace prefixes the names of all metrics of the service
+const namespace = "signing"
+
+// OutcomeSuccess is the outcome of operations that did not fail. Failed operations have the code of their
+// domain error as outcome, e.g. device_not_found, or OutcomeInternalError.
+const (
+	OutcomeSuccess       = "success"
+	OutcomeInternalError = "internal_error"
+)
+
+// cryptoBuckets are the histogram buckets of key generation and signing, from 50µs to 10s, as RSA key
+// generation is orders of magnitude slower than ECDSA signing
+var cryptoBuckets = prometheus.ExponentialBucketsRange(0.00005, 10, 12)
+
+// DeviceCounter counts the devices that have not been deleted, like persistence.DeviceRepository
+type DeviceCounter interface {
+	CountDevices(ctx context.Context) (int, error)
+}
+
+// Metrics holds the Prometheus metrics of the service in its own registry, so several servers in one
+// process don't share them. All methods can be called on a nil *Metrics, which records nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	deviceCreations    *prometheus.CounterVec
+	signatures         *prometheus.CounterVec
+	requestDuration    *prometheus.HistogramVec
+	requestsInFlight   prometheus.Gauge
+	keyGeneration      *prometheus.HistogramVec
+	signing            *prometheus.HistogramVec
+	repositoryDuration *prometheus.HistogramVec
+	devicesMu          sync.RWMutex
+	devices            DeviceCounter
+}
+
+// New creates the metrics of the service, including the Go runtime and process metrics
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		deviceCreations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "device_creations_total",
+			Help:      "Signature devices created, by algorithm and outcome.",
+		}, []string{"algorithm", "outcome"}),
+		signatures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "signatures_total",
+			Help:      "Transactions signed, by algorithm and outcome.",
+		}, []string{"algorithm", "outcome"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of HTTP requests, by method, route and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "code"}),
+		requestsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "http_requests_in_flight",
+			Help:      "HTTP requests being served.",
+		}),
+		keyGeneration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "key_generation_duration_seconds",
+			Help:      "Duration of generating the key pair of a device, by algorithm.",
+			Buckets:   cryptoBuckets,
+		}, []string{"algorithm"}),
+		signing: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "signing_duration_seconds",
+			Help:      "Duration of signing the signed data of a transaction, by algorithm.",
+			Buckets:   cryptoBuckets,
+		}, []string{"algorithm"}),
+		repositoryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "repository_operation_duration_seconds",
+			Help:      "Duration of repository operations, by operation and outcome.",
+			Buckets:   prometheus.ExponentialBucketsRange(0.00001, 1, 11),
+		}, []string{"operation", "outcome"}),
+	}
+
+	buildInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "build_info",
+		Help:        "Always 1; the labels hold the version and revision of the running service.",
+		ConstLabels: prometheus.Labels{"version": buildinfo.Version(), "revision": buildinfo.Revision()},
+	})
+	buildInfo.Set(1)
+
+	devices := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "devices",
+		Help:      "Signature devices that have not been deleted.",
+	}, m.countDevices)
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		buildInfo,
+		devices,
+		m.deviceCreations,
+		m.signatures,
+		m.requestDuration,
+		m.requestsInFlight,
+		m.keyGeneration,
+		m.signing,
+		m.repositoryDuration,
+	)
+	return m
+}
+
+// Registry returns the registry holding the metrics, e.g. to register further collectors
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// TrackDevices reports the number of devices counted by counter whenever the metrics are collected
+func (m *Metrics) TrackDevices(counter DeviceCounter) {
+	if m == nil {
+		return
+	}
+	m.devicesMu.Lock()
+	defer m.devicesMu.Unlock()
+	m.devices = counter
+}
+
+// countDevices counts the devices for the devices gauge, -1 if they can't be counted
+func (m *Metrics) countDevices() float64 {
+	m.devicesMu.RLock()
+	counter := m.devices
+	m.devicesMu.RUnlock()
+	if counter == nil {
+		return 0
+	}
+	count, err := counter.CountDevices(context.Background())
+	if err != nil {
+		return -1
+	}
+	return float64(count)
+}
+
+// Outcome returns the outcome label of an operation that returned err
+func Outcome(err error) string {
+	if err == nil {
+		return OutcomeSuccess
+	}
+	if code := domain.CodeOf(err); code != "" {
+		return code
+	}
+	return OutcomeInternalError
+}
+
+// algorithmLabel returns the label of an algorithm; it is "unknown" for requests rejected before the
+// algorithm was known and "unsupported" for algorithms the service has no keys for, to bound the values
+func algorithmLabel(algorithm domain.AlgorithmType) string {
+	switch algorithm {
+	case domain.ECC, domain.RSA:
+		return string(algorithm)
+	case "":
+		return "unknown"
+	default:
+		return "unsupported"
+	}
+}
+
+// DeviceCreated counts the creation of a device with an algorithm that returned err
+func (m *Metrics) DeviceCreated(algorithm domain.AlgorithmType, err error) {
+	if m == nil {
+		return
+	}
+	m.deviceCreations.WithLabelValues(algorithmLabel(algorithm), Outcome(err)).Inc()
+}
+
+// TransactionSigned counts a signature with a device of an algorithm that returned err
+func (m *Metrics) TransactionSigned(algorithm domain.AlgorithmType, err error) {
+	if m == nil {
+		return
+	}
+	m.signatures.WithLabelValues(algorithmLabel(algorithm), Outcome(err)).Inc()
+}
+
+// ObserveKeyGeneration records the duration of generating a key pair
+func (m *Metrics) ObserveKeyGeneration(algorithm domain.AlgorithmType, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.keyGeneration.WithLabelValues(algorithmLabel(algorithm)).Observe(duration.Seconds())
+}
+
+// ObserveSigning records the duration of signing the signed data of a transaction
+func (m *Metrics) ObserveSigning(algorithm domain.AlgorithmType, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.signing.WithLabelValues(algorithmLabel(algorithm)).Observe(duration.Seconds())
+}
+
+// ObserveRepositoryOperation records the duration of a repository operation that returned err. Its
+// signature matches persistence.OperationObserver.
+func (m *Metrics) ObserveRepositoryOperation(ctx context.Context, operation string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.repositoryDuration.WithLabelValues(operation, Outcome(err)).Observe(duration.Seconds())
+}
+
+// InstrumentHandler records the duration of the requests of a route, which is the pattern the handler
+// is registered with, e.g. /api/v1/devices/{id}
+func (m *Metrics) InstrumentHandler(route string, handler http.Handler) http.Handler {
+	if m == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+		m.requestDuration.WithLabelValues(r.Method, route, strconv.Itoa(recorder.status)).Observe(time.Since(started).Seconds())
+	})
+}
+
+// InstrumentInFlight counts the requests being served by handler
+func (m *Metrics) InstrumentInFlight(handler http.Handler) http.Handler {
+	if m == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.requestsInFlight.Inc()
+		defer m.requestsInFlight.Dec()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap gives http.ResponseController access to the wrapped writer, e.g. to flush it
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,11 @@
+package request
+
+// APIKeyRequest request for creating an API key
+type APIKeyRequest struct {
+	// TenantID is the tenant the key acts for (optional, defaults to the tenant of the caller)
+	TenantID string `json:"tenantId"`
+	// ClientID identifies the client the key is issued to, e.g. in device policies (optional, defaults to the key ID)
+	ClientID string   `json:"clientId"`
+	Name     string   `json:"name"`   // JSON label for Name (optional)
+	Scopes   []string `json:"scopes"` // JSON label for Scopes: devices:read, devices:write, sign and admin
+}
@@ -0,0 +1,11 @@
+package request
+
+import "encoding/json"
+
+// DevicePolicyRequest request for replacing the signing policy of a device
+type DevicePolicyRequest struct {
+	// Policy is the new policy document; null or {} removes all rules
+	Policy json.RawMessage `json:"policy"`
+	// ChangedBy is the identity of the client, taken from its credentials, not from the body
+	ChangedBy string `json:"-"`
+}
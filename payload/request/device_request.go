@@ -1,8 +1,28 @@
 package request
 
+import "encoding/json"
+
 // DeviceRequest request for creating a device
 type DeviceRequest struct {
-	ID        string `json:"id"`        // JSON label for ID
+	ID        string `json:"id"`        // JSON label for ID (optional, generated by the service if empty)
 	Algorithm string `json:"algorithm"` // JSON label for Algorithm
 	Label     string `json:"label"`     // JSON label for Label (optional)
+	// SignedDataFormat selects the signed-data format version, v1 or v2 (optional, defaults to v1)
+	SignedDataFormat string `json:"signedDataFormat"`
+	// Status is the initial lifecycle state, active or inactive (optional, defaults to active)
+	Status string `json:"status"`
+	// Metadata holds free-form attributes such as the store location or register serial (optional)
+	Metadata map[string]string `json:"metadata"`
+	// Limits restricts how many signatures the device can create and when (optional)
+	Limits *SigningLimitsRequest `json:"limits"`
+	// Policy restricts what the device may sign, see DevicePolicyRequest (optional)
+	Policy json.RawMessage `json:"policy"`
+	// BoundClient binds the device to the client certificate with this client ID, its common name (optional)
+	BoundClient string `json:"boundClient"`
+	// ClientID is the identity of the client, taken from its credentials, not from the body
+	ClientID string `json:"-"`
+	// TenantID is the tenant of the caller, derived from its credentials, not from the body
+	TenantID string `json:"-"`
+	// ClientCertificate is set if the caller authenticated by client certificate
+	ClientCertificate bool `json:"-"`
 }
@@ -0,0 +1,7 @@
+package request
+
+// DeviceStatusRequest request for changing the lifecycle state of a device
+type DeviceStatusRequest struct {
+	Action string `json:"action"` // JSON label for Action: activate, suspend, resume or decommission
+	Reason string `json:"reason"` // JSON label for Reason (optional)
+}
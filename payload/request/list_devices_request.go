@@ -0,0 +1,18 @@
+package request
+
+import "time"
+
+// ListDevicesRequest request for listing signature devices, built from the query string
+type ListDevicesRequest struct {
+	TenantID       string    // Tenant of the caller, derived from its credentials: only its devices are listed
+	Algorithm      string    // Query parameter algorithm: only list devices using this algorithm
+	LabelPrefix    string    // Query parameter labelPrefix: only list devices whose label starts with this prefix
+	Status         string    // Query parameter status: only list devices in this lifecycle state
+	CreatedFrom    time.Time // Query parameter createdFrom (RFC 3339, inclusive)
+	CreatedTo      time.Time // Query parameter createdTo (RFC 3339, exclusive)
+	IncludeDeleted bool      // Query parameter includeDeleted: also list the tombstones of deleted devices
+	SortBy         string    // Query parameter sort: id, label or createdAt (default)
+	Order          string    // Query parameter order: asc (default) or desc
+	Cursor         string    // Query parameter cursor: the next cursor returned with the previous page
+	Limit          int       // Query parameter limit: page size, 100 by default and at most 1000
+}
@@ -1,7 +1,38 @@
 package request
 
+import (
+	"encoding/json"
+	"log/slog"
+)
+
 // SignTransactionRequest request for signing a transaction
 type SignTransactionRequest struct {
-	DeviceID string `json:"deviceId"` // JSON label for DeviceID
-	Data     string `json:"data"`     // JSON label for Data
+	DeviceID     string `json:"deviceId"`     // JSON label for DeviceID
+	Data         string `json:"data"`         // JSON label for Data
+	DataEncoding string `json:"dataEncoding"` // JSON label for DataEncoding: utf8, base64 or hex (optional)
+	Mode         string `json:"mode"`         // JSON label for Mode: data or digest (optional)
+	// Payload is a JSON object signed in its canonical (RFC 8785) form, instead of Data (optional)
+	Payload json.RawMessage `json:"payload"`
+	// IdempotencyKey is taken from the Idempotency-Key header, not from the body
+	IdempotencyKey string `json:"-"`
+	// ClientID is the identity of the client, taken from its credentials, not from the body
+	ClientID string `json:"-"`
+	// TenantID is the tenant of the caller, derived from its credentials, not from the body
+	TenantID string `json:"-"`
+	// ClientCertificate is set if the caller authenticated by client certificate; it can then only sign with
+	// the devices bound to its ClientID
+	ClientCertificate bool `json:"-"`
+}
+
+// LogValue logs the request without the transaction data, which must not reach the logs
+func (req *SignTransactionRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("device_id", req.DeviceID),
+		slog.String("data_encoding", req.DataEncoding),
+		slog.String("mode", req.Mode),
+		slog.Bool("idempotent", req.IdempotencyKey != ""),
+		slog.String("client_id", req.ClientID),
+		slog.String("tenant_id", req.TenantID),
+		slog.Bool("client_certificate", req.ClientCertificate),
+	)
 }
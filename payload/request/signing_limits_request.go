@@ -0,0 +1,11 @@
+package request
+
+import "time"
+
+// SigningLimitsRequest limits how many signatures a device can create and when. Zero or missing values mean no limit.
+type SigningLimitsRequest struct {
+	MaxSignatures uint64     `json:"maxSignatures"` // JSON label for MaxSignatures: signatures over the device's lifetime
+	DailyLimit    uint64     `json:"dailyLimit"`    // JSON label for DailyLimit: signatures per calendar day (UTC)
+	NotBefore     *time.Time `json:"notBefore"`     // JSON label for NotBefore: start of the validity window (RFC 3339)
+	NotAfter      *time.Time `json:"notAfter"`      // JSON label for NotAfter: end of the validity window (RFC 3339)
+}
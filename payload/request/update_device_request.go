@@ -0,0 +1,14 @@
+package request
+
+// UpdateDeviceRequest request for updating a device. Fields that are left out stay unchanged.
+type UpdateDeviceRequest struct {
+	Label *string `json:"label"` // JSON label for Label (optional)
+	// Metadata is merged into the existing metadata; keys set to null are removed (optional)
+	Metadata map[string]*string `json:"metadata"`
+	// Limits replaces the signing limits of the device as a whole (optional)
+	Limits *SigningLimitsRequest `json:"limits"`
+	// BoundClient binds the device to the client certificate with this client ID; an empty string unbinds it (optional)
+	BoundClient *string `json:"boundClient"`
+	// ClientCertificate is set if the caller authenticated by client certificate
+	ClientCertificate bool `json:"-"`
+}
@@ -0,0 +1,21 @@
+package response
+
+import "time"
+
+// APIKeyResponse response for an API key; the secret key itself is never returned again after creation
+type APIKeyResponse struct {
+	ID        string
+	TenantID  string
+	ClientID  string
+	Name      string
+	Scopes    []string
+	CreatedAt time.Time
+	RevokedAt *time.Time // Set once the key has been revoked
+}
+
+// CreatedAPIKeyResponse response for a newly created API key, including its secret key
+type CreatedAPIKeyResponse struct {
+	APIKeyResponse
+	// Key is the secret API key; it is only returned once and cannot be recovered
+	Key string
+}
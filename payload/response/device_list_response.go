@@ -0,0 +1,7 @@
+package response
+
+// DeviceListResponse response for a page of devices
+type DeviceListResponse struct {
+	Devices    []*DeviceResponse
+	NextCursor string // Continues the listing after this page, empty on the last page
+}
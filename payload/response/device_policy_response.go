@@ -0,0 +1,21 @@
+package response
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DevicePolicyResponse response for the signing policy of a device and its change history
+type DevicePolicyResponse struct {
+	ID      string
+	Policy  json.RawMessage // null if the device has no policy
+	History []*PolicyChangeResponse
+}
+
+// PolicyChangeResponse response for a single audited policy change
+type PolicyChangeResponse struct {
+	PreviousPolicy json.RawMessage
+	Policy         json.RawMessage
+	ChangedBy      string
+	At             time.Time
+}
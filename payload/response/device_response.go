@@ -1,9 +1,27 @@
 package response
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // DeviceResponse response for creating a device
 type DeviceResponse struct {
-	ID             string
-	PublicKey      string
-	Label          string
-	SignatureCount uint64
+	ID               string
+	TenantID         string
+	PublicKey        string
+	Label            string
+	SignatureCount   uint64
+	SignedDataFormat string
+	Status           string
+	Metadata         map[string]string
+	Limits           SigningLimitsResponse
+	Policy           json.RawMessage // null if the device has no signing policy
+	BoundClient      string          // Client ID of the client certificate the device is bound to, empty if unbound
+	// RemainingSignatures and RemainingSignaturesToday are null when the corresponding limit is not set
+	RemainingSignatures      *uint64
+	RemainingSignaturesToday *uint64
+	CreatedAt                time.Time
+	UpdatedAt                time.Time
+	DeletedAt                *time.Time // Set once the device has been deleted and only its tombstone is kept
 }
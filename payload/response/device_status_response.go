@@ -0,0 +1,19 @@
+package response
+
+import "time"
+
+// DeviceStatusResponse response for the lifecycle state of a device and its history
+type DeviceStatusResponse struct {
+	ID      string
+	Status  string
+	History []*StatusTransitionResponse
+}
+
+// StatusTransitionResponse response for a single lifecycle transition
+type StatusTransitionResponse struct {
+	From   string
+	To     string
+	Action string
+	Reason string
+	At     time.Time
+}
@@ -2,6 +2,10 @@ package response
 
 // SignTransactionResponse response for signing a transaction
 type SignTransactionResponse struct {
-	Signature  string
-	SignedData string
+	Signature          string
+	SignedData         string
+	SignedDataEncoding string
+	FormatVersion      string
+	// Replayed is set when the response belongs to a signature created by an earlier request with the same idempotency key
+	Replayed bool `json:"-"`
 }
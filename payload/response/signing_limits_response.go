@@ -0,0 +1,11 @@
+package response
+
+import "time"
+
+// SigningLimitsResponse response for the signing limits of a device; zero or null values mean no limit
+type SigningLimitsResponse struct {
+	MaxSignatures uint64
+	DailyLimit    uint64
+	NotBefore     *time.Time
+	NotAfter      *time.Time
+}
@@ -0,0 +1,42 @@
+package v1
+
+import (
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/response"
+	"time"
+)
+
+// APIKeyResponse response for an API key; the secret key itself is never returned again after creation
+type APIKeyResponse struct {
+	ID        string     `json:"id"`
+	TenantID  string     `json:"tenantId"`
+	ClientID  string     `json:"clientId"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"` // Set once the key has been revoked
+}
+
+// CreatedAPIKeyResponse response for a newly created API key, including its secret key
+type CreatedAPIKeyResponse struct {
+	APIKeyResponse
+	// Key is the secret API key; it is only returned once and cannot be recovered
+	Key string `json:"key"`
+}
+
+// NewAPIKeyResponse converts an API key to its v1 representation
+func NewAPIKeyResponse(key *response.APIKeyResponse) *APIKeyResponse {
+	return &APIKeyResponse{
+		ID:        key.ID,
+		TenantID:  key.TenantID,
+		ClientID:  key.ClientID,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt,
+		RevokedAt: key.RevokedAt,
+	}
+}
+
+// NewCreatedAPIKeyResponse converts a newly created API key to its v1 representation
+func NewCreatedAPIKeyResponse(key *response.CreatedAPIKeyResponse) *CreatedAPIKeyResponse {
+	return &CreatedAPIKeyResponse{APIKeyResponse: *NewAPIKeyResponse(&key.APIKeyResponse), Key: key.Key}
+}
@@ -0,0 +1,79 @@
+package v1
+
+import (
+	"encoding/json"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/response"
+	"time"
+)
+
+// DeviceResponse response for a signature device
+type DeviceResponse struct {
+	ID               string                `json:"id"`
+	TenantID         string                `json:"tenantId"`
+	PublicKey        string                `json:"publicKey"`
+	Label            string                `json:"label"`
+	SignatureCount   uint64                `json:"signatureCount"`
+	SignedDataFormat string                `json:"signedDataFormat"`
+	Status           string                `json:"status"`
+	Metadata         map[string]string     `json:"metadata"`
+	Limits           SigningLimitsResponse `json:"limits"`
+	Policy           json.RawMessage       `json:"policy"` // null if the device has no signing policy
+	// BoundClient is the client ID of the client certificate the device is bound to, absent if unbound
+	BoundClient string `json:"boundClient,omitempty"`
+	// RemainingSignatures and RemainingSignaturesToday are null when the corresponding limit is not set
+	RemainingSignatures      *uint64    `json:"remainingSignatures"`
+	RemainingSignaturesToday *uint64    `json:"remainingSignaturesToday"`
+	CreatedAt                time.Time  `json:"createdAt"`
+	UpdatedAt                time.Time  `json:"updatedAt"`
+	DeletedAt                *time.Time `json:"deletedAt,omitempty"` // Set once the device has been deleted
+}
+
+// SigningLimitsResponse response for the signing limits of a device; zero or null values mean no limit
+type SigningLimitsResponse struct {
+	MaxSignatures uint64     `json:"maxSignatures"`
+	DailyLimit    uint64     `json:"dailyLimit"`
+	NotBefore     *time.Time `json:"notBefore"`
+	NotAfter      *time.Time `json:"notAfter"`
+}
+
+// DeviceListResponse response for a page of devices
+type DeviceListResponse struct {
+	Devices    []*DeviceResponse `json:"devices"`
+	NextCursor string            `json:"nextCursor,omitempty"` // Continues the listing after this page, absent on the last page
+}
+
+// NewDeviceResponse converts a device to its v1 representation
+func NewDeviceResponse(device *response.DeviceResponse) *DeviceResponse {
+	return &DeviceResponse{
+		ID:               device.ID,
+		TenantID:         device.TenantID,
+		PublicKey:        device.PublicKey,
+		Label:            device.Label,
+		SignatureCount:   device.SignatureCount,
+		SignedDataFormat: device.SignedDataFormat,
+		Status:           device.Status,
+		Metadata:         device.Metadata,
+		Limits: SigningLimitsResponse{
+			MaxSignatures: device.Limits.MaxSignatures,
+			DailyLimit:    device.Limits.DailyLimit,
+			NotBefore:     device.Limits.NotBefore,
+			NotAfter:      device.Limits.NotAfter,
+		},
+		Policy:                   device.Policy,
+		BoundClient:              device.BoundClient,
+		RemainingSignatures:      device.RemainingSignatures,
+		RemainingSignaturesToday: device.RemainingSignaturesToday,
+		CreatedAt:                device.CreatedAt,
+		UpdatedAt:                device.UpdatedAt,
+		DeletedAt:                device.DeletedAt,
+	}
+}
+
+// NewDeviceListResponse converts a page of devices to its v1 representation
+func NewDeviceListResponse(page *response.DeviceListResponse) *DeviceListResponse {
+	devices := make([]*DeviceResponse, 0, len(page.Devices))
+	for _, device := range page.Devices {
+		devices = append(devices, NewDeviceResponse(device))
+	}
+	return &DeviceListResponse{Devices: devices, NextCursor: page.NextCursor}
+}
@@ -0,0 +1,36 @@
+package v1
+
+import (
+	"encoding/json"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/response"
+	"time"
+)
+
+// DevicePolicyResponse response for the signing policy of a device and its change history
+type DevicePolicyResponse struct {
+	ID      string                  `json:"id"`
+	Policy  json.RawMessage         `json:"policy"` // null if the device has no policy
+	History []*PolicyChangeResponse `json:"history"`
+}
+
+// PolicyChangeResponse response for a single audited policy change
+type PolicyChangeResponse struct {
+	PreviousPolicy json.RawMessage `json:"previousPolicy"`
+	Policy         json.RawMessage `json:"policy"`
+	ChangedBy      string          `json:"changedBy"`
+	At             time.Time       `json:"at"`
+}
+
+// NewDevicePolicyResponse converts the signing policy of a device to its v1 representation
+func NewDevicePolicyResponse(devicePolicy *response.DevicePolicyResponse) *DevicePolicyResponse {
+	history := make([]*PolicyChangeResponse, 0, len(devicePolicy.History))
+	for _, change := range devicePolicy.History {
+		history = append(history, &PolicyChangeResponse{
+			PreviousPolicy: change.PreviousPolicy,
+			Policy:         change.Policy,
+			ChangedBy:      change.ChangedBy,
+			At:             change.At,
+		})
+	}
+	return &DevicePolicyResponse{ID: devicePolicy.ID, Policy: devicePolicy.Policy, History: history}
+}
@@ -0,0 +1,27 @@
+package v1
+
+import "github.com/fiskaly/coding-challenges/signing-service-challenge/payload/response"
+
+// SignatureResponse response for a signature created by a device
+type SignatureResponse struct {
+	Signature          string `json:"signature"`
+	SignedData         string `json:"signedData"`
+	SignedDataEncoding string `json:"signedDataEncoding"`
+	FormatVersion      string `json:"formatVersion"`
+}
+
+// CanonicalResponse response for the canonical (RFC 8785) form of a JSON payload. It is a string, so the
+// exact bytes survive the response envelope.
+type CanonicalResponse struct {
+	Canonical string `json:"canonical"`
+}
+
+// NewSignatureResponse converts a signature to its v1 representation
+func NewSignatureResponse(signature *response.SignTransactionResponse) *SignatureResponse {
+	return &SignatureResponse{
+		Signature:          signature.Signature,
+		SignedData:         signature.SignedData,
+		SignedDataEncoding: signature.SignedDataEncoding,
+		FormatVersion:      signature.FormatVersion,
+	}
+}
@@ -0,0 +1,37 @@
+package v1
+
+import (
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/response"
+	"time"
+)
+
+// DeviceStatusResponse response for the lifecycle state of a device and its history
+type DeviceStatusResponse struct {
+	ID      string                      `json:"id"`
+	Status  string                      `json:"status"`
+	History []*StatusTransitionResponse `json:"history"`
+}
+
+// StatusTransitionResponse response for a single lifecycle transition
+type StatusTransitionResponse struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Action string    `json:"action"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+}
+
+// NewDeviceStatusResponse converts the lifecycle state of a device to its v1 representation
+func NewDeviceStatusResponse(status *response.DeviceStatusResponse) *DeviceStatusResponse {
+	history := make([]*StatusTransitionResponse, 0, len(status.History))
+	for _, transition := range status.History {
+		history = append(history, &StatusTransitionResponse{
+			From:   transition.From,
+			To:     transition.To,
+			Action: transition.Action,
+			Reason: transition.Reason,
+			At:     transition.At,
+		})
+	}
+	return &DeviceStatusResponse{ID: status.ID, Status: status.Status, History: history}
+}
@@ -1,12 +1,37 @@
 package persistence
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
+// timeLayout is a fixed-width UTC layout, so stored timestamps sort lexicographically
+const timeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// deviceColumns lists the columns read for a SignatureDevice, in the order scanDevice expects them
+const deviceColumns = `id, label, algorithm, publicKey, privateKey, lastSignature, signatureCount, signedDataFormat, status, metadata, deletedAt, createdAt, updatedAt, maxSignatures, dailySignatureLimit, notBefore, notAfter, policy, tenantId, boundClient`
+
+// deviceListColumns lists the same columns as deviceColumns, but leaves out the private key, which listings never need
+const deviceListColumns = `id, label, algorithm, publicKey, '', lastSignature, signatureCount, signedDataFormat, status, metadata, deletedAt, createdAt, updatedAt, maxSignatures, dailySignatureLimit, notBefore, notAfter, policy, tenantId, boundClient`
+
+// deviceSortColumns maps the sort fields of a DeviceQuery to their columns
+var deviceSortColumns = map[string]string{
+	SortByID:        "id",
+	SortByLabel:     "label",
+	SortByCreatedAt: "createdAt",
+}
+
+// signatureColumns lists the columns read for a SignatureRecord, in the order scanSignatureRecord expects them
+const signatureColumns = `deviceId, counter, signature, signedData, signedDataEncoding, formatVersion, createdAt, idempotencyKey, requestHash`
+
 // SQLiteDeviceRepository implements the DeviceRepository interface for SQLite
 type SQLiteDeviceRepository struct {
 	db *sql.DB
@@ -14,7 +39,7 @@ type SQLiteDeviceRepository struct {
 
 // NewSQLiteDeviceRepository creates a new instance of SQLiteDeviceRepository
 func NewSQLiteDeviceRepository(dataSourceName string) (DeviceRepository, error) {
-	db, err := sql.Open("sqlite3", dataSourceName)
+	db, err := sql.Open("sqlite3", withSecureDelete(dataSourceName))
 	if err != nil {
 		return nil, err
 	}
@@ -36,78 +61,445 @@ func NewSQLiteDeviceRepository(dataSourceName string) (DeviceRepository, error)
 		return nil, err
 	}
 
+	// Add columns introduced after the initial schema to existing databases
+	if err = ensureColumn(db, "devices", "signedDataFormat", "TEXT NOT NULL DEFAULT 'v1'"); err != nil {
+		return nil, err
+	}
+	if err = ensureColumn(db, "devices", "status", "TEXT NOT NULL DEFAULT 'active'"); err != nil {
+		return nil, err
+	}
+	if err = ensureColumn(db, "devices", "metadata", "TEXT NOT NULL DEFAULT '{}'"); err != nil {
+		return nil, err
+	}
+	if err = ensureColumn(db, "devices", "deletedAt", "TEXT"); err != nil {
+		return nil, err
+	}
+	// Devices created before creation times were recorded get the zero time
+	if err = ensureColumn(db, "devices", "createdAt", "TEXT NOT NULL DEFAULT '"+time.Time{}.Format(timeLayout)+"'"); err != nil {
+		return nil, err
+	}
+	if err = ensureColumn(db, "devices", "updatedAt", "TEXT NOT NULL DEFAULT '"+time.Time{}.Format(timeLayout)+"'"); err != nil {
+		return nil, err
+	}
+	// Signing limits; zero and NULL mean no limit
+	if err = ensureColumn(db, "devices", "maxSignatures", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+	if err = ensureColumn(db, "devices", "dailySignatureLimit", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+	if err = ensureColumn(db, "devices", "notBefore", "TEXT"); err != nil {
+		return nil, err
+	}
+	if err = ensureColumn(db, "devices", "notAfter", "TEXT"); err != nil {
+		return nil, err
+	}
+	if err = ensureColumn(db, "devices", "policy", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return nil, err
+	}
+	// Devices created before tenants were introduced belong to the default tenant
+	if err = ensureColumn(db, "devices", "tenantId", "TEXT NOT NULL DEFAULT '"+domain.DefaultTenantID+"'"); err != nil {
+		return nil, err
+	}
+	if err = ensureColumn(db, "devices", "boundClient", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return nil, err
+	}
+
+	// Indexes for the sort orders of device queries
+	_, err = db.Exec(`
+	CREATE INDEX IF NOT EXISTS devices_label ON devices (label, id);
+	CREATE INDEX IF NOT EXISTS devices_created_at ON devices (createdAt, id);
+	CREATE INDEX IF NOT EXISTS devices_tenant_label ON devices (tenantId, label, id);
+	CREATE INDEX IF NOT EXISTS devices_tenant_created_at ON devices (tenantId, createdAt, id);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the status transitions table if it doesn't exist
+	createStatusTransitionsTableSQL := `
+	CREATE TABLE IF NOT EXISTS status_transitions (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		deviceId TEXT NOT NULL,
+		fromStatus TEXT NOT NULL,
+		toStatus TEXT NOT NULL,
+		action TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		at TEXT NOT NULL
+	);
+	`
+	_, err = db.Exec(createStatusTransitionsTableSQL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the policy audit table if it doesn't exist
+	createPolicyChangesTableSQL := `
+	CREATE TABLE IF NOT EXISTS policy_changes (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		deviceId TEXT NOT NULL,
+		previousPolicy TEXT NOT NULL,
+		policy TEXT NOT NULL,
+		changedBy TEXT NOT NULL,
+		at TEXT NOT NULL
+	);
+	`
+	_, err = db.Exec(createPolicyChangesTableSQL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the API keys table if it doesn't exist; only hashes of the secret keys are stored
+	createAPIKeysTableSQL := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		tenantId TEXT NOT NULL,
+		clientId TEXT NOT NULL,
+		name TEXT NOT NULL,
+		hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		createdAt TEXT NOT NULL,
+		revokedAt TEXT
+	);
+	CREATE INDEX IF NOT EXISTS api_keys_tenant ON api_keys (tenantId, createdAt, id);
+	`
+	_, err = db.Exec(createAPIKeysTableSQL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the signatures table if it doesn't exist
+	createSignaturesTableSQL := `
+	CREATE TABLE IF NOT EXISTS signatures (
+		deviceId TEXT NOT NULL,
+		counter INTEGER NOT NULL,
+		signature TEXT NOT NULL,
+		signedData TEXT NOT NULL,
+		signedDataEncoding TEXT NOT NULL,
+		formatVersion TEXT NOT NULL,
+		createdAt TEXT NOT NULL,
+		PRIMARY KEY (deviceId, counter)
+	);
+	`
+	_, err = db.Exec(createSignaturesTableSQL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Idempotency keys are stored with the signature they produced
+	if err = ensureColumn(db, "signatures", "idempotencyKey", "TEXT"); err != nil {
+		return nil, err
+	}
+	if err = ensureColumn(db, "signatures", "requestHash", "TEXT"); err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS signatures_idempotency_key ON signatures (deviceId, idempotencyKey) WHERE idempotencyKey IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	// Daily signing limits count the signatures of a device by creation time
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS signatures_created_at ON signatures (deviceId, createdAt)`)
+	if err != nil {
+		return nil, err
+	}
+
 	return &SQLiteDeviceRepository{db: db}, nil
 }
 
-// AddDevice saves a new SignatureDevice to the repository
-func (repo *SQLiteDeviceRepository) AddDevice(id, label string, algorithm domain.AlgorithmType, publicKey, privateKey, lastSignature string) (*domain.SignatureDevice, error) {
-	// Check if device already exists in the database
+// withSecureDelete makes every connection of the pool zero the space freed by deleted or overwritten data, so
+// destroyed private keys do not linger in the database file
+func withSecureDelete(dataSourceName string) string {
+	if strings.Contains(dataSourceName, "?") {
+		return dataSourceName + "&_secure_delete=on"
+	}
+	return dataSourceName + "?_secure_delete=on"
+}
+
+// isUniqueViolation reports whether a statement failed because of a primary key or unique constraint
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey || sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// ensureColumn adds a column to a table unless it already exists
+func ensureColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, primaryKey int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// queryRower is implemented by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// deviceExists reports whether a device with the given ID is stored
+func deviceExists(ctx context.Context, db queryRower, id string) (bool, error) {
 	var count int
-	querySQL := `SELECT COUNT(*) FROM devices WHERE id = ?`
-	err := repo.db.QueryRow(querySQL, id).Scan(&count)
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM devices WHERE id = ?`, id).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// scanDevice reads a SignatureDevice from a row selected with deviceColumns
+func scanDevice(row rowScanner) (*domain.SignatureDevice, error) {
+	var id, label, algorithm, publicKey, lastSignature, signedDataFormat, status, metadataJSON string
+	// The key is scanned into bytes the device takes over, so it can be zeroed once the device is deleted
+	var privateKey []byte
+	var signatureCount uint64
+	var deletedAt sql.NullString
+	var createdAt, updatedAt, policy, tenantID, boundClient string
+	var maxSignatures, dailySignatureLimit uint64
+	var notBefore, notAfter sql.NullString
+
+	if err := row.Scan(&id, &label, &algorithm, &publicKey, &privateKey, &lastSignature, &signatureCount, &signedDataFormat, &status, &metadataJSON, &deletedAt, &createdAt, &updatedAt,
+		&maxSignatures, &dailySignatureLimit, &notBefore, &notAfter, &policy, &tenantID, &boundClient); err != nil {
+		return nil, err
+	}
+
+	createdAtTime, err := time.Parse(timeLayout, createdAt)
 	if err != nil {
-		return nil, err // Handle error if query fails
+		return nil, err
 	}
-	if count > 0 {
-		return nil, errors.New("device with this ID already exists")
+	updatedAtTime, err := time.Parse(timeLayout, updatedAt)
+	if err != nil {
+		return nil, err
 	}
 
-	device := domain.NewSignatureDevice(id, label, algorithm, publicKey, privateKey, lastSignature)
+	var metadata map[string]string
+	if err := json.Unmarshal([]byte(metadataJSON), &metadata); err != nil {
+		return nil, err
+	}
 
-	insertSQL := `INSERT INTO devices (id, label, algorithm, publicKey, privateKey, lastSignature, signatureCount) VALUES (?, ?, ?, ?, ?, ?, ?)`
-	_, err = repo.db.Exec(insertSQL, device.GetID(), device.GetLabel(), device.GetAlgorithm(), device.GetPublicKey(), device.GetPrivateKey(), device.GetLastSignature(), device.GetSignatureCount())
+	// Create a new SignatureDevice using the retrieved values
+	device := domain.NewSignatureDevice(id, label, domain.AlgorithmType(algorithm), publicKey, privateKey, lastSignature)
+	device.SetSignatureCount(signatureCount)
+	device.SetSignedDataFormat(signedDataFormat)
+	device.SetStatus(domain.DeviceStatus(status))
+	device.SetMetadata(metadata)
+	device.SetCreatedAt(createdAtTime)
+	device.SetUpdatedAt(updatedAtTime)
+	deletedAtTime, err := parseNullTime(deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	device.SetDeletedAt(deletedAtTime)
+	notBeforeTime, err := parseNullTime(notBefore)
 	if err != nil {
 		return nil, err
 	}
+	notAfterTime, err := parseNullTime(notAfter)
+	if err != nil {
+		return nil, err
+	}
+	device.SetSigningLimits(domain.NewSigningLimits(maxSignatures, dailySignatureLimit, notBeforeTime, notAfterTime))
+	device.SetPolicy(policy)
+	device.SetTenantID(tenantID)
+	device.SetBoundClient(boundClient)
 
 	return device, nil
 }
 
-// GetDevice retrieves a SignatureDevice by its ID
-func (repo *SQLiteDeviceRepository) GetDevice(id string) (*domain.SignatureDevice, error) {
-	querySQL := `SELECT id, label, algorithm, publicKey, privateKey, lastSignature, signatureCount FROM devices WHERE id = ?`
-	row := repo.db.QueryRow(querySQL, id)
+// parseNullTime parses an optional timestamp, returning the zero time for NULL
+func parseNullTime(value sql.NullString) (time.Time, error) {
+	if !value.Valid {
+		return time.Time{}, nil
+	}
+	return time.Parse(timeLayout, value.String)
+}
 
-	var label, algorithm, publicKey, privateKey, lastSignature string
-	var signatureCount uint64
+// formatNullTime formats an optional timestamp, storing the zero time as NULL
+func formatNullTime(value time.Time) sql.NullString {
+	if value.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: value.UTC().Format(timeLayout), Valid: true}
+}
+
+// scanSignatureRecord reads a SignatureRecord from a row selected with signatureColumns
+func scanSignatureRecord(row rowScanner) (*domain.SignatureRecord, error) {
+	var deviceID, signature, signedData, signedDataEncoding, formatVersion, createdAt string
+	var counter uint64
+	var idempotencyKey, requestHash sql.NullString
+
+	if err := row.Scan(&deviceID, &counter, &signature, &signedData, &signedDataEncoding, &formatVersion, &createdAt, &idempotencyKey, &requestHash); err != nil {
+		return nil, err
+	}
+
+	createdAtTime, err := time.Parse(timeLayout, createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	record := domain.NewSignatureRecord(deviceID, counter, signature, signedData, signedDataEncoding, formatVersion, createdAtTime)
+	record.SetIdempotencyKey(idempotencyKey.String, requestHash.String)
+	return record, nil
+}
+
+// AddDevice saves a new SignatureDevice to the repository
+func (repo *SQLiteDeviceRepository) AddDevice(ctx context.Context, id, label string, algorithm domain.AlgorithmType, publicKey string, privateKey []byte, lastSignature string) (*domain.SignatureDevice, error) {
+	device := domain.NewSignatureDevice(id, label, algorithm, publicKey, privateKey, lastSignature)
+	if err := repo.CreateDevice(ctx, device); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// CreateDevice saves a fully initialised SignatureDevice to the repository
+func (repo *SQLiteDeviceRepository) CreateDevice(ctx context.Context, device *domain.SignatureDevice) error {
+	metadata, err := json.Marshal(device.GetMetadata())
+	if err != nil {
+		return err
+	}
+	limits := device.GetSigningLimits()
+	privateKey := device.GetPrivateKey()
+	defer clear(privateKey)
+
+	insertSQL := `INSERT INTO devices (id, label, algorithm, publicKey, privateKey, lastSignature, signatureCount, signedDataFormat, status, metadata, createdAt, updatedAt, maxSignatures, dailySignatureLimit, notBefore, notAfter, policy, tenantId, boundClient) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = repo.db.ExecContext(ctx, insertSQL, device.GetID(), device.GetLabel(), device.GetAlgorithm(), device.GetPublicKey(), privateKey, device.GetLastSignature(), device.GetSignatureCount(), device.GetSignedDataFormat(), device.GetStatus(), string(metadata),
+		device.GetCreatedAt().UTC().Format(timeLayout), device.GetUpdatedAt().UTC().Format(timeLayout),
+		limits.GetMaxSignatures(), limits.GetDailyLimit(), formatNullTime(limits.GetNotBefore()), formatNullTime(limits.GetNotAfter()), device.GetPolicy(), device.GetTenantID(), device.GetBoundClient())
+	// The primary key rejects the IDs of existing devices and tombstones, even when they are created concurrently
+	if isUniqueViolation(err) {
+		return domain.ErrDeviceAlreadyExists
+	}
+	return err
+}
+
+// UpdateDevice stores the user-editable attributes of a device, its label, metadata, signing limits and client
+// binding, and when they changed
+func (repo *SQLiteDeviceRepository) UpdateDevice(ctx context.Context, device *domain.SignatureDevice) error {
+	metadata, err := json.Marshal(device.GetMetadata())
+	if err != nil {
+		return err
+	}
+
+	limits := device.GetSigningLimits()
+
+	updateSQL := `UPDATE devices SET label = ?, metadata = ?, maxSignatures = ?, dailySignatureLimit = ?, notBefore = ?, notAfter = ?, boundClient = ?, updatedAt = ? WHERE id = ?`
+	result, err := repo.db.ExecContext(ctx, updateSQL, device.GetLabel(), string(metadata), limits.GetMaxSignatures(), limits.GetDailyLimit(),
+		formatNullTime(limits.GetNotBefore()), formatNullTime(limits.GetNotAfter()), device.GetBoundClient(), device.GetUpdatedAt().UTC().Format(timeLayout), device.GetID())
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return domain.ErrDeviceNotFound
+	}
+	return nil
+}
+
+// DeleteDevice turns a device into a tombstone, clearing its private key
+func (repo *SQLiteDeviceRepository) DeleteDevice(ctx context.Context, id string, deletedAt time.Time) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var deleted sql.NullString
+	if err = tx.QueryRowContext(ctx, `SELECT deletedAt FROM devices WHERE id = ?`, id).Scan(&deleted); err != nil {
+		if err == sql.ErrNoRows {
+			return domain.ErrDeviceNotFound
+		}
+		return err
+	}
+	if deleted.Valid {
+		return domain.ErrDeviceDeleted
+	}
+
+	// Every connection has secure delete enabled, so SQLite zeroes the space freed by the old key
+	deleteSQL := `UPDATE devices SET privateKey = '', deletedAt = ?, updatedAt = ? WHERE id = ?`
+	if _, err = tx.ExecContext(ctx, deleteSQL, deletedAt.UTC().Format(timeLayout), deletedAt.UTC().Format(timeLayout), id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
 
-	err := row.Scan(&id, &label, &algorithm, &publicKey, &privateKey, &lastSignature, &signatureCount)
+// GetDevice retrieves a SignatureDevice by its ID
+func (repo *SQLiteDeviceRepository) GetDevice(ctx context.Context, id string) (*domain.SignatureDevice, error) {
+	querySQL := `SELECT ` + deviceColumns + ` FROM devices WHERE id = ?`
+	device, err := scanDevice(repo.db.QueryRowContext(ctx, querySQL, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.New("device not found")
+			return nil, domain.ErrDeviceNotFound
 		}
 		return nil, err
 	}
 
-	// Create a new SignatureDevice using the retrieved values
-	device := domain.NewSignatureDevice(id, label, domain.AlgorithmType(algorithm), publicKey, privateKey, lastSignature)
-	device.SetSignatureCount(signatureCount)
+	return device, nil
+}
+
+// GetTenantDevice retrieves a SignatureDevice by its ID if it belongs to the given tenant
+func (repo *SQLiteDeviceRepository) GetTenantDevice(ctx context.Context, tenantID, id string) (*domain.SignatureDevice, error) {
+	// Devices of other tenants are reported as missing, so their IDs cannot be probed
+	querySQL := `SELECT ` + deviceColumns + ` FROM devices WHERE id = ? AND tenantId = ?`
+	device, err := scanDevice(repo.db.QueryRowContext(ctx, querySQL, id, tenantID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.ErrDeviceNotFound
+		}
+		return nil, err
+	}
 
 	return device, nil
 }
 
 // ListDevices returns all SignatureDevices in the repository
-func (repo *SQLiteDeviceRepository) ListDevices() ([]*domain.SignatureDevice, error) {
+func (repo *SQLiteDeviceRepository) ListDevices(ctx context.Context) ([]*domain.SignatureDevice, error) {
 	var devices []*domain.SignatureDevice
 
-	querySQL := `SELECT id, label, algorithm, publicKey, privateKey, lastSignature, signatureCount FROM devices`
-	rows, err := repo.db.Query(querySQL)
+	querySQL := `SELECT ` + deviceColumns + ` FROM devices ORDER BY createdAt, id`
+	rows, err := repo.db.QueryContext(ctx, querySQL)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var id, label, algorithm, publicKey, privateKey, lastSignature string
-		var signatureCount uint64
-
-		if err := rows.Scan(&id, &label, &algorithm, &publicKey, &privateKey, &lastSignature, &signatureCount); err != nil {
+		device, err := scanDevice(rows)
+		if err != nil {
 			return nil, err
 		}
 
-		device := domain.NewSignatureDevice(id, label, domain.AlgorithmType(algorithm), publicKey, privateKey, lastSignature)
-		device.SetSignatureCount(signatureCount)
-
 		devices = append(devices, device)
 	}
 
@@ -118,20 +510,533 @@ func (repo *SQLiteDeviceRepository) ListDevices() ([]*domain.SignatureDevice, er
 	return devices, nil
 }
 
+// QueryDevices returns a page of the devices matching the query, using keyset pagination on the sort column
+func (repo *SQLiteDeviceRepository) QueryDevices(ctx context.Context, query DeviceQuery) (*DevicePage, error) {
+	query, cursor, err := query.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	var conditions []string
+	var args []interface{}
+	if query.TenantID != "" {
+		conditions = append(conditions, `tenantId = ?`)
+		args = append(args, query.TenantID)
+	}
+	if query.Algorithm != "" {
+		conditions = append(conditions, `algorithm = ?`)
+		args = append(args, query.Algorithm)
+	}
+	if query.LabelPrefix != "" {
+		// LIKE is case-insensitive, so the prefix is compared exactly instead
+		conditions = append(conditions, `substr(label, 1, length(?)) = ?`)
+		args = append(args, query.LabelPrefix, query.LabelPrefix)
+	}
+	if query.Status != "" {
+		conditions = append(conditions, `status = ?`)
+		args = append(args, query.Status)
+	}
+	if !query.CreatedFrom.IsZero() {
+		conditions = append(conditions, `createdAt >= ?`)
+		args = append(args, query.CreatedFrom.UTC().Format(timeLayout))
+	}
+	if !query.CreatedTo.IsZero() {
+		conditions = append(conditions, `createdAt < ?`)
+		args = append(args, query.CreatedTo.UTC().Format(timeLayout))
+	}
+	if !query.IncludeDeleted {
+		conditions = append(conditions, `deletedAt IS NULL`)
+	}
+
+	column, direction, comparison := deviceSortColumns[query.SortBy], "ASC", ">"
+	if query.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if cursor != nil {
+		if column == "id" {
+			conditions = append(conditions, `id `+comparison+` ?`)
+			args = append(args, cursor.ID)
+		} else {
+			conditions = append(conditions, fmt.Sprintf(`(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))`, column, comparison))
+			args = append(args, cursor.Value, cursor.Value, cursor.ID)
+		}
+	}
+
+	querySQL := `SELECT ` + deviceListColumns + ` FROM devices`
+	if len(conditions) > 0 {
+		querySQL += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	querySQL += fmt.Sprintf(` ORDER BY %[1]s %[2]s, id %[2]s LIMIT ?`, column, direction)
+	// One more device than requested tells whether there is a next page
+	args = append(args, query.Limit+1)
+
+	rows, err := repo.db.QueryContext(ctx, querySQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &DevicePage{Devices: []*domain.SignatureDevice{}}
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Devices) == query.Limit {
+			page.NextCursor = query.cursorAfter(page.Devices[len(page.Devices)-1])
+			break
+		}
+		page.Devices = append(page.Devices, device)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
 // IncrementSignatureCount updates the signature count of a device
-func (repo *SQLiteDeviceRepository) IncrementSignatureCount(id string) error {
+func (repo *SQLiteDeviceRepository) IncrementSignatureCount(ctx context.Context, id string) error {
 	updateSQL := `UPDATE devices SET signatureCount = signatureCount + 1 WHERE id = ?`
-	_, err := repo.db.Exec(updateSQL, id)
+	_, err := repo.db.ExecContext(ctx, updateSQL, id)
 	return err
 }
 
 // UpdateLastSignature updates the last signature of a device
-func (repo *SQLiteDeviceRepository) UpdateLastSignature(id string, lastSignature string) error {
+func (repo *SQLiteDeviceRepository) UpdateLastSignature(ctx context.Context, id string, lastSignature string) error {
 	updateSQL := `UPDATE devices SET lastSignature = ? WHERE id = ?`
-	_, err := repo.db.Exec(updateSQL, lastSignature, id)
+	_, err := repo.db.ExecContext(ctx, updateSQL, lastSignature, id)
+	return err
+}
+
+// AddSignatureRecord stores a signature created by a device
+func (repo *SQLiteDeviceRepository) AddSignatureRecord(ctx context.Context, record *domain.SignatureRecord) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = insertSignatureRecord(ctx, tx, record); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AppendSignature stores a signature and makes it the last signature of its device in one transaction
+func (repo *SQLiteDeviceRepository) AppendSignature(ctx context.Context, record *domain.SignatureRecord) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Only the counter the signature was created with may be advanced
+	updateSQL := `UPDATE devices SET lastSignature = ?, signatureCount = signatureCount + 1 WHERE id = ? AND signatureCount = ?`
+	result, err := tx.ExecContext(ctx, updateSQL, record.GetSignature(), record.GetDeviceID(), record.GetCounter())
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		exists, err := deviceExists(ctx, tx, record.GetDeviceID())
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return domain.ErrDeviceNotFound
+		}
+		return domain.ErrSignatureCounterConflict
+	}
+
+	if err = insertSignatureRecord(ctx, tx, record); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertSignatureRecord inserts a signature into the signatures table; it must run in a transaction, so a
+// reused idempotency key is moved to the new signature atomically
+func insertSignatureRecord(ctx context.Context, db execer, record *domain.SignatureRecord) error {
+	var idempotencyKey, requestHash sql.NullString
+	if record.GetIdempotencyKey() != "" {
+		idempotencyKey = sql.NullString{String: record.GetIdempotencyKey(), Valid: true}
+		requestHash = sql.NullString{String: record.GetRequestHash(), Valid: true}
+
+		// An expired key may be reused before it is purged; the new signature takes it over, like in memory
+		clearSQL := `UPDATE signatures SET idempotencyKey = NULL, requestHash = NULL WHERE deviceId = ? AND idempotencyKey = ?`
+		if _, err := db.ExecContext(ctx, clearSQL, record.GetDeviceID(), idempotencyKey); err != nil {
+			return err
+		}
+	}
+
+	insertSQL := `INSERT INTO signatures (deviceId, counter, signature, signedData, signedDataEncoding, formatVersion, createdAt, idempotencyKey, requestHash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.ExecContext(ctx, insertSQL, record.GetDeviceID(), record.GetCounter(), record.GetSignature(), record.GetSignedData(),
+		record.GetSignedDataEncoding(), record.GetFormatVersion(), record.GetCreatedAt().UTC().Format(timeLayout), idempotencyKey, requestHash)
+	return err
+}
+
+// ListSignatureRecords returns the signatures of a device ordered by signature counter
+func (repo *SQLiteDeviceRepository) ListSignatureRecords(ctx context.Context, deviceID string) ([]*domain.SignatureRecord, error) {
+	records := []*domain.SignatureRecord{}
+
+	exists, err := deviceExists(ctx, repo.db, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, domain.ErrDeviceNotFound
+	}
+
+	querySQL := `SELECT ` + signatureColumns + ` FROM signatures WHERE deviceId = ? ORDER BY counter`
+	rows, err := repo.db.QueryContext(ctx, querySQL, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanSignatureRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// CountSignatureRecords returns the number of signatures a device created at or after the given time
+func (repo *SQLiteDeviceRepository) CountSignatureRecords(ctx context.Context, deviceID string, since time.Time) (uint64, error) {
+	exists, err := deviceExists(ctx, repo.db, deviceID)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, domain.ErrDeviceNotFound
+	}
+
+	var count uint64
+	querySQL := `SELECT COUNT(*) FROM signatures WHERE deviceId = ? AND createdAt >= ?`
+	if err := repo.db.QueryRowContext(ctx, querySQL, deviceID, since.UTC().Format(timeLayout)).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// GetSignatureRecordByIdempotencyKey returns the signature a device created for an idempotency key
+// used at or after notBefore
+func (repo *SQLiteDeviceRepository) GetSignatureRecordByIdempotencyKey(ctx context.Context, deviceID, idempotencyKey string, notBefore time.Time) (*domain.SignatureRecord, error) {
+	querySQL := `SELECT ` + signatureColumns + ` FROM signatures WHERE deviceId = ? AND idempotencyKey = ? AND createdAt >= ?`
+	record, err := scanSignatureRecord(repo.db.QueryRowContext(ctx, querySQL, deviceID, idempotencyKey, notBefore.UTC().Format(timeLayout)))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.ErrSignatureNotFound
+		}
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// ExpireIdempotencyKeys forgets the idempotency keys of signatures created before the given time
+func (repo *SQLiteDeviceRepository) ExpireIdempotencyKeys(ctx context.Context, before time.Time) error {
+	updateSQL := `UPDATE signatures SET idempotencyKey = NULL, requestHash = NULL WHERE idempotencyKey IS NOT NULL AND createdAt < ?`
+	_, err := repo.db.ExecContext(ctx, updateSQL, before.UTC().Format(timeLayout))
+	return err
+}
+
+// UpdateDeviceStatus applies a lifecycle transition to a device and records it in the device's history.
+// It fails if the device is no longer in the state the transition starts from.
+func (repo *SQLiteDeviceRepository) UpdateDeviceStatus(ctx context.Context, transition *domain.StatusTransition) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	updateSQL := `UPDATE devices SET status = ?, updatedAt = ? WHERE id = ? AND status = ?`
+	result, err := tx.ExecContext(ctx, updateSQL, transition.GetTo(), transition.GetAt().UTC().Format(timeLayout), transition.GetDeviceID(), transition.GetFrom())
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		exists, err := deviceExists(ctx, tx, transition.GetDeviceID())
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return domain.ErrDeviceNotFound
+		}
+		return domain.ErrInvalidStatusTransition
+	}
+
+	insertSQL := `INSERT INTO status_transitions (deviceId, fromStatus, toStatus, action, reason, at) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err = tx.ExecContext(ctx, insertSQL, transition.GetDeviceID(), transition.GetFrom(), transition.GetTo(), transition.GetAction(),
+		transition.GetReason(), transition.GetAt().UTC().Format(timeLayout))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListStatusTransitions returns the lifecycle history of a device, oldest first
+func (repo *SQLiteDeviceRepository) ListStatusTransitions(ctx context.Context, deviceID string) ([]*domain.StatusTransition, error) {
+	transitions := []*domain.StatusTransition{}
+
+	exists, err := deviceExists(ctx, repo.db, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, domain.ErrDeviceNotFound
+	}
+
+	querySQL := `SELECT fromStatus, toStatus, action, reason, at FROM status_transitions WHERE deviceId = ? ORDER BY seq`
+	rows, err := repo.db.QueryContext(ctx, querySQL, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var from, to, action, reason, at string
+		if err := rows.Scan(&from, &to, &action, &reason, &at); err != nil {
+			return nil, err
+		}
+
+		atTime, err := time.Parse(timeLayout, at)
+		if err != nil {
+			return nil, err
+		}
+
+		transitions = append(transitions, domain.NewStatusTransition(deviceID, domain.DeviceStatus(from), domain.DeviceStatus(to),
+			domain.StatusAction(action), reason, atTime))
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return transitions, nil
+}
+
+// UpdateDevicePolicy sets the signing policy of a device and records the change in the device's audit trail
+func (repo *SQLiteDeviceRepository) UpdateDevicePolicy(ctx context.Context, change *domain.PolicyChange) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	at := change.GetAt().UTC().Format(timeLayout)
+	result, err := tx.ExecContext(ctx, `UPDATE devices SET policy = ?, updatedAt = ? WHERE id = ?`, change.GetPolicy(), at, change.GetDeviceID())
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return domain.ErrDeviceNotFound
+	}
+
+	insertSQL := `INSERT INTO policy_changes (deviceId, previousPolicy, policy, changedBy, at) VALUES (?, ?, ?, ?, ?)`
+	_, err = tx.ExecContext(ctx, insertSQL, change.GetDeviceID(), change.GetPreviousPolicy(), change.GetPolicy(), change.GetChangedBy(), at)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListPolicyChanges returns the policy audit trail of a device, oldest first
+func (repo *SQLiteDeviceRepository) ListPolicyChanges(ctx context.Context, deviceID string) ([]*domain.PolicyChange, error) {
+	changes := []*domain.PolicyChange{}
+
+	exists, err := deviceExists(ctx, repo.db, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, domain.ErrDeviceNotFound
+	}
+
+	querySQL := `SELECT previousPolicy, policy, changedBy, at FROM policy_changes WHERE deviceId = ? ORDER BY seq`
+	rows, err := repo.db.QueryContext(ctx, querySQL, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var previousPolicy, policy, changedBy, at string
+		if err := rows.Scan(&previousPolicy, &policy, &changedBy, &at); err != nil {
+			return nil, err
+		}
+
+		atTime, err := time.Parse(timeLayout, at)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, domain.NewPolicyChange(deviceID, previousPolicy, policy, changedBy, atTime))
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// apiKeyColumns lists the columns read for an APIKey, in the order scanAPIKey expects them
+const apiKeyColumns = `id, tenantId, clientId, name, hash, scopes, createdAt, revokedAt`
+
+// scanAPIKey reads an APIKey from a row selected with apiKeyColumns
+func scanAPIKey(row rowScanner) (*domain.APIKey, error) {
+	var id, tenantID, clientID, name, hash, scopes, createdAt string
+	var revokedAt sql.NullString
+	if err := row.Scan(&id, &tenantID, &clientID, &name, &hash, &scopes, &createdAt, &revokedAt); err != nil {
+		return nil, err
+	}
+
+	createdAtTime, err := time.Parse(timeLayout, createdAt)
+	if err != nil {
+		return nil, err
+	}
+	revokedAtTime, err := parseNullTime(revokedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyScopes []domain.Scope
+	for _, scope := range strings.Fields(scopes) {
+		keyScopes = append(keyScopes, domain.Scope(scope))
+	}
+
+	key := domain.NewAPIKey(id, tenantID, clientID, name, hash, keyScopes, createdAtTime)
+	key.SetRevokedAt(revokedAtTime)
+	return key, nil
+}
+
+// CreateAPIKey stores a new API key
+func (repo *SQLiteDeviceRepository) CreateAPIKey(ctx context.Context, key *domain.APIKey) error {
+	scopes := make([]string, 0, len(key.GetScopes()))
+	for _, scope := range key.GetScopes() {
+		scopes = append(scopes, string(scope))
+	}
+
+	insertSQL := `INSERT INTO api_keys (` + apiKeyColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := repo.db.ExecContext(ctx, insertSQL, key.GetID(), key.GetTenantID(), key.GetClientID(), key.GetName(), key.GetHash(),
+		strings.Join(scopes, " "), key.GetCreatedAt().UTC().Format(timeLayout), formatNullTime(key.GetRevokedAt()))
+	if isUniqueViolation(err) {
+		return domain.ErrAPIKeyAlreadyExists
+	}
 	return err
 }
 
+// GetAPIKey retrieves an API key of a tenant by its ID; keys of other tenants are not found
+func (repo *SQLiteDeviceRepository) GetAPIKey(ctx context.Context, tenantID, id string) (*domain.APIKey, error) {
+	querySQL := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id = ? AND tenantId = ?`
+	key, err := scanAPIKey(repo.db.QueryRowContext(ctx, querySQL, id, tenantID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of its secret
+func (repo *SQLiteDeviceRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*domain.APIKey, error) {
+	querySQL := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE hash = ?`
+	key, err := scanAPIKey(repo.db.QueryRowContext(ctx, querySQL, hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// ListAPIKeys returns the API keys of a tenant, oldest first
+func (repo *SQLiteDeviceRepository) ListAPIKeys(ctx context.Context, tenantID string) ([]*domain.APIKey, error) {
+	keys := []*domain.APIKey{}
+
+	querySQL := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE tenantId = ? ORDER BY createdAt, id`
+	rows, err := repo.db.QueryContext(ctx, querySQL, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an API key of a tenant; revoking a key again keeps the time of the first revocation.
+// Keys of other tenants are not found.
+func (repo *SQLiteDeviceRepository) RevokeAPIKey(ctx context.Context, tenantID, id string, revokedAt time.Time) error {
+	updateSQL := `UPDATE api_keys SET revokedAt = COALESCE(revokedAt, ?) WHERE id = ? AND tenantId = ?`
+	result, err := repo.db.ExecContext(ctx, updateSQL, revokedAt.UTC().Format(timeLayout), id, tenantID)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return domain.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// CountDevices counts the devices of all tenants that have not been deleted
+func (repo *SQLiteDeviceRepository) CountDevices(ctx context.Context) (int, error) {
+	var count int
+	err := repo.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM devices WHERE deletedAt IS NULL`).Scan(&count)
+	return count, err
+}
+
+// Ping checks the database connection and runs a query on the devices table
+func (repo *SQLiteDeviceRepository) Ping(ctx context.Context) error {
+	if err := repo.db.PingContext(ctx); err != nil {
+		return err
+	}
+	var count int
+	return repo.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM (SELECT 1 FROM devices LIMIT 1)`).Scan(&count)
+}
+
 // Close closes the database connection
 func (repo *SQLiteDeviceRepository) Close() error {
 	return repo.db.Close()
@@ -2,52 +2,137 @@ package persistence
 
 // TODO: in-memory persistence ...
 import (
+	"context"
 	"errors"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 // InMemoryDeviceRepository implements the DeviceRepositoryInterface
 type InMemoryDeviceRepository struct {
-	devices map[string]*domain.SignatureDevice
-	mu      sync.RWMutex
+	devices    map[string]*domain.SignatureDevice
+	signatures map[string][]*domain.SignatureRecord
+	// idempotencyKeys indexes signature records by device ID and idempotency key
+	idempotencyKeys map[string]map[string]*domain.SignatureRecord
+	// statusTransitions holds the lifecycle history per device ID
+	statusTransitions map[string][]*domain.StatusTransition
+	// policyChanges holds the policy audit trail per device ID
+	policyChanges map[string][]*domain.PolicyChange
+	// apiKeys holds the API keys by ID, apiKeyHashes their IDs by the hash of the secret key
+	apiKeys      map[string]*domain.APIKey
+	apiKeyHashes map[string]string
+	mu           sync.RWMutex
 }
 
 // NewInMemoryDeviceRepository creates a new instance of InMemoryDeviceRepository
 func NewInMemoryDeviceRepository() DeviceRepository {
 	return &InMemoryDeviceRepository{
-		devices: make(map[string]*domain.SignatureDevice),
+		devices:           make(map[string]*domain.SignatureDevice),
+		signatures:        make(map[string][]*domain.SignatureRecord),
+		idempotencyKeys:   make(map[string]map[string]*domain.SignatureRecord),
+		statusTransitions: make(map[string][]*domain.StatusTransition),
+		policyChanges:     make(map[string][]*domain.PolicyChange),
+		apiKeys:           make(map[string]*domain.APIKey),
+		apiKeyHashes:      make(map[string]string),
 	}
 }
 
-// AddDevice saves a new SignatureDevice to the repository
-func (repo *InMemoryDeviceRepository) AddDevice(id, label string, algorithm domain.AlgorithmType, publicKey, privateKey, lastSignature string) (*domain.SignatureDevice, error) {
+// AddDevice saves a new SignatureDevice to the repository, which takes ownership of the private key
+func (repo *InMemoryDeviceRepository) AddDevice(ctx context.Context, id, label string, algorithm domain.AlgorithmType, publicKey string, privateKey []byte, lastSignature string) (*domain.SignatureDevice, error) {
+	device := domain.NewSignatureDevice(id, label, algorithm, publicKey, privateKey, lastSignature)
+
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
-	if _, exists := repo.devices[id]; exists {
-		return nil, errors.New("device with this ID already exists")
+	if err := repo.storeDevice(device); err != nil {
+		return nil, err
+	}
+	return device.Clone(), nil
+}
+
+// CreateDevice saves a fully initialised SignatureDevice to the repository
+func (repo *InMemoryDeviceRepository) CreateDevice(ctx context.Context, device *domain.SignatureDevice) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return repo.storeDevice(device.Clone())
+}
+
+// storeDevice saves a device the repository owns; the caller must hold the write lock
+func (repo *InMemoryDeviceRepository) storeDevice(device *domain.SignatureDevice) error {
+	if _, exists := repo.devices[device.GetID()]; exists {
+		return domain.ErrDeviceAlreadyExists
 	}
-	device := domain.NewSignatureDevice(id, label, algorithm, publicKey, privateKey, lastSignature)
 	repo.devices[device.GetID()] = device
-	return device, nil
+	return nil
 }
 
 // GetDevice retrieves a SignatureDevice by its ID
-func (repo *InMemoryDeviceRepository) GetDevice(id string) (*domain.SignatureDevice, error) {
+func (repo *InMemoryDeviceRepository) GetDevice(ctx context.Context, id string) (*domain.SignatureDevice, error) {
 	repo.mu.RLock()
 	defer repo.mu.RUnlock()
 
 	device, exists := repo.devices[id]
 	if !exists {
-		return nil, errors.New("device not found")
+		return nil, domain.ErrDeviceNotFound
+	}
+
+	// Return a copy so callers cannot change the stored device without going through the repository
+	return device.Clone(), nil
+}
+
+// GetTenantDevice retrieves a SignatureDevice by its ID if it belongs to the given tenant
+func (repo *InMemoryDeviceRepository) GetTenantDevice(ctx context.Context, tenantID, id string) (*domain.SignatureDevice, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	// Devices of other tenants are reported as missing, so their IDs cannot be probed
+	device, exists := repo.devices[id]
+	if !exists || device.GetTenantID() != tenantID {
+		return nil, domain.ErrDeviceNotFound
+	}
+
+	return device.Clone(), nil
+}
+
+// UpdateDevice stores the user-editable attributes of a device, its label, metadata, signing limits and client
+// binding, and when they changed
+func (repo *InMemoryDeviceRepository) UpdateDevice(ctx context.Context, device *domain.SignatureDevice) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	stored, exists := repo.devices[device.GetID()]
+	if !exists {
+		return domain.ErrDeviceNotFound
+	}
+
+	stored.SetLabel(device.GetLabel())
+	stored.SetMetadata(device.GetMetadata())
+	stored.SetSigningLimits(device.GetSigningLimits())
+	stored.SetBoundClient(device.GetBoundClient())
+	stored.SetUpdatedAt(device.GetUpdatedAt())
+	return nil
+}
+
+// DeleteDevice turns a device into a tombstone, zeroing its private key
+func (repo *InMemoryDeviceRepository) DeleteDevice(ctx context.Context, id string, deletedAt time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	stored, exists := repo.devices[id]
+	if !exists {
+		return domain.ErrDeviceNotFound
 	}
 
-	return device, nil
+	// The stored key is zeroed in place; devices handed out before only hold copies
+	return stored.Delete(deletedAt)
 }
 
 // ListDevices returns all SignatureDevices in the repository
-func (repo *InMemoryDeviceRepository) ListDevices() ([]*domain.SignatureDevice, error) {
+func (repo *InMemoryDeviceRepository) ListDevices(ctx context.Context) ([]*domain.SignatureDevice, error) {
 	repo.mu.RLock()
 	defer repo.mu.RUnlock()
 
@@ -57,20 +142,101 @@ func (repo *InMemoryDeviceRepository) ListDevices() ([]*domain.SignatureDevice,
 
 	devices := make([]*domain.SignatureDevice, 0, len(repo.devices))
 	for _, device := range repo.devices {
-		devices = append(devices, device)
+		devices = append(devices, device.Clone())
 	}
 
+	// Map iteration order is random, so the devices are returned in creation order
+	sort.Slice(devices, func(i, j int) bool {
+		return lessBy(devices[i], devices[j], SortByCreatedAt)
+	})
+
 	return devices, nil
 }
 
+// QueryDevices returns a page of the devices matching the query, emulating the SQLite queries
+func (repo *InMemoryDeviceRepository) QueryDevices(ctx context.Context, query DeviceQuery) (*DevicePage, error) {
+	query, cursor, err := query.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	var matches []*domain.SignatureDevice
+	for _, device := range repo.devices {
+		if matchesQuery(device, query) && (cursor == nil || isAfterCursor(device, query, cursor)) {
+			matches = append(matches, device)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if query.Descending {
+			return lessBy(matches[j], matches[i], query.SortBy)
+		}
+		return lessBy(matches[i], matches[j], query.SortBy)
+	})
+
+	page := &DevicePage{Devices: []*domain.SignatureDevice{}}
+	for _, device := range matches {
+		if len(page.Devices) == query.Limit {
+			page.NextCursor = query.cursorAfter(page.Devices[len(page.Devices)-1])
+			break
+		}
+		// Listings never carry private keys
+		clone := device.Clone()
+		clone.ClearPrivateKey()
+		page.Devices = append(page.Devices, clone)
+	}
+	return page, nil
+}
+
+// matchesQuery reports whether a device passes the filters of a query
+func matchesQuery(device *domain.SignatureDevice, query DeviceQuery) bool {
+	switch {
+	case query.TenantID != "" && device.GetTenantID() != query.TenantID:
+		return false
+	case query.Algorithm != "" && device.GetAlgorithm() != query.Algorithm:
+		return false
+	case query.LabelPrefix != "" && !strings.HasPrefix(device.GetLabel(), query.LabelPrefix):
+		return false
+	case query.Status != "" && device.GetStatus() != query.Status:
+		return false
+	case !query.CreatedFrom.IsZero() && device.GetCreatedAt().Before(query.CreatedFrom):
+		return false
+	case !query.CreatedTo.IsZero() && !device.GetCreatedAt().Before(query.CreatedTo):
+		return false
+	case device.IsDeleted() && !query.IncludeDeleted:
+		return false
+	}
+	return true
+}
+
+// isAfterCursor reports whether a device comes after the cursor in the order of the query
+func isAfterCursor(device *domain.SignatureDevice, query DeviceQuery, cursor *deviceCursor) bool {
+	value := sortValue(device, query.SortBy)
+	if value == cursor.Value {
+		return (device.GetID() > cursor.ID) != query.Descending && device.GetID() != cursor.ID
+	}
+	return (value > cursor.Value) != query.Descending
+}
+
+// lessBy orders two devices by a sort field, breaking ties by ID
+func lessBy(a, b *domain.SignatureDevice, sortBy string) bool {
+	valueA, valueB := sortValue(a, sortBy), sortValue(b, sortBy)
+	if valueA != valueB {
+		return valueA < valueB
+	}
+	return a.GetID() < b.GetID()
+}
+
 // IncrementSignatureCount updates the signature count of a device
-func (repo *InMemoryDeviceRepository) IncrementSignatureCount(id string) error {
+func (repo *InMemoryDeviceRepository) IncrementSignatureCount(ctx context.Context, id string) error {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
 	device, exists := repo.devices[id]
 	if !exists {
-		return errors.New("device not found")
+		return domain.ErrDeviceNotFound
 	}
 
 	// Increment the signature count directly
@@ -80,16 +246,298 @@ func (repo *InMemoryDeviceRepository) IncrementSignatureCount(id string) error {
 }
 
 // UpdateLastSignature updates the last signature of a device
-func (repo *InMemoryDeviceRepository) UpdateLastSignature(id string, lastSignature string) error {
+func (repo *InMemoryDeviceRepository) UpdateLastSignature(ctx context.Context, id string, lastSignature string) error {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
 	device, exists := repo.devices[id]
 	if !exists {
-		return errors.New("device not found")
+		return domain.ErrDeviceNotFound
 	}
 
 	// Use the setter to update the last signature
 	device.SetLastSignature(lastSignature)
 	return nil
 }
+
+// AddSignatureRecord stores a signature created by a device
+func (repo *InMemoryDeviceRepository) AddSignatureRecord(ctx context.Context, record *domain.SignatureRecord) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, exists := repo.devices[record.GetDeviceID()]; !exists {
+		return domain.ErrDeviceNotFound
+	}
+	repo.addSignatureRecord(record)
+	return nil
+}
+
+// AppendSignature stores a signature and makes it the last signature of its device under one lock
+func (repo *InMemoryDeviceRepository) AppendSignature(ctx context.Context, record *domain.SignatureRecord) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	device, exists := repo.devices[record.GetDeviceID()]
+	if !exists {
+		return domain.ErrDeviceNotFound
+	}
+	// Only the counter the signature was created with may be advanced
+	if device.GetSignatureCount() != record.GetCounter() {
+		return domain.ErrSignatureCounterConflict
+	}
+
+	repo.addSignatureRecord(record)
+	device.SetLastSignature(record.GetSignature())
+	device.SetSignatureCount(record.GetCounter() + 1)
+	return nil
+}
+
+// addSignatureRecord stores a signature of an existing device; the caller must hold the write lock
+func (repo *InMemoryDeviceRepository) addSignatureRecord(record *domain.SignatureRecord) {
+	if key := record.GetIdempotencyKey(); key != "" {
+		if repo.idempotencyKeys[record.GetDeviceID()] == nil {
+			repo.idempotencyKeys[record.GetDeviceID()] = make(map[string]*domain.SignatureRecord)
+		}
+		repo.idempotencyKeys[record.GetDeviceID()][key] = record
+	}
+
+	repo.signatures[record.GetDeviceID()] = append(repo.signatures[record.GetDeviceID()], record)
+}
+
+// ListSignatureRecords returns the signatures of a device ordered by signature counter
+func (repo *InMemoryDeviceRepository) ListSignatureRecords(ctx context.Context, deviceID string) ([]*domain.SignatureRecord, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	if _, exists := repo.devices[deviceID]; !exists {
+		return nil, domain.ErrDeviceNotFound
+	}
+
+	records := make([]*domain.SignatureRecord, len(repo.signatures[deviceID]))
+	copy(records, repo.signatures[deviceID])
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].GetCounter() < records[j].GetCounter()
+	})
+	return records, nil
+}
+
+// CountSignatureRecords returns the number of signatures a device created at or after the given time
+func (repo *InMemoryDeviceRepository) CountSignatureRecords(ctx context.Context, deviceID string, since time.Time) (uint64, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	if _, exists := repo.devices[deviceID]; !exists {
+		return 0, domain.ErrDeviceNotFound
+	}
+
+	var count uint64
+	for _, record := range repo.signatures[deviceID] {
+		if !record.GetCreatedAt().Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetSignatureRecordByIdempotencyKey returns the signature a device created for an idempotency key
+// used at or after notBefore
+func (repo *InMemoryDeviceRepository) GetSignatureRecordByIdempotencyKey(ctx context.Context, deviceID, idempotencyKey string, notBefore time.Time) (*domain.SignatureRecord, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	record, exists := repo.idempotencyKeys[deviceID][idempotencyKey]
+	if !exists || record.GetCreatedAt().Before(notBefore) {
+		return nil, domain.ErrSignatureNotFound
+	}
+
+	return record, nil
+}
+
+// ExpireIdempotencyKeys forgets the idempotency keys of signatures created before the given time
+func (repo *InMemoryDeviceRepository) ExpireIdempotencyKeys(ctx context.Context, before time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for deviceID, records := range repo.idempotencyKeys {
+		for key, record := range records {
+			if record.GetCreatedAt().Before(before) {
+				delete(records, key)
+			}
+		}
+		if len(records) == 0 {
+			delete(repo.idempotencyKeys, deviceID)
+		}
+	}
+	return nil
+}
+
+// UpdateDeviceStatus applies a lifecycle transition to a device and records it in the device's history.
+// It fails if the device is no longer in the state the transition starts from.
+func (repo *InMemoryDeviceRepository) UpdateDeviceStatus(ctx context.Context, transition *domain.StatusTransition) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	device, exists := repo.devices[transition.GetDeviceID()]
+	if !exists {
+		return domain.ErrDeviceNotFound
+	}
+	if device.GetStatus() != transition.GetFrom() {
+		return domain.ErrInvalidStatusTransition
+	}
+
+	device.SetStatus(transition.GetTo())
+	device.SetUpdatedAt(transition.GetAt())
+	repo.statusTransitions[device.GetID()] = append(repo.statusTransitions[device.GetID()], transition)
+	return nil
+}
+
+// ListStatusTransitions returns the lifecycle history of a device, oldest first
+func (repo *InMemoryDeviceRepository) ListStatusTransitions(ctx context.Context, deviceID string) ([]*domain.StatusTransition, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	if _, exists := repo.devices[deviceID]; !exists {
+		return nil, domain.ErrDeviceNotFound
+	}
+
+	transitions := make([]*domain.StatusTransition, len(repo.statusTransitions[deviceID]))
+	copy(transitions, repo.statusTransitions[deviceID])
+	return transitions, nil
+}
+
+// UpdateDevicePolicy sets the signing policy of a device and records the change in the device's audit trail
+func (repo *InMemoryDeviceRepository) UpdateDevicePolicy(ctx context.Context, change *domain.PolicyChange) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	device, exists := repo.devices[change.GetDeviceID()]
+	if !exists {
+		return domain.ErrDeviceNotFound
+	}
+
+	device.SetPolicy(change.GetPolicy())
+	device.SetUpdatedAt(change.GetAt())
+	repo.policyChanges[device.GetID()] = append(repo.policyChanges[device.GetID()], change)
+	return nil
+}
+
+// ListPolicyChanges returns the policy audit trail of a device, oldest first
+func (repo *InMemoryDeviceRepository) ListPolicyChanges(ctx context.Context, deviceID string) ([]*domain.PolicyChange, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	if _, exists := repo.devices[deviceID]; !exists {
+		return nil, domain.ErrDeviceNotFound
+	}
+
+	changes := make([]*domain.PolicyChange, len(repo.policyChanges[deviceID]))
+	copy(changes, repo.policyChanges[deviceID])
+	return changes, nil
+}
+
+// CreateAPIKey stores a new API key
+func (repo *InMemoryDeviceRepository) CreateAPIKey(ctx context.Context, key *domain.APIKey) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, exists := repo.apiKeys[key.GetID()]; exists {
+		return domain.ErrAPIKeyAlreadyExists
+	}
+	if _, exists := repo.apiKeyHashes[key.GetHash()]; exists {
+		return domain.ErrAPIKeyAlreadyExists
+	}
+	repo.apiKeys[key.GetID()] = cloneAPIKey(key)
+	repo.apiKeyHashes[key.GetHash()] = key.GetID()
+	return nil
+}
+
+// GetAPIKey retrieves an API key of a tenant by its ID; keys of other tenants are not found
+func (repo *InMemoryDeviceRepository) GetAPIKey(ctx context.Context, tenantID, id string) (*domain.APIKey, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	key, exists := repo.apiKeys[id]
+	if !exists || key.GetTenantID() != tenantID {
+		return nil, domain.ErrAPIKeyNotFound
+	}
+	return cloneAPIKey(key), nil
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of its secret
+func (repo *InMemoryDeviceRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*domain.APIKey, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	id, exists := repo.apiKeyHashes[hash]
+	if !exists {
+		return nil, domain.ErrAPIKeyNotFound
+	}
+	return cloneAPIKey(repo.apiKeys[id]), nil
+}
+
+// ListAPIKeys returns the API keys of a tenant, oldest first
+func (repo *InMemoryDeviceRepository) ListAPIKeys(ctx context.Context, tenantID string) ([]*domain.APIKey, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	keys := []*domain.APIKey{}
+	for _, key := range repo.apiKeys {
+		if key.GetTenantID() == tenantID {
+			keys = append(keys, cloneAPIKey(key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].GetCreatedAt().Equal(keys[j].GetCreatedAt()) {
+			return keys[i].GetCreatedAt().Before(keys[j].GetCreatedAt())
+		}
+		return keys[i].GetID() < keys[j].GetID()
+	})
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an API key of a tenant; revoking a key again keeps the time of the first revocation.
+// Keys of other tenants are not found.
+func (repo *InMemoryDeviceRepository) RevokeAPIKey(ctx context.Context, tenantID, id string, revokedAt time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	key, exists := repo.apiKeys[id]
+	if !exists || key.GetTenantID() != tenantID {
+		return domain.ErrAPIKeyNotFound
+	}
+	if !key.IsRevoked() {
+		key.SetRevokedAt(revokedAt)
+	}
+	return nil
+}
+
+// cloneAPIKey returns a copy of an API key that can be changed without affecting the stored key
+func cloneAPIKey(key *domain.APIKey) *domain.APIKey {
+	clone := domain.NewAPIKey(key.GetID(), key.GetTenantID(), key.GetClientID(), key.GetName(), key.GetHash(), key.GetScopes(), key.GetCreatedAt())
+	clone.SetRevokedAt(key.GetRevokedAt())
+	return clone
+}
+
+// CountDevices counts the devices of all tenants that have not been deleted
+func (repo *InMemoryDeviceRepository) CountDevices(ctx context.Context) (int, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	count := 0
+	for _, device := range repo.devices {
+		if !device.IsDeleted() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Ping only reports whether the context is done; the data in memory is always reachable
+func (repo *InMemoryDeviceRepository) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Close does nothing; the data is released with the repository
+func (repo *InMemoryDeviceRepository) Close() error {
+	return nil
+}
@@ -0,0 +1,255 @@
+package persistence
+
+import (
+	"context"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"time"
+)
+
+// OperationObserver is told the context, the name, e.g. GetDevice, the duration and the error of every
+// repository operation
+type OperationObserver func(ctx context.Context, operation string, duration time.Duration, err error)
+
+// ObservedRepository is a DeviceRepository that reports every operation of the repository it wraps to
+// OperationObservers, e.g. to record latency metrics or to log the operations of a request
+type ObservedRepository struct {
+	repo      DeviceRepository
+	observers []OperationObserver
+}
+
+// NewObservedRepository wraps a repository so its operations are reported to the observers, in order
+func NewObservedRepository(repo DeviceRepository, observers ...OperationObserver) DeviceRepository {
+	return &ObservedRepository{repo: repo, observers: observers}
+}
+
+// observed reports an operation that started at started and returned err
+func (o *ObservedRepository) observed(ctx context.Context, operation string, started time.Time, err error) {
+	duration := time.Since(started)
+	for _, observe := range o.observers {
+		observe(ctx, operation, duration, err)
+	}
+}
+
+// AddDevice calls AddDevice of the wrapped repository
+func (o *ObservedRepository) AddDevice(ctx context.Context, id, label string, algorithm domain.AlgorithmType, publicKey string, privateKey []byte, lastSignature string) (*domain.SignatureDevice, error) {
+	started := time.Now()
+	result, err := o.repo.AddDevice(ctx, id, label, algorithm, publicKey, privateKey, lastSignature)
+	o.observed(ctx, "AddDevice", started, err)
+	return result, err
+}
+
+// CreateDevice calls CreateDevice of the wrapped repository
+func (o *ObservedRepository) CreateDevice(ctx context.Context, device *domain.SignatureDevice) error {
+	started := time.Now()
+	err := o.repo.CreateDevice(ctx, device)
+	o.observed(ctx, "CreateDevice", started, err)
+	return err
+}
+
+// GetDevice calls GetDevice of the wrapped repository
+func (o *ObservedRepository) GetDevice(ctx context.Context, id string) (*domain.SignatureDevice, error) {
+	started := time.Now()
+	result, err := o.repo.GetDevice(ctx, id)
+	o.observed(ctx, "GetDevice", started, err)
+	return result, err
+}
+
+// GetTenantDevice calls GetTenantDevice of the wrapped repository
+func (o *ObservedRepository) GetTenantDevice(ctx context.Context, tenantID, id string) (*domain.SignatureDevice, error) {
+	started := time.Now()
+	result, err := o.repo.GetTenantDevice(ctx, tenantID, id)
+	o.observed(ctx, "GetTenantDevice", started, err)
+	return result, err
+}
+
+// UpdateDevice calls UpdateDevice of the wrapped repository
+func (o *ObservedRepository) UpdateDevice(ctx context.Context, device *domain.SignatureDevice) error {
+	started := time.Now()
+	err := o.repo.UpdateDevice(ctx, device)
+	o.observed(ctx, "UpdateDevice", started, err)
+	return err
+}
+
+// DeleteDevice calls DeleteDevice of the wrapped repository
+func (o *ObservedRepository) DeleteDevice(ctx context.Context, id string, deletedAt time.Time) error {
+	started := time.Now()
+	err := o.repo.DeleteDevice(ctx, id, deletedAt)
+	o.observed(ctx, "DeleteDevice", started, err)
+	return err
+}
+
+// ListDevices calls ListDevices of the wrapped repository
+func (o *ObservedRepository) ListDevices(ctx context.Context) ([]*domain.SignatureDevice, error) {
+	started := time.Now()
+	result, err := o.repo.ListDevices(ctx)
+	o.observed(ctx, "ListDevices", started, err)
+	return result, err
+}
+
+// CountDevices calls CountDevices of the wrapped repository
+func (o *ObservedRepository) CountDevices(ctx context.Context) (int, error) {
+	started := time.Now()
+	result, err := o.repo.CountDevices(ctx)
+	o.observed(ctx, "CountDevices", started, err)
+	return result, err
+}
+
+// QueryDevices calls QueryDevices of the wrapped repository
+func (o *ObservedRepository) QueryDevices(ctx context.Context, query DeviceQuery) (*DevicePage, error) {
+	started := time.Now()
+	result, err := o.repo.QueryDevices(ctx, query)
+	o.observed(ctx, "QueryDevices", started, err)
+	return result, err
+}
+
+// IncrementSignatureCount calls IncrementSignatureCount of the wrapped repository
+func (o *ObservedRepository) IncrementSignatureCount(ctx context.Context, id string) error {
+	started := time.Now()
+	err := o.repo.IncrementSignatureCount(ctx, id)
+	o.observed(ctx, "IncrementSignatureCount", started, err)
+	return err
+}
+
+// UpdateLastSignature calls UpdateLastSignature of the wrapped repository
+func (o *ObservedRepository) UpdateLastSignature(ctx context.Context, id string, lastSignature string) error {
+	started := time.Now()
+	err := o.repo.UpdateLastSignature(ctx, id, lastSignature)
+	o.observed(ctx, "UpdateLastSignature", started, err)
+	return err
+}
+
+// AddSignatureRecord calls AddSignatureRecord of the wrapped repository
+func (o *ObservedRepository) AddSignatureRecord(ctx context.Context, record *domain.SignatureRecord) error {
+	started := time.Now()
+	err := o.repo.AddSignatureRecord(ctx, record)
+	o.observed(ctx, "AddSignatureRecord", started, err)
+	return err
+}
+
+// AppendSignature calls AppendSignature of the wrapped repository
+func (o *ObservedRepository) AppendSignature(ctx context.Context, record *domain.SignatureRecord) error {
+	started := time.Now()
+	err := o.repo.AppendSignature(ctx, record)
+	o.observed(ctx, "AppendSignature", started, err)
+	return err
+}
+
+// ListSignatureRecords calls ListSignatureRecords of the wrapped repository
+func (o *ObservedRepository) ListSignatureRecords(ctx context.Context, deviceID string) ([]*domain.SignatureRecord, error) {
+	started := time.Now()
+	result, err := o.repo.ListSignatureRecords(ctx, deviceID)
+	o.observed(ctx, "ListSignatureRecords", started, err)
+	return result, err
+}
+
+// CountSignatureRecords calls CountSignatureRecords of the wrapped repository
+func (o *ObservedRepository) CountSignatureRecords(ctx context.Context, deviceID string, since time.Time) (uint64, error) {
+	started := time.Now()
+	result, err := o.repo.CountSignatureRecords(ctx, deviceID, since)
+	o.observed(ctx, "CountSignatureRecords", started, err)
+	return result, err
+}
+
+// GetSignatureRecordByIdempotencyKey calls GetSignatureRecordByIdempotencyKey of the wrapped repository
+func (o *ObservedRepository) GetSignatureRecordByIdempotencyKey(ctx context.Context, deviceID, idempotencyKey string, notBefore time.Time) (*domain.SignatureRecord, error) {
+	started := time.Now()
+	result, err := o.repo.GetSignatureRecordByIdempotencyKey(ctx, deviceID, idempotencyKey, notBefore)
+	o.observed(ctx, "GetSignatureRecordByIdempotencyKey", started, err)
+	return result, err
+}
+
+// ExpireIdempotencyKeys calls ExpireIdempotencyKeys of the wrapped repository
+func (o *ObservedRepository) ExpireIdempotencyKeys(ctx context.Context, before time.Time) error {
+	started := time.Now()
+	err := o.repo.ExpireIdempotencyKeys(ctx, before)
+	o.observed(ctx, "ExpireIdempotencyKeys", started, err)
+	return err
+}
+
+// UpdateDeviceStatus calls UpdateDeviceStatus of the wrapped repository
+func (o *ObservedRepository) UpdateDeviceStatus(ctx context.Context, transition *domain.StatusTransition) error {
+	started := time.Now()
+	err := o.repo.UpdateDeviceStatus(ctx, transition)
+	o.observed(ctx, "UpdateDeviceStatus", started, err)
+	return err
+}
+
+// ListStatusTransitions calls ListStatusTransitions of the wrapped repository
+func (o *ObservedRepository) ListStatusTransitions(ctx context.Context, deviceID string) ([]*domain.StatusTransition, error) {
+	started := time.Now()
+	result, err := o.repo.ListStatusTransitions(ctx, deviceID)
+	o.observed(ctx, "ListStatusTransitions", started, err)
+	return result, err
+}
+
+// UpdateDevicePolicy calls UpdateDevicePolicy of the wrapped repository
+func (o *ObservedRepository) UpdateDevicePolicy(ctx context.Context, change *domain.PolicyChange) error {
+	started := time.Now()
+	err := o.repo.UpdateDevicePolicy(ctx, change)
+	o.observed(ctx, "UpdateDevicePolicy", started, err)
+	return err
+}
+
+// ListPolicyChanges calls ListPolicyChanges of the wrapped repository
+func (o *ObservedRepository) ListPolicyChanges(ctx context.Context, deviceID string) ([]*domain.PolicyChange, error) {
+	started := time.Now()
+	result, err := o.repo.ListPolicyChanges(ctx, deviceID)
+	o.observed(ctx, "ListPolicyChanges", started, err)
+	return result, err
+}
+
+// CreateAPIKey calls CreateAPIKey of the wrapped repository
+func (o *ObservedRepository) CreateAPIKey(ctx context.Context, key *domain.APIKey) error {
+	started := time.Now()
+	err := o.repo.CreateAPIKey(ctx, key)
+	o.observed(ctx, "CreateAPIKey", started, err)
+	return err
+}
+
+// GetAPIKey calls GetAPIKey of the wrapped repository
+func (o *ObservedRepository) GetAPIKey(ctx context.Context, tenantID, id string) (*domain.APIKey, error) {
+	started := time.Now()
+	result, err := o.repo.GetAPIKey(ctx, tenantID, id)
+	o.observed(ctx, "GetAPIKey", started, err)
+	return result, err
+}
+
+// GetAPIKeyByHash calls GetAPIKeyByHash of the wrapped repository
+func (o *ObservedRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*domain.APIKey, error) {
+	started := time.Now()
+	result, err := o.repo.GetAPIKeyByHash(ctx, hash)
+	o.observed(ctx, "GetAPIKeyByHash", started, err)
+	return result, err
+}
+
+// ListAPIKeys calls ListAPIKeys of the wrapped repository
+func (o *ObservedRepository) ListAPIKeys(ctx context.Context, tenantID string) ([]*domain.APIKey, error) {
+	started := time.Now()
+	result, err := o.repo.ListAPIKeys(ctx, tenantID)
+	o.observed(ctx, "ListAPIKeys", started, err)
+	return result, err
+}
+
+// RevokeAPIKey calls RevokeAPIKey of the wrapped repository
+func (o *ObservedRepository) RevokeAPIKey(ctx context.Context, tenantID, id string, revokedAt time.Time) error {
+	started := time.Now()
+	err := o.repo.RevokeAPIKey(ctx, tenantID, id, revokedAt)
+	o.observed(ctx, "RevokeAPIKey", started, err)
+	return err
+}
+
+// Ping calls Ping of the wrapped repository
+func (o *ObservedRepository) Ping(ctx context.Context) error {
+	started := time.Now()
+	err := o.repo.Ping(ctx)
+	o.observed(ctx, "Ping", started, err)
+	return err
+}
+
+// Close calls Close of the wrapped repository
+func (o *ObservedRepository) Close() error {
+	started := time.Now()
+	err := o.repo.Close()
+	o.observed(context.Background(), "Close", started, err)
+	return err
+}
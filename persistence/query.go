@@ -0,0 +1,118 @@
+package persistence
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+// Fields device queries can be sorted by. The device ID breaks ties, so the order is always stable.
+const (
+	SortByID        = "id"
+	SortByLabel     = "label"
+	SortByCreatedAt = "createdAt"
+)
+
+// Page sizes of device queries
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// ErrInvalidCursor is returned when a cursor is malformed or was issued for a different sort order
+var ErrInvalidCursor = domain.NewError(domain.KindInvalid, "invalid_cursor", "invalid cursor")
+
+// ErrInvalidSortField is returned when a device query is sorted by an unknown field
+var ErrInvalidSortField = domain.NewError(domain.KindInvalid, "invalid_sort_field", "invalid sort field")
+
+// DeviceQuery selects a page of devices. Zero values disable a filter.
+type DeviceQuery struct {
+	// TenantID restricts the query to the devices of one tenant; callers serving a tenant must always set it
+	TenantID    string
+	Algorithm   domain.AlgorithmType
+	LabelPrefix string
+	Status      domain.DeviceStatus
+	// CreatedFrom is inclusive, CreatedTo exclusive
+	CreatedFrom    time.Time
+	CreatedTo      time.Time
+	IncludeDeleted bool
+	// SortBy is one of the SortBy constants, SortByCreatedAt by default
+	SortBy     string
+	Descending bool
+	// Cursor continues a previous query where its page ended
+	Cursor string
+	// Limit is the page size, DefaultPageSize if zero
+	Limit int
+}
+
+// DevicePage is a page of devices returned by a DeviceQuery. Private keys are never included.
+type DevicePage struct {
+	Devices []*domain.SignatureDevice
+	// NextCursor continues the query after this page, empty on the last page
+	NextCursor string
+}
+
+// deviceCursor is the position after the last device of a page
+type deviceCursor struct {
+	SortBy     string `json:"s"`
+	Descending bool   `json:"d"`
+	Value      string `json:"v"`
+	ID         string `json:"i"`
+}
+
+// normalize fills in the defaults of a query and decodes its cursor, which is nil on the first page
+func (query DeviceQuery) normalize() (DeviceQuery, *deviceCursor, error) {
+	if query.SortBy == "" {
+		query.SortBy = SortByCreatedAt
+	}
+	if query.SortBy != SortByID && query.SortBy != SortByLabel && query.SortBy != SortByCreatedAt {
+		return query, nil, ErrInvalidSortField
+	}
+	if query.Limit <= 0 {
+		query.Limit = DefaultPageSize
+	}
+	if query.Limit > MaxPageSize {
+		query.Limit = MaxPageSize
+	}
+	if query.Cursor == "" {
+		return query, nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return query, nil, ErrInvalidCursor
+	}
+	var cursor deviceCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.ID == "" {
+		return query, nil, ErrInvalidCursor
+	}
+	if cursor.SortBy != query.SortBy || cursor.Descending != query.Descending {
+		return query, nil, ErrInvalidCursor
+	}
+	return query, &cursor, nil
+}
+
+// cursorAfter returns the cursor continuing a query after the given device
+func (query DeviceQuery) cursorAfter(device *domain.SignatureDevice) string {
+	raw, _ := json.Marshal(deviceCursor{
+		SortBy:     query.SortBy,
+		Descending: query.Descending,
+		Value:      sortValue(device, query.SortBy),
+		ID:         device.GetID(),
+	})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// sortValue returns the value a device is sorted by, compared as a string in both backends
+func sortValue(device *domain.SignatureDevice, sortBy string) string {
+	switch sortBy {
+	case SortByLabel:
+		return device.GetLabel()
+	case SortByCreatedAt:
+		return device.GetCreatedAt().UTC().Format(timeLayout)
+	default:
+		return device.GetID()
+	}
+}
@@ -1,12 +1,48 @@
 package persistence
 
-import "github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+import (
+	"context"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"time"
+)
 
 // DeviceRepository defines the interface for storage backends, allowing flexibility for future implementations.
 type DeviceRepository interface {
-	AddDevice(id, label string, algorithm domain.AlgorithmType, publicKey, privateKey, lastSignature string) (*domain.SignatureDevice, error)
-	GetDevice(id string) (*domain.SignatureDevice, error)
-	ListDevices() ([]*domain.SignatureDevice, error)
-	IncrementSignatureCount(id string) error
-	UpdateLastSignature(id string, lastSignature string) error
+	AddDevice(ctx context.Context, id, label string, algorithm domain.AlgorithmType, publicKey string, privateKey []byte, lastSignature string) (*domain.SignatureDevice, error)
+	CreateDevice(ctx context.Context, device *domain.SignatureDevice) error
+	GetDevice(ctx context.Context, id string) (*domain.SignatureDevice, error)
+	GetTenantDevice(ctx context.Context, tenantID, id string) (*domain.SignatureDevice, error)
+	UpdateDevice(ctx context.Context, device *domain.SignatureDevice) error
+	DeleteDevice(ctx context.Context, id string, deletedAt time.Time) error
+	ListDevices(ctx context.Context) ([]*domain.SignatureDevice, error)
+	// CountDevices counts the devices of all tenants that have not been deleted
+	CountDevices(ctx context.Context) (int, error)
+	QueryDevices(ctx context.Context, query DeviceQuery) (*DevicePage, error)
+	IncrementSignatureCount(ctx context.Context, id string) error
+	UpdateLastSignature(ctx context.Context, id string, lastSignature string) error
+	// AddSignatureRecord stores a signature. An idempotency key of the record replaces the same key of an
+	// earlier signature of the device, which callers only reuse once GetSignatureRecordByIdempotencyKey
+	// no longer finds it.
+	AddSignatureRecord(ctx context.Context, record *domain.SignatureRecord) error
+	// AppendSignature stores a signature and makes it the last signature of its device in one step, so the
+	// counter and the chain of the device never get out of step with the stored signatures. It fails with
+	// domain.ErrSignatureCounterConflict unless the counter of the record is the signature count of the device.
+	AppendSignature(ctx context.Context, record *domain.SignatureRecord) error
+	ListSignatureRecords(ctx context.Context, deviceID string) ([]*domain.SignatureRecord, error)
+	CountSignatureRecords(ctx context.Context, deviceID string, since time.Time) (uint64, error)
+	GetSignatureRecordByIdempotencyKey(ctx context.Context, deviceID, idempotencyKey string, notBefore time.Time) (*domain.SignatureRecord, error)
+	ExpireIdempotencyKeys(ctx context.Context, before time.Time) error
+	UpdateDeviceStatus(ctx context.Context, transition *domain.StatusTransition) error
+	ListStatusTransitions(ctx context.Context, deviceID string) ([]*domain.StatusTransition, error)
+	UpdateDevicePolicy(ctx context.Context, change *domain.PolicyChange) error
+	ListPolicyChanges(ctx context.Context, deviceID string) ([]*domain.PolicyChange, error)
+	CreateAPIKey(ctx context.Context, key *domain.APIKey) error
+	GetAPIKey(ctx context.Context, tenantID, id string) (*domain.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*domain.APIKey, error)
+	ListAPIKeys(ctx context.Context, tenantID string) ([]*domain.APIKey, error)
+	RevokeAPIKey(ctx context.Context, tenantID, id string, revokedAt time.Time) error
+	// Ping checks that the repository can be reached and queried
+	Ping(ctx context.Context) error
+	// Close releases the resources of the repository; it must not be used afterwards
+	Close() error
 }
@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrPolicyViolation is matched by every *ViolationError.
+var ErrPolicyViolation = domain.NewError(domain.KindForbidden, "policy_violation", "policy violation")
+
+// Rules reported in violations.
+const (
+	RuleMaxPayloadSize = "maxPayloadSize"
+	RuleDataPattern    = "dataPattern"
+	RuleDataSchema     = "dataSchema"
+	RuleAllowedHours   = "allowedHours"
+	RuleAllowedClients = "allowedClients"
+)
+
+// Violation describes a rule a sign request breaks.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ViolationError is returned when a sign request breaks one or more rules of a policy.
+type ViolationError struct {
+	Violations []Violation
+}
+
+// Error lists the violated rules.
+func (err *ViolationError) Error() string {
+	messages := make([]string, 0, len(err.Violations))
+	for _, violation := range err.Violations {
+		messages = append(messages, violation.Rule+": "+violation.Message)
+	}
+	return ErrPolicyViolation.Error() + ": " + strings.Join(messages, "; ")
+}
+
+// Unwrap makes errors.Is(err, ErrPolicyViolation) match and gives the error its kind and code.
+func (err *ViolationError) Unwrap() error {
+	return ErrPolicyViolation
+}
+
+// Input is what a policy is evaluated against.
+type Input struct {
+	// ClientID is the identity of the caller, empty if unknown.
+	ClientID string
+	// Data is the decoded transaction data; nil when only a digest is signed.
+	Data []byte
+	// At is the time of the sign request.
+	At time.Time
+}
+
+// Evaluate checks a sign request against every rule of the policy and returns a *ViolationError
+// listing all rules it breaks, or nil.
+func (policy *Policy) Evaluate(input Input) error {
+	var violations []Violation
+
+	if policy.MaxPayloadSize > 0 && len(input.Data) > policy.MaxPayloadSize {
+		violations = append(violations, Violation{
+			Rule:    RuleMaxPayloadSize,
+			Message: fmt.Sprintf("data is %d bytes, at most %d are allowed", len(input.Data), policy.MaxPayloadSize),
+		})
+	}
+
+	// Content rules cannot be checked when the client only sends a digest
+	if (policy.pattern != nil || policy.schema != nil) && input.Data == nil {
+		rule := RuleDataPattern
+		if policy.pattern == nil {
+			rule = RuleDataSchema
+		}
+		violations = append(violations, Violation{
+			Rule:    rule,
+			Message: "the device only signs data it can inspect, not pre-computed digests",
+		})
+	} else {
+		if policy.pattern != nil && !policy.pattern.Match(input.Data) {
+			violations = append(violations, Violation{
+				Rule:    RuleDataPattern,
+				Message: "data does not match the allowed pattern",
+			})
+		}
+		if policy.schema != nil {
+			var value interface{}
+			if err := json.Unmarshal(input.Data, &value); err != nil {
+				violations = append(violations, Violation{Rule: RuleDataSchema, Message: "data is not valid JSON"})
+			} else if err := policy.schema.Validate(value); err != nil {
+				violations = append(violations, Violation{Rule: RuleDataSchema, Message: err.Error()})
+			}
+		}
+	}
+
+	if hours := policy.AllowedHours; hours != nil && !hours.contains(input.At) {
+		violations = append(violations, Violation{
+			Rule:    RuleAllowedHours,
+			Message: fmt.Sprintf("signing is only allowed from %02d:00 to %02d:00 (%s)", hours.From, hours.To, hours.location),
+		})
+	}
+
+	if len(policy.AllowedClients) > 0 && !slices.Contains(policy.AllowedClients, input.ClientID) {
+		message := fmt.Sprintf("client %q may not sign with this device", input.ClientID)
+		if input.ClientID == "" {
+			message = "the device only signs for identified clients"
+		}
+		violations = append(violations, Violation{Rule: RuleAllowedClients, Message: message})
+	}
+
+	if len(violations) > 0 {
+		return &ViolationError{Violations: violations}
+	}
+	return nil
+}
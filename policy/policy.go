@@ -0,0 +1,109 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"regexp"
+	"time"
+)
+
+// ErrInvalidPolicy is returned for policy documents that cannot be parsed or contain invalid rules.
+var ErrInvalidPolicy = domain.NewError(domain.KindInvalid, "invalid_policy", "invalid policy")
+
+// Policy restricts what a device may sign. Rules that are left out do not apply.
+type Policy struct {
+	// MaxPayloadSize is the maximum size of the decoded transaction data in bytes.
+	MaxPayloadSize int `json:"maxPayloadSize,omitempty"`
+	// DataPattern is a regular expression the whole transaction data must match.
+	DataPattern string `json:"dataPattern,omitempty"`
+	// DataSchema is a JSON schema the transaction data must be valid against.
+	DataSchema json.RawMessage `json:"dataSchema,omitempty"`
+	// AllowedHours restricts signing to a time of day.
+	AllowedHours *HourRange `json:"allowedHours,omitempty"`
+	// AllowedClients lists the client identities that may sign with the device.
+	AllowedClients []string `json:"allowedClients,omitempty"`
+
+	// pattern and schema are compiled by Parse
+	pattern *regexp.Regexp
+	schema  *Schema
+}
+
+// HourRange is a range of hours of the day. From is inclusive, To exclusive;
+// ranges with From after To wrap around midnight.
+type HourRange struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+	// TimeZone is an IANA time zone name, UTC if empty.
+	TimeZone string `json:"timeZone,omitempty"`
+
+	location *time.Location
+}
+
+// Parse parses and validates a policy document. An empty document is a policy without rules.
+func Parse(document []byte) (*Policy, error) {
+	policy := &Policy{}
+	if len(bytes.TrimSpace(document)) == 0 || bytes.Equal(bytes.TrimSpace(document), []byte("null")) {
+		return policy, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(policy); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+
+	if policy.MaxPayloadSize < 0 {
+		return nil, fmt.Errorf("%w: maxPayloadSize must not be negative", ErrInvalidPolicy)
+	}
+	if policy.DataPattern != "" {
+		// The pattern has to match the whole data, not just a part of it
+		pattern, err := regexp.Compile(`^(?:` + policy.DataPattern + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("%w: dataPattern: %v", ErrInvalidPolicy, err)
+		}
+		policy.pattern = pattern
+	}
+	if len(policy.DataSchema) != 0 {
+		schema, err := ParseSchema(policy.DataSchema)
+		if err != nil {
+			return nil, fmt.Errorf("%w: dataSchema: %v", ErrInvalidPolicy, err)
+		}
+		policy.schema = schema
+	}
+	if hours := policy.AllowedHours; hours != nil {
+		if hours.From < 0 || hours.From > 23 || hours.To < 0 || hours.To > 24 || hours.From == hours.To {
+			return nil, fmt.Errorf("%w: allowedHours must be a non-empty range of hours between 0 and 24", ErrInvalidPolicy)
+		}
+		location, err := time.LoadLocation(hours.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("%w: allowedHours: unknown time zone %q", ErrInvalidPolicy, hours.TimeZone)
+		}
+		hours.location = location
+	}
+	for _, client := range policy.AllowedClients {
+		if client == "" {
+			return nil, fmt.Errorf("%w: allowedClients must not contain empty identities", ErrInvalidPolicy)
+		}
+	}
+	return policy, nil
+}
+
+// Document returns the normalized JSON document of the policy, which is empty for a policy without rules.
+func (policy *Policy) Document() []byte {
+	document, _ := json.Marshal(policy)
+	if bytes.Equal(document, []byte("{}")) {
+		return nil
+	}
+	return document
+}
+
+// contains reports whether the hour range includes the given time
+func (hours *HourRange) contains(at time.Time) bool {
+	hour := at.In(hours.location).Hour()
+	if hours.From < hours.To {
+		return hour >= hours.From && hour < hours.To
+	}
+	return hour >= hours.From || hour < hours.To
+}
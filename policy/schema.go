@@ -0,0 +1,241 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// Schema is a compiled JSON schema. It supports the subset of JSON Schema (draft 2020-12) needed to
+// describe transaction data: type, enum, const, properties, required, additionalProperties, items,
+// minLength, maxLength, pattern, minimum, maximum, minItems and maxItems. Other keywords are rejected
+// rather than ignored, so a policy never silently allows more than it states.
+type Schema struct {
+	types                []string
+	enum                 []interface{}
+	constValue           *interface{}
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *bool
+	items                *Schema
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+	minimum, maximum     *float64
+	minItems, maxItems   *int
+}
+
+// schemaDocument is the JSON form of a Schema
+type schemaDocument struct {
+	Schema               string                     `json:"$schema"`
+	Title                string                     `json:"title"`
+	Description          string                     `json:"description"`
+	Type                 json.RawMessage            `json:"type"`
+	Enum                 []interface{}              `json:"enum"`
+	Const                json.RawMessage            `json:"const"`
+	Properties           map[string]json.RawMessage `json:"properties"`
+	Required             []string                   `json:"required"`
+	AdditionalProperties *bool                      `json:"additionalProperties"`
+	Items                json.RawMessage            `json:"items"`
+	MinLength            *int                       `json:"minLength"`
+	MaxLength            *int                       `json:"maxLength"`
+	Pattern              *string                    `json:"pattern"`
+	Minimum              *float64                   `json:"minimum"`
+	Maximum              *float64                   `json:"maximum"`
+	MinItems             *int                       `json:"minItems"`
+	MaxItems             *int                       `json:"maxItems"`
+}
+
+// schemaTypes lists the type names a schema may use
+var schemaTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true, "integer": true, "boolean": true, "null": true,
+}
+
+// ParseSchema compiles a JSON schema document.
+func ParseSchema(document []byte) (*Schema, error) {
+	var raw schemaDocument
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	schema := &Schema{
+		enum:                 raw.Enum,
+		required:             raw.Required,
+		additionalProperties: raw.AdditionalProperties,
+		minLength:            raw.MinLength,
+		maxLength:            raw.MaxLength,
+		minimum:              raw.Minimum,
+		maximum:              raw.Maximum,
+		minItems:             raw.MinItems,
+		maxItems:             raw.MaxItems,
+	}
+
+	if len(raw.Type) != 0 {
+		var single string
+		if err := json.Unmarshal(raw.Type, &single); err == nil {
+			schema.types = []string{single}
+		} else if err := json.Unmarshal(raw.Type, &schema.types); err != nil {
+			return nil, fmt.Errorf("type must be a string or an array of strings")
+		}
+		for _, name := range schema.types {
+			if !schemaTypes[name] {
+				return nil, fmt.Errorf("unknown type %q", name)
+			}
+		}
+	}
+	if len(raw.Const) != 0 {
+		var value interface{}
+		if err := json.Unmarshal(raw.Const, &value); err != nil {
+			return nil, err
+		}
+		schema.constValue = &value
+	}
+	if raw.Properties != nil {
+		schema.properties = make(map[string]*Schema, len(raw.Properties))
+		for name, property := range raw.Properties {
+			compiled, err := ParseSchema(property)
+			if err != nil {
+				return nil, fmt.Errorf("properties.%s: %v", name, err)
+			}
+			schema.properties[name] = compiled
+		}
+	}
+	if len(raw.Items) != 0 {
+		items, err := ParseSchema(raw.Items)
+		if err != nil {
+			return nil, fmt.Errorf("items: %v", err)
+		}
+		schema.items = items
+	}
+	if raw.Pattern != nil {
+		pattern, err := regexp.Compile(*raw.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("pattern: %v", err)
+		}
+		schema.pattern = pattern
+	}
+	return schema, nil
+}
+
+// Validate checks a value decoded by encoding/json against the schema.
+func (schema *Schema) Validate(value interface{}) error {
+	return schema.validate(value, "$")
+}
+
+// validate checks a value at the given path
+func (schema *Schema) validate(value interface{}, path string) error {
+	if len(schema.types) > 0 && !matchesAnyType(value, schema.types) {
+		return fmt.Errorf("%s must be of type %v", path, schema.types)
+	}
+	if schema.constValue != nil && !reflect.DeepEqual(value, *schema.constValue) {
+		return fmt.Errorf("%s must be %v", path, *schema.constValue)
+	}
+	if schema.enum != nil {
+		allowed := false
+		for _, candidate := range schema.enum {
+			allowed = allowed || reflect.DeepEqual(value, candidate)
+		}
+		if !allowed {
+			return fmt.Errorf("%s must be one of %v", path, schema.enum)
+		}
+	}
+
+	switch typed := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(typed)
+		if schema.minLength != nil && length < *schema.minLength {
+			return fmt.Errorf("%s must be at least %d characters long", path, *schema.minLength)
+		}
+		if schema.maxLength != nil && length > *schema.maxLength {
+			return fmt.Errorf("%s must be at most %d characters long", path, *schema.maxLength)
+		}
+		if schema.pattern != nil && !schema.pattern.MatchString(typed) {
+			return fmt.Errorf("%s must match %s", path, schema.pattern)
+		}
+	case float64:
+		if schema.minimum != nil && typed < *schema.minimum {
+			return fmt.Errorf("%s must be at least %v", path, *schema.minimum)
+		}
+		if schema.maximum != nil && typed > *schema.maximum {
+			return fmt.Errorf("%s must be at most %v", path, *schema.maximum)
+		}
+	case []interface{}:
+		if schema.minItems != nil && len(typed) < *schema.minItems {
+			return fmt.Errorf("%s must have at least %d items", path, *schema.minItems)
+		}
+		if schema.maxItems != nil && len(typed) > *schema.maxItems {
+			return fmt.Errorf("%s must have at most %d items", path, *schema.maxItems)
+		}
+		if schema.items != nil {
+			for i, item := range typed {
+				if err := schema.items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range schema.required {
+			if _, exists := typed[name]; !exists {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+		// Properties are checked in a fixed order so the reported violation is deterministic
+		names := make([]string, 0, len(typed))
+		for name := range typed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, declared := schema.properties[name]
+			if !declared {
+				if schema.additionalProperties != nil && !*schema.additionalProperties {
+					return fmt.Errorf("%s.%s is not allowed", path, name)
+				}
+				continue
+			}
+			if err := property.validate(typed[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchesAnyType reports whether a decoded JSON value has one of the given schema types
+func matchesAnyType(value interface{}, types []string) bool {
+	for _, name := range types {
+		switch typed := value.(type) {
+		case nil:
+			if name == "null" {
+				return true
+			}
+		case bool:
+			if name == "boolean" {
+				return true
+			}
+		case string:
+			if name == "string" {
+				return true
+			}
+		case float64:
+			if name == "number" || (name == "integer" && typed == math.Trunc(typed)) {
+				return true
+			}
+		case []interface{}:
+			if name == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if name == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
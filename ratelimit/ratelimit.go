@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is the rate of a token bucket: Burst requests at once, refilled by Rate requests per second
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled reports whether the limit restricts requests at all
+func (limit Limit) Enabled() bool {
+	return limit.Rate > 0 && limit.Burst > 0
+}
+
+// Decision is the outcome of taking a token from a bucket
+type Decision struct {
+	// Allowed reports whether a token was taken, i.e. whether the request may proceed
+	Allowed bool
+	// Limit is the size of the bucket
+	Limit int
+	// Remaining is the number of whole tokens left in the bucket
+	Remaining int
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time until the next token is available if the request was not allowed
+	RetryAfter time.Duration
+}
+
+// Store keeps the token buckets of a rate limiter
+type Store interface {
+	// Take takes a token from the bucket with the given key, which is created full on first use
+	Take(key string, limit Limit, now time.Time) Decision
+}
+
+// idleBucketSweepInterval is how often a MemoryStore drops buckets that have refilled completely
+const idleBucketSweepInterval = time.Minute
+
+// MemoryStore keeps token buckets in memory. It is only suitable for a single node, as the buckets are
+// not shared between processes.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	sweptAt time.Time
+}
+
+// bucket is the state of a token bucket
+type bucket struct {
+	limit     Limit
+	tokens    float64
+	updatedAt time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+// Take takes a token from the bucket with the given key
+func (store *MemoryStore) Take(key string, limit Limit, now time.Time) Decision {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if now.Sub(store.sweptAt) >= idleBucketSweepInterval {
+		store.sweep(now)
+	}
+
+	b, exists := store.buckets[key]
+	if !exists || b.limit != limit {
+		b = &bucket{limit: limit, tokens: float64(limit.Burst), updatedAt: now}
+		store.buckets[key] = b
+	}
+	b.refill(now)
+
+	decision := Decision{Limit: limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+	decision.Remaining = int(math.Floor(b.tokens))
+	decision.Reset = seconds((float64(limit.Burst) - b.tokens) / limit.Rate)
+	return decision
+}
+
+// sweep drops the buckets that are full, which behave the same as new ones; callers hold the lock
+func (store *MemoryStore) sweep(now time.Time) {
+	for key, b := range store.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(store.buckets, key)
+		}
+	}
+	store.sweptAt = now
+}
+
+// refill adds the tokens accumulated since the last update
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updatedAt).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+		b.updatedAt = now
+	}
+}
+
+// seconds converts a number of seconds to a duration
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
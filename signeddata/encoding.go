@@ -0,0 +1,120 @@
+package signeddata
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"strconv"
+)
+
+// Supported encodings of the transaction data sent by the client.
+const (
+	EncodingUTF8   = "utf8"
+	EncodingBase64 = "base64"
+	EncodingHex    = "hex"
+)
+
+// Supported signing modes.
+const (
+	// ModeData signs the transaction data itself.
+	ModeData = "data"
+	// ModeDigest signs a SHA-256 digest pre-computed by the client.
+	ModeDigest = "digest"
+)
+
+// Errors returned for transaction data that cannot be decoded or signed.
+var (
+	ErrInvalidEncoding = domain.NewError(domain.KindInvalid, "invalid_data_encoding", "invalid data encoding")
+	ErrMalformedData   = domain.NewError(domain.KindInvalid, "malformed_data", "data does not match its encoding")
+	ErrInvalidMode     = domain.NewError(domain.KindInvalid, "invalid_mode", "invalid mode")
+	ErrInvalidDigest   = domain.NewError(domain.KindInvalid, "invalid_digest", "digest must be a SHA-256 hash of 32 bytes")
+)
+
+// DecodePayload decodes the transaction data according to the given encoding.
+// An empty encoding is treated as utf8.
+func DecodePayload(data, encoding string) ([]byte, error) {
+	switch encoding {
+	case "", EncodingUTF8:
+		return []byte(data), nil
+	case EncodingBase64:
+		payload, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, ErrMalformedData
+		}
+		return payload, nil
+	case EncodingHex:
+		payload, err := hex.DecodeString(data)
+		if err != nil {
+			return nil, ErrMalformedData
+		}
+		return payload, nil
+	default:
+		return nil, ErrInvalidEncoding
+	}
+}
+
+// ValidateMode checks the signing mode and, for digest mode, the decoded digest length.
+// An empty mode is treated as data.
+func ValidateMode(mode string, payload []byte) error {
+	switch mode {
+	case "", ModeData:
+		return nil
+	case ModeDigest:
+		if len(payload) != sha256.Size {
+			return ErrInvalidDigest
+		}
+		return nil
+	default:
+		return ErrInvalidMode
+	}
+}
+
+// EncodeLengthPrefixed builds the unambiguous signed-data representation.
+// Every field is written as a 4-byte big-endian length followed by its bytes, in the order:
+// signature counter (decimal), mode, payload, chain reference (base64 last signature or device ID).
+func EncodeLengthPrefixed(counter uint64, mode string, payload []byte, chainReference string) []byte {
+	if mode == "" {
+		mode = ModeData
+	}
+
+	fields := [][]byte{
+		[]byte(strconv.FormatUint(counter, 10)),
+		[]byte(mode),
+		payload,
+		[]byte(chainReference),
+	}
+
+	size := 0
+	for _, field := range fields {
+		size += 4 + len(field)
+	}
+
+	encoded := make([]byte, 0, size)
+	for _, field := range fields {
+		encoded = binary.BigEndian.AppendUint32(encoded, uint32(len(field)))
+		encoded = append(encoded, field...)
+	}
+	return encoded
+}
+
+// DecodeLengthPrefixed splits a length-prefixed signed-data value back into its fields.
+// It is the inverse of EncodeLengthPrefixed and is meant for verifiers.
+func DecodeLengthPrefixed(encoded []byte) ([][]byte, error) {
+	var fields [][]byte
+	for len(encoded) > 0 {
+		if len(encoded) < 4 {
+			return nil, errors.New("truncated length prefix")
+		}
+		length := binary.BigEndian.Uint32(encoded)
+		encoded = encoded[4:]
+		if uint64(len(encoded)) < uint64(length) {
+			return nil, errors.New("truncated field")
+		}
+		fields = append(fields, encoded[:length])
+		encoded = encoded[length:]
+	}
+	return fields, nil
+}
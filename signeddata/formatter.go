@@ -0,0 +1,122 @@
+package signeddata
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Versions of the signed-data formats.
+const (
+	FormatV1 = "v1"
+	FormatV2 = "v2"
+)
+
+// DefaultFormat is used for devices that do not choose a format.
+const DefaultFormat = FormatV1
+
+// Input holds everything a formatter may include in the signed data.
+type Input struct {
+	DeviceID       string
+	Counter        uint64
+	Data           string    // Data as sent by the client
+	DataEncoding   string    // Encoding of Data, empty if not given
+	Mode           string    // Signing mode, empty if not given
+	Payload        []byte    // Decoded Data
+	ChainReference string    // Last signature, or the base64 encoded device ID for the first transaction
+	Timestamp      time.Time // Time of signing
+}
+
+// SignedDataFormatter builds the bytes that get signed for a transaction.
+type SignedDataFormatter interface {
+	Version() string
+	// Format returns the signed data and the encoding it has to be returned in (utf8 or base64).
+	Format(input Input) ([]byte, string, error)
+}
+
+// FormatterFactory is a factory for signed-data formatters based on the format version.
+type FormatterFactory struct{}
+
+// NewFormatterFactory creates a new FormatterFactory.
+func NewFormatterFactory() *FormatterFactory {
+	return &FormatterFactory{}
+}
+
+// GetFormatter returns the formatter for the given version. An empty version selects DefaultFormat.
+func (f *FormatterFactory) GetFormatter(version string) (SignedDataFormatter, error) {
+	switch version {
+	case "", FormatV1:
+		return &V1Formatter{}, nil
+	case FormatV2:
+		return &V2Formatter{}, nil
+	default:
+		return nil, errors.New("unsupported signed data format")
+	}
+}
+
+// V1Formatter produces "<counter>_<data>_<chain reference>" for plain text requests
+// and the length-prefixed encoding for binary data and digests.
+type V1Formatter struct{}
+
+// Version returns the format version.
+func (f *V1Formatter) Version() string {
+	return FormatV1
+}
+
+// Format builds the v1 signed data.
+func (f *V1Formatter) Format(input Input) ([]byte, string, error) {
+	if input.DataEncoding == "" && input.Mode == "" {
+		return []byte(fmt.Sprintf("%d_%s_%s", input.Counter, input.Data, input.ChainReference)), EncodingUTF8, nil
+	}
+	return EncodeLengthPrefixed(input.Counter, input.Mode, input.Payload, input.ChainReference), EncodingBase64, nil
+}
+
+// V2Formatter produces a canonical JSON object with sorted keys and no insignificant whitespace.
+type V2Formatter struct{}
+
+// v2SignedData is the v2 structure. Fields are declared in lexicographic order of their JSON names
+// so that encoding/json writes the keys sorted.
+type v2SignedData struct {
+	Counter           uint64 `json:"counter"`
+	Data              string `json:"data"`
+	DeviceID          string `json:"deviceId"`
+	Mode              string `json:"mode"`
+	PreviousSignature string `json:"previousSignature"`
+	Timestamp         string `json:"timestamp"`
+	Version           string `json:"version"`
+}
+
+// Version returns the format version.
+func (f *V2Formatter) Version() string {
+	return FormatV2
+}
+
+// Format builds the v2 signed data. The payload is always base64 encoded so binary data stays unambiguous.
+func (f *V2Formatter) Format(input Input) ([]byte, string, error) {
+	mode := input.Mode
+	if mode == "" {
+		mode = ModeData
+	}
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(v2SignedData{
+		Counter:           input.Counter,
+		Data:              base64.StdEncoding.EncodeToString(input.Payload),
+		DeviceID:          input.DeviceID,
+		Mode:              mode,
+		PreviousSignature: input.ChainReference,
+		Timestamp:         input.Timestamp.UTC().Format(time.RFC3339Nano),
+		Version:           FormatV2,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Drop the trailing newline written by the encoder
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), EncodingUTF8, nil
+}
@@ -0,0 +1,226 @@
+package signeddata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Errors returned for JSON payloads.
+var (
+	ErrInvalidJSON      = domain.NewError(domain.KindInvalid, "invalid_json_payload", "payload is not valid I-JSON")
+	ErrNotJSONObject    = domain.NewError(domain.KindInvalid, "payload_not_object", "payload must be a JSON object")
+	ErrAmbiguousPayload = domain.NewError(domain.KindInvalid, "ambiguous_payload", "either data or payload must be given, not both")
+	ErrPayloadEncoding  = domain.NewError(domain.KindInvalid, "payload_encoding", "dataEncoding and digest mode cannot be used with a JSON payload")
+)
+
+// CanonicalizeJSON returns the JSON Canonicalization Scheme (RFC 8785) form of a JSON text:
+// object members sorted by their UTF-16 code units, no insignificant whitespace,
+// minimal string escaping and numbers serialized like ECMAScript does.
+func CanonicalizeJSON(data []byte) ([]byte, error) {
+	if !utf8.Valid(data) {
+		return nil, ErrInvalidJSON
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	value, err := parseJSONValue(decoder)
+	if err != nil {
+		return nil, err
+	}
+	// Only a single JSON value is allowed
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, ErrInvalidJSON
+	}
+
+	var buffer bytes.Buffer
+	if err := writeCanonical(&buffer, value); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// jsonMember is a single member of a JSON object
+type jsonMember struct {
+	key   string
+	value interface{}
+}
+
+// parseJSONValue reads the next value from the decoder. Objects are returned as []jsonMember
+// so duplicate member names can be detected, which encoding/json would silently merge.
+func parseJSONValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, ErrInvalidJSON
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		switch token {
+		case '{':
+			var members []jsonMember
+			seen := make(map[string]bool)
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return nil, ErrInvalidJSON
+				}
+				key, ok := keyToken.(string)
+				if !ok || seen[key] {
+					return nil, ErrInvalidJSON
+				}
+				seen[key] = true
+
+				value, err := parseJSONValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				members = append(members, jsonMember{key: key, value: value})
+			}
+			if _, err := decoder.Token(); err != nil {
+				return nil, ErrInvalidJSON
+			}
+			return members, nil
+		case '[':
+			elements := []interface{}{}
+			for decoder.More() {
+				value, err := parseJSONValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, value)
+			}
+			if _, err := decoder.Token(); err != nil {
+				return nil, ErrInvalidJSON
+			}
+			return elements, nil
+		}
+		return nil, ErrInvalidJSON
+	default:
+		return token, nil
+	}
+}
+
+// writeCanonical writes a parsed JSON value in canonical form
+func writeCanonical(buffer *bytes.Buffer, value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+		buffer.WriteString("null")
+	case bool:
+		buffer.WriteString(strconv.FormatBool(value))
+	case string:
+		writeCanonicalString(buffer, value)
+	case json.Number:
+		number, err := formatCanonicalNumber(value)
+		if err != nil {
+			return err
+		}
+		buffer.WriteString(number)
+	case []interface{}:
+		buffer.WriteByte('[')
+		for i, element := range value {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			if err := writeCanonical(buffer, element); err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte(']')
+	case []jsonMember:
+		// Members are sorted by the UTF-16 code units of their names
+		sort.Slice(value, func(i, j int) bool {
+			return lessUTF16(value[i].key, value[j].key)
+		})
+		buffer.WriteByte('{')
+		for i, member := range value {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			writeCanonicalString(buffer, member.key)
+			buffer.WriteByte(':')
+			if err := writeCanonical(buffer, member.value); err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte('}')
+	default:
+		return ErrInvalidJSON
+	}
+	return nil
+}
+
+// writeCanonicalString escapes only what JSON requires, using the short forms where they exist
+func writeCanonicalString(buffer *bytes.Buffer, value string) {
+	buffer.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"':
+			buffer.WriteString(`\"`)
+		case '\\':
+			buffer.WriteString(`\\`)
+		case '\b':
+			buffer.WriteString(`\b`)
+		case '\f':
+			buffer.WriteString(`\f`)
+		case '\n':
+			buffer.WriteString(`\n`)
+		case '\r':
+			buffer.WriteString(`\r`)
+		case '\t':
+			buffer.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buffer, `\u%04x`, r)
+			} else {
+				buffer.WriteRune(r)
+			}
+		}
+	}
+	buffer.WriteByte('"')
+}
+
+// formatCanonicalNumber serializes a number like ECMAScript's Number.prototype.toString
+func formatCanonicalNumber(number json.Number) (string, error) {
+	value, err := strconv.ParseFloat(string(number), 64)
+	if err != nil || math.IsInf(value, 0) || math.IsNaN(value) {
+		return "", ErrInvalidJSON
+	}
+	if value == 0 {
+		// Also covers negative zero
+		return "0", nil
+	}
+
+	absolute := math.Abs(value)
+	if absolute >= 1e-6 && absolute < 1e21 {
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	}
+
+	// Exponential notation without the leading zeros Go adds to the exponent
+	formatted := strconv.FormatFloat(value, 'e', -1, 64)
+	mantissa, exponent, _ := strings.Cut(formatted, "e")
+	sign := exponent[:1]
+	exponent = strings.TrimLeft(exponent[1:], "0")
+	return mantissa + "e" + sign + exponent, nil
+}
+
+// lessUTF16 compares two strings by their UTF-16 code units
+func lessUTF16(a, b string) bool {
+	unitsA := utf16.Encode([]rune(a))
+	unitsB := utf16.Encode([]rune(b))
+	for i := 0; i < len(unitsA) && i < len(unitsB); i++ {
+		if unitsA[i] != unitsB[i] {
+			return unitsA[i] < unitsB[i]
+		}
+	}
+	return len(unitsA) < len(unitsB)
+}
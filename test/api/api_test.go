@@ -2,14 +2,28 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/api"
 	_ "github.com/fiskaly/coding-challenges/signing-service-challenge/api"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/jwtauth"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/request"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/response"
-	_ "github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ratelimit"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Setup a new server for testing
@@ -17,6 +31,30 @@ func setup() *api.Server {
 	return api.NewServer(":8080")
 }
 
+// testKeys issues the API keys of the tests; the server under test keeps its own keys
+var testKeys = api.NewAPIKeyService(persistence.NewInMemoryDeviceRepository())
+
+// newAPIKey creates an API key of a tenant, the default tenant if empty, and returns its secret
+func newAPIKey(t *testing.T, tenantID, clientID string, scopes ...string) string {
+	ctx := context.Background()
+	t.Helper()
+	created, err := testKeys.CreateAPIKey(ctx, &request.APIKeyRequest{TenantID: tenantID, ClientID: clientID, Scopes: scopes})
+	if err != nil {
+		t.Fatalf("failed to create api key: %v", err)
+	}
+	return created.Key
+}
+
+// withScope wraps a handler so it requires an API key issued by testKeys with the given scope
+func withScope(scope domain.Scope, handler http.HandlerFunc) http.Handler {
+	return withScopeHandler(scope, handler)
+}
+
+// withScopeHandler is withScope for handlers wrapped by middleware
+func withScopeHandler(scope domain.Scope, handler http.Handler) http.Handler {
+	return api.RequireScope(api.NewAPIKeyAuthenticator(testKeys), scope, handler)
+}
+
 // TestCreateSignatureDeviceHandler tests the CreateSignatureDeviceHandler function
 func TestCreateSignatureDeviceHandler(t *testing.T) {
 	// Initialize the server and test recorder
@@ -178,3 +216,791 @@ func TestGetSignatureDeviceByIdHandler(t *testing.T) {
 		t.Errorf("unexpected device ID: got %v want %v", res.ID, "123e4567-e89b-12d3-a456-426614174000")
 	}
 }
+
+// TestCanonicalizeHandler tests the CanonicalizeHandler function
+func TestCanonicalizeHandler(t *testing.T) {
+	server := setup()
+
+	req := httptest.NewRequest("POST", "/api/v0/canonicalize", bytes.NewBufferString(`{ "b": [1.0, "x"], "a": {"d": null, "c": true} }`))
+	recorder := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(server.CanonicalizeHandler)
+	handler.ServeHTTP(recorder, req)
+
+	if status := recorder.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if body := recorder.Body.String(); body != `{"a":{"c":true,"d":null},"b":[1,"x"]}` {
+		t.Errorf("unexpected canonical JSON: got %v", body)
+	}
+
+	// Duplicate member names are rejected
+	req = httptest.NewRequest("POST", "/api/v0/canonicalize", bytes.NewBufferString(`{"a":1,"a":2}`))
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if status := recorder.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+// TestSignTransactionHandlerIdempotencyKey tests that retries with the same Idempotency-Key header are replayed
+func TestSignTransactionHandlerIdempotencyKey(t *testing.T) {
+	server := setup()
+	deviceID := uuid.New().String()
+
+	createReq := httptest.NewRequest("POST", "/api/v0/create-signature-device", bytes.NewBufferString(`{"id": "`+deviceID+`", "algorithm": "ECC"}`))
+	createRecorder := httptest.NewRecorder()
+	http.HandlerFunc(server.CreateSignatureDeviceHandler).ServeHTTP(createRecorder, createReq)
+
+	sign := func(body string) *httptest.ResponseRecorder {
+		signReq := httptest.NewRequest("POST", "/api/v0/sign-transaction", bytes.NewBufferString(body))
+		signReq.Header.Set("Idempotency-Key", "retry-"+deviceID)
+		signRecorder := httptest.NewRecorder()
+		http.HandlerFunc(server.SignTransactionHandler).ServeHTTP(signRecorder, signReq)
+		return signRecorder
+	}
+
+	first := sign(`{"deviceId": "` + deviceID + `", "data": "sample-transaction-data"}`)
+	replay := sign(`{"deviceId": "` + deviceID + `", "data": "sample-transaction-data"}`)
+	if first.Code != http.StatusOK || replay.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status codes: got %v and %v want %v", first.Code, replay.Code, http.StatusOK)
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" || replay.Body.String() != first.Body.String() {
+		t.Errorf("expected the original response to be replayed")
+	}
+
+	conflict := sign(`{"deviceId": "` + deviceID + `", "data": "other-data"}`)
+	if conflict.Code != http.StatusUnprocessableEntity {
+		t.Errorf("handler returned wrong status code: got %v want %v", conflict.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+// TestChangeDeviceStatusHandler tests the ChangeDeviceStatusHandler and GetDeviceStatusHandler functions
+func TestChangeDeviceStatusHandler(t *testing.T) {
+	server := setup()
+	deviceID := uuid.New().String()
+
+	createReq := httptest.NewRequest("POST", "/api/v0/create-signature-device", bytes.NewBufferString(`{"id": "`+deviceID+`", "algorithm": "ECC"}`))
+	http.HandlerFunc(server.CreateSignatureDeviceHandler).ServeHTTP(httptest.NewRecorder(), createReq)
+
+	changeStatus := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v0/devices/"+deviceID+"/status", bytes.NewBufferString(body))
+		req.SetPathValue("id", deviceID)
+		recorder := httptest.NewRecorder()
+		http.HandlerFunc(server.ChangeDeviceStatusHandler).ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if recorder := changeStatus(`{"action": "suspend", "reason": "register lost"}`); recorder.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusOK)
+	}
+	if recorder := changeStatus(`{"action": "suspend"}`); recorder.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusConflict)
+	}
+	if recorder := changeStatus(`{"action": "explode"}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusBadRequest)
+	}
+
+	// Suspended devices cannot sign
+	signReq := httptest.NewRequest("POST", "/api/v0/sign-transaction", bytes.NewBufferString(`{"deviceId": "`+deviceID+`", "data": "sample"}`))
+	signRecorder := httptest.NewRecorder()
+	http.HandlerFunc(server.SignTransactionHandler).ServeHTTP(signRecorder, signReq)
+	if signRecorder.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", signRecorder.Code, http.StatusConflict)
+	}
+
+	getReq := httptest.NewRequest("GET", "/api/v0/devices/"+deviceID+"/status", nil)
+	getReq.SetPathValue("id", deviceID)
+	getRecorder := httptest.NewRecorder()
+	http.HandlerFunc(server.GetDeviceStatusHandler).ServeHTTP(getRecorder, getReq)
+
+	var res response.DeviceStatusResponse
+	if err := json.Unmarshal(getRecorder.Body.Bytes(), &res); err != nil {
+		t.Errorf("unexpected error in response unmarshalling: %v", err)
+	}
+	if res.Status != "suspended" || len(res.History) != 1 || res.History[0].Reason != "register lost" {
+		t.Errorf("unexpected status response: %+v", res)
+	}
+}
+
+// TestUpdateSignatureDeviceHandler tests the UpdateSignatureDeviceHandler function
+func TestUpdateSignatureDeviceHandler(t *testing.T) {
+	server := setup()
+	deviceID := uuid.New().String()
+
+	createReq := httptest.NewRequest("POST", "/api/v0/create-signature-device", bytes.NewBufferString(`{"id": "`+deviceID+`", "algorithm": "ECC", "label": "old"}`))
+	http.HandlerFunc(server.CreateSignatureDeviceHandler).ServeHTTP(httptest.NewRecorder(), createReq)
+
+	req := httptest.NewRequest("PATCH", "/api/v0/devices/"+deviceID, bytes.NewBufferString(`{"label": "new", "metadata": {"store": "Berlin"}}`))
+	req.SetPathValue("id", deviceID)
+	recorder := httptest.NewRecorder()
+	http.HandlerFunc(server.UpdateSignatureDeviceHandler).ServeHTTP(recorder, req)
+
+	if status := recorder.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var res response.DeviceResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &res); err != nil {
+		t.Errorf("unexpected error in response unmarshalling: %v", err)
+	}
+	if res.Label != "new" || res.Metadata["store"] != "Berlin" {
+		t.Errorf("unexpected device response: %+v", res)
+	}
+
+	missingID := uuid.New().String()
+	req = httptest.NewRequest("PATCH", "/api/v0/devices/"+missingID, bytes.NewBufferString(`{"label": "new"}`))
+	req.SetPathValue("id", missingID)
+	recorder = httptest.NewRecorder()
+	http.HandlerFunc(server.UpdateSignatureDeviceHandler).ServeHTTP(recorder, req)
+	if status := recorder.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+// TestDeleteSignatureDeviceHandler tests the DeleteSignatureDeviceHandler function
+func TestDeleteSignatureDeviceHandler(t *testing.T) {
+	server := setup()
+	deviceID := uuid.New().String()
+
+	createReq := httptest.NewRequest("POST", "/api/v0/create-signature-device", bytes.NewBufferString(`{"id": "`+deviceID+`", "algorithm": "ECC"}`))
+	http.HandlerFunc(server.CreateSignatureDeviceHandler).ServeHTTP(httptest.NewRecorder(), createReq)
+
+	deleteDevice := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", "/api/v0/devices/"+id, nil)
+		req.SetPathValue("id", id)
+		recorder := httptest.NewRecorder()
+		http.HandlerFunc(server.DeleteSignatureDeviceHandler).ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := deleteDevice(deviceID)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusOK)
+	}
+	var res response.DeviceResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &res); err != nil {
+		t.Errorf("unexpected error in response unmarshalling: %v", err)
+	}
+	if res.DeletedAt == nil || res.PublicKey == "" {
+		t.Errorf("unexpected tombstone: %+v", res)
+	}
+
+	if recorder := deleteDevice(deviceID); recorder.Code != http.StatusGone {
+		t.Errorf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusGone)
+	}
+	if recorder := deleteDevice(uuid.New().String()); recorder.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusNotFound)
+	}
+
+	// Deleted devices cannot sign
+	signReq := httptest.NewRequest("POST", "/api/v0/sign-transaction", bytes.NewBufferString(`{"deviceId": "`+deviceID+`", "data": "sample"}`))
+	signRecorder := httptest.NewRecorder()
+	http.HandlerFunc(server.SignTransactionHandler).ServeHTTP(signRecorder, signReq)
+	if signRecorder.Code != http.StatusGone {
+		t.Errorf("handler returned wrong status code: got %v want %v", signRecorder.Code, http.StatusGone)
+	}
+
+	// The tombstone is only listed with includeDeleted=true
+	for query, wantListed := range map[string]bool{"": false, "?includeDeleted=true": true} {
+		listRecorder := httptest.NewRecorder()
+		http.HandlerFunc(server.ListSignatureDevicesHandler).ServeHTTP(listRecorder, httptest.NewRequest("GET", "/api/v0/devices"+query, nil))
+		var devices []response.DeviceResponse
+		if err := json.Unmarshal(listRecorder.Body.Bytes(), &devices); err != nil {
+			t.Errorf("unexpected error in response unmarshalling: %v", err)
+		}
+		listed := false
+		for _, device := range devices {
+			listed = listed || device.ID == deviceID
+		}
+		if listed != wantListed {
+			t.Errorf("list%v: expected deleted device listed %v, but got %v", query, wantListed, listed)
+		}
+	}
+}
+
+// TestListSignatureDevicesHandlerPagination tests the paging and query parameters of the ListSignatureDevicesHandler function
+func TestListSignatureDevicesHandlerPagination(t *testing.T) {
+	server := setup()
+	prefix := uuid.New().String()
+
+	for i := 0; i < 3; i++ {
+		createReq := httptest.NewRequest("POST", "/api/v0/create-signature-device", bytes.NewBufferString(`{"id": "`+uuid.New().String()+`", "algorithm": "ECC", "label": "`+prefix+`"}`))
+		http.HandlerFunc(server.CreateSignatureDeviceHandler).ServeHTTP(httptest.NewRecorder(), createReq)
+	}
+
+	list := func(query string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		http.HandlerFunc(server.ListSignatureDevicesHandler).ServeHTTP(recorder, httptest.NewRequest("GET", "/api/v0/devices?"+query, nil))
+		return recorder
+	}
+
+	seen := 0
+	cursor := ""
+	for pages := 0; pages < 3; pages++ {
+		recorder := list("labelPrefix=" + prefix + "&limit=2&cursor=" + cursor)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusOK)
+		}
+		var res []response.DeviceResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &res); err != nil {
+			t.Errorf("unexpected error in response unmarshalling: %v", err)
+		}
+		seen += len(res)
+		cursor = recorder.Header().Get("X-Next-Cursor")
+		if cursor == "" {
+			break
+		}
+	}
+	if seen != 3 {
+		t.Errorf("expected to page through 3 devices, but got %d", seen)
+	}
+
+	for _, query := range []string{"limit=0", "createdFrom=yesterday", "order=sideways", "cursor=garbage"} {
+		if recorder := list(query); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%v: handler returned wrong status code: got %v want %v", query, recorder.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// TestCreateSignatureDeviceHandlerWithoutID tests that the CreateSignatureDeviceHandler generates missing IDs
+func TestCreateSignatureDeviceHandlerWithoutID(t *testing.T) {
+	server := setup()
+
+	req := httptest.NewRequest("POST", "/api/v0/create-signature-device", bytes.NewBufferString(`{"algorithm": "ECC", "label": "generated"}`))
+	recorder := httptest.NewRecorder()
+	http.HandlerFunc(server.CreateSignatureDeviceHandler).ServeHTTP(recorder, req)
+
+	if status := recorder.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var res response.DeviceResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &res); err != nil {
+		t.Errorf("unexpected error in response unmarshalling: %v", err)
+	}
+	if _, err := uuid.Parse(res.ID); err != nil || res.CreatedAt.IsZero() || res.UpdatedAt.IsZero() {
+		t.Errorf("expected a generated ID and timestamps, but got %+v", res)
+	}
+}
+
+// TestSignTransactionHandlerQuota tests the status codes of the SignTransactionHandler function for exhausted quotas
+func TestSignTransactionHandlerQuota(t *testing.T) {
+	server := setup()
+
+	for body, want := range map[string]int{
+		`{"maxSignatures": 1}`: http.StatusForbidden,
+		`{"dailyLimit": 1}`:    http.StatusTooManyRequests,
+	} {
+		deviceID := uuid.New().String()
+		createReq := httptest.NewRequest("POST", "/api/v0/create-signature-device", bytes.NewBufferString(`{"id": "`+deviceID+`", "algorithm": "ECC", "limits": `+body+`}`))
+		http.HandlerFunc(server.CreateSignatureDeviceHandler).ServeHTTP(httptest.NewRecorder(), createReq)
+
+		var recorder *httptest.ResponseRecorder
+		for i := 0; i < 2; i++ {
+			signReq := httptest.NewRequest("POST", "/api/v0/sign-transaction", bytes.NewBufferString(`{"deviceId": "`+deviceID+`", "data": "sample"}`))
+			recorder = httptest.NewRecorder()
+			http.HandlerFunc(server.SignTransactionHandler).ServeHTTP(recorder, signReq)
+		}
+		if recorder.Code != want {
+			t.Errorf("%v: handler returned wrong status code: got %v want %v", body, recorder.Code, want)
+		}
+		if want == http.StatusTooManyRequests && recorder.Header().Get("Retry-After") == "" {
+			t.Errorf("expected a Retry-After header")
+		}
+	}
+}
+
+func TestDevicePolicyHandlers(t *testing.T) {
+	server := setup()
+	admin := newAPIKey(t, "", "admin", "devices:read", "devices:write")
+	register := newAPIKey(t, "", "pos-1", "sign")
+	otherRegister := newAPIKey(t, "", "pos-2", "sign")
+
+	deviceID := uuid.New().String()
+	createReq := httptest.NewRequest("POST", "/api/v0/create-signature-device", bytes.NewBufferString(`{"id": "`+deviceID+`", "algorithm": "ECC"}`))
+	createReq.Header.Set("Authorization", "Bearer "+admin)
+	withScope(domain.ScopeDevicesWrite, server.CreateSignatureDeviceHandler).ServeHTTP(httptest.NewRecorder(), createReq)
+
+	for body, want := range map[string]int{
+		`{"policy": {"maxPayloadSize": 4, "allowedClients": ["pos-1"]}}`: http.StatusOK,
+		`{"policy": {"dataPattern": "("}}`:                               http.StatusBadRequest,
+	} {
+		policyReq := httptest.NewRequest("PUT", "/api/v0/devices/"+deviceID+"/policy", bytes.NewBufferString(body))
+		policyReq.SetPathValue("id", deviceID)
+		policyReq.Header.Set("Authorization", "Bearer "+admin)
+		recorder := httptest.NewRecorder()
+		withScope(domain.ScopeDevicesWrite, server.SetDevicePolicyHandler).ServeHTTP(recorder, policyReq)
+		if recorder.Code != want {
+			t.Errorf("%v: handler returned wrong status code: got %v want %v", body, recorder.Code, want)
+		}
+	}
+
+	// Violations are reported rule by rule
+	signReq := httptest.NewRequest("POST", "/api/v0/sign-transaction", bytes.NewBufferString(`{"deviceId": "`+deviceID+`", "data": "sample"}`))
+	signReq.Header.Set("Authorization", "Bearer "+otherRegister)
+	recorder := httptest.NewRecorder()
+	withScope(domain.ScopeSign, server.SignTransactionHandler).ServeHTTP(recorder, signReq)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusForbidden)
+	}
+	var violationResponse api.ErrorResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&violationResponse); err != nil || violationResponse.Code != "policy_violation" || len(violationResponse.Violations) != 2 {
+		t.Errorf("unexpected policy violation response: %+v, %v", violationResponse, err)
+	}
+
+	signReq = httptest.NewRequest("POST", "/api/v0/sign-transaction", bytes.NewBufferString(`{"deviceId": "`+deviceID+`", "data": "ok"}`))
+	signReq.Header.Set("Authorization", "Bearer "+register)
+	recorder = httptest.NewRecorder()
+	withScope(domain.ScopeSign, server.SignTransactionHandler).ServeHTTP(recorder, signReq)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusOK)
+	}
+
+	getReq := httptest.NewRequest("GET", "/api/v0/devices/"+deviceID+"/policy", nil)
+	getReq.SetPathValue("id", deviceID)
+	getReq.Header.Set("Authorization", "Bearer "+admin)
+	recorder = httptest.NewRecorder()
+	withScope(domain.ScopeDevicesRead, server.GetDevicePolicyHandler).ServeHTTP(recorder, getReq)
+	var policyResponse response.DevicePolicyResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&policyResponse); err != nil {
+		t.Fatalf("failed to decode policy response: %v", err)
+	}
+	if len(policyResponse.History) != 1 || policyResponse.History[0].ChangedBy != "admin" {
+		t.Errorf("unexpected policy history: %+v", policyResponse.History)
+	}
+}
+
+func TestTenantIsolationHandlers(t *testing.T) {
+	server := setup()
+	tenant := "merchant-" + uuid.New().String()
+	owner := newAPIKey(t, tenant, "", "devices:read", "devices:write", "sign")
+	other := newAPIKey(t, "merchant-other", "", "devices:read", "devices:write", "sign")
+	defaultTenant := newAPIKey(t, "", "", "devices:read", "devices:write", "sign")
+
+	deviceID := uuid.New().String()
+	createReq := httptest.NewRequest("POST", "/api/v0/create-signature-device", bytes.NewBufferString(`{"id": "`+deviceID+`", "algorithm": "ECC"}`))
+	createReq.Header.Set("Authorization", "Bearer "+owner)
+	recorder := httptest.NewRecorder()
+	withScope(domain.ScopeDevicesWrite, server.CreateSignatureDeviceHandler).ServeHTTP(recorder, createReq)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusOK)
+	}
+
+	for key, want := range map[string]int{owner: http.StatusOK, other: http.StatusNotFound, defaultTenant: http.StatusNotFound} {
+		getReq := httptest.NewRequest("GET", "/api/v0/device?id="+deviceID, nil)
+		getReq.Header.Set("Authorization", "Bearer "+key)
+		recorder = httptest.NewRecorder()
+		withScope(domain.ScopeDevicesRead, server.GetSignatureDeviceByIdHandler).ServeHTTP(recorder, getReq)
+		if recorder.Code != want {
+			t.Errorf("get: handler returned wrong status code: got %v want %v", recorder.Code, want)
+		}
+
+		signReq := httptest.NewRequest("POST", "/api/v0/sign-transaction", bytes.NewBufferString(`{"deviceId": "`+deviceID+`", "data": "sample"}`))
+		signReq.Header.Set("Authorization", "Bearer "+key)
+		recorder = httptest.NewRecorder()
+		withScope(domain.ScopeSign, server.SignTransactionHandler).ServeHTTP(recorder, signReq)
+		if recorder.Code != want {
+			t.Errorf("sign: handler returned wrong status code: got %v want %v", recorder.Code, want)
+		}
+	}
+
+	listReq := httptest.NewRequest("GET", "/api/v0/devices", nil)
+	listReq.Header.Set("Authorization", "Bearer "+owner)
+	recorder = httptest.NewRecorder()
+	withScope(domain.ScopeDevicesRead, server.ListSignatureDevicesHandler).ServeHTTP(recorder, listReq)
+	var devices []response.DeviceResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&devices); err != nil || len(devices) != 1 || devices[0].ID != deviceID {
+		t.Errorf("expected only the device of the tenant, got %+v (%v)", devices, err)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	server := setup()
+	reader := newAPIKey(t, "", "", "devices:read")
+
+	for name, test := range map[string]struct {
+		header string
+		want   int
+	}{
+		"missing key":   {"", http.StatusUnauthorized},
+		"unknown key":   {"Bearer ssk_unknown", http.StatusUnauthorized},
+		"missing scope": {"Bearer " + reader, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("POST", "/api/v0/create-signature-device", bytes.NewBufferString(`{"algorithm": "ECC"}`))
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+		recorder := httptest.NewRecorder()
+		withScope(domain.ScopeDevicesWrite, server.CreateSignatureDeviceHandler).ServeHTTP(recorder, req)
+		if recorder.Code != test.want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", name, recorder.Code, test.want)
+		}
+		if test.want == http.StatusUnauthorized && recorder.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected a WWW-Authenticate header", name)
+		}
+	}
+
+	// The X-API-Key header is accepted as well
+	req := httptest.NewRequest("GET", "/api/v0/devices", nil)
+	req.Header.Set("X-API-Key", reader)
+	recorder := httptest.NewRecorder()
+	withScope(domain.ScopeDevicesRead, server.ListSignatureDevicesHandler).ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusOK)
+	}
+
+	// All routes but the health check require an API key
+	for _, route := range []string{"/api/v0/devices", "/api/v0/sign-transaction", "/api/v0/api-keys"} {
+		recorder = httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", route, nil))
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", route, recorder.Code, http.StatusUnauthorized)
+		}
+	}
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/api/v0/health", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("health: handler returned wrong status code: got %v want %v", recorder.Code, http.StatusOK)
+	}
+}
+
+// unavailableKeyRepository fails every API key lookup like a database that can't be reached
+type unavailableKeyRepository struct {
+	persistence.DeviceRepository
+}
+
+func (unavailableKeyRepository) GetAPIKeyByHash(context.Context, string) (*domain.APIKey, error) {
+	return nil, errors.New("unable to open database file")
+}
+
+// TestRequireScopeWithUnavailableKeyStore tests that failing to look up an API key is reported as a server
+// error rather than as invalid credentials
+func TestRequireScopeWithUnavailableKeyStore(t *testing.T) {
+	server := setup()
+	keys := api.NewAPIKeyService(unavailableKeyRepository{persistence.NewInMemoryDeviceRepository()})
+	authenticators := map[string]api.Authenticator{
+		"api key": api.NewAPIKeyAuthenticator(keys),
+		"chain":   api.NewChainAuthenticator(api.NewAPIKeyAuthenticator(keys), api.NewAPIKeyAuthenticator(testKeys)),
+	}
+
+	for name, authenticator := range authenticators {
+		handler := api.RequireScope(authenticator, domain.ScopeDevicesRead, http.HandlerFunc(server.ListSignatureDevicesHandler))
+		req := httptest.NewRequest("GET", "/api/v0/devices", nil)
+		req.Header.Set("Authorization", "Bearer ssk_unknown")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusInternalServerError || recorder.Header().Get("WWW-Authenticate") != "" {
+			t.Errorf("%s: expected an internal server error without WWW-Authenticate, got %v %v", name, recorder.Code, recorder.Header())
+		}
+
+		// Credentials no authenticator has to look up are still rejected as such
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/v0/devices", nil))
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", name, recorder.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// Another authenticator of the chain accepting the request wins over the failed lookup
+	chain := api.RequireScope(authenticators["chain"], domain.ScopeDevicesRead, http.HandlerFunc(server.ListSignatureDevicesHandler))
+	req := httptest.NewRequest("GET", "/api/v0/devices", nil)
+	req.Header.Set("Authorization", "Bearer "+newAPIKey(t, "", "", "devices:read"))
+	recorder := httptest.NewRecorder()
+	chain.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusOK)
+	}
+}
+
+func TestAPIKeyHandlers(t *testing.T) {
+	server := setup()
+	admin := newAPIKey(t, "", "", "admin")
+	tenant := "merchant-" + uuid.New().String()
+
+	createReq := httptest.NewRequest("POST", "/api/v0/api-keys", bytes.NewBufferString(`{"tenantId": "`+tenant+`", "clientId": "pos-1", "scopes": ["sign"]}`))
+	createReq.Header.Set("Authorization", "Bearer "+admin)
+	recorder := httptest.NewRecorder()
+	withScope(domain.ScopeAdmin, server.CreateAPIKeyHandler).ServeHTTP(recorder, createReq)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusCreated)
+	}
+	var created response.CreatedAPIKeyResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&created); err != nil || created.Key == "" || created.TenantID != tenant {
+		t.Fatalf("unexpected api key response: %+v (%v)", created, err)
+	}
+
+	listReq := httptest.NewRequest("GET", "/api/v0/api-keys?tenantId="+tenant, nil)
+	listReq.Header.Set("Authorization", "Bearer "+admin)
+	recorder = httptest.NewRecorder()
+	withScope(domain.ScopeAdmin, server.ListAPIKeysHandler).ServeHTTP(recorder, listReq)
+	if body := recorder.Body.String(); recorder.Code != http.StatusOK || !strings.Contains(body, created.ID) || strings.Contains(body, created.Key) {
+		t.Errorf("unexpected api key listing: %v %v", recorder.Code, body)
+	}
+
+	revokeReq := httptest.NewRequest("DELETE", "/api/v0/api-keys/"+created.ID+"?tenantId="+tenant, nil)
+	revokeReq.SetPathValue("id", created.ID)
+	revokeReq.Header.Set("Authorization", "Bearer "+admin)
+	recorder = httptest.NewRecorder()
+	withScope(domain.ScopeAdmin, server.RevokeAPIKeyHandler).ServeHTTP(recorder, revokeReq)
+	var revoked response.APIKeyResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&revoked); err != nil || recorder.Code != http.StatusOK || revoked.RevokedAt == nil {
+		t.Errorf("unexpected revocation response: %v %+v (%v)", recorder.Code, revoked, err)
+	}
+
+	createReq = httptest.NewRequest("POST", "/api/v0/api-keys", bytes.NewBufferString(`{"scopes": ["root"]}`))
+	createReq.Header.Set("Authorization", "Bearer "+admin)
+	recorder = httptest.NewRecorder()
+	withScope(domain.ScopeAdmin, server.CreateAPIKeyHandler).ServeHTTP(recorder, createReq)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+// identityAuthenticator authenticates every request as the same caller
+type identityAuthenticator struct {
+	identity *api.Identity
+}
+
+func (authenticator identityAuthenticator) Authenticate(*http.Request) (*api.Identity, error) {
+	return authenticator.identity, nil
+}
+
+// TestAPIKeyTenantIsolation tests that only operators manage the API keys of other tenants, so the admin of
+// one tenant can't issue itself keys for the devices of another
+func TestAPIKeyTenantIsolation(t *testing.T) {
+	server := setup()
+	handler := http.NewServeMux()
+	handler.Handle("POST /api/v1/api-keys", withScope(domain.ScopeAdmin, server.CreateAPIKeyV1Handler))
+	handler.Handle("GET /api/v1/api-keys", withScope(domain.ScopeAdmin, server.ListAPIKeysV1Handler))
+	handler.Handle("DELETE /api/v1/api-keys/{id}", withScope(domain.ScopeAdmin, server.RevokeAPIKeyV1Handler))
+	operator := newAPIKey(t, "", "", "admin")
+	tenantA, tenantB := "merchant-"+uuid.New().String(), "merchant-"+uuid.New().String()
+	adminA := newAPIKey(t, tenantA, "", "admin")
+
+	recorder, body := v1Request(t, handler, operator, "POST", "/api/v1/api-keys", `{"tenantId": "`+tenantB+`", "scopes": ["sign"]}`)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("expected operators to create keys of any tenant, got %v %v", recorder.Code, body)
+	}
+	keyB := body["data"].(map[string]interface{})["id"].(string)
+
+	cases := []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{"POST", "/api/v1/api-keys", `{"tenantId": "` + tenantB + `", "scopes": ["sign", "devices:write"]}`, http.StatusForbidden, "operator_required"},
+		{"POST", "/api/v1/api-keys", `{"tenantId": "default", "scopes": ["sign"]}`, http.StatusForbidden, "operator_required"},
+		{"GET", "/api/v1/api-keys?tenantId=" + tenantB, "", http.StatusForbidden, "operator_required"},
+		{"DELETE", "/api/v1/api-keys/" + keyB + "?tenantId=" + tenantB, "", http.StatusForbidden, "operator_required"},
+		{"DELETE", "/api/v1/api-keys/" + keyB, "", http.StatusNotFound, "api_key_not_found"},
+		{"POST", "/api/v1/api-keys", `{"tenantId": "` + tenantA + `", "scopes": ["sign"]}`, http.StatusCreated, ""},
+		{"GET", "/api/v1/api-keys", "", http.StatusOK, ""},
+	}
+	for _, c := range cases {
+		recorder, body := v1Request(t, handler, adminA, c.method, c.path, c.body)
+		if recorder.Code != c.status || (c.code != "" && body["code"] != c.code) {
+			t.Errorf("%s %s: expected %v %s, got %v %v", c.method, c.path, c.status, c.code, recorder.Code, body)
+		}
+	}
+
+	// Admins of the default tenant that are not operators, e.g. authenticated by JWT, can't create operator keys
+	admin := &api.Identity{TenantID: "default", ClientID: "idp-admin", Scopes: []domain.Scope{domain.ScopeAdmin}}
+	create := api.RequireScope(identityAuthenticator{admin}, domain.ScopeAdmin, http.HandlerFunc(server.CreateAPIKeyV1Handler))
+	recorder, body = v1Request(t, create, "", "POST", "/api/v1/api-keys", `{"scopes": ["admin"]}`)
+	if recorder.Code != http.StatusForbidden || body["code"] != "operator_required" {
+		t.Errorf("expected operator keys to require an operator, got %v %v", recorder.Code, body)
+	}
+	recorder, body = v1Request(t, create, "", "POST", "/api/v1/api-keys", `{"scopes": ["sign"]}`)
+	if recorder.Code != http.StatusCreated {
+		t.Errorf("expected keys of the own tenant to be created, got %v %v", recorder.Code, body)
+	}
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	server := setup()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := jwtauth.ParseKeySet([]byte(`{"keys": [{"kty": "OKP", "crv": "Ed25519", "kid": "test", "x": "` +
+		base64.RawURLEncoding.EncodeToString(public) + `"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := jwtauth.NewVerifier(keys, "https://issuer.example.com/", "signing-service")
+	authenticator := api.NewChainAuthenticator(api.NewAPIKeyAuthenticator(testKeys), api.NewJWTAuthenticator(verifier, "merchant"))
+	issue := func(claims jwt.MapClaims) string {
+		claims["iss"], claims["aud"] = "https://issuer.example.com/", "signing-service"
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	tenant := "merchant-" + uuid.New().String()
+
+	for name, test := range map[string]struct {
+		token string
+		want  int
+	}{
+		"scope claim":         {issue(jwt.MapClaims{"merchant": tenant, "sub": "pos-1", "scope": "openid devices:write"}), http.StatusOK},
+		"scp claim":           {issue(jwt.MapClaims{"merchant": tenant, "azp": "pos-1", "scp": []string{"devices:write"}}), http.StatusOK},
+		"missing scope":       {issue(jwt.MapClaims{"merchant": tenant, "sub": "pos-1", "scope": "devices:read"}), http.StatusForbidden},
+		"missing tenant":      {issue(jwt.MapClaims{"sub": "pos-1", "scope": "devices:write"}), http.StatusUnauthorized},
+		"API key":             {newAPIKey(t, tenant, "pos-1", "devices:write"), http.StatusOK},
+		"invalid credentials": {"ey.invalid.token", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("POST", "/api/v0/create-signature-device", bytes.NewBufferString(`{"algorithm": "ECC", "policy": {"allowedClients": ["pos-1"]}}`))
+		req.Header.Set("Authorization", "Bearer "+test.token)
+		recorder := httptest.NewRecorder()
+		api.RequireScope(authenticator, domain.ScopeDevicesWrite, http.HandlerFunc(server.CreateSignatureDeviceHandler)).ServeHTTP(recorder, req)
+		if recorder.Code != test.want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", name, recorder.Code, test.want)
+			continue
+		}
+		if test.want != http.StatusOK {
+			continue
+		}
+
+		// The device belongs to the tenant of the caller
+		var device response.DeviceResponse
+		if err := json.NewDecoder(recorder.Body).Decode(&device); err != nil || device.TenantID != tenant {
+			t.Errorf("%s: expected a device of tenant %s, got %+v (%v)", name, tenant, device, err)
+		}
+	}
+}
+
+func TestRateLimiting(t *testing.T) {
+	server := setup()
+	// Limits refilling so slowly that the tests never see a refill
+	limiter := api.NewRateLimiter(ratelimit.NewMemoryStore(), ratelimit.Limit{Rate: 0.001, Burst: 3}, ratelimit.Limit{Rate: 0.001, Burst: 2})
+	tenant := "merchant-" + uuid.New().String()
+	signer := newAPIKey(t, tenant, "pos-1", "devices:write", "sign")
+	otherSigner := newAPIKey(t, tenant, "pos-2", "sign")
+	sign := func(key, deviceID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v0/sign-transaction", bytes.NewBufferString(`{"deviceId": "`+deviceID+`", "data": "sample"}`))
+		req.Header.Set("Authorization", "Bearer "+key)
+		recorder := httptest.NewRecorder()
+		handler := limiter.LimitClient(limiter.LimitDevice(func(r *http.Request) string { return deviceID }, http.HandlerFunc(server.SignTransactionHandler)))
+		withScopeHandler(domain.ScopeSign, handler).ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	deviceID := uuid.New().String()
+	createReq := httptest.NewRequest("POST", "/api/v0/create-signature-device", bytes.NewBufferString(`{"id": "`+deviceID+`", "algorithm": "ECC"}`))
+	createReq.Header.Set("Authorization", "Bearer "+signer)
+	recorder := httptest.NewRecorder()
+	withScope(domain.ScopeDevicesWrite, server.CreateSignatureDeviceHandler).ServeHTTP(recorder, createReq)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusOK)
+	}
+
+	// The device allows two requests at once, across clients
+	for i, key := range []string{signer, otherSigner} {
+		recorder = sign(key, deviceID)
+		if recorder.Code != http.StatusOK || recorder.Header().Get("RateLimit-Limit") != "2" || recorder.Header().Get("RateLimit-Remaining") != strconv.Itoa(1-i) {
+			t.Errorf("request %d: got %v with RateLimit-Limit %q and RateLimit-Remaining %q", i, recorder.Code,
+				recorder.Header().Get("RateLimit-Limit"), recorder.Header().Get("RateLimit-Remaining"))
+		}
+	}
+	recorder = sign(otherSigner, deviceID)
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") == "" || recorder.Header().Get("RateLimit-Reset") == "" {
+		t.Errorf("expected 429 with Retry-After and RateLimit-Reset, got %v %v", recorder.Code, recorder.Header())
+	}
+
+	// The client allows three requests at once, across devices
+	for i := 0; i < 2; i++ {
+		recorder = sign(signer, uuid.New().String())
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusNotFound)
+		}
+	}
+	recorder = sign(signer, uuid.New().String())
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("RateLimit-Limit") != "3" {
+		t.Errorf("expected 429 of the client limit, got %v %v", recorder.Code, recorder.Header())
+	}
+}
+
+// TestProblemDetails tests that errors are returned as RFC 7807 problem details with stable error codes
+func TestProblemDetails(t *testing.T) {
+	server := setup()
+	handler := http.NewServeMux()
+	handler.Handle("POST /api/v0/create-signature-device", withScope(domain.ScopeDevicesWrite, server.CreateSignatureDeviceHandler))
+	handler.Handle("GET /api/v0/devices", withScope(domain.ScopeDevicesRead, server.ListSignatureDevicesHandler))
+	handler.Handle("GET /api/v0/device", withScope(domain.ScopeDevicesRead, server.GetSignatureDeviceByIdHandler))
+	handler.Handle("GET /api/v1/devices/{id}", withScope(domain.ScopeDevicesRead, server.GetDeviceV1Handler))
+	handler.Handle("GET /api/v1/api-keys", withScope(domain.ScopeAdmin, server.ListAPIKeysV1Handler))
+	key := newAPIKey(t, "", "", "devices:read", "devices:write")
+
+	cases := []struct {
+		method, path, body string
+		status             int
+		code               string
+		invalidParam       string
+	}{
+		{"POST", "/api/v0/create-signature-device", `{"algorithm": "RSA", "label": "` + strings.Repeat("x", 256) + `"}`, http.StatusBadRequest, "invalid_device_attributes", "label"},
+		{"POST", "/api/v0/create-signature-device", `{"label": "no algorithm"}`, http.StatusBadRequest, "invalid_device_request", "algorithm"},
+		{"POST", "/api/v0/create-signature-device", `{"algorithm": "DSA"}`, http.StatusUnprocessableEntity, "invalid_algorithm", "algorithm"},
+		{"POST", "/api/v0/create-signature-device", `{"algorithm": `, http.StatusBadRequest, "malformed_request", ""},
+		{"GET", "/api/v0/devices?limit=0", "", http.StatusBadRequest, "invalid_list_request", "limit"},
+		{"GET", "/api/v0/device?id=" + uuid.New().String(), "", http.StatusNotFound, "device_not_found", ""},
+		{"GET", "/api/v1/devices/" + uuid.New().String(), "", http.StatusNotFound, "device_not_found", ""},
+		{"GET", "/api/v1/api-keys", "", http.StatusForbidden, "insufficient_scope", ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, bytes.NewBufferString(c.body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != c.status {
+			t.Errorf("%s %s: handler returned wrong status code: got %v want %v", c.method, c.path, recorder.Code, c.status)
+		}
+		if contentType := recorder.Header().Get("Content-Type"); contentType != "application/problem+json" {
+			t.Errorf("%s %s: expected application/problem+json, got %q", c.method, c.path, contentType)
+		}
+		var problem api.ErrorResponse
+		if err := json.NewDecoder(recorder.Body).Decode(&problem); err != nil {
+			t.Fatalf("%s %s: unexpected error in response unmarshalling: %v", c.method, c.path, err)
+		}
+		if problem.Code != c.code || problem.Status != c.status || problem.Type == "" || problem.Title == "" || problem.Detail == "" {
+			t.Errorf("%s %s: unexpected problem details: %+v", c.method, c.path, problem)
+		}
+		if problem.Instance != req.URL.Path {
+			t.Errorf("%s %s: expected instance %q, got %q", c.method, c.path, req.URL.Path, problem.Instance)
+		}
+		if c.invalidParam != "" && (len(problem.InvalidParams) != 1 || problem.InvalidParams[0].Name != c.invalidParam) {
+			t.Errorf("%s %s: expected invalid param %q, got %+v", c.method, c.path, c.invalidParam, problem.InvalidParams)
+		}
+	}
+}
+
+// TestServersWithSeparateRepositories tests that servers in one process only share the dependencies they are given
+func TestServersWithSeparateRepositories(t *testing.T) {
+	ctx := context.Background()
+	storeA, storeB := persistence.NewInMemoryDeviceRepository(), persistence.NewInMemoryDeviceRepository()
+	authenticator := api.NewAPIKeyAuthenticator(testKeys)
+	serverA := api.NewServer(":8080", api.WithRepository(storeA), api.WithAuthenticator(authenticator)).Handler()
+	serverB := api.NewServer(":8080", api.WithDeviceService(api.NewDeviceService(storeB)), api.WithAuthenticator(authenticator)).Handler()
+	key := newAPIKey(t, "", "", "devices:read", "devices:write")
+
+	recorder, body := v1Request(t, serverA, key, "POST", "/api/v1/devices", `{"algorithm": "ECC"}`)
+	device, _ := body["data"].(map[string]interface{})
+	deviceID, _ := device["id"].(string)
+	if recorder.Code != http.StatusCreated || deviceID == "" {
+		t.Fatalf("expected the created device, got %v %v", recorder.Code, body)
+	}
+
+	// The device is stored in the repository of the first server only
+	if _, err := storeA.GetDevice(ctx, deviceID); err != nil {
+		t.Errorf("expected the device in the repository of the server: %v", err)
+	}
+	if recorder, body = v1Request(t, serverB, key, "GET", "/api/v1/devices/"+deviceID, ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("expected 404 from the other server, got %v %v", recorder.Code, body)
+	}
+	if recorder, body = v1Request(t, serverA, key, "GET", "/api/v1/devices/"+deviceID, ""); recorder.Code != http.StatusOK {
+		t.Errorf("expected the device, got %v %v", recorder.Code, body)
+	}
+}
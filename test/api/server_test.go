@@ -0,0 +1,370 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/api"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/buildinfo"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/health"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/logging"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRequestBodyLimit tests that request bodies exceeding the maximum body size are rejected with 413
+func TestRequestBodyLimit(t *testing.T) {
+	server := api.NewServer(":8080",
+		api.WithAuthenticator(api.NewAPIKeyAuthenticator(testKeys)),
+		api.WithLimits(api.ServerLimits{MaxBodyBytes: 1024}),
+	).Handler()
+	key := newAPIKey(t, "", "", "sign")
+	large := `{"data": "` + strings.Repeat("a", 2048) + `"}`
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		code int
+	}{
+		{"small body", "/api/v1/canonicalize", `{"b": 1, "a": 2}`, http.StatusOK},
+		{"large body read whole", "/api/v1/canonicalize", large, http.StatusRequestEntityTooLarge},
+		{"large body decoded", "/api/v0/sign-transaction", large, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+key)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.code {
+				t.Fatalf("expected status %v, got %v: %s", tt.code, recorder.Code, recorder.Body.String())
+			}
+			if tt.code != http.StatusRequestEntityTooLarge {
+				return
+			}
+			var problem api.ErrorResponse
+			if err := json.NewDecoder(recorder.Body).Decode(&problem); err != nil {
+				t.Fatalf("expected problem details: %v", err)
+			}
+			if problem.Code != "request_too_large" {
+				t.Errorf("expected code request_too_large, got %q", problem.Code)
+			}
+		})
+	}
+}
+
+// TestGracefulShutdown tests that a stopping server completes in-flight requests but accepts no new ones
+func TestGracefulShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := api.NewServer(listener.Addr().String(), api.WithAuthenticator(api.NewAPIKeyAuthenticator(testKeys)))
+	key := newAPIKey(t, "", "", "sign")
+	url := "http://" + listener.Addr().String() + "/api/v1/canonicalize"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ctx, listener)
+	}()
+
+	// Start a request whose body is still being sent when the server is stopped
+	body, writer := io.Pipe()
+	req, _ := http.NewRequest(http.MethodPost, url, body)
+	req.Header.Set("Authorization", "Bearer "+key)
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("expected the in-flight request to complete: %v", err)
+		}
+		responses <- resp
+	}()
+	writer.Write([]byte(`{"b": 1, `))
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := http.Post(url, "application/json", strings.NewReader("{}")); err == nil {
+		t.Errorf("expected new connections to be refused while stopping")
+	}
+
+	writer.Write([]byte(`"a": 2}`))
+	writer.Close()
+	if resp := <-responses; resp != nil {
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected status 200 for the in-flight request, got %v", resp.StatusCode)
+		}
+		resp.Body.Close()
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the server to stop after the in-flight request")
+	}
+}
+
+// TestHealthProbes tests the liveness and readiness probes and that the health checks report failing checks
+func TestHealthProbes(t *testing.T) {
+	failing := health.CheckerFunc(func(ctx context.Context) []health.Result {
+		return []health.Result{{ComponentType: "datastore", Status: health.StatusFail, Output: "database is locked"}}
+	})
+	tests := []struct {
+		name    string
+		options []api.ServerOption
+		path    string
+		code    int
+		checks  []string
+	}{
+		{"liveness", nil, "/livez", http.StatusOK, []string{"uptime"}},
+		{"readiness", nil, "/readyz", http.StatusOK, []string{"uptime", "datastore:connectivity", "crypto:selfTest", "crypto:keyGeneration"}},
+		{"failing liveness ignores readiness checks", []api.ServerOption{api.WithHealthCheck("datastore:connectivity", health.Readiness, failing)}, "/livez", http.StatusOK, []string{"uptime"}},
+		{"failing readiness", []api.ServerOption{api.WithHealthCheck("datastore:connectivity", health.Readiness, failing)}, "/readyz", http.StatusServiceUnavailable, []string{"uptime", "datastore:connectivity", "crypto:selfTest", "crypto:keyGeneration"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := api.NewServer(":8080", tt.options...).Handler()
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if recorder.Code != tt.code {
+				t.Fatalf("expected status %v, got %v: %s", tt.code, recorder.Code, recorder.Body.String())
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != health.ContentType {
+				t.Errorf("expected content type %v, got %v", health.ContentType, contentType)
+			}
+			var report health.Report
+			if err := json.NewDecoder(recorder.Body).Decode(&report); err != nil {
+				t.Fatalf("expected a health report: %v", err)
+			}
+			if report.Version != buildinfo.Version() || report.Version == "" {
+				t.Errorf("expected the build version, got %q", report.Version)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Errorf("expected checks %v, got %v", tt.checks, report.Checks)
+			}
+			for _, name := range tt.checks {
+				if _, ok := report.Checks[name]; !ok {
+					t.Errorf("expected check %v, got %v", name, report.Checks)
+				}
+			}
+		})
+	}
+
+	// The health checks of the v0 and v1 APIs report the readiness and the build version
+	server := api.NewServer(":8080", api.WithHealthCheck("datastore:connectivity", health.Readiness, failing)).Handler()
+	recorder, body := v1Request(t, server, "", "GET", "/api/v1/health", "")
+	if data, _ := body["data"].(map[string]interface{}); recorder.Code != http.StatusServiceUnavailable || data["status"] != "fail" || data["version"] != buildinfo.Version() {
+		t.Errorf("expected the failing health in the data envelope, got %v %v", recorder.Code, body)
+	}
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v0/health", nil))
+	var response api.HealthResponse
+	json.NewDecoder(recorder.Body).Decode(&response)
+	if recorder.Code != http.StatusServiceUnavailable || response.Status != "fail" || response.Version != buildinfo.Version() {
+		t.Errorf("expected the failing health with the build version, got %v %+v", recorder.Code, response)
+	}
+}
+
+// TestMetricsEndpoint tests that /metrics exposes the device, signature, request and repository metrics
+func TestMetricsEndpoint(t *testing.T) {
+	handler := api.NewServer(":8080", api.WithAuthenticator(api.NewAPIKeyAuthenticator(testKeys))).Handler()
+	key := newAPIKey(t, "", "", "devices:read", "devices:write", "sign")
+
+	_, body := v1Request(t, handler, key, "POST", "/api/v1/devices", `{"algorithm": "ECC"}`)
+	device, _ := body["data"].(map[string]interface{})
+	deviceID, _ := device["id"].(string)
+	v1Request(t, handler, key, "POST", "/api/v1/devices/"+deviceID+"/signatures", `{"data": "sample"}`)
+	v1Request(t, handler, key, "POST", "/api/v1/devices/"+uuid.New().String()+"/signatures", `{"data": "sample"}`)
+
+	// The metrics need no credentials
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v", recorder.Code)
+	}
+	metrics := recorder.Body.String()
+	for _, expected := range []string{
+		`signing_build_info{revision="`,
+		"signing_devices 1",
+		`signing_device_creations_total{algorithm="ECC",outcome="success"} 1`,
+		`signing_signatures_total{algorithm="ECC",outcome="success"} 1`,
+		`signing_signatures_total{algorithm="unknown",outcome="device_not_found"} 1`,
+		`signing_key_generation_duration_seconds_count{algorithm="ECC"} 1`,
+		`signing_signing_duration_seconds_count{algorithm="ECC"} 1`,
+		`signing_http_request_duration_seconds_count{code="201",method="POST",route="/api/v1/devices"} 1`,
+		`signing_http_request_duration_seconds_count{code="404",method="POST",route="/api/v1/devices/{id}/signatures"} 1`,
+		`signing_repository_operation_duration_seconds_count{operation="CreateDevice",outcome="success"} 1`,
+		"signing_http_requests_in_flight 1",
+		"go_goroutines",
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("expected the metrics to contain %q", expected)
+		}
+	}
+}
+
+// TestRequestLogging tests that requests get an ID that is echoed, logged with every record of the request
+// and passed on to the service, and that neither keys nor transaction data are logged
+func TestRequestLogging(t *testing.T) {
+	var logs bytes.Buffer
+	logger := logging.New(&logs, logging.FormatJSON, slog.LevelDebug)
+	handler := api.NewServer(":8080", api.WithAuthenticator(api.NewAPIKeyAuthenticator(testKeys)), api.WithLogger(logger)).Handler()
+	key := newAPIKey(t, "", "pos-1", "devices:write", "sign")
+
+	request := func(requestID, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		if requestID != "" {
+			req.Header.Set(logging.RequestIDHeader, requestID)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := request("create-1", "POST", "/api/v1/devices", `{"algorithm": "ECC"}`)
+	if got := recorder.Header().Get(logging.RequestIDHeader); got != "create-1" {
+		t.Errorf("expected the request ID of the client to be echoed, got %q", got)
+	}
+	var created struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	json.NewDecoder(recorder.Body).Decode(&created)
+
+	// Request IDs that could forge log lines are replaced
+	recorder = request("sign-1\nforged", "POST", "/api/v1/devices/"+created.Data.ID+"/signatures", `{"data": "receipt-4711"}`)
+	signID := recorder.Header().Get(logging.RequestIDHeader)
+	if signID == "" || strings.Contains(signID, "forged") {
+		t.Errorf("expected a generated request ID, got %q", signID)
+	}
+
+	messages := map[string]map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("expected JSON logs, got %q", line)
+		}
+		messages[record["msg"].(string)+" "+record["request_id"].(string)] = record
+	}
+	for _, expected := range []string{"device created create-1", "request served create-1", "transaction signed " + signID, "request served " + signID} {
+		if _, ok := messages[expected]; !ok {
+			t.Errorf("expected a %q record, got %v", expected, logs.String())
+		}
+	}
+	if record := messages["request served "+signID]; record["status"] != float64(http.StatusCreated) || record["client_id"] != "pos-1" || record["path"] == nil {
+		t.Errorf("expected the access log to hold the status, path and client, got %v", record)
+	}
+	if record := messages["repository operation "+signID]; record == nil {
+		t.Errorf("expected the repository operations to be logged with the request ID, got %v", logs.String())
+	}
+	for _, secret := range []string{"receipt-4711", "PRIVATE KEY", key} {
+		if strings.Contains(logs.String(), secret) {
+			t.Errorf("expected %q not to be logged", secret)
+		}
+	}
+}
+
+// TestRequestTracing tests that requests continue the trace of the client, with spans of the service,
+// crypto and repository operations below the span of the route, and that their logs carry the trace ID
+func TestRequestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewTracerProvider(sdktrace.WithSyncer(exporter), 1)
+	defer provider.Shutdown(context.Background())
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	}()
+
+	var logs bytes.Buffer
+	logger := logging.New(&logs, logging.FormatJSON, slog.LevelInfo)
+	handler := api.NewServer(":8080", api.WithAuthenticator(api.NewAPIKeyAuthenticator(testKeys)), api.WithLogger(logger)).Handler()
+	key := newAPIKey(t, "", "pos-1", "devices:write", "sign")
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := request("POST", "/api/v1/devices", `{"algorithm": "ECC"}`)
+	var created struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	json.NewDecoder(recorder.Body).Decode(&created)
+	exporter.Reset()
+	logs.Reset()
+
+	recorder = request("POST", "/api/v1/devices/"+created.Data.ID+"/signatures", `{"data": "receipt-4711"}`)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", recorder.Code)
+	}
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		if got := span.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("expected span %s to continue the trace of the client, got trace %s", span.Name, got)
+		}
+		spans[span.Name] = span
+	}
+	root, ok := spans["POST /api/v1/devices/{id}/signatures"]
+	if !ok {
+		t.Fatalf("expected a span named after the route, got %v", spans)
+	}
+	if got := root.Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("expected the request span to be a child of the client span, got %s", got)
+	}
+	service, ok := spans["DeviceService.SignTransaction"]
+	if !ok || service.Parent.SpanID() != root.SpanContext.SpanID() {
+		t.Fatalf("expected a service span below the request span, got %v", spans)
+	}
+	for _, name := range []string{"Signer.Sign", "DeviceRepository.GetTenantDevice", "DeviceRepository.AppendSignature"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("expected a %s span, got %v", name, spans)
+			continue
+		}
+		if span.Parent.SpanID() != service.SpanContext.SpanID() {
+			t.Errorf("expected the %s span to be a child of the service span", name)
+		}
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]interface{}
+		json.Unmarshal([]byte(line), &record)
+		if record["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("expected the logs to carry the trace ID, got %q", line)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/api"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/google/uuid"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate is a certificate of the tests with its private key
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	der         []byte
+}
+
+// issueCertificate issues a certificate signed by the parent, or a self-signed CA certificate without one
+func issueCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore, template.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	issuer, issuerKey := template, key
+	if parent != nil {
+		issuer, issuerKey = parent.certificate, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCertificate{certificate: certificate, key: key, der: der}
+}
+
+// writePEM writes the certificate and its key as PEM files and returns their paths
+func (c *testCertificate) writePEM(t *testing.T, name string) (string, string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(t.TempDir(), name+".crt"), filepath.Join(t.TempDir(), name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// tlsClient returns a client trusting the CA, presenting the client certificate if given
+func tlsClient(ca, client *testCertificate) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.certificate)
+	config := &tls.Config{RootCAs: roots}
+	if client != nil {
+		config.Certificates = []tls.Certificate{{Certificate: [][]byte{client.der}, PrivateKey: client.key}}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+}
+
+func TestMutualTLS(t *testing.T) {
+	server := setup()
+	ca := issueCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	serverCertificate := issueCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	tenant := "merchant-" + uuid.New().String()
+	register := func(name string) *testCertificate {
+		return issueCertificate(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: name, Organization: []string{tenant}},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, ca)
+	}
+	register1, register2 := register("register-1"), register("register-2")
+
+	certFile, keyFile := serverCertificate.writePEM(t, "server")
+	caFile, _ := ca.writePEM(t, "ca")
+	tlsConfig, err := api.NewTLSConfig(api.TLSSettings{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: api.ClientAuthRequire})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// API keys take precedence over the certificate, so the devices of the registers can be managed over the same connection
+	authenticator := api.NewChainAuthenticator(api.NewAPIKeyAuthenticator(testKeys), api.NewCertificateAuthenticator([]domain.Scope{domain.ScopeDevicesWrite, domain.ScopeSign}))
+	mux := http.NewServeMux()
+	mux.Handle("/api/v0/create-signature-device", api.RequireScope(authenticator, domain.ScopeDevicesWrite, http.HandlerFunc(server.CreateSignatureDeviceHandler)))
+	mux.Handle("/api/v0/sign-transaction", api.RequireScope(authenticator, domain.ScopeSign, http.HandlerFunc(server.SignTransactionHandler)))
+	httpsServer := httptest.NewUnstartedServer(mux)
+	httpsServer.TLS = tlsConfig
+	httpsServer.StartTLS()
+	defer httpsServer.Close()
+
+	post := func(client *testCertificate, key, path, body string) (*http.Response, map[string]interface{}) {
+		t.Helper()
+		req, err := http.NewRequest("POST", httpsServer.URL+path, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		resp, err := tlsClient(ca, client).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var decoded map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&decoded)
+		return resp, decoded
+	}
+
+	// Connections without a client certificate are rejected in the handshake
+	if _, err := tlsClient(ca, nil).Get(httpsServer.URL + "/api/v0/sign-transaction"); err == nil {
+		t.Errorf("expected a connection without client certificate to fail")
+	}
+
+	// The tenant binds one device to the first register and leaves another one unbound
+	manager := newAPIKey(t, tenant, "", "devices:write")
+	createDevice := func(body string) string {
+		t.Helper()
+		resp, device := post(register1, manager, "/api/v0/create-signature-device", body)
+		if resp.StatusCode != http.StatusOK || device["TenantID"] != tenant {
+			t.Fatalf("expected a device of tenant %s, got %v %v", tenant, resp.StatusCode, device)
+		}
+		return device["ID"].(string)
+	}
+	bound := createDevice(`{"algorithm": "ECC", "boundClient": "register-1"}`)
+	unbound := createDevice(`{"algorithm": "ECC"}`)
+
+	// Registers only sign with the devices bound to them
+	for _, test := range []struct {
+		client   *testCertificate
+		deviceID string
+		want     int
+	}{
+		{register1, bound, http.StatusOK},
+		{register2, bound, http.StatusForbidden},
+		{register1, unbound, http.StatusForbidden},
+	} {
+		resp, body := post(test.client, "", "/api/v0/sign-transaction", `{"deviceId": "`+test.deviceID+`", "data": "sample"}`)
+		if resp.StatusCode != test.want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v (%v)", test.client.certificate.Subject.CommonName, resp.StatusCode, test.want, body)
+		}
+		if test.want == http.StatusForbidden && body["code"] != "device_not_bound" {
+			t.Errorf("%s: expected device_not_bound, got %v", test.client.certificate.Subject.CommonName, body)
+		}
+	}
+
+	// Registers can't bind devices to themselves
+	resp, body := post(register2, "", "/api/v0/create-signature-device", `{"algorithm": "ECC", "boundClient": "register-2"}`)
+	if resp.StatusCode != http.StatusForbidden || body["code"] != "client_binding_forbidden" {
+		t.Errorf("expected registers not to bind devices, got %v %v", resp.StatusCode, body)
+	}
+
+	// Certificates without an organization don't fall back to the default tenant
+	anonymous := issueCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "register-3"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	resp, _ = post(anonymous, "", "/api/v0/sign-transaction", `{"deviceId": "`+bound+`", "data": "sample"}`)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("certificate without organization: handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	// Client certificates are only accepted with a CA bundle to verify them
+	for _, settings := range []api.TLSSettings{
+		{CertFile: certFile, KeyFile: keyFile, ClientAuth: api.ClientAuthOptional},
+		{CertFile: certFile, KeyFile: keyFile, ClientAuth: "sometimes", ClientCAFile: caFile},
+		{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.0"},
+		{CertFile: certFile, KeyFile: keyFile, CipherPolicy: "legacy"},
+		{CertFile: certFile, KeyFile: caFile},
+	} {
+		if _, err := api.NewTLSConfig(settings); err == nil {
+			t.Errorf("expected invalid TLS settings %+v to be rejected", settings)
+		}
+	}
+}
+
+func TestCertificateAuthenticatorWithoutCertificate(t *testing.T) {
+	server := setup()
+	authenticator := api.NewCertificateAuthenticator([]domain.Scope{domain.ScopeDevicesRead})
+
+	// Plain HTTP requests carry no certificate identity
+	recorder := httptest.NewRecorder()
+	api.RequireScope(authenticator, domain.ScopeDevicesRead, http.HandlerFunc(server.ListSignatureDevicesHandler)).
+		ServeHTTP(recorder, httptest.NewRequest("GET", "/api/v0/devices", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/api"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/buildinfo"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// v1Handler routes the v1 API like Server.Handler, but authenticates with the API keys of testKeys
+func v1Handler(server *api.Server) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/v1/devices", withScope(domain.ScopeDevicesWrite, server.CreateDeviceV1Handler))
+	mux.Handle("GET /api/v1/devices", withScope(domain.ScopeDevicesRead, server.ListDevicesV1Handler))
+	mux.Handle("GET /api/v1/devices/{id}", withScope(domain.ScopeDevicesRead, server.GetDeviceV1Handler))
+	mux.Handle("POST /api/v1/devices/{id}/signatures", withScope(domain.ScopeSign, server.CreateSignatureV1Handler))
+	mux.Handle("POST /api/v1/devices/{id}/status", withScope(domain.ScopeDevicesWrite, server.ChangeDeviceStatusV1Handler))
+	return mux
+}
+
+// v1Request sends a request to the v1 API and decodes the JSON response body
+func v1Request(t *testing.T, handler http.Handler, key, method, path, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+key)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("%s %s: invalid JSON response %q: %v", method, path, recorder.Body.String(), err)
+	}
+	return recorder, decoded
+}
+
+func TestV1DeviceLifecycle(t *testing.T) {
+	handler := v1Handler(setup())
+	key := newAPIKey(t, "merchant-"+uuid.New().String(), "pos-1", "devices:read", "devices:write", "sign")
+
+	// Creating a device returns 201 with its URL and the device in the data envelope, in camelCase
+	recorder, body := v1Request(t, handler, key, "POST", "/api/v1/devices", `{"algorithm": "ECC", "label": "Register 1"}`)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusCreated)
+	}
+	device, _ := body["data"].(map[string]interface{})
+	deviceID, _ := device["id"].(string)
+	if deviceID == "" || device["publicKey"] == "" || device["label"] != "Register 1" || device["signatureCount"] != float64(0) {
+		t.Fatalf("expected a camelCase device in the data envelope, got %v", body)
+	}
+	if location := recorder.Header().Get("Location"); location != "/api/v1/devices/"+deviceID {
+		t.Errorf("expected Location /api/v1/devices/%s, got %q", deviceID, location)
+	}
+
+	recorder, body = v1Request(t, handler, key, "GET", "/api/v1/devices/"+deviceID, "")
+	if device, _ := body["data"].(map[string]interface{}); recorder.Code != http.StatusOK || device["id"] != deviceID {
+		t.Errorf("expected the device, got %v %v", recorder.Code, body)
+	}
+
+	// Signatures are created below the device; the device ID comes from the path
+	recorder, body = v1Request(t, handler, key, "POST", "/api/v1/devices/"+deviceID+"/signatures", `{"data": "sample"}`)
+	signature, _ := body["data"].(map[string]interface{})
+	if recorder.Code != http.StatusCreated || signature["signature"] == nil || signature["signedData"] == nil {
+		t.Errorf("expected a camelCase signature in the data envelope, got %v %v", recorder.Code, body)
+	}
+	recorder, body = v1Request(t, handler, key, "POST", "/api/v1/devices/"+deviceID+"/signatures", `{"deviceId": "`+uuid.New().String()+`", "data": "sample"}`)
+	if recorder.Code != http.StatusBadRequest || body["code"] != "invalid_sign_request" {
+		t.Errorf("expected 400 for a deviceId contradicting the path, got %v %v", recorder.Code, body)
+	}
+
+	// The signature counter has moved on, and the list contains the device
+	recorder, body = v1Request(t, handler, key, "GET", "/api/v1/devices?limit=1", "")
+	page, _ := body["data"].(map[string]interface{})
+	devices, _ := page["devices"].([]interface{})
+	if recorder.Code != http.StatusOK || len(devices) != 1 || devices[0].(map[string]interface{})["signatureCount"] != float64(1) {
+		t.Errorf("expected the device in the list, got %v %v", recorder.Code, body)
+	}
+
+	// Errors are reported as problem details with the status codes of v0
+	recorder, body = v1Request(t, handler, key, "POST", "/api/v1/devices/"+deviceID+"/status", `{"action": "resume"}`)
+	if recorder.Code != http.StatusConflict || body["code"] != "invalid_status_transition" {
+		t.Errorf("expected 409 as problem details, got %v %v", recorder.Code, body)
+	}
+	recorder, body = v1Request(t, handler, key, "GET", "/api/v1/devices/"+uuid.New().String(), "")
+	if recorder.Code != http.StatusNotFound || body["code"] != "device_not_found" {
+		t.Errorf("expected 404 as problem details, got %v %v", recorder.Code, body)
+	}
+	recorder, body = v1Request(t, handler, "ssk_unknown", "GET", "/api/v1/devices/"+deviceID, "")
+	if recorder.Code != http.StatusUnauthorized || body["code"] != "unauthenticated" {
+		t.Errorf("expected 401 as problem details, got %v %v", recorder.Code, body)
+	}
+}
+
+func TestV1Routes(t *testing.T) {
+	handler := setup().Handler()
+
+	recorder, body := v1Request(t, handler, "", "GET", "/api/v1/health", "")
+	if health, _ := body["data"].(map[string]interface{}); recorder.Code != http.StatusOK || health["version"] != buildinfo.Version() {
+		t.Errorf("expected the health in the data envelope, got %v %v", recorder.Code, body)
+	}
+
+	// The v1 routes require credentials like v0, whose routes remain registered
+	for _, route := range []struct{ method, path string }{
+		{"POST", "/api/v1/devices"},
+		{"GET", "/api/v1/devices/" + uuid.New().String()},
+		{"POST", "/api/v1/devices/" + uuid.New().String() + "/signatures"},
+		{"POST", "/api/v0/sign-transaction"},
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(route.method, route.path, nil))
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: handler returned wrong status code: got %v want %v", route.method, route.path, recorder.Code, http.StatusUnauthorized)
+		}
+	}
+}
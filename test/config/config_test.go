@@ -0,0 +1,158 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// env returns a getenv function reading the given variables
+func env(variables map[string]string) func(string) string {
+	return func(name string) string {
+		return variables[name]
+	}
+}
+
+// writeFile writes a configuration file into a temporary directory and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, options, err := config.Load(nil, env(nil), io.Discard)
+	require.NoError(t, err)
+
+	assert.Equal(t, config.Default(), cfg)
+	assert.Equal(t, config.Options{}, options)
+	assert.Equal(t, ":8080", cfg.Server.Address)
+	assert.Equal(t, config.StorageMemory, cfg.Storage.Backend)
+	assert.Equal(t, 512, cfg.Crypto.RSAKeyBits)
+	assert.Equal(t, time.Second, cfg.Crypto.KeyGenerationThreshold)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+server:
+  address: ":7000"
+  writeTimeout: 10s
+storage:
+  backend: sqlite
+  dsn: file.db
+crypto:
+  rsaKeyBits: 1024
+log:
+  level: debug
+`)
+	variables := map[string]string{
+		"CONFIG_FILE":  path,
+		"PORT":         "9000",
+		"RSA_KEY_BITS": "2048",
+		"DATA_STORE":   "db",
+	}
+
+	cfg, options, err := config.Load([]string{"--rsa-key-bits", "4096"}, env(variables), io.Discard)
+	require.NoError(t, err)
+
+	assert.Equal(t, path, options.ConfigFile)
+	// The file overrides the defaults
+	assert.Equal(t, 10*time.Second, cfg.Server.WriteTimeout)
+	assert.Equal(t, "file.db", cfg.Storage.DSN)
+	assert.Equal(t, "debug", cfg.Log.Level)
+	assert.Equal(t, 5*time.Second, cfg.Server.ReadHeaderTimeout)
+	// Environment variables override the file, db is accepted for sqlite
+	assert.Equal(t, ":9000", cfg.Server.Address)
+	assert.Equal(t, config.StorageSQLite, cfg.Storage.Backend)
+	// Flags override environment variables
+	assert.Equal(t, 4096, cfg.Crypto.RSAKeyBits)
+}
+
+func TestLoadJSONFile(t *testing.T) {
+	path := writeFile(t, "config.json", `{
+  "server": {"address": "127.0.0.1:8443", "idleTimeout": "1m"},
+  "auth": {"certificateScopes": ["sign"]},
+  "rateLimit": {"client": {"rate": 0, "burst": 1}},
+  "log": {"format": "json"}
+}`)
+
+	cfg, _, err := config.Load([]string{"--config", path}, env(nil), io.Discard)
+	require.NoError(t, err)
+
+	assert.Equal(t, "127.0.0.1:8443", cfg.Server.Address)
+	assert.Equal(t, time.Minute, cfg.Server.IdleTimeout)
+	assert.Equal(t, []string{"sign"}, cfg.Auth.CertificateScopes)
+	assert.Equal(t, config.Limit{Rate: 0, Burst: 1}, cfg.RateLimit.Client)
+	assert.Equal(t, config.Limit{Rate: 20, Burst: 40}, cfg.RateLimit.Device)
+	assert.Equal(t, config.LogFormatJSON, cfg.Log.Format)
+}
+
+func TestLoadRejectsUnknownFileSettings(t *testing.T) {
+	path := writeFile(t, "config.yaml", "server:\n  port: 8080\n")
+
+	_, _, err := config.Load([]string{"--config", path}, env(nil), io.Discard)
+	assert.ErrorContains(t, err, "field port not found")
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	_, _, err := config.Load(nil, env(map[string]string{"SERVER_READ_TIMEOUT": "soon"}), io.Discard)
+	assert.EqualError(t, err, `SERVER_READ_TIMEOUT: invalid duration "soon"`)
+
+	_, _, err = config.Load([]string{"--rsa-key-bits", "many"}, env(nil), io.Discard)
+	assert.EqualError(t, err, `--rsa-key-bits: invalid integer "many"`)
+
+	_, _, err = config.Load([]string{"--unknown"}, env(nil), io.Discard)
+	assert.ErrorContains(t, err, "flag provided but not defined: -unknown")
+
+	_, _, err = config.Load([]string{"-h"}, env(nil), io.Discard)
+	assert.ErrorIs(t, err, flag.ErrHelp)
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	cfg := config.Default()
+	cfg.Storage.Backend = "postgres"
+	cfg.Crypto.SignedDataFormat = "v9"
+	cfg.TLS.CertFile = "server.crt"
+	cfg.Auth.CertificateScopes = []string{"everything"}
+	cfg.RateLimit.Device.Burst = 0
+	cfg.Tracing.Exporter = "file"
+	cfg.Tracing.SampleRatio = 1.5
+
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "storage.backend must be memory or sqlite")
+	assert.Contains(t, err.Error(), `crypto.signedDataFormat "v9" is not supported`)
+	assert.Contains(t, err.Error(), "tls.certFile and tls.keyFile must be given together")
+	assert.Contains(t, err.Error(), "auth.certificateScopes")
+	assert.Contains(t, err.Error(), "rateLimit.device.burst must be at least 1")
+	assert.Contains(t, err.Error(), "tracing.file is required with the file exporter")
+	assert.Contains(t, err.Error(), "tracing.sampleRatio must be between 0 and 1")
+
+	assert.NoError(t, config.Default().Validate())
+}
+
+func TestWriteRedactsSecretsAndRoundTrips(t *testing.T) {
+	cfg := config.Default()
+	cfg.Auth.BootstrapAPIKey = "ssk_secret"
+	cfg.Crypto.RSAKeyBits = 2048
+
+	var out bytes.Buffer
+	require.NoError(t, cfg.Write(&out))
+	assert.NotContains(t, out.String(), "ssk_secret")
+	assert.Contains(t, out.String(), "bootstrapApiKey: REDACTED")
+	assert.Equal(t, "ssk_secret", cfg.Auth.BootstrapAPIKey)
+
+	// The printed configuration can be loaded again
+	loaded := config.Default()
+	require.NoError(t, loaded.LoadFile(writeFile(t, "printed.yaml", out.String())))
+	assert.Equal(t, 2048, loaded.Crypto.RSAKeyBits)
+	assert.Equal(t, cfg.Server, loaded.Server)
+}
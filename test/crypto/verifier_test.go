@@ -0,0 +1,33 @@
+package crypto
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	factory := crypto.NewKeyPairFactory()
+	data := []byte("transaction")
+
+	for _, algorithm := range []domain.AlgorithmType{domain.ECC, domain.RSA} {
+		generator, err := factory.GetKeyPair(algorithm)
+		require.NoError(t, err)
+		publicKey, privateKey, err := generator.GenerateKeyPair(context.Background())
+		require.NoError(t, err)
+		signer, err := generator.UnmarshalPrivateKey(privateKey)
+		require.NoError(t, err)
+
+		// ECDSA signatures drop leading zeros of r and s, so sign several times to cover shorter ones
+		for i := 0; i < 20; i++ {
+			signature, err := signer.Sign(context.Background(), data)
+			require.NoError(t, err)
+			assert.NoError(t, crypto.Verify(algorithm, publicKey, data, signature), algorithm)
+			assert.ErrorIs(t, crypto.Verify(algorithm, publicKey, []byte("other"), signature), crypto.ErrInvalidSignature)
+		}
+	}
+}
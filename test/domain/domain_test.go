@@ -1,9 +1,12 @@
 package domain
 
 import (
+	"errors"
+	"fmt"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestNewSignatureDevice(t *testing.T) {
@@ -12,7 +15,7 @@ func TestNewSignatureDevice(t *testing.T) {
 	label := "Test Device"
 	algorithm := domain.AlgorithmType("RSA")
 	publicKey := "public-key"
-	privateKey := "private-key"
+	privateKey := []byte("private-key")
 	lastSignature := "signature-1"
 
 	// Create new device
@@ -29,71 +32,254 @@ func TestNewSignatureDevice(t *testing.T) {
 }
 
 func TestGetID(t *testing.T) {
-	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", "private-key", "signature-1")
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
 	assert.Equal(t, "device-1", device.GetID())
 }
 
 func TestGetSignatureCount(t *testing.T) {
-	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", "private-key", "signature-1")
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
 	assert.Equal(t, uint64(0), device.GetSignatureCount())
 }
 
 func TestGetLastSignature(t *testing.T) {
-	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", "private-key", "signature-1")
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
 	assert.Equal(t, "signature-1", device.GetLastSignature())
 }
 
 func TestGetLabel(t *testing.T) {
-	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", "private-key", "signature-1")
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
 	assert.Equal(t, "Test Device", device.GetLabel())
 }
 
 func TestGetAlgorithm(t *testing.T) {
-	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", "private-key", "signature-1")
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
 	assert.Equal(t, domain.AlgorithmType("RSA"), device.GetAlgorithm())
 }
 
 func TestGetPublicKey(t *testing.T) {
-	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", "private-key", "signature-1")
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
 	assert.Equal(t, "public-key", device.GetPublicKey())
 }
 
 func TestGetPrivateKey(t *testing.T) {
-	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", "private-key", "signature-1")
-	assert.Equal(t, "private-key", device.GetPrivateKey())
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
+	assert.Equal(t, []byte("private-key"), device.GetPrivateKey())
+
+	// Callers get a copy, so clearing it leaves the key of the device intact
+	clear(device.GetPrivateKey())
+	assert.Equal(t, []byte("private-key"), device.GetPrivateKey())
 }
 
 func TestSetLabel(t *testing.T) {
-	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", "private-key", "signature-1")
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
 	newLabel := "New Device Label"
 	device.SetLabel(newLabel)
 	assert.Equal(t, newLabel, device.GetLabel())
 }
 
 func TestSetPublicKey(t *testing.T) {
-	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", "private-key", "signature-1")
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
 	newPublicKey := "new-public-key"
 	device.SetPublicKey(newPublicKey)
 	assert.Equal(t, newPublicKey, device.GetPublicKey())
 }
 
 func TestSetPrivateKey(t *testing.T) {
-	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", "private-key", "signature-1")
-	newPrivateKey := "new-private-key"
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
+	newPrivateKey := []byte("new-private-key")
 	device.SetPrivateKey(newPrivateKey)
 	assert.Equal(t, newPrivateKey, device.GetPrivateKey())
 }
 
 func TestSetSignatureCount(t *testing.T) {
-	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", "private-key", "signature-1")
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
 	newCount := uint64(5)
 	device.SetSignatureCount(newCount)
 	assert.Equal(t, newCount, device.GetSignatureCount())
 }
 
 func TestSetLastSignature(t *testing.T) {
-	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", "private-key", "signature-1")
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
 	newSignature := "signature-2"
 	device.SetLastSignature(newSignature)
 	assert.Equal(t, newSignature, device.GetLastSignature())
 }
+
+func TestNewSignatureDeviceIsActive(t *testing.T) {
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
+	assert.Equal(t, domain.StatusActive, device.GetStatus())
+	assert.True(t, device.CanSign())
+}
+
+func TestStatusTransitions(t *testing.T) {
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
+	device.SetStatus(domain.StatusInactive)
+	now := time.Now()
+
+	transition, err := device.Activate("installed", now)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.StatusInactive, transition.GetFrom())
+	assert.Equal(t, domain.StatusActive, transition.GetTo())
+	assert.Equal(t, domain.ActionActivate, transition.GetAction())
+	assert.Equal(t, "installed", transition.GetReason())
+
+	_, err = device.Suspend("register lost", now)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.StatusSuspended, device.GetStatus())
+	assert.False(t, device.CanSign())
+
+	_, err = device.Resume("register found", now)
+	assert.NoError(t, err)
+	assert.True(t, device.CanSign())
+
+	_, err = device.Decommission("replaced", now)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.StatusDecommissioned, device.GetStatus())
+}
+
+func TestInvalidStatusTransitions(t *testing.T) {
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
+	now := time.Now()
+
+	_, err := device.Resume("", now)
+	assert.ErrorIs(t, err, domain.ErrInvalidStatusTransition)
+	_, err = device.Activate("", now)
+	assert.ErrorIs(t, err, domain.ErrInvalidStatusTransition)
+	_, err = device.ApplyStatusAction("explode", "", now)
+	assert.ErrorIs(t, err, domain.ErrUnknownStatusAction)
+
+	// Decommissioned devices cannot be brought back
+	_, err = device.Decommission("", now)
+	assert.NoError(t, err)
+	_, err = device.Resume("", now)
+	assert.ErrorIs(t, err, domain.ErrInvalidStatusTransition)
+	assert.Equal(t, domain.StatusDecommissioned, device.GetStatus())
+}
+
+func TestMetadataIsCopied(t *testing.T) {
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
+	metadata := map[string]string{"store": "Berlin"}
+	device.SetMetadata(metadata)
+
+	// Changing the maps passed in or returned does not change the device
+	metadata["store"] = "Vienna"
+	device.GetMetadata()["register"] = "R-1"
+	clone := device.Clone()
+	clone.SetMetadata(map[string]string{})
+
+	assert.Equal(t, map[string]string{"store": "Berlin"}, device.GetMetadata())
+}
+
+func TestDeleteDevice(t *testing.T) {
+	privateKey := []byte("private-key")
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", privateKey, "signature-1")
+	device.SetSignatureCount(3)
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	assert.NoError(t, device.Delete(at))
+	assert.True(t, device.IsDeleted())
+	assert.Equal(t, at, device.GetDeletedAt())
+	assert.False(t, device.CanSign())
+
+	// The tombstone keeps what is needed to verify historic signatures
+	assert.Empty(t, device.GetPrivateKey())
+	assert.Equal(t, make([]byte, len(privateKey)), privateKey, "the key must be zeroed in place")
+	assert.Equal(t, "public-key", device.GetPublicKey())
+	assert.Equal(t, uint64(3), device.GetSignatureCount())
+
+	assert.ErrorIs(t, device.Delete(at), domain.ErrDeviceDeleted)
+	_, err := device.Suspend("", at)
+	assert.ErrorIs(t, err, domain.ErrDeviceDeleted)
+}
+
+func TestNewSignatureDeviceRecordsCreation(t *testing.T) {
+	before := time.Now()
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "")
+	assert.False(t, device.GetCreatedAt().Before(before.Truncate(time.Second)))
+	assert.Equal(t, time.UTC, device.GetCreatedAt().Location())
+}
+
+func TestCheckSigningLimits(t *testing.T) {
+	at := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "")
+
+	// Without limits everything is allowed
+	assert.NoError(t, device.CheckSigningLimits(at, 1000))
+	_, limited := device.RemainingSignatures()
+	assert.False(t, limited)
+
+	device.SetSigningLimits(domain.NewSigningLimits(5, 2, at.Add(-time.Hour), at.Add(time.Hour)))
+	device.SetSignatureCount(3)
+	assert.NoError(t, device.CheckSigningLimits(at, 1))
+	assert.ErrorIs(t, device.CheckSigningLimits(at.Add(-2*time.Hour), 0), domain.ErrDeviceNotYetValid)
+	assert.ErrorIs(t, device.CheckSigningLimits(at.Add(2*time.Hour), 0), domain.ErrDeviceExpired)
+	assert.ErrorIs(t, device.CheckSigningLimits(at, 2), domain.ErrDailySignatureQuotaExhausted)
+
+	remaining, limited := device.RemainingSignatures()
+	assert.True(t, limited)
+	assert.Equal(t, uint64(2), remaining)
+	remaining, _ = device.RemainingSignaturesToday(1)
+	assert.Equal(t, uint64(1), remaining)
+
+	device.SetSignatureCount(5)
+	assert.ErrorIs(t, device.CheckSigningLimits(at, 0), domain.ErrSignatureQuotaExhausted)
+	remaining, _ = device.RemainingSignatures()
+	assert.Equal(t, uint64(0), remaining)
+}
+
+func TestStartOfDay(t *testing.T) {
+	at := time.Date(2024, 1, 2, 23, 30, 0, 0, time.FixedZone("CET", 3600))
+	assert.Equal(t, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), domain.StartOfDay(at))
+}
+
+func TestNewSignatureDeviceBelongsToDefaultTenant(t *testing.T) {
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "")
+	assert.Equal(t, domain.DefaultTenantID, device.GetTenantID())
+
+	device.SetTenantID("merchant-a")
+	assert.Equal(t, "merchant-a", device.Clone().GetTenantID())
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := domain.ParseScopes([]string{"sign", "devices:read", "sign"})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Scope{domain.ScopeSign, domain.ScopeDevicesRead}, scopes)
+
+	_, err = domain.ParseScopes([]string{"devices:delete"})
+	assert.ErrorIs(t, err, domain.ErrUnknownScope)
+}
+
+func TestAPIKey(t *testing.T) {
+	createdAt := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	key := domain.NewAPIKey("key-1", "merchant-a", "pos-1", "Register 1", "hash", []domain.Scope{domain.ScopeSign}, createdAt)
+	assert.True(t, key.HasScope(domain.ScopeSign))
+	assert.False(t, key.HasScope(domain.ScopeAdmin))
+	assert.False(t, key.IsRevoked())
+
+	// Scopes cannot be changed through the returned slice
+	key.GetScopes()[0] = domain.ScopeAdmin
+	assert.False(t, key.HasScope(domain.ScopeAdmin))
+
+	key.SetRevokedAt(createdAt.Add(time.Hour))
+	assert.True(t, key.IsRevoked())
+}
+
+func TestErrors(t *testing.T) {
+	// Wrapped errors keep the kind and code of the sentinel
+	err := fmt.Errorf("failed to sign: %w", domain.ErrDeviceNotActive)
+	assert.ErrorIs(t, err, domain.ErrDeviceNotActive)
+	assert.Equal(t, domain.KindConflict, domain.KindOf(err))
+	assert.Equal(t, "device_not_active", domain.CodeOf(err))
+
+	// Errors without a kind are internal
+	assert.Equal(t, domain.KindInternal, domain.KindOf(errors.New("disk full")))
+	assert.Empty(t, domain.CodeOf(errors.New("disk full")))
+
+	// Validation errors add field details without changing the message
+	err = domain.NewValidationError(domain.ErrDeviceNotFound, domain.FieldError{Field: "id", Message: "is unknown"})
+	assert.EqualError(t, err, "device not found")
+	assert.ErrorIs(t, err, domain.ErrDeviceNotFound)
+	assert.Equal(t, domain.KindNotFound, domain.KindOf(err))
+	assert.Equal(t, []domain.FieldError{{Field: "id", Message: "is unknown"}}, domain.FieldErrorsOf(fmt.Errorf("lookup: %w", err)))
+	assert.Nil(t, domain.FieldErrorsOf(domain.ErrDeviceNotFound))
+}
@@ -0,0 +1,138 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// status returns a checker reporting a single result with the given status
+func status(status health.Status) health.Checker {
+	return health.CheckerFunc(func(ctx context.Context) []health.Result {
+		return []health.Result{{Status: status}}
+	})
+}
+
+// pinger is a health.Pinger returning err
+type pinger struct {
+	err error
+}
+
+func (p pinger) Ping(ctx context.Context) error {
+	return p.err
+}
+
+func TestRegistryReportsWorstStatus(t *testing.T) {
+	registry := health.NewRegistry()
+	registry.Register("uptime", health.Liveness, status(health.StatusPass))
+	registry.Register("disk:free", health.Readiness, status(health.StatusWarn))
+
+	report := registry.Run(context.Background(), health.Readiness)
+	assert.Equal(t, health.StatusWarn, report.Status)
+	assert.Len(t, report.Checks, 2)
+	assert.False(t, report.Checks["uptime"][0].Time.IsZero())
+
+	// The liveness probe leaves out the readiness checks
+	report = registry.Run(context.Background(), health.Liveness)
+	assert.Equal(t, health.StatusPass, report.Status)
+	assert.Len(t, report.Checks, 1)
+	assert.Contains(t, report.Checks, "uptime")
+
+	// A check registered again replaces the earlier one
+	registry.Register("disk:free", health.Readiness, status(health.StatusFail))
+	report = registry.Run(context.Background(), health.Readiness)
+	assert.Equal(t, health.StatusFail, report.Status)
+	assert.Len(t, report.Checks, 2)
+}
+
+func TestRegistryFailsBrokenChecks(t *testing.T) {
+	registry := health.NewRegistry()
+	registry.SetTimeout(50 * time.Millisecond)
+	registry.Register("slow", health.Readiness, health.CheckerFunc(func(ctx context.Context) []health.Result {
+		time.Sleep(time.Second)
+		return []health.Result{{Status: health.StatusPass}}
+	}))
+	registry.Register("panicking", health.Readiness, health.CheckerFunc(func(ctx context.Context) []health.Result {
+		panic("boom")
+	}))
+	registry.Register("empty", health.Readiness, health.CheckerFunc(func(ctx context.Context) []health.Result {
+		return nil
+	}))
+
+	started := time.Now()
+	report := registry.Run(context.Background(), health.Readiness)
+	assert.Less(t, time.Since(started), 500*time.Millisecond)
+	assert.Equal(t, health.StatusFail, report.Status)
+	assert.Contains(t, report.Checks["slow"][0].Output, "did not complete")
+	assert.Contains(t, report.Checks["panicking"][0].Output, "boom")
+	assert.Equal(t, health.StatusFail, report.Checks["empty"][0].Status)
+}
+
+func TestConnectivity(t *testing.T) {
+	result := health.Connectivity(pinger{}).Check(context.Background())
+	require.Len(t, result, 1)
+	assert.Equal(t, health.StatusPass, result[0].Status)
+	assert.Equal(t, "ms", result[0].ObservedUnit)
+
+	result = health.Connectivity(pinger{errors.New("database is locked")}).Check(context.Background())
+	assert.Equal(t, health.StatusFail, result[0].Status)
+	assert.Equal(t, "database is locked", result[0].Output)
+}
+
+func TestCryptoSelfTest(t *testing.T) {
+	checker := health.CryptoSelfTest(crypto.NewKeyPairFactory(), domain.ECC, domain.RSA, domain.AlgorithmType("DSA"))
+
+	// The keys are reused, so repeated checks keep passing with the same keys
+	for i := 0; i < 3; i++ {
+		results := checker.Check(context.Background())
+		require.Len(t, results, 3)
+		assert.Equal(t, "ECC", results[0].ComponentID)
+		assert.Equal(t, health.StatusPass, results[0].Status)
+		assert.Equal(t, "RSA", results[1].ComponentID)
+		assert.Equal(t, health.StatusPass, results[1].Status)
+		assert.Equal(t, health.StatusFail, results[2].Status)
+		assert.Equal(t, "unsupported algorithm", results[2].Output)
+	}
+}
+
+func TestKeyGeneration(t *testing.T) {
+	checker := health.KeyGeneration(crypto.NewKeyPairFactory(), time.Minute, domain.ECC, domain.RSA, domain.AlgorithmType("DSA"))
+	results := checker.Check(context.Background())
+	require.Len(t, results, 3)
+	for i, algorithm := range []string{"ECC", "RSA"} {
+		assert.Equal(t, algorithm, results[i].ComponentID)
+		assert.Equal(t, health.StatusPass, results[i].Status)
+		assert.Equal(t, "ms", results[i].ObservedUnit)
+	}
+	assert.Equal(t, health.StatusFail, results[2].Status)
+	assert.Equal(t, "unsupported algorithm", results[2].Output)
+
+	// Keys are not generated on every check; the last measurement is reported with the time it was taken
+	assert.Equal(t, results, checker.Check(context.Background()))
+
+	// Generating keys slower than the threshold is a warning
+	results = health.KeyGeneration(crypto.NewKeyPairFactory(crypto.WithRSAKeyBits(2048)), time.Nanosecond, domain.RSA).Check(context.Background())
+	require.Len(t, results, 1)
+	assert.Equal(t, health.StatusWarn, results[0].Status)
+	assert.Equal(t, "key generation took longer than 1ns", results[0].Output)
+}
+
+func TestDiskSpace(t *testing.T) {
+	results := health.DiskSpace(t.TempDir(), 1).Check(context.Background())
+	require.Len(t, results, 1)
+	assert.Equal(t, health.StatusPass, results[0].Status)
+	assert.Equal(t, "bytes", results[0].ObservedUnit)
+
+	results = health.DiskSpace(t.TempDir(), 1<<62).Check(context.Background())
+	assert.Equal(t, health.StatusFail, results[0].Status)
+
+	results = health.DiskSpace("/does/not/exist", 1).Check(context.Background())
+	assert.Equal(t, health.StatusWarn, results[0].Status)
+}
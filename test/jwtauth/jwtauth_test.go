@@ -0,0 +1,307 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/jwtauth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	issuer   = "https://issuer.example.com/"
+	audience = "signing-service"
+)
+
+// signingKey is a private key of the tests with the JWK of its public key
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+func newRSAKey(t *testing.T, kid string) signingKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return signingKey{kid: kid, method: jwt.SigningMethodRS256, private: key}
+}
+
+func newECKey(t *testing.T, kid string) signingKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return signingKey{kid: kid, method: jwt.SigningMethodES256, private: key}
+}
+
+func newEd25519Key(t *testing.T, kid string) signingKey {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return signingKey{kid: kid, method: jwt.SigningMethodEdDSA, private: key}
+}
+
+// jwk returns the JWK of the public key
+func (key signingKey) jwk() map[string]string {
+	encode := base64.RawURLEncoding.EncodeToString
+	switch public := key.private.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": key.kid, "n": encode(public.N.Bytes()), "e": encode(big.NewInt(int64(public.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": key.kid, "crv": "P-256", "x": encode(public.X.FillBytes(make([]byte, 32))), "y": encode(public.Y.FillBytes(make([]byte, 32)))}
+	default:
+		return map[string]string{"kty": "OKP", "kid": key.kid, "crv": "Ed25519", "x": encode(public.(ed25519.PublicKey))}
+	}
+}
+
+// sign issues a token with the given claims
+func (key signingKey) sign(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	signed, err := token.SignedString(key.private)
+	require.NoError(t, err)
+	return signed
+}
+
+// validClaims returns claims the verifier accepts
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":       issuer,
+		"aud":       audience,
+		"sub":       "pos-1",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"tenant_id": "tenant-a",
+	}
+}
+
+// keySetDocument returns the JWKS of the keys
+func keySetDocument(t *testing.T, keys ...signingKey) []byte {
+	jwks := map[string][]map[string]string{"keys": {}}
+	for _, key := range keys {
+		jwks["keys"] = append(jwks["keys"], key.jwk())
+	}
+	document, err := json.Marshal(jwks)
+	require.NoError(t, err)
+	return document
+}
+
+// writeKeySet writes the JWKS of the keys to a temporary file
+func writeKeySet(t *testing.T, keys ...signingKey) string {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, keySetDocument(t, keys...), 0o600))
+	return path
+}
+
+func TestVerifySupportedAlgorithms(t *testing.T) {
+	ctx := context.Background()
+	keys := []signingKey{newRSAKey(t, "rsa"), newECKey(t, "ec"), newEd25519Key(t, "ed25519")}
+	source, err := jwtauth.LoadKeySource(ctx, writeKeySet(t, keys...))
+	require.NoError(t, err)
+	verifier := jwtauth.NewVerifier(source, issuer, audience)
+
+	for _, key := range keys {
+		claims, err := verifier.Verify(ctx, key.sign(t, validClaims()))
+		require.NoError(t, err, key.kid)
+		assert.Equal(t, "tenant-a", claims["tenant_id"], key.kid)
+	}
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	ctx := context.Background()
+	key := newECKey(t, "ec")
+	rsaKey := newRSAKey(t, "rsa")
+	source, err := jwtauth.LoadKeySource(ctx, writeKeySet(t, key, rsaKey))
+	require.NoError(t, err)
+	verifier := jwtauth.NewVerifier(source, issuer, audience)
+
+	with := func(name string, value interface{}) jwt.MapClaims {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	tokens := map[string]string{
+		"expired":         key.sign(t, with("exp", time.Now().Add(-time.Hour).Unix())),
+		"without expiry":  key.sign(t, with("exp", nil)),
+		"not yet valid":   key.sign(t, with("nbf", time.Now().Add(time.Hour).Unix())),
+		"wrong issuer":    key.sign(t, with("iss", "https://other.example.com/")),
+		"without issuer":  key.sign(t, with("iss", nil)),
+		"wrong audience":  key.sign(t, with("aud", "other-service")),
+		"unknown key":     newECKey(t, "other").sign(t, validClaims()),
+		"unknown key ID":  signingKey{kid: "rsa", method: jwt.SigningMethodES256, private: newECKey(t, "").private}.sign(t, validClaims()),
+		"malformed":       "not-a-token",
+		"tampered claims": key.sign(t, validClaims())[:10] + "x" + key.sign(t, validClaims())[11:],
+	}
+
+	// Tokens MACed with the public key as HMAC secret must not be accepted (algorithm confusion)
+	rsaPublic := rsaKey.private.Public().(*rsa.PublicKey)
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims())
+	hmacToken.Header["kid"] = "rsa"
+	tokens["HS256"], err = hmacToken.SignedString(rsaPublic.N.Bytes())
+	require.NoError(t, err)
+
+	// Unsigned tokens must not be accepted
+	noneToken := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims())
+	noneToken.Header["kid"] = "ec"
+	tokens["none"], err = noneToken.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+
+	for name, token := range tokens {
+		_, err := verifier.Verify(ctx, token)
+		assert.ErrorIs(t, err, jwtauth.ErrInvalidToken, name)
+	}
+}
+
+func TestParseKeySet(t *testing.T) {
+	ctx := context.Background()
+	set, err := jwtauth.ParseKeySet(keySetDocument(t, newEd25519Key(t, "ed25519")))
+	require.NoError(t, err)
+	_, _, err = set.Key(ctx, "ed25519")
+	assert.NoError(t, err)
+	_, _, err = set.Key(ctx, "other")
+	assert.ErrorIs(t, err, jwtauth.ErrUnknownKey)
+
+	// Encryption keys are skipped
+	set, err = jwtauth.ParseKeySet([]byte(`{"keys": [{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"}]}`))
+	require.NoError(t, err)
+	_, _, err = set.Key(ctx, "enc")
+	assert.ErrorIs(t, err, jwtauth.ErrUnknownKey)
+
+	for _, document := range []string{
+		`not json`,
+		`{"keys": [{"kty": "oct", "kid": "secret", "k": "c2VjcmV0"}]}`,
+		`{"keys": [{"kty": "RSA", "kid": "short", "n": "AQAB", "e": "AQAB"}]}`,
+		`{"keys": [{"kty": "EC", "kid": "p384", "crv": "P-384", "x": "AQAB", "y": "AQAB"}]}`,
+		`{"keys": [{"kty": "EC", "kid": "off-curve", "crv": "P-256", "x": "AQAB", "y": "AQAB"}]}`,
+		`{"keys": [{"kty": "OKP", "kid": "short", "crv": "Ed25519", "x": "AQAB"}]}`,
+	} {
+		_, err := jwtauth.ParseKeySet([]byte(document))
+		assert.Error(t, err, document)
+	}
+}
+
+func TestVerifyKeyRestrictedToAlgorithm(t *testing.T) {
+	ctx := context.Background()
+	key := newRSAKey(t, "rsa")
+	jwk := key.jwk()
+	jwk["alg"] = "PS256"
+	document, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{jwk}})
+	require.NoError(t, err)
+	set, err := jwtauth.ParseKeySet(document)
+	require.NoError(t, err)
+
+	_, err = jwtauth.NewVerifier(set, issuer, audience).Verify(ctx, key.sign(t, validClaims()))
+	assert.ErrorIs(t, err, jwtauth.ErrInvalidToken)
+}
+
+func TestRemoteKeySetRefreshesOnUnknownKey(t *testing.T) {
+	ctx := context.Background()
+	oldKey, newKey := newECKey(t, "old"), newEd25519Key(t, "new")
+	var document atomic.Value
+	document.Store(keySetDocument(t, oldKey))
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write(document.Load().([]byte))
+	}))
+	defer server.Close()
+
+	source, err := jwtauth.NewRemoteKeySet(ctx, server.URL, server.Client(), time.Second)
+	require.NoError(t, err)
+	verifier := jwtauth.NewVerifier(source, issuer, audience)
+	_, err = verifier.Verify(ctx, oldKey.sign(t, validClaims()))
+	require.NoError(t, err)
+
+	// The issuer rotates its keys; unknown key IDs trigger a refresh, but not more often than allowed
+	document.Store(keySetDocument(t, oldKey, newKey))
+	fetchesBefore := fetches.Load()
+	_, err = verifier.Verify(ctx, newECKey(t, "unknown").sign(t, validClaims()))
+	assert.ErrorIs(t, err, jwtauth.ErrInvalidToken)
+	_, err = verifier.Verify(ctx, newKey.sign(t, validClaims()))
+	assert.ErrorIs(t, err, jwtauth.ErrInvalidToken)
+	assert.Equal(t, fetchesBefore, fetches.Load())
+
+	time.Sleep(250 * time.Millisecond)
+	_, err = verifier.Verify(ctx, newKey.sign(t, validClaims()))
+	assert.NoError(t, err)
+}
+
+func TestRemoteKeySetServesCachedKeysWhileRefreshing(t *testing.T) {
+	ctx := context.Background()
+	key := newECKey(t, "ec")
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every fetch after the first one hangs until the end of the test
+		if fetches.Add(1) > 1 {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.Write(keySetDocument(t, key))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	source, err := jwtauth.NewRemoteKeySet(ctx, server.URL, server.Client(), 100*time.Millisecond)
+	require.NoError(t, err)
+	verifier := jwtauth.NewVerifier(source, issuer, audience)
+	time.Sleep(150 * time.Millisecond)
+
+	// The stale keys are refreshed in the background, so tokens of known keys are verified right away
+	started := time.Now()
+	_, err = verifier.Verify(ctx, key.sign(t, validClaims()))
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(started), 50*time.Millisecond)
+
+	// Unknown keys wait for the hanging refresh only as long as the request allows
+	requestCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	started = time.Now()
+	_, err = verifier.Verify(requestCtx, newECKey(t, "unknown").sign(t, validClaims()))
+	assert.ErrorIs(t, err, jwtauth.ErrInvalidToken)
+	assert.Less(t, time.Since(started), time.Second)
+	assert.Equal(t, int32(2), fetches.Load())
+}
+
+func TestLoadKeySourceFromURL(t *testing.T) {
+	ctx := context.Background()
+	key := newRSAKey(t, "rsa")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/jwks.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(keySetDocument(t, key))
+	}))
+	defer server.Close()
+
+	source, err := jwtauth.LoadKeySource(ctx, server.URL+"/jwks.json")
+	require.NoError(t, err)
+	_, err = jwtauth.NewVerifier(source, issuer, audience).Verify(ctx, key.sign(t, validClaims()))
+	assert.NoError(t, err)
+
+	_, err = jwtauth.LoadKeySource(ctx, server.URL+"/missing.json")
+	assert.Error(t, err)
+	_, err = jwtauth.LoadKeySource(ctx, filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
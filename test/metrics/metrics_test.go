@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/metrics"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// counter is a metrics.DeviceCounter returning a fixed count
+type counter struct {
+	count int
+	err   error
+}
+
+func (c counter) CountDevices(ctx context.Context) (int, error) {
+	return c.count, c.err
+}
+
+func TestOutcome(t *testing.T) {
+	assert.Equal(t, metrics.OutcomeSuccess, metrics.Outcome(nil))
+	assert.Equal(t, "device_not_found", metrics.Outcome(domain.NewError(domain.KindNotFound, "device_not_found", "device not found")))
+	assert.Equal(t, metrics.OutcomeInternalError, metrics.Outcome(errors.New("disk full")))
+}
+
+func TestCountersBoundAlgorithmLabels(t *testing.T) {
+	m := metrics.New()
+	m.TransactionSigned(domain.ECC, nil)
+	m.TransactionSigned("", errors.New("disk full"))
+	m.TransactionSigned(domain.AlgorithmType("DSA; DROP TABLE"), nil)
+	m.DeviceCreated(domain.RSA, nil)
+
+	expected := `
+# HELP signing_signatures_total Transactions signed, by algorithm and outcome.
+# TYPE signing_signatures_total counter
+signing_signatures_total{algorithm="ECC",outcome="success"} 1
+signing_signatures_total{algorithm="unknown",outcome="internal_error"} 1
+signing_signatures_total{algorithm="unsupported",outcome="success"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(m.Registry(), strings.NewReader(expected), "signing_signatures_total"))
+	count, err := testutil.GatherAndCount(m.Registry(), "signing_device_creations_total")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestDevicesGauge(t *testing.T) {
+	m := metrics.New()
+	expected := func(value string) *strings.Reader {
+		return strings.NewReader("# HELP signing_devices Signature devices that have not been deleted.\n# TYPE signing_devices gauge\nsigning_devices " + value + "\n")
+	}
+	assert.NoError(t, testutil.GatherAndCompare(m.Registry(), expected("0"), "signing_devices"))
+
+	m.TrackDevices(counter{count: 3})
+	assert.NoError(t, testutil.GatherAndCompare(m.Registry(), expected("3"), "signing_devices"))
+
+	// A failing count is reported as -1 rather than a stale value
+	m.TrackDevices(counter{err: errors.New("database is locked")})
+	assert.NoError(t, testutil.GatherAndCompare(m.Registry(), expected("-1"), "signing_devices"))
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	m := metrics.New()
+	handler := m.InstrumentInFlight(m.InstrumentHandler("/api/v1/devices/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.WriteHeader(http.StatusOK)
+	})))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/devices/1", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/devices/2", nil))
+
+	// The route keeps the label values bounded, and the first status code written counts
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, recorder.Body.String(), `signing_http_request_duration_seconds_count{code="404",method="GET",route="/api/v1/devices/{id}"} 2`)
+	assert.Contains(t, recorder.Body.String(), "signing_http_requests_in_flight 0")
+}
+
+func TestObservedRepository(t *testing.T) {
+	ctx := context.Background()
+	m := metrics.New()
+	repo := persistence.NewObservedRepository(persistence.NewInMemoryDeviceRepository(), m.ObserveRepositoryOperation)
+
+	_, err := repo.GetDevice(ctx, "missing")
+	require.Error(t, err)
+	_, err = repo.AddDevice(ctx, "1", "Register 1", domain.ECC, "public", []byte("private"), "")
+	require.NoError(t, err)
+	count, err := repo.CountDevices(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, recorder.Body.String(), `signing_repository_operation_duration_seconds_count{operation="AddDevice",outcome="success"} 1`)
+	assert.Contains(t, recorder.Body.String(), `signing_repository_operation_duration_seconds_count{operation="CountDevices",outcome="success"} 1`)
+	assert.NotContains(t, recorder.Body.String(), `operation="GetDevice",outcome="success"`)
+}
+
+func TestNilMetricsRecordNothing(t *testing.T) {
+	var m *metrics.Metrics
+	assert.NotPanics(t, func() {
+		m.DeviceCreated(domain.ECC, nil)
+		m.TransactionSigned(domain.ECC, nil)
+		m.ObserveKeyGeneration(domain.ECC, time.Millisecond)
+		m.ObserveSigning(domain.ECC, time.Millisecond)
+		m.ObserveRepositoryOperation(context.Background(), "GetDevice", time.Millisecond, nil)
+		m.TrackDevices(counter{})
+	})
+	handler := http.NotFoundHandler()
+	assert.NotNil(t, m.InstrumentHandler("/", handler))
+}
@@ -1,22 +1,26 @@
 package persistence
 
 import (
+	"context"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 func TestAddDevice(t *testing.T) {
+	ctx := context.Background()
 	repo := persistence.NewInMemoryDeviceRepository()
 	id := "device-1"
 	label := "Test Device"
 	algorithm := domain.AlgorithmType("RSA")
 	publicKey := "public-key"
-	privateKey := "private-key"
+	privateKey := []byte("private-key")
 	lastSignature := "signature-1"
 
-	device, err := repo.AddDevice(id, label, algorithm, publicKey, privateKey, lastSignature)
+	device, err := repo.AddDevice(ctx, id, label, algorithm, publicKey, privateKey, lastSignature)
 	assert.NoError(t, err)
 	assert.NotNil(t, device)
 	assert.Equal(t, id, device.GetID())
@@ -25,79 +29,556 @@ func TestAddDevice(t *testing.T) {
 }
 
 func TestGetDevice(t *testing.T) {
+	ctx := context.Background()
 	repo := persistence.NewInMemoryDeviceRepository()
 	id := "device-1"
-	repo.AddDevice(id, "Test Device", domain.AlgorithmType("RSA"), "public-key", "private-key", "signature-1")
+	repo.AddDevice(ctx, id, "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
 
-	device, err := repo.GetDevice(id)
+	device, err := repo.GetDevice(ctx, id)
 	assert.NoError(t, err)
 	assert.NotNil(t, device)
 	assert.Equal(t, id, device.GetID())
 }
 
 func TestGetDevice_NonExistent(t *testing.T) {
+	ctx := context.Background()
 	repo := persistence.NewInMemoryDeviceRepository()
-	_, err := repo.GetDevice("non-existent")
+	_, err := repo.GetDevice(ctx, "non-existent")
 	assert.Error(t, err)
 	assert.EqualError(t, err, "device not found")
 }
 
 func TestListDevices(t *testing.T) {
+	ctx := context.Background()
 	repo := persistence.NewInMemoryDeviceRepository()
 	id := "device-1"
-	repo.AddDevice(id, "Test Device", domain.AlgorithmType("RSA"), "public-key", "private-key", "signature-1")
+	repo.AddDevice(ctx, id, "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
 
-	devices, err := repo.ListDevices()
+	devices, err := repo.ListDevices(ctx)
 	assert.NoError(t, err)
 	assert.Len(t, devices, 1)
 	assert.Equal(t, id, devices[0].GetID())
 }
 
 func TestListDevices_NoDevices(t *testing.T) {
+	ctx := context.Background()
 	repo := persistence.NewInMemoryDeviceRepository()
-	devices, err := repo.ListDevices()
+	devices, err := repo.ListDevices(ctx)
 	assert.Error(t, err)
 	assert.EqualError(t, err, "no devices found")
 	assert.Nil(t, devices)
 }
 
 func TestIncrementSignatureCount(t *testing.T) {
+	ctx := context.Background()
 	repo := persistence.NewInMemoryDeviceRepository()
 	id := "device-1"
-	repo.AddDevice(id, "Test Device", domain.AlgorithmType("RSA"), "public-key", "private-key", "signature-1")
+	repo.AddDevice(ctx, id, "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
 
-	err := repo.IncrementSignatureCount(id)
+	err := repo.IncrementSignatureCount(ctx, id)
 	assert.NoError(t, err)
 
-	device, err := repo.GetDevice(id)
+	device, err := repo.GetDevice(ctx, id)
 	assert.NoError(t, err)
 	assert.Equal(t, uint64(1), device.GetSignatureCount())
 }
 
 func TestIncrementSignatureCount_NonExistent(t *testing.T) {
+	ctx := context.Background()
 	repo := persistence.NewInMemoryDeviceRepository()
-	err := repo.IncrementSignatureCount("non-existent")
+	err := repo.IncrementSignatureCount(ctx, "non-existent")
 	assert.Error(t, err)
 	assert.EqualError(t, err, "device not found")
 }
 
 func TestUpdateLastSignature(t *testing.T) {
+	ctx := context.Background()
 	repo := persistence.NewInMemoryDeviceRepository()
 	id := "device-1"
-	repo.AddDevice(id, "Test Device", domain.AlgorithmType("RSA"), "public-key", "private-key", "signature-1")
+	repo.AddDevice(ctx, id, "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "signature-1")
 
 	newSignature := "signature-2"
-	err := repo.UpdateLastSignature(id, newSignature)
+	err := repo.UpdateLastSignature(ctx, id, newSignature)
 	assert.NoError(t, err)
 
-	device, err := repo.GetDevice(id)
+	device, err := repo.GetDevice(ctx, id)
 	assert.NoError(t, err)
 	assert.Equal(t, newSignature, device.GetLastSignature())
 }
 
 func TestUpdateLastSignature_NonExistent(t *testing.T) {
+	ctx := context.Background()
 	repo := persistence.NewInMemoryDeviceRepository()
-	err := repo.UpdateLastSignature("non-existent", "new-signature")
+	err := repo.UpdateLastSignature(ctx, "non-existent", "new-signature")
 	assert.Error(t, err)
 	assert.EqualError(t, err, "device not found")
 }
+
+func TestCreateDevice(t *testing.T) {
+	ctx := context.Background()
+	repo := persistence.NewInMemoryDeviceRepository()
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("ECC"), "public-key", []byte("private-key"), "")
+	device.SetSignedDataFormat("v2")
+
+	assert.NoError(t, repo.CreateDevice(ctx, device))
+	assert.EqualError(t, repo.CreateDevice(ctx, device), "device with this ID already exists")
+
+	stored, err := repo.GetDevice(ctx, "device-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", stored.GetSignedDataFormat())
+}
+
+func TestSignatureRecords(t *testing.T) {
+	ctx := context.Background()
+	repo := persistence.NewInMemoryDeviceRepository()
+	repo.AddDevice(ctx, "device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "")
+
+	now := time.Now().UTC()
+	assert.NoError(t, repo.AddSignatureRecord(ctx, domain.NewSignatureRecord("device-1", 1, "sig-1", "data-1", "utf8", "v2", now)))
+	assert.NoError(t, repo.AddSignatureRecord(ctx, domain.NewSignatureRecord("device-1", 0, "sig-0", "data-0", "utf8", "v1", now)))
+
+	records, err := repo.ListSignatureRecords(ctx, "device-1")
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, uint64(0), records[0].GetCounter())
+	assert.Equal(t, "v1", records[0].GetFormatVersion())
+	assert.Equal(t, "v2", records[1].GetFormatVersion())
+
+	assert.EqualError(t, repo.AddSignatureRecord(ctx, domain.NewSignatureRecord("non-existent", 0, "sig", "data", "utf8", "v1", now)), "device not found")
+}
+
+func TestAppendSignature(t *testing.T) {
+	testAppendSignature(t, persistence.NewInMemoryDeviceRepository())
+}
+
+// testAppendSignature checks that appending a signature stores it and advances the device; it is shared by
+// both backends
+func testAppendSignature(t *testing.T, repo persistence.DeviceRepository) {
+	ctx := context.Background()
+	_, err := repo.AddDevice(ctx, "device-1", "Test Device", domain.AlgorithmType("ECC"), "public-key", []byte("private-key"), "")
+	require.NoError(t, err)
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	require.NoError(t, repo.AppendSignature(ctx, domain.NewSignatureRecord("device-1", 0, "sig-0", "data-0", "utf8", "v1", createdAt)))
+	require.NoError(t, repo.AppendSignature(ctx, domain.NewSignatureRecord("device-1", 1, "sig-1", "data-1", "utf8", "v1", createdAt)))
+	device, err := repo.GetDevice(ctx, "device-1")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), device.GetSignatureCount())
+	assert.Equal(t, "sig-1", device.GetLastSignature())
+
+	// A counter the device has moved past, or not reached yet, leaves the device and its signatures as they were
+	for _, counter := range []uint64{1, 3} {
+		err = repo.AppendSignature(ctx, domain.NewSignatureRecord("device-1", counter, "sig-x", "data-x", "utf8", "v1", createdAt))
+		assert.ErrorIs(t, err, domain.ErrSignatureCounterConflict)
+	}
+	device, err = repo.GetDevice(ctx, "device-1")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), device.GetSignatureCount())
+	assert.Equal(t, "sig-1", device.GetLastSignature())
+	records, err := repo.ListSignatureRecords(ctx, "device-1")
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "sig-1", records[1].GetSignature())
+
+	err = repo.AppendSignature(ctx, domain.NewSignatureRecord("non-existent", 0, "sig", "data", "utf8", "v1", createdAt))
+	assert.ErrorIs(t, err, domain.ErrDeviceNotFound)
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	ctx := context.Background()
+	repo := persistence.NewInMemoryDeviceRepository()
+	repo.AddDevice(ctx, "device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "")
+
+	createdAt := time.Now().UTC()
+	record := domain.NewSignatureRecord("device-1", 0, "sig-0", "data-0", "utf8", "v1", createdAt)
+	record.SetIdempotencyKey("key-1", "hash-1")
+	assert.NoError(t, repo.AddSignatureRecord(ctx, record))
+
+	found, err := repo.GetSignatureRecordByIdempotencyKey(ctx, "device-1", "key-1", createdAt.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, "hash-1", found.GetRequestHash())
+
+	// Keys are scoped per device and ignored once they are older than notBefore
+	_, err = repo.GetSignatureRecordByIdempotencyKey(ctx, "device-2", "key-1", createdAt.Add(-time.Hour))
+	assert.EqualError(t, err, "signature not found")
+	_, err = repo.GetSignatureRecordByIdempotencyKey(ctx, "device-1", "key-1", createdAt.Add(time.Second))
+	assert.EqualError(t, err, "signature not found")
+
+	assert.NoError(t, repo.ExpireIdempotencyKeys(ctx, createdAt.Add(time.Second)))
+	_, err = repo.GetSignatureRecordByIdempotencyKey(ctx, "device-1", "key-1", createdAt.Add(-time.Hour))
+	assert.EqualError(t, err, "signature not found")
+}
+
+func TestReuseExpiredIdempotencyKey(t *testing.T) {
+	testReuseExpiredIdempotencyKey(t, persistence.NewInMemoryDeviceRepository())
+}
+
+// testReuseExpiredIdempotencyKey checks that a key that expired but was not purged yet moves to the new
+// signature; it is shared by both backends
+func testReuseExpiredIdempotencyKey(t *testing.T, repo persistence.DeviceRepository) {
+	ctx := context.Background()
+	_, err := repo.AddDevice(ctx, "device-1", "Test Device", domain.AlgorithmType("ECC"), "public-key", []byte("private-key"), "")
+	require.NoError(t, err)
+	expired := time.Now().UTC().Add(-2 * time.Hour)
+	now := time.Now().UTC()
+
+	first := domain.NewSignatureRecord("device-1", 0, "sig-0", "data-0", "utf8", "v1", expired)
+	first.SetIdempotencyKey("key-1", "hash-1")
+	require.NoError(t, repo.AppendSignature(ctx, first))
+	_, err = repo.GetSignatureRecordByIdempotencyKey(ctx, "device-1", "key-1", now.Add(-time.Hour))
+	require.ErrorIs(t, err, domain.ErrSignatureNotFound)
+
+	second := domain.NewSignatureRecord("device-1", 1, "sig-1", "data-1", "utf8", "v1", now)
+	second.SetIdempotencyKey("key-1", "hash-2")
+	require.NoError(t, repo.AppendSignature(ctx, second))
+	third := domain.NewSignatureRecord("device-1", 2, "sig-2", "data-2", "utf8", "v1", now)
+	third.SetIdempotencyKey("key-1", "hash-3")
+	require.NoError(t, repo.AddSignatureRecord(ctx, third))
+
+	found, err := repo.GetSignatureRecordByIdempotencyKey(ctx, "device-1", "key-1", expired.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, "sig-2", found.GetSignature())
+	assert.Equal(t, "hash-3", found.GetRequestHash())
+	records, err := repo.ListSignatureRecords(ctx, "device-1")
+	require.NoError(t, err)
+	assert.Len(t, records, 3)
+}
+
+func TestUpdateDeviceStatus(t *testing.T) {
+	ctx := context.Background()
+	repo := persistence.NewInMemoryDeviceRepository()
+	repo.AddDevice(ctx, "device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "")
+
+	device, err := repo.GetDevice(ctx, "device-1")
+	assert.NoError(t, err)
+	transition, err := device.Suspend("register lost", time.Now())
+	assert.NoError(t, err)
+
+	// Changing the returned device does not change the stored one
+	stored, _ := repo.GetDevice(ctx, "device-1")
+	assert.Equal(t, domain.StatusActive, stored.GetStatus())
+
+	assert.NoError(t, repo.UpdateDeviceStatus(ctx, transition))
+	stored, _ = repo.GetDevice(ctx, "device-1")
+	assert.Equal(t, domain.StatusSuspended, stored.GetStatus())
+
+	// The same transition cannot be applied twice
+	assert.ErrorIs(t, repo.UpdateDeviceStatus(ctx, transition), domain.ErrInvalidStatusTransition)
+
+	transitions, err := repo.ListStatusTransitions(ctx, "device-1")
+	assert.NoError(t, err)
+	assert.Len(t, transitions, 1)
+	assert.Equal(t, "register lost", transitions[0].GetReason())
+}
+
+func TestUpdateDevice(t *testing.T) {
+	ctx := context.Background()
+	repo := persistence.NewInMemoryDeviceRepository()
+	repo.AddDevice(ctx, "device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "")
+	repo.IncrementSignatureCount(ctx, "device-1")
+
+	device, _ := repo.GetDevice(ctx, "device-1")
+	device.SetLabel("Register 1")
+	device.SetMetadata(map[string]string{"store": "Berlin"})
+	device.SetSignatureCount(0)
+	assert.NoError(t, repo.UpdateDevice(ctx, device))
+
+	// Only the label and metadata are updated
+	stored, _ := repo.GetDevice(ctx, "device-1")
+	assert.Equal(t, "Register 1", stored.GetLabel())
+	assert.Equal(t, map[string]string{"store": "Berlin"}, stored.GetMetadata())
+	assert.Equal(t, uint64(1), stored.GetSignatureCount())
+
+	missing := domain.NewSignatureDevice("non-existent", "", domain.AlgorithmType("RSA"), "", nil, "")
+	assert.EqualError(t, repo.UpdateDevice(ctx, missing), "device not found")
+}
+
+func TestDeleteDevice(t *testing.T) {
+	ctx := context.Background()
+	repo := persistence.NewInMemoryDeviceRepository()
+	privateKey := []byte("private-key")
+	repo.AddDevice(ctx, "device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", privateKey, "")
+	repo.IncrementSignatureCount(ctx, "device-1")
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, repo.DeleteDevice(ctx, "device-1", at))
+	// The repository owns the key it was given and zeroes it in place
+	assert.Equal(t, make([]byte, len("private-key")), privateKey)
+	assert.ErrorIs(t, repo.DeleteDevice(ctx, "device-1", at), domain.ErrDeviceDeleted)
+	assert.EqualError(t, repo.DeleteDevice(ctx, "non-existent", at), "device not found")
+
+	tombstone, err := repo.GetDevice(ctx, "device-1")
+	assert.NoError(t, err)
+	assert.Equal(t, at, tombstone.GetDeletedAt())
+	assert.Empty(t, tombstone.GetPrivateKey())
+	assert.Equal(t, "public-key", tombstone.GetPublicKey())
+	assert.Equal(t, uint64(1), tombstone.GetSignatureCount())
+
+	// The tombstone keeps the ID from being reused
+	_, err = repo.AddDevice(ctx, "device-1", "New Device", domain.AlgorithmType("RSA"), "public-key-2", []byte("private-key-2"), "")
+	assert.EqualError(t, err, "device with this ID already exists")
+}
+
+// testQueryDevices checks filtering, sorting and pagination of a repository; it is shared by both backends
+func testQueryDevices(t *testing.T, repo persistence.DeviceRepository) {
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, spec := range []struct {
+		id, label string
+		algorithm domain.AlgorithmType
+		status    domain.DeviceStatus
+	}{
+		{"device-c", "Berlin 1", domain.RSA, domain.StatusActive},
+		{"device-a", "Berlin 2", domain.ECC, domain.StatusSuspended},
+		{"device-e", "berlin 3", domain.ECC, domain.StatusActive},
+		{"device-b", "Vienna 1", domain.ECC, domain.StatusActive},
+		{"device-d", "Berlin 4", domain.ECC, domain.StatusActive},
+	} {
+		device := domain.NewSignatureDevice(spec.id, spec.label, spec.algorithm, "public-key", []byte("private-key"), "")
+		device.SetStatus(spec.status)
+		device.SetCreatedAt(base.Add(time.Duration(i) * time.Hour))
+		require.NoError(t, repo.CreateDevice(ctx, device))
+	}
+	require.NoError(t, repo.DeleteDevice(ctx, "device-d", base.Add(time.Hour)))
+
+	ids := func(page *persistence.DevicePage) []string {
+		var ids []string
+		for _, device := range page.Devices {
+			ids = append(ids, device.GetID())
+			assert.Empty(t, device.GetPrivateKey(), "listings must not carry private keys")
+		}
+		return ids
+	}
+
+	for name, test := range map[string]struct {
+		query persistence.DeviceQuery
+		want  []string
+	}{
+		"default order":   {persistence.DeviceQuery{}, []string{"device-c", "device-a", "device-e", "device-b"}},
+		"include deleted": {persistence.DeviceQuery{IncludeDeleted: true}, []string{"device-c", "device-a", "device-e", "device-b", "device-d"}},
+		"algorithm":       {persistence.DeviceQuery{Algorithm: domain.RSA}, []string{"device-c"}},
+		"label prefix":    {persistence.DeviceQuery{LabelPrefix: "Berlin"}, []string{"device-c", "device-a"}},
+		"status":          {persistence.DeviceQuery{Status: domain.StatusSuspended}, []string{"device-a"}},
+		"created range": {persistence.DeviceQuery{CreatedFrom: base.Add(time.Hour), CreatedTo: base.Add(3 * time.Hour)},
+			[]string{"device-a", "device-e"}},
+		"by id":              {persistence.DeviceQuery{SortBy: persistence.SortByID}, []string{"device-a", "device-b", "device-c", "device-e"}},
+		"by label desc":      {persistence.DeviceQuery{SortBy: persistence.SortByLabel, Descending: true}, []string{"device-e", "device-b", "device-a", "device-c"}},
+		"by created at desc": {persistence.DeviceQuery{Descending: true, Limit: 2}, []string{"device-b", "device-e"}},
+	} {
+		page, err := repo.QueryDevices(ctx, test.query)
+		require.NoError(t, err, name)
+		assert.Equal(t, test.want, ids(page), name)
+	}
+
+	// Walking the pages returns every device exactly once, in order
+	for _, sortBy := range []string{persistence.SortByID, persistence.SortByLabel, persistence.SortByCreatedAt} {
+		for _, descending := range []bool{false, true} {
+			query := persistence.DeviceQuery{SortBy: sortBy, Descending: descending, IncludeDeleted: true, Limit: 2}
+			all, err := repo.QueryDevices(ctx, persistence.DeviceQuery{SortBy: sortBy, Descending: descending, IncludeDeleted: true})
+			require.NoError(t, err)
+
+			var walked []string
+			for pages := 0; pages < 10; pages++ {
+				page, err := repo.QueryDevices(ctx, query)
+				require.NoError(t, err)
+				walked = append(walked, ids(page)...)
+				if page.NextCursor == "" {
+					break
+				}
+				query.Cursor = page.NextCursor
+			}
+			assert.Equal(t, ids(all), walked, "sort %v descending %v", sortBy, descending)
+		}
+	}
+
+	page, err := repo.QueryDevices(ctx, persistence.DeviceQuery{Limit: 1})
+	require.NoError(t, err)
+	_, err = repo.QueryDevices(ctx, persistence.DeviceQuery{SortBy: persistence.SortByLabel, Cursor: page.NextCursor})
+	assert.ErrorIs(t, err, persistence.ErrInvalidCursor)
+	_, err = repo.QueryDevices(ctx, persistence.DeviceQuery{Cursor: "not-a-cursor"})
+	assert.ErrorIs(t, err, persistence.ErrInvalidCursor)
+	_, err = repo.QueryDevices(ctx, persistence.DeviceQuery{SortBy: "privateKey"})
+	assert.ErrorIs(t, err, persistence.ErrInvalidSortField)
+}
+
+func TestQueryDevices(t *testing.T) {
+	testQueryDevices(t, persistence.NewInMemoryDeviceRepository())
+}
+
+func TestCountSignatureRecords(t *testing.T) {
+	repo := persistence.NewInMemoryDeviceRepository()
+	testCountSignatureRecords(t, repo)
+}
+
+// testCountSignatureRecords checks counting signatures by creation time; it is shared by both backends
+func testCountSignatureRecords(t *testing.T, repo persistence.DeviceRepository) {
+	ctx := context.Background()
+	require.NoError(t, repo.CreateDevice(ctx, domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "")))
+
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	for i, createdAt := range []time.Time{day.Add(-time.Minute), day, day.Add(time.Hour)} {
+		require.NoError(t, repo.AddSignatureRecord(ctx, domain.NewSignatureRecord("device-1", uint64(i), "signature", "data", "utf8", "v1", createdAt)))
+	}
+
+	count, err := repo.CountSignatureRecords(ctx, "device-1", day)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+
+	_, err = repo.CountSignatureRecords(ctx, "non-existent", day)
+	assert.EqualError(t, err, "device not found")
+}
+
+func TestUpdateDevicePolicy(t *testing.T) {
+	testUpdateDevicePolicy(t, persistence.NewInMemoryDeviceRepository())
+}
+
+// testUpdateDevicePolicy checks that policy changes update the device and are audited; it is shared by both backends
+func testUpdateDevicePolicy(t *testing.T, repo persistence.DeviceRepository) {
+	ctx := context.Background()
+	require.NoError(t, repo.CreateDevice(ctx, domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "")))
+
+	first := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	require.NoError(t, repo.UpdateDevicePolicy(ctx, domain.NewPolicyChange("device-1", "", `{"maxPayloadSize":10}`, "admin", first)))
+	require.NoError(t, repo.UpdateDevicePolicy(ctx, domain.NewPolicyChange("device-1", `{"maxPayloadSize":10}`, "", "", second)))
+
+	device, err := repo.GetDevice(ctx, "device-1")
+	require.NoError(t, err)
+	assert.Equal(t, "", device.GetPolicy())
+	assert.Equal(t, second, device.GetUpdatedAt())
+
+	changes, err := repo.ListPolicyChanges(ctx, "device-1")
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.Equal(t, "", changes[0].GetPreviousPolicy())
+	assert.Equal(t, `{"maxPayloadSize":10}`, changes[0].GetPolicy())
+	assert.Equal(t, "admin", changes[0].GetChangedBy())
+	assert.Equal(t, first, changes[0].GetAt())
+	assert.Equal(t, `{"maxPayloadSize":10}`, changes[1].GetPreviousPolicy())
+	assert.Equal(t, second, changes[1].GetAt())
+
+	assert.EqualError(t, repo.UpdateDevicePolicy(ctx, domain.NewPolicyChange("non-existent", "", "", "", first)), "device not found")
+	_, err = repo.ListPolicyChanges(ctx, "non-existent")
+	assert.EqualError(t, err, "device not found")
+}
+
+func TestDeviceClientBinding(t *testing.T) {
+	testDeviceClientBinding(t, persistence.NewInMemoryDeviceRepository())
+}
+
+// testDeviceClientBinding checks that the client a device is bound to is stored, listed and updated; it is shared by both backends
+func testDeviceClientBinding(t *testing.T, repo persistence.DeviceRepository) {
+	ctx := context.Background()
+	device := domain.NewSignatureDevice("device-1", "Test Device", domain.AlgorithmType("ECC"), "public-key", []byte("private-key"), "")
+	device.SetBoundClient("register-1")
+	require.NoError(t, repo.CreateDevice(ctx, device))
+
+	stored, err := repo.GetDevice(ctx, "device-1")
+	require.NoError(t, err)
+	assert.Equal(t, "register-1", stored.GetBoundClient())
+	assert.True(t, stored.IsBoundTo("register-1"))
+	assert.False(t, stored.IsBoundTo("register-2"))
+
+	page, err := repo.QueryDevices(ctx, persistence.DeviceQuery{})
+	require.NoError(t, err)
+	require.Len(t, page.Devices, 1)
+	assert.Equal(t, "register-1", page.Devices[0].GetBoundClient())
+
+	stored.SetBoundClient("")
+	require.NoError(t, repo.UpdateDevice(ctx, stored))
+	stored, err = repo.GetDevice(ctx, "device-1")
+	require.NoError(t, err)
+	assert.Equal(t, "", stored.GetBoundClient())
+	assert.False(t, stored.IsBoundTo(""))
+}
+
+func TestTenantDevices(t *testing.T) {
+	testTenantDevices(t, persistence.NewInMemoryDeviceRepository())
+}
+
+// testTenantDevices checks that tenant-scoped lookups and queries never return devices of other tenants; it is shared by both backends
+func testTenantDevices(t *testing.T, repo persistence.DeviceRepository) {
+	ctx := context.Background()
+	for _, id := range []string{"device-a1", "device-a2", "device-b1"} {
+		device := domain.NewSignatureDevice(id, "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "")
+		device.SetTenantID("tenant-" + id[7:8])
+		require.NoError(t, repo.CreateDevice(ctx, device))
+	}
+
+	device, err := repo.GetTenantDevice(ctx, "tenant-a", "device-a1")
+	require.NoError(t, err)
+	assert.Equal(t, "tenant-a", device.GetTenantID())
+
+	_, err = repo.GetTenantDevice(ctx, "tenant-b", "device-a1")
+	assert.EqualError(t, err, "device not found")
+
+	page, err := repo.QueryDevices(ctx, persistence.DeviceQuery{TenantID: "tenant-a"})
+	require.NoError(t, err)
+	require.Len(t, page.Devices, 2)
+	for _, device := range page.Devices {
+		assert.Equal(t, "tenant-a", device.GetTenantID())
+	}
+
+	page, err = repo.QueryDevices(ctx, persistence.DeviceQuery{TenantID: "tenant-c"})
+	require.NoError(t, err)
+	assert.Empty(t, page.Devices)
+
+	// Devices created without a tenant belong to the default tenant
+	require.NoError(t, repo.CreateDevice(ctx, domain.NewSignatureDevice("device-d", "Test Device", domain.AlgorithmType("RSA"), "public-key", []byte("private-key"), "")))
+	_, err = repo.GetTenantDevice(ctx, domain.DefaultTenantID, "device-d")
+	assert.NoError(t, err)
+}
+
+func TestAPIKeys(t *testing.T) {
+	testAPIKeys(t, persistence.NewInMemoryDeviceRepository())
+}
+
+// testAPIKeys checks storing, looking up, listing and revoking API keys; it is shared by both backends
+func testAPIKeys(t *testing.T, repo persistence.DeviceRepository) {
+	ctx := context.Background()
+	createdAt := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	scopes := []domain.Scope{domain.ScopeDevicesRead, domain.ScopeSign}
+	require.NoError(t, repo.CreateAPIKey(ctx, domain.NewAPIKey("key-1", "merchant-a", "pos-1", "Register 1", "hash-1", scopes, createdAt)))
+	require.NoError(t, repo.CreateAPIKey(ctx, domain.NewAPIKey("key-2", "merchant-a", "pos-2", "Register 2", "hash-2", scopes, createdAt.Add(time.Minute))))
+	require.NoError(t, repo.CreateAPIKey(ctx, domain.NewAPIKey("key-3", "merchant-b", "pos-3", "Register 3", "hash-3", scopes, createdAt)))
+	assert.EqualError(t, repo.CreateAPIKey(ctx, domain.NewAPIKey("key-4", "merchant-b", "pos-4", "", "hash-1", scopes, createdAt)), "api key with this ID already exists")
+
+	key, err := repo.GetAPIKeyByHash(ctx, "hash-1")
+	require.NoError(t, err)
+	assert.Equal(t, "key-1", key.GetID())
+	assert.Equal(t, "merchant-a", key.GetTenantID())
+	assert.Equal(t, "pos-1", key.GetClientID())
+	assert.Equal(t, "Register 1", key.GetName())
+	assert.Equal(t, scopes, key.GetScopes())
+	assert.Equal(t, createdAt, key.GetCreatedAt())
+	assert.False(t, key.IsRevoked())
+
+	_, err = repo.GetAPIKeyByHash(ctx, "unknown")
+	assert.EqualError(t, err, "api key not found")
+
+	keys, err := repo.ListAPIKeys(ctx, "merchant-a")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "key-1", keys[0].GetID())
+	assert.Equal(t, "key-2", keys[1].GetID())
+
+	// Revoking again keeps the time of the first revocation
+	revokedAt := createdAt.Add(time.Hour)
+	require.NoError(t, repo.RevokeAPIKey(ctx, "merchant-a", "key-1", revokedAt))
+	require.NoError(t, repo.RevokeAPIKey(ctx, "merchant-a", "key-1", revokedAt.Add(time.Hour)))
+	key, err = repo.GetAPIKey(ctx, "merchant-a", "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, revokedAt, key.GetRevokedAt())
+
+	assert.EqualError(t, repo.RevokeAPIKey(ctx, "merchant-a", "unknown", revokedAt), "api key not found")
+	_, err = repo.GetAPIKey(ctx, "merchant-a", "unknown")
+	assert.EqualError(t, err, "api key not found")
+
+	// Keys of other tenants are not found
+	assert.EqualError(t, repo.RevokeAPIKey(ctx, "merchant-b", "key-2", revokedAt), "api key not found")
+	_, err = repo.GetAPIKey(ctx, "merchant-b", "key-2")
+	assert.EqualError(t, err, "api key not found")
+	key, err = repo.GetAPIKey(ctx, "merchant-a", "key-2")
+	require.NoError(t, err)
+	assert.False(t, key.IsRevoked())
+}
@@ -0,0 +1,141 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/policy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noon is a time inside the allowed hours used in the tests
+var noon = time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+
+func TestParseEmptyPolicy(t *testing.T) {
+	for _, document := range []string{"", "null", "{}"} {
+		parsed, err := policy.Parse([]byte(document))
+		require.NoError(t, err, document)
+		assert.Nil(t, parsed.Document(), document)
+		assert.NoError(t, parsed.Evaluate(policy.Input{At: noon}), document)
+	}
+}
+
+func TestParseInvalidPolicy(t *testing.T) {
+	for _, document := range []string{
+		`{"unknownRule": true}`,
+		`{"maxPayloadSize": -1}`,
+		`{"dataPattern": "("}`,
+		`{"dataSchema": {"type": "unknown"}}`,
+		`{"dataSchema": {"oneOf": []}}`,
+		`{"allowedHours": {"from": 8, "to": 8}}`,
+		`{"allowedHours": {"from": 8, "to": 25}}`,
+		`{"allowedHours": {"from": 8, "to": 18, "timeZone": "Nowhere/Special"}}`,
+		`{"allowedClients": [""]}`,
+		`[]`,
+	} {
+		_, err := policy.Parse([]byte(document))
+		assert.ErrorIs(t, err, policy.ErrInvalidPolicy, document)
+	}
+}
+
+func TestPolicyDocumentIsNormalized(t *testing.T) {
+	parsed, err := policy.Parse([]byte(`{ "allowedClients": ["pos-1"],  "maxPayloadSize": 10 }`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"maxPayloadSize": 10, "allowedClients": ["pos-1"]}`, string(parsed.Document()))
+}
+
+func TestEvaluateMaxPayloadSize(t *testing.T) {
+	parsed, err := policy.Parse([]byte(`{"maxPayloadSize": 5}`))
+	require.NoError(t, err)
+
+	assert.NoError(t, parsed.Evaluate(policy.Input{Data: []byte("12345"), At: noon}))
+	assertViolations(t, parsed.Evaluate(policy.Input{Data: []byte("123456"), At: noon}), policy.RuleMaxPayloadSize)
+}
+
+func TestEvaluateDataPattern(t *testing.T) {
+	parsed, err := policy.Parse([]byte(`{"dataPattern": "receipt-[0-9]+"}`))
+	require.NoError(t, err)
+
+	assert.NoError(t, parsed.Evaluate(policy.Input{Data: []byte("receipt-42"), At: noon}))
+	// The pattern has to match the whole data
+	assertViolations(t, parsed.Evaluate(policy.Input{Data: []byte("receipt-42 and more"), At: noon}), policy.RuleDataPattern)
+	// Digests cannot be matched
+	assertViolations(t, parsed.Evaluate(policy.Input{At: noon}), policy.RuleDataPattern)
+}
+
+func TestEvaluateDataSchema(t *testing.T) {
+	parsed, err := policy.Parse([]byte(`{"dataSchema": {
+		"type": "object",
+		"required": ["amount", "currency"],
+		"additionalProperties": false,
+		"properties": {
+			"amount": {"type": "integer", "minimum": 0, "maximum": 100000},
+			"currency": {"enum": ["EUR", "USD"]},
+			"items": {"type": "array", "maxItems": 2, "items": {"type": "string", "maxLength": 5}}
+		}
+	}}`))
+	require.NoError(t, err)
+
+	assert.NoError(t, parsed.Evaluate(policy.Input{Data: []byte(`{"amount": 250, "currency": "EUR", "items": ["tea"]}`), At: noon}))
+	for _, data := range []string{
+		`not json`,
+		`[]`,
+		`{"amount": 250}`,
+		`{"amount": 2.5, "currency": "EUR"}`,
+		`{"amount": -1, "currency": "EUR"}`,
+		`{"amount": 250, "currency": "GBP"}`,
+		`{"amount": 250, "currency": "EUR", "tip": 5}`,
+		`{"amount": 250, "currency": "EUR", "items": ["a", "b", "c"]}`,
+		`{"amount": 250, "currency": "EUR", "items": ["coffee"]}`,
+	} {
+		assertViolations(t, parsed.Evaluate(policy.Input{Data: []byte(data), At: noon}), policy.RuleDataSchema)
+	}
+}
+
+func TestEvaluateAllowedHours(t *testing.T) {
+	parsed, err := policy.Parse([]byte(`{"allowedHours": {"from": 8, "to": 18, "timeZone": "Europe/Berlin"}}`))
+	require.NoError(t, err)
+
+	// 12:00 UTC is 13:00 in Berlin, 17:30 UTC is 18:30
+	assert.NoError(t, parsed.Evaluate(policy.Input{At: noon}))
+	assertViolations(t, parsed.Evaluate(policy.Input{At: noon.Add(5*time.Hour + 30*time.Minute)}), policy.RuleAllowedHours)
+
+	overnight, err := policy.Parse([]byte(`{"allowedHours": {"from": 22, "to": 6}}`))
+	require.NoError(t, err)
+	assert.NoError(t, overnight.Evaluate(policy.Input{At: noon.Add(11 * time.Hour)}))
+	assert.NoError(t, overnight.Evaluate(policy.Input{At: noon.Add(-7 * time.Hour)}))
+	assertViolations(t, overnight.Evaluate(policy.Input{At: noon}), policy.RuleAllowedHours)
+}
+
+func TestEvaluateAllowedClients(t *testing.T) {
+	parsed, err := policy.Parse([]byte(`{"allowedClients": ["pos-1", "pos-2"]}`))
+	require.NoError(t, err)
+
+	assert.NoError(t, parsed.Evaluate(policy.Input{ClientID: "pos-2", At: noon}))
+	assertViolations(t, parsed.Evaluate(policy.Input{ClientID: "pos-3", At: noon}), policy.RuleAllowedClients)
+	assertViolations(t, parsed.Evaluate(policy.Input{At: noon}), policy.RuleAllowedClients)
+}
+
+func TestEvaluateReportsAllViolations(t *testing.T) {
+	parsed, err := policy.Parse([]byte(`{"maxPayloadSize": 3, "dataPattern": "[a-z]+", "allowedClients": ["pos-1"]}`))
+	require.NoError(t, err)
+
+	assertViolations(t, parsed.Evaluate(policy.Input{Data: []byte("1234"), At: noon}),
+		policy.RuleMaxPayloadSize, policy.RuleDataPattern, policy.RuleAllowedClients)
+}
+
+// assertViolations checks that err is a policy violation of exactly the given rules
+func assertViolations(t *testing.T, err error, rules ...string) {
+	t.Helper()
+	require.ErrorIs(t, err, policy.ErrPolicyViolation)
+
+	var violationErr *policy.ViolationError
+	require.True(t, errors.As(err, &violationErr))
+	violated := make([]string, 0, len(violationErr.Violations))
+	for _, violation := range violationErr.Violations {
+		violated = append(violated, violation.Rule)
+	}
+	assert.Equal(t, rules, violated)
+}
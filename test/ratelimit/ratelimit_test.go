@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+// start is the time of the first request in the tests
+var start = time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+
+func TestMemoryStoreTakesBurstThenRefills(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	limit := ratelimit.Limit{Rate: 2, Burst: 3}
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		decision := store.Take("client", limit, start)
+		assert.True(t, decision.Allowed)
+		assert.Equal(t, 3, decision.Limit)
+		assert.Equal(t, remaining, decision.Remaining)
+	}
+
+	decision := store.Take("client", limit, start)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, 0, decision.Remaining)
+	assert.Equal(t, 500*time.Millisecond, decision.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, decision.Reset)
+
+	// A token is refilled every half second
+	decision = store.Take("client", limit, start.Add(400*time.Millisecond))
+	assert.False(t, decision.Allowed)
+	assert.InDelta(t, float64(100*time.Millisecond), float64(decision.RetryAfter), float64(time.Microsecond))
+	decision = store.Take("client", limit, start.Add(500*time.Millisecond))
+	assert.True(t, decision.Allowed)
+
+	// The bucket never holds more than the burst
+	decision = store.Take("client", limit, start.Add(time.Hour))
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, 2, decision.Remaining)
+}
+
+func TestMemoryStoreKeepsBucketsApart(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	limit := ratelimit.Limit{Rate: 1, Burst: 1}
+
+	assert.True(t, store.Take("client:a", limit, start).Allowed)
+	assert.False(t, store.Take("client:a", limit, start).Allowed)
+	assert.True(t, store.Take("client:b", limit, start).Allowed)
+
+	// Idle buckets are dropped after they have refilled, which must not reset busy ones
+	assert.True(t, store.Take("client:b", limit, start.Add(time.Minute)).Allowed)
+	assert.False(t, store.Take("client:b", limit, start.Add(time.Minute)).Allowed)
+	assert.True(t, store.Take("client:a", limit, start.Add(time.Minute)).Allowed)
+}
+
+func TestLimitEnabled(t *testing.T) {
+	assert.True(t, ratelimit.Limit{Rate: 0.5, Burst: 1}.Enabled())
+	assert.False(t, ratelimit.Limit{Rate: 0, Burst: 10}.Enabled())
+	assert.False(t, ratelimit.Limit{}.Enabled())
+}
@@ -1,13 +1,26 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/api"
 	_ "github.com/fiskaly/coding-challenges/signing-service-challenge/api"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/payload/request"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
 	_ "github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/policy"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/signeddata"
+	"github.com/google/uuid"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Setup a new DeviceService for testing
@@ -18,6 +31,7 @@ func setupService() api.DeviceServiceInterface {
 
 // TestCreateSignatureDevice tests the CreateSignatureDevice function
 func TestCreateSignatureDevice(t *testing.T) {
+	ctx := context.Background()
 	service := setupService()
 
 	// Define a valid request
@@ -32,7 +46,7 @@ func TestCreateSignatureDevice(t *testing.T) {
 	}
 
 	// Call the service method
-	response, err := service.CreateSignatureDevice(&req)
+	response, err := service.CreateSignatureDevice(ctx, &req)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -51,6 +65,7 @@ func TestCreateSignatureDevice(t *testing.T) {
 
 // TestCreateSignatureDeviceDuplicateID tests the duplicate ID error in CreateSignatureDevice function
 func TestCreateSignatureDeviceDuplicateID(t *testing.T) {
+	ctx := context.Background()
 	service := setupService()
 
 	// Create the first device
@@ -64,13 +79,13 @@ func TestCreateSignatureDeviceDuplicateID(t *testing.T) {
 		Algorithm: string(algorithm),
 	}
 
-	_, err := service.CreateSignatureDevice(&req)
+	_, err := service.CreateSignatureDevice(ctx, &req)
 	if err != nil {
 		t.Fatalf("unexpected error during first creation: %v", err)
 	}
 
 	// Try to create the device with the same ID again
-	_, err = service.CreateSignatureDevice(&req)
+	_, err = service.CreateSignatureDevice(ctx, &req)
 	if err == nil {
 		t.Errorf("expected error due to duplicate ID, but got none")
 	}
@@ -78,6 +93,7 @@ func TestCreateSignatureDeviceDuplicateID(t *testing.T) {
 
 // TestSignTransaction tests the SignTransaction function
 func TestSignTransaction(t *testing.T) {
+	ctx := context.Background()
 	service := setupService()
 
 	// First, create a device
@@ -91,7 +107,7 @@ func TestSignTransaction(t *testing.T) {
 		Algorithm: string(algorithm),
 	}
 
-	_, err := service.CreateSignatureDevice(&reqCSD)
+	_, err := service.CreateSignatureDevice(ctx, &reqCSD)
 	if err != nil {
 		t.Fatalf("unexpected error during device creation: %v", err)
 	}
@@ -103,7 +119,7 @@ func TestSignTransaction(t *testing.T) {
 		DeviceID: id,
 		Data:     data,
 	}
-	signResponse, err := service.SignTransaction(&reqST)
+	signResponse, err := service.SignTransaction(ctx, &reqST)
 	if err != nil {
 		t.Fatalf("unexpected error during signing: %v", err)
 	}
@@ -117,12 +133,96 @@ func TestSignTransaction(t *testing.T) {
 	}
 }
 
+// failingAppendRepository fails the next appends of signatures, like a full disk or a lost database connection
+type failingAppendRepository struct {
+	persistence.DeviceRepository
+	failures int
+}
+
+func (repo *failingAppendRepository) AppendSignature(ctx context.Context, record *domain.SignatureRecord) error {
+	if repo.failures > 0 {
+		repo.failures--
+		return errors.New("disk I/O error")
+	}
+	return repo.DeviceRepository.AppendSignature(ctx, record)
+}
+
+// TestSignTransactionFailedAppend tests that a signature that could not be stored leaves the device as it
+// was, so the next signature uses the same counter and chains to the same signature
+func TestSignTransactionFailedAppend(t *testing.T) {
+	ctx := context.Background()
+	store := &failingAppendRepository{DeviceRepository: persistence.NewInMemoryDeviceRepository()}
+	service := api.NewDeviceService(store)
+	device, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{Algorithm: string(domain.ECC)})
+	if err != nil {
+		t.Fatalf("unexpected error during device creation: %v", err)
+	}
+
+	store.failures = 1
+	if _, err := service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: device.ID, Data: "receipt-1"}); err == nil {
+		t.Fatal("expected the signature to fail")
+	}
+	if _, err := service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: device.ID, Data: "receipt-1"}); err != nil {
+		t.Fatalf("expected the device to sign after the failure, got %v", err)
+	}
+
+	records, err := store.ListSignatureRecords(ctx, device.ID)
+	if err != nil || len(records) != 1 || records[0].GetCounter() != 0 {
+		t.Errorf("expected one signature with counter 0, got %v (%v)", records, err)
+	}
+	stored, err := store.GetDevice(ctx, device.ID)
+	if err != nil || stored.GetSignatureCount() != 1 || stored.GetLastSignature() != records[0].GetSignature() {
+		t.Errorf("expected the device to be advanced by the stored signature, got %+v (%v)", stored, err)
+	}
+}
+
+// TestSignTransactionConcurrently tests that concurrent signatures of a device get gapless counters, also
+// while signatures are requested for devices that don't exist
+func TestSignTransactionConcurrently(t *testing.T) {
+	ctx := context.Background()
+	store := persistence.NewInMemoryDeviceRepository()
+	service := api.NewDeviceService(store)
+	device, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{Algorithm: string(domain.ECC)})
+	if err != nil {
+		t.Fatalf("unexpected error during device creation: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: device.ID, Data: "receipt"}); err != nil {
+				t.Errorf("unexpected error during signing: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: uuid.NewString(), Data: "receipt"}); !errors.Is(err, domain.ErrDeviceNotFound) {
+				t.Errorf("expected device not found error, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	records, err := store.ListSignatureRecords(ctx, device.ID)
+	if err != nil || len(records) != 50 {
+		t.Fatalf("expected 50 signatures, got %d (%v)", len(records), err)
+	}
+	for i, record := range records {
+		if record.GetCounter() != uint64(i) {
+			t.Errorf("expected counter %d, got %d", i, record.GetCounter())
+		}
+	}
+}
+
 // TestListSignatureDevices tests the ListSignatureDevices function
 func TestListSignatureDevices(t *testing.T) {
+	ctx := context.Background()
 	service := setupService()
 
 	// Initially, the list should be empty
-	devices, err := service.ListSignatureDevices()
+	devices, err := service.ListSignatureDevices(ctx, domain.DefaultTenantID)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -141,13 +241,13 @@ func TestListSignatureDevices(t *testing.T) {
 		Algorithm: string(algorithm),
 	}
 
-	_, err = service.CreateSignatureDevice(&req)
+	_, err = service.CreateSignatureDevice(ctx, &req)
 	if err != nil {
 		t.Fatalf("unexpected error during device creation: %v", err)
 	}
 
 	// List devices again
-	devices, err = service.ListSignatureDevices()
+	devices, err = service.ListSignatureDevices(ctx, domain.DefaultTenantID)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -170,6 +270,7 @@ func TestListSignatureDevices(t *testing.T) {
 
 // TestGetSignatureDeviceById tests the GetSignatureDeviceById function
 func TestGetSignatureDeviceById(t *testing.T) {
+	ctx := context.Background()
 	service := setupService()
 
 	// First, create a device
@@ -183,13 +284,13 @@ func TestGetSignatureDeviceById(t *testing.T) {
 		Algorithm: string(algorithm),
 	}
 
-	_, err := service.CreateSignatureDevice(&req)
+	_, err := service.CreateSignatureDevice(ctx, &req)
 	if err != nil {
 		t.Fatalf("unexpected error during device creation: %v", err)
 	}
 
 	// Get the device by ID
-	deviceResponse, err := service.GetSignatureDeviceById(id)
+	deviceResponse, err := service.GetSignatureDeviceById(ctx, domain.DefaultTenantID, id)
 	if err != nil {
 		t.Fatalf("unexpected error during device retrieval: %v", err)
 	}
@@ -208,11 +309,853 @@ func TestGetSignatureDeviceById(t *testing.T) {
 
 // TestGetSignatureDeviceByIdNotFound tests the GetSignatureDeviceById function when the device is not found
 func TestGetSignatureDeviceByIdNotFound(t *testing.T) {
+	ctx := context.Background()
 	service := setupService()
 
 	// Try to get a device that doesn't exist
-	_, err := service.GetSignatureDeviceById("non-existent-id")
+	_, err := service.GetSignatureDeviceById(ctx, domain.DefaultTenantID, "non-existent-id")
 	if err == nil {
 		t.Errorf("expected error for non-existent device ID, but got none")
 	}
 }
+
+// TestSignTransactionBinaryData tests signing base64 encoded binary data with the length-prefixed format
+func TestSignTransactionBinaryData(t *testing.T) {
+	ctx := context.Background()
+	service := setupService()
+
+	id := "123e4567-e89b-12d3-a456-426614174000"
+	_, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{ID: id, Algorithm: string(domain.ECC)})
+	if err != nil {
+		t.Fatalf("unexpected error during device creation: %v", err)
+	}
+
+	reqST := request.SignTransactionRequest{
+		DeviceID:     id,
+		Data:         base64.StdEncoding.EncodeToString([]byte{0x00, '_', 0xff}),
+		DataEncoding: signeddata.EncodingBase64,
+	}
+	signResponse, err := service.SignTransaction(ctx, &reqST)
+	if err != nil {
+		t.Fatalf("unexpected error during signing: %v", err)
+	}
+	if signResponse.SignedDataEncoding != signeddata.EncodingBase64 {
+		t.Fatalf("expected signed data encoding %v, but got %v", signeddata.EncodingBase64, signResponse.SignedDataEncoding)
+	}
+
+	signedData, err := base64.StdEncoding.DecodeString(signResponse.SignedData)
+	if err != nil {
+		t.Fatalf("unexpected error decoding signed data: %v", err)
+	}
+	fields, err := signeddata.DecodeLengthPrefixed(signedData)
+	if err != nil {
+		t.Fatalf("unexpected error decoding fields: %v", err)
+	}
+	if len(fields) != 4 || string(fields[0]) != "0" || string(fields[2]) != "\x00_\xff" ||
+		string(fields[3]) != base64.StdEncoding.EncodeToString([]byte(id)) {
+		t.Errorf("unexpected signed data fields: %q", fields)
+	}
+}
+
+// TestSignTransactionDigest tests signing a pre-computed digest and rejecting digests of the wrong size
+func TestSignTransactionDigest(t *testing.T) {
+	ctx := context.Background()
+	service := setupService()
+
+	id := "123e4567-e89b-12d3-a456-426614174000"
+	_, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{ID: id, Algorithm: string(domain.RSA)})
+	if err != nil {
+		t.Fatalf("unexpected error during device creation: %v", err)
+	}
+
+	digest := sha256.Sum256([]byte("sample-transaction-data"))
+	_, err = service.SignTransaction(ctx, &request.SignTransactionRequest{
+		DeviceID:     id,
+		Data:         hex.EncodeToString(digest[:]),
+		DataEncoding: signeddata.EncodingHex,
+		Mode:         signeddata.ModeDigest,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error during signing: %v", err)
+	}
+
+	_, err = service.SignTransaction(ctx, &request.SignTransactionRequest{
+		DeviceID:     id,
+		Data:         "abcd",
+		DataEncoding: signeddata.EncodingHex,
+		Mode:         signeddata.ModeDigest,
+	})
+	if !errors.Is(err, signeddata.ErrInvalidDigest) {
+		t.Errorf("expected invalid digest error, but got %v", err)
+	}
+}
+
+// TestSignTransactionV2Format tests that a device created with the v2 format signs canonical JSON and records the version
+func TestSignTransactionV2Format(t *testing.T) {
+	ctx := context.Background()
+	store := persistence.NewInMemoryDeviceRepository()
+	service := api.NewDeviceService(store)
+
+	id := "123e4567-e89b-12d3-a456-426614174000"
+	deviceResponse, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{
+		ID:               id,
+		Algorithm:        string(domain.ECC),
+		SignedDataFormat: signeddata.FormatV2,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error during device creation: %v", err)
+	}
+	if deviceResponse.SignedDataFormat != signeddata.FormatV2 {
+		t.Errorf("expected signed data format %v, but got %v", signeddata.FormatV2, deviceResponse.SignedDataFormat)
+	}
+
+	signResponse, err := service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: id, Data: "sample-transaction-data"})
+	if err != nil {
+		t.Fatalf("unexpected error during signing: %v", err)
+	}
+	if signResponse.FormatVersion != signeddata.FormatV2 {
+		t.Errorf("expected format version %v, but got %v", signeddata.FormatV2, signResponse.FormatVersion)
+	}
+
+	var signedData map[string]interface{}
+	if err := json.Unmarshal([]byte(signResponse.SignedData), &signedData); err != nil {
+		t.Fatalf("expected JSON signed data: %v", err)
+	}
+	if signedData["deviceId"] != id || signedData["counter"] != float64(0) || signedData["timestamp"] == "" {
+		t.Errorf("unexpected signed data: %v", signResponse.SignedData)
+	}
+
+	records, err := store.ListSignatureRecords(ctx, id)
+	if err != nil {
+		t.Fatalf("unexpected error listing signatures: %v", err)
+	}
+	if len(records) != 1 || records[0].GetFormatVersion() != signeddata.FormatV2 || records[0].GetSignature() != signResponse.Signature {
+		t.Errorf("expected the signature to be recorded with format %v", signeddata.FormatV2)
+	}
+}
+
+// TestCreateSignatureDeviceInvalidFormat tests that unknown signed-data formats are rejected
+func TestCreateSignatureDeviceInvalidFormat(t *testing.T) {
+	ctx := context.Background()
+	service := setupService()
+
+	_, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{
+		ID:               "123e4567-e89b-12d3-a456-426614174000",
+		Algorithm:        string(domain.RSA),
+		SignedDataFormat: "v99",
+	})
+	if err == nil || err.Error() != "invalid signed data format" {
+		t.Errorf("expected invalid signed data format error, but got %v", err)
+	}
+}
+
+// TestSignTransactionJSONPayload tests that semantically identical JSON payloads are signed in the same canonical form
+func TestSignTransactionJSONPayload(t *testing.T) {
+	ctx := context.Background()
+	service := setupService()
+
+	id := "123e4567-e89b-12d3-a456-426614174000"
+	_, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{ID: id, Algorithm: string(domain.ECC)})
+	if err != nil {
+		t.Fatalf("unexpected error during device creation: %v", err)
+	}
+
+	var payloads [][]byte
+	for _, payload := range []string{`{"total": 10.50, "currency": "EUR"}`, `{"currency":"EUR","total":10.5}`} {
+		signResponse, err := service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: id, Payload: json.RawMessage(payload)})
+		if err != nil {
+			t.Fatalf("unexpected error during signing: %v", err)
+		}
+		signedData, err := base64.StdEncoding.DecodeString(signResponse.SignedData)
+		if err != nil {
+			t.Fatalf("unexpected error decoding signed data: %v", err)
+		}
+		fields, err := signeddata.DecodeLengthPrefixed(signedData)
+		if err != nil || len(fields) != 4 {
+			t.Fatalf("unexpected signed data: %v", err)
+		}
+		payloads = append(payloads, fields[2])
+	}
+
+	if string(payloads[0]) != `{"currency":"EUR","total":10.5}` || string(payloads[0]) != string(payloads[1]) {
+		t.Errorf("expected identical canonical payloads, but got %q and %q", payloads[0], payloads[1])
+	}
+
+	// A payload has to be a JSON object and cannot be combined with data
+	_, err = service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: id, Payload: json.RawMessage(`[1,2]`)})
+	if !errors.Is(err, signeddata.ErrNotJSONObject) {
+		t.Errorf("expected not a JSON object error, but got %v", err)
+	}
+	_, err = service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: id, Data: "data", Payload: json.RawMessage(`{}`)})
+	if !errors.Is(err, signeddata.ErrAmbiguousPayload) {
+		t.Errorf("expected ambiguous payload error, but got %v", err)
+	}
+}
+
+// TestSignTransactionIdempotencyKey tests that a replayed idempotency key returns the original signature
+func TestSignTransactionIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	service := setupService()
+
+	id := "123e4567-e89b-12d3-a456-426614174000"
+	_, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{ID: id, Algorithm: string(domain.ECC)})
+	if err != nil {
+		t.Fatalf("unexpected error during device creation: %v", err)
+	}
+
+	req := request.SignTransactionRequest{DeviceID: id, Data: "sample-transaction-data", IdempotencyKey: "retry-1"}
+	first, err := service.SignTransaction(ctx, &req)
+	if err != nil {
+		t.Fatalf("unexpected error during signing: %v", err)
+	}
+	replay, err := service.SignTransaction(ctx, &req)
+	if err != nil {
+		t.Fatalf("unexpected error during replay: %v", err)
+	}
+	if !replay.Replayed || replay.Signature != first.Signature || replay.SignedData != first.SignedData {
+		t.Errorf("expected the original signature to be replayed")
+	}
+
+	// The counter only advanced once
+	device, err := service.GetSignatureDeviceById(ctx, domain.DefaultTenantID, id)
+	if err != nil {
+		t.Fatalf("unexpected error during device retrieval: %v", err)
+	}
+	if device.SignatureCount != 1 {
+		t.Errorf("expected signature count 1, but got %v", device.SignatureCount)
+	}
+
+	// Replaying the key with a different body is rejected
+	_, err = service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: id, Data: "other-data", IdempotencyKey: "retry-1"})
+	if !errors.Is(err, api.ErrIdempotencyKeyReused) {
+		t.Errorf("expected idempotency key reused error, but got %v", err)
+	}
+}
+
+// TestSignTransactionReplayAfterDeviceStopsSigning tests that a retried signature is replayed even if the device
+// was suspended or deleted after the original request went through
+func TestSignTransactionReplayAfterDeviceStopsSigning(t *testing.T) {
+	ctx := context.Background()
+	service := setupService()
+
+	id := "123e4567-e89b-12d3-a456-426614174000"
+	_, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{ID: id, Algorithm: string(domain.ECC)})
+	if err != nil {
+		t.Fatalf("unexpected error during device creation: %v", err)
+	}
+
+	req := request.SignTransactionRequest{DeviceID: id, Data: "sample-transaction-data", IdempotencyKey: "retry-1"}
+	first, err := service.SignTransaction(ctx, &req)
+	if err != nil {
+		t.Fatalf("unexpected error during signing: %v", err)
+	}
+
+	replay := func() {
+		t.Helper()
+		replayed, err := service.SignTransaction(ctx, &req)
+		if err != nil {
+			t.Fatalf("unexpected error during replay: %v", err)
+		}
+		if !replayed.Replayed || replayed.Signature != first.Signature {
+			t.Errorf("expected the original signature to be replayed")
+		}
+	}
+
+	if _, err := service.ChangeDeviceStatus(ctx, domain.DefaultTenantID, id, &request.DeviceStatusRequest{Action: "suspend"}); err != nil {
+		t.Fatalf("unexpected error during suspension: %v", err)
+	}
+	replay()
+
+	// New requests are still refused
+	_, err = service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: id, Data: "sample-transaction-data", IdempotencyKey: "retry-2"})
+	if !errors.Is(err, domain.ErrDeviceNotActive) {
+		t.Errorf("expected device not active error, but got %v", err)
+	}
+
+	if _, err := service.DeleteSignatureDevice(ctx, domain.DefaultTenantID, id); err != nil {
+		t.Fatalf("unexpected error during deletion: %v", err)
+	}
+	replay()
+}
+
+// TestSignTransactionIdempotencyKeyExpires tests that idempotency keys are forgotten after the retention window,
+// even before the expired keys are purged
+func TestSignTransactionIdempotencyKeyExpires(t *testing.T) {
+	stores := map[string]func(t *testing.T) persistence.DeviceRepository{
+		"in-memory": func(t *testing.T) persistence.DeviceRepository {
+			return persistence.NewInMemoryDeviceRepository()
+		},
+		"sqlite": func(t *testing.T) persistence.DeviceRepository {
+			store, err := persistence.NewSQLiteDeviceRepository(filepath.Join(t.TempDir(), "devices.db"))
+			if err != nil {
+				t.Fatalf("unexpected error opening the database: %v", err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			service := api.NewDeviceService(newStore(t), api.WithIdempotencyRetention(time.Millisecond))
+
+			id := "123e4567-e89b-12d3-a456-426614174000"
+			_, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{ID: id, Algorithm: string(domain.ECC)})
+			if err != nil {
+				t.Fatalf("unexpected error during device creation: %v", err)
+			}
+
+			req := request.SignTransactionRequest{DeviceID: id, Data: "sample-transaction-data", IdempotencyKey: "retry-1"}
+			first, err := service.SignTransaction(ctx, &req)
+			if err != nil {
+				t.Fatalf("unexpected error during signing: %v", err)
+			}
+
+			time.Sleep(5 * time.Millisecond)
+
+			// The first request purged before the key existed, so the next purge is only due in a minute
+			second, err := service.SignTransaction(ctx, &req)
+			if err != nil {
+				t.Fatalf("unexpected error during signing: %v", err)
+			}
+			if second.Replayed || second.Signature == first.Signature {
+				t.Errorf("expected a new signature after the idempotency key expired")
+			}
+
+			// The new signature holds the key from now on
+			time.Sleep(5 * time.Millisecond)
+			third, err := service.SignTransaction(ctx, &req)
+			if err != nil {
+				t.Fatalf("unexpected error during signing: %v", err)
+			}
+			if third.Replayed {
+				t.Errorf("expected the key of the second signature to expire as well")
+			}
+		})
+	}
+}
+
+// TestDeviceLifecycle tests that only active devices can sign and that transitions are recorded
+func TestDeviceLifecycle(t *testing.T) {
+	ctx := context.Background()
+	service := setupService()
+
+	id := "123e4567-e89b-12d3-a456-426614174000"
+	deviceResponse, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{ID: id, Algorithm: string(domain.ECC), Status: "inactive"})
+	if err != nil {
+		t.Fatalf("unexpected error during device creation: %v", err)
+	}
+	if deviceResponse.Status != string(domain.StatusInactive) {
+		t.Errorf("expected status inactive, but got %v", deviceResponse.Status)
+	}
+
+	sign := func() error {
+		_, err := service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: id, Data: "sample-transaction-data"})
+		return err
+	}
+	if err := sign(); !errors.Is(err, domain.ErrDeviceNotActive) {
+		t.Errorf("expected inactive device to be rejected, but got %v", err)
+	}
+
+	for _, step := range []struct {
+		action  string
+		canSign bool
+	}{
+		{"activate", true},
+		{"suspend", false},
+		{"resume", true},
+		{"decommission", false},
+	} {
+		if _, err := service.ChangeDeviceStatus(ctx, domain.DefaultTenantID, id, &request.DeviceStatusRequest{Action: step.action, Reason: "test"}); err != nil {
+			t.Fatalf("unexpected error applying %v: %v", step.action, err)
+		}
+		if err := sign(); (err == nil) != step.canSign {
+			t.Errorf("after %v: expected signing allowed %v, but got error %v", step.action, step.canSign, err)
+		}
+	}
+
+	_, err = service.ChangeDeviceStatus(ctx, domain.DefaultTenantID, id, &request.DeviceStatusRequest{Action: "resume"})
+	if !errors.Is(err, domain.ErrInvalidStatusTransition) {
+		t.Errorf("expected decommissioned device not to resume, but got %v", err)
+	}
+
+	status, err := service.GetDeviceStatus(ctx, domain.DefaultTenantID, id)
+	if err != nil {
+		t.Fatalf("unexpected error reading status: %v", err)
+	}
+	if status.Status != string(domain.StatusDecommissioned) || len(status.History) != 4 {
+		t.Errorf("expected decommissioned status with 4 transitions, but got %v with %d", status.Status, len(status.History))
+	}
+	if status.History[1].From != "active" || status.History[1].To != "suspended" || status.History[1].Reason != "test" {
+		t.Errorf("unexpected transition: %+v", status.History[1])
+	}
+}
+
+// TestUpdateSignatureDevice tests updating the label and merging the metadata of a device
+func TestUpdateSignatureDevice(t *testing.T) {
+	ctx := context.Background()
+	service := setupService()
+
+	id := "123e4567-e89b-12d3-a456-426614174000"
+	_, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{
+		ID:        id,
+		Algorithm: string(domain.RSA),
+		Label:     "test-device",
+		Metadata:  map[string]string{"store": "Berlin", "register": "R-1"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error during device creation: %v", err)
+	}
+
+	label := "register-2"
+	serial := "SN-42"
+	deviceResponse, err := service.UpdateSignatureDevice(ctx, domain.DefaultTenantID, id, &request.UpdateDeviceRequest{
+		Label:    &label,
+		Metadata: map[string]*string{"register": nil, "serial": &serial},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error during update: %v", err)
+	}
+	if deviceResponse.Label != label {
+		t.Errorf("expected label %v, but got %v", label, deviceResponse.Label)
+	}
+	if len(deviceResponse.Metadata) != 2 || deviceResponse.Metadata["store"] != "Berlin" || deviceResponse.Metadata["serial"] != serial {
+		t.Errorf("unexpected metadata: %v", deviceResponse.Metadata)
+	}
+
+	// Leaving the label out keeps it
+	deviceResponse, err = service.UpdateSignatureDevice(ctx, domain.DefaultTenantID, id, &request.UpdateDeviceRequest{})
+	if err != nil || deviceResponse.Label != label {
+		t.Errorf("expected label %v to be kept, but got %v (%v)", label, deviceResponse.Label, err)
+	}
+
+	_, err = service.UpdateSignatureDevice(ctx, domain.DefaultTenantID, id, &request.UpdateDeviceRequest{Metadata: map[string]*string{"": &serial}})
+	if !errors.Is(err, api.ErrInvalidDeviceAttributes) {
+		t.Errorf("expected invalid device attributes error, but got %v", err)
+	}
+	_, err = service.UpdateSignatureDevice(ctx, domain.DefaultTenantID, "non-existent-id", &request.UpdateDeviceRequest{Label: &label})
+	if err == nil || err.Error() != "device not found" {
+		t.Errorf("expected device not found error, but got %v", err)
+	}
+}
+
+// TestDeleteSignatureDevice tests deleting a device and keeping its tombstone
+func TestDeleteSignatureDevice(t *testing.T) {
+	ctx := context.Background()
+	service := setupService()
+
+	id := "123e4567-e89b-12d3-a456-426614174000"
+	created, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{ID: id, Algorithm: string(domain.ECC)})
+	if err != nil {
+		t.Fatalf("unexpected error during device creation: %v", err)
+	}
+	if _, err := service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: id, Data: "sample-transaction-data"}); err != nil {
+		t.Fatalf("unexpected error during signing: %v", err)
+	}
+
+	tombstone, err := service.DeleteSignatureDevice(ctx, domain.DefaultTenantID, id)
+	if err != nil {
+		t.Fatalf("unexpected error during deletion: %v", err)
+	}
+	if tombstone.DeletedAt == nil || tombstone.PublicKey != created.PublicKey || tombstone.SignatureCount != 1 {
+		t.Errorf("unexpected tombstone: %+v", tombstone)
+	}
+
+	// The tombstone can still be read, but the device can no longer be used
+	if device, err := service.GetSignatureDeviceById(ctx, domain.DefaultTenantID, id); err != nil || device.DeletedAt == nil {
+		t.Errorf("expected the tombstone to be readable, but got %+v (%v)", device, err)
+	}
+	if _, err := service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: id, Data: "sample-transaction-data"}); !errors.Is(err, domain.ErrDeviceDeleted) {
+		t.Errorf("expected deleted device error when signing, but got %v", err)
+	}
+	label := "new-label"
+	if _, err := service.UpdateSignatureDevice(ctx, domain.DefaultTenantID, id, &request.UpdateDeviceRequest{Label: &label}); !errors.Is(err, domain.ErrDeviceDeleted) {
+		t.Errorf("expected deleted device error when updating, but got %v", err)
+	}
+	if _, err := service.ChangeDeviceStatus(ctx, domain.DefaultTenantID, id, &request.DeviceStatusRequest{Action: "suspend"}); !errors.Is(err, domain.ErrDeviceDeleted) {
+		t.Errorf("expected deleted device error when changing the status, but got %v", err)
+	}
+	if _, err := service.DeleteSignatureDevice(ctx, domain.DefaultTenantID, id); !errors.Is(err, domain.ErrDeviceDeleted) {
+		t.Errorf("expected deleted device error when deleting again, but got %v", err)
+	}
+	if _, err := service.DeleteSignatureDevice(ctx, domain.DefaultTenantID, "non-existent-id"); err == nil || err.Error() != "device not found" {
+		t.Errorf("expected device not found error, but got %v", err)
+	}
+
+	// The ID cannot be reused
+	_, err = service.CreateSignatureDevice(ctx, &request.DeviceRequest{ID: id, Algorithm: string(domain.ECC)})
+	if err == nil || err.Error() != "device with this ID already exists" {
+		t.Errorf("expected device ID reuse to be rejected, but got %v", err)
+	}
+
+	// Tombstones are only listed when asked for
+	devices, _ := service.ListSignatureDevices(ctx, domain.DefaultTenantID)
+	if len(devices) != 0 {
+		t.Errorf("expected deleted device not to be listed, but got %d devices", len(devices))
+	}
+	page, _ := service.QuerySignatureDevices(ctx, &request.ListDevicesRequest{IncludeDeleted: true})
+	if len(page.Devices) != 1 || page.Devices[0].DeletedAt == nil {
+		t.Errorf("expected the tombstone to be listed, but got %+v", page.Devices)
+	}
+}
+
+// TestQuerySignatureDevices tests filtering and paging through the signature devices
+func TestQuerySignatureDevices(t *testing.T) {
+	ctx := context.Background()
+	service := setupService()
+
+	for _, req := range []request.DeviceRequest{
+		{ID: "123e4567-e89b-12d3-a456-426614174001", Algorithm: string(domain.ECC), Label: "berlin-1"},
+		{ID: "123e4567-e89b-12d3-a456-426614174002", Algorithm: string(domain.RSA), Label: "berlin-2"},
+		{ID: "123e4567-e89b-12d3-a456-426614174003", Algorithm: string(domain.ECC), Label: "vienna-1"},
+	} {
+		if _, err := service.CreateSignatureDevice(ctx, &req); err != nil {
+			t.Fatalf("unexpected error during device creation: %v", err)
+		}
+	}
+
+	page, err := service.QuerySignatureDevices(ctx, &request.ListDevicesRequest{Algorithm: string(domain.ECC), SortBy: "label", Order: "desc", Limit: 1})
+	if err != nil {
+		t.Fatalf("unexpected error during listing: %v", err)
+	}
+	if len(page.Devices) != 1 || page.Devices[0].Label != "vienna-1" || page.NextCursor == "" {
+		t.Errorf("unexpected first page: %+v", page)
+	}
+	page, err = service.QuerySignatureDevices(ctx, &request.ListDevicesRequest{Algorithm: string(domain.ECC), SortBy: "label", Order: "desc", Limit: 1, Cursor: page.NextCursor})
+	if err != nil {
+		t.Fatalf("unexpected error during listing: %v", err)
+	}
+	if len(page.Devices) != 1 || page.Devices[0].Label != "berlin-1" || page.NextCursor != "" {
+		t.Errorf("unexpected last page: %+v", page)
+	}
+	if page.Devices[0].CreatedAt.IsZero() {
+		t.Errorf("expected the creation time to be set")
+	}
+
+	for _, req := range []request.ListDevicesRequest{{Order: "sideways"}, {SortBy: "publicKey"}, {Cursor: "garbage"}, {Limit: 5000}} {
+		if _, err := service.QuerySignatureDevices(ctx, &req); !errors.Is(err, api.ErrInvalidListRequest) {
+			t.Errorf("expected invalid list request error for %+v, but got %v", req, err)
+		}
+	}
+}
+
+// TestCreateSignatureDeviceGeneratesID tests that devices created without an ID get a generated UUID
+func TestCreateSignatureDeviceGeneratesID(t *testing.T) {
+	ctx := context.Background()
+	for version, option := range map[uuid.Version]api.DeviceServiceOption{
+		4: api.WithDeviceIDVersion(api.DeviceIDVersion4),
+		7: api.WithDeviceIDVersion(api.DeviceIDVersion7),
+	} {
+		service := api.NewDeviceService(persistence.NewInMemoryDeviceRepository(), option)
+
+		deviceResponse, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{Algorithm: string(domain.ECC)})
+		if err != nil {
+			t.Fatalf("unexpected error during device creation: %v", err)
+		}
+		id, err := uuid.Parse(deviceResponse.ID)
+		if err != nil || id.Version() != version {
+			t.Errorf("expected a version %d UUID, but got %v (%v)", version, deviceResponse.ID, err)
+		}
+		if _, err := service.GetSignatureDeviceById(ctx, domain.DefaultTenantID, deviceResponse.ID); err != nil {
+			t.Errorf("expected the device to be stored under the generated ID, but got %v", err)
+		}
+	}
+
+	// The default generates time-ordered IDs
+	deviceResponse, _ := setupService().CreateSignatureDevice(ctx, &request.DeviceRequest{Algorithm: string(domain.ECC)})
+	if id, _ := uuid.Parse(deviceResponse.ID); id.Version() != 7 {
+		t.Errorf("expected a version 7 UUID by default, but got %v", deviceResponse.ID)
+	}
+}
+
+// TestDeviceTimestamps tests the creation and update timestamps of a device
+func TestDeviceTimestamps(t *testing.T) {
+	ctx := context.Background()
+	service := setupService()
+
+	created, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{Algorithm: string(domain.ECC)})
+	if err != nil {
+		t.Fatalf("unexpected error during device creation: %v", err)
+	}
+	if created.CreatedAt.IsZero() || !created.UpdatedAt.Equal(created.CreatedAt) {
+		t.Errorf("expected matching creation and update times, but got %v and %v", created.CreatedAt, created.UpdatedAt)
+	}
+
+	// Signing does not count as an update
+	if _, err := service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: created.ID, Data: "sample-transaction-data"}); err != nil {
+		t.Fatalf("unexpected error during signing: %v", err)
+	}
+	device, _ := service.GetSignatureDeviceById(ctx, domain.DefaultTenantID, created.ID)
+	if !device.UpdatedAt.Equal(created.UpdatedAt) {
+		t.Errorf("expected signing not to change the update time, but got %v", device.UpdatedAt)
+	}
+
+	label := "new-label"
+	updated, err := service.UpdateSignatureDevice(ctx, domain.DefaultTenantID, created.ID, &request.UpdateDeviceRequest{Label: &label})
+	if err != nil {
+		t.Fatalf("unexpected error during update: %v", err)
+	}
+	if !updated.UpdatedAt.After(created.UpdatedAt) || !updated.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("expected only the update time to advance, but got %v and %v", updated.CreatedAt, updated.UpdatedAt)
+	}
+
+	if _, err := service.ChangeDeviceStatus(ctx, domain.DefaultTenantID, created.ID, &request.DeviceStatusRequest{Action: "suspend"}); err != nil {
+		t.Fatalf("unexpected error changing the status: %v", err)
+	}
+	device, _ = service.GetSignatureDeviceById(ctx, domain.DefaultTenantID, created.ID)
+	if !device.UpdatedAt.After(updated.UpdatedAt) {
+		t.Errorf("expected the status change to advance the update time, but got %v", device.UpdatedAt)
+	}
+}
+
+// TestSigningLimits tests that signature quotas and validity windows are enforced
+func TestSigningLimits(t *testing.T) {
+	ctx := context.Background()
+	service := setupService()
+
+	created, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{
+		Algorithm: string(domain.ECC),
+		Limits:    &request.SigningLimitsRequest{MaxSignatures: 3, DailyLimit: 2},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error during device creation: %v", err)
+	}
+	if created.RemainingSignatures == nil || *created.RemainingSignatures != 3 || created.RemainingSignaturesToday == nil || *created.RemainingSignaturesToday != 2 {
+		t.Errorf("unexpected remaining quota: %v, %v", created.RemainingSignatures, created.RemainingSignaturesToday)
+	}
+
+	sign := func() error {
+		_, err := service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: created.ID, Data: "sample-transaction-data"})
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		if err := sign(); err != nil {
+			t.Fatalf("unexpected error during signing: %v", err)
+		}
+	}
+	if err := sign(); !errors.Is(err, domain.ErrDailySignatureQuotaExhausted) {
+		t.Errorf("expected daily quota error, but got %v", err)
+	}
+
+	// Raising the daily limit leaves the lifetime quota
+	updated, err := service.UpdateSignatureDevice(ctx, domain.DefaultTenantID, created.ID, &request.UpdateDeviceRequest{Limits: &request.SigningLimitsRequest{MaxSignatures: 3}})
+	if err != nil {
+		t.Fatalf("unexpected error during update: %v", err)
+	}
+	if *updated.RemainingSignatures != 1 || updated.RemainingSignaturesToday != nil {
+		t.Errorf("unexpected remaining quota: %v, %v", *updated.RemainingSignatures, updated.RemainingSignaturesToday)
+	}
+	if err := sign(); err != nil {
+		t.Fatalf("unexpected error during signing: %v", err)
+	}
+	if err := sign(); !errors.Is(err, domain.ErrSignatureQuotaExhausted) {
+		t.Errorf("expected quota error, but got %v", err)
+	}
+
+	// Validity windows
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	for limits, wantErr := range map[*request.SigningLimitsRequest]error{
+		{NotBefore: &future}: domain.ErrDeviceNotYetValid,
+		{NotAfter: &past}:    domain.ErrDeviceExpired,
+	} {
+		device, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{Algorithm: string(domain.ECC), Limits: limits})
+		if err != nil {
+			t.Fatalf("unexpected error during device creation: %v", err)
+		}
+		_, err = service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: device.ID, Data: "sample-transaction-data"})
+		if !errors.Is(err, wantErr) {
+			t.Errorf("expected %v, but got %v", wantErr, err)
+		}
+	}
+
+	_, err = service.CreateSignatureDevice(ctx, &request.DeviceRequest{Algorithm: string(domain.ECC), Limits: &request.SigningLimitsRequest{NotBefore: &future, NotAfter: &past}})
+	if !errors.Is(err, api.ErrInvalidDeviceAttributes) {
+		t.Errorf("expected invalid device attributes error, but got %v", err)
+	}
+}
+
+// TestDevicePolicy tests that sign requests are checked against the policy of the device and policy changes are audited
+func TestDevicePolicy(t *testing.T) {
+	ctx := context.Background()
+	service := setupService()
+
+	created, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{
+		Algorithm: string(domain.ECC),
+		Policy:    json.RawMessage(`{"dataPattern": "receipt-[0-9]+", "allowedClients": ["pos-1"]}`),
+		ClientID:  "admin",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error during device creation: %v", err)
+	}
+	if len(created.Policy) == 0 {
+		t.Errorf("expected the policy in the device response")
+	}
+
+	if _, err := service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: created.ID, Data: "receipt-1", ClientID: "pos-1"}); err != nil {
+		t.Fatalf("unexpected error during signing: %v", err)
+	}
+	_, err = service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: created.ID, Data: "invoice-1", ClientID: "pos-2"})
+	var violationErr *policy.ViolationError
+	if !errors.As(err, &violationErr) || len(violationErr.Violations) != 2 {
+		t.Fatalf("expected two policy violations, but got %v", err)
+	}
+
+	// Rejected requests do not create signatures
+	device, _ := service.GetSignatureDeviceById(ctx, domain.DefaultTenantID, created.ID)
+	if device.SignatureCount != 1 {
+		t.Errorf("expected signature count 1, got %d", device.SignatureCount)
+	}
+
+	// Removing the policy lifts all restrictions
+	policyResponse, err := service.SetDevicePolicy(ctx, domain.DefaultTenantID, created.ID, &request.DevicePolicyRequest{Policy: json.RawMessage(`null`), ChangedBy: "admin"})
+	if err != nil {
+		t.Fatalf("unexpected error during policy change: %v", err)
+	}
+	if policyResponse.Policy != nil || len(policyResponse.History) != 2 {
+		t.Fatalf("unexpected policy response: %+v", policyResponse)
+	}
+	if policyResponse.History[0].PreviousPolicy != nil || policyResponse.History[1].Policy != nil || policyResponse.History[1].ChangedBy != "admin" {
+		t.Errorf("unexpected policy history: %+v, %+v", policyResponse.History[0], policyResponse.History[1])
+	}
+	if _, err := service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: created.ID, Data: "invoice-1"}); err != nil {
+		t.Errorf("unexpected error during signing: %v", err)
+	}
+
+	_, err = service.SetDevicePolicy(ctx, domain.DefaultTenantID, created.ID, &request.DevicePolicyRequest{Policy: json.RawMessage(`{"maxPayloadSize": "large"}`)})
+	if !errors.Is(err, policy.ErrInvalidPolicy) {
+		t.Errorf("expected invalid policy error, but got %v", err)
+	}
+	_, err = service.SetDevicePolicy(ctx, domain.DefaultTenantID, uuid.New().String(), &request.DevicePolicyRequest{})
+	if err == nil || err.Error() != "device not found" {
+		t.Errorf("expected device not found error, but got %v", err)
+	}
+}
+
+// TestTenantIsolation tests that a tenant can neither list, read, change nor sign with the devices of another tenant
+func TestTenantIsolation(t *testing.T) {
+	ctx := context.Background()
+	service := setupService()
+
+	created, err := service.CreateSignatureDevice(ctx, &request.DeviceRequest{Algorithm: string(domain.ECC), TenantID: "merchant-a"})
+	if err != nil {
+		t.Fatalf("unexpected error during device creation: %v", err)
+	}
+	if _, err := service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: created.ID, Data: "sample", TenantID: "merchant-a"}); err != nil {
+		t.Fatalf("unexpected error during signing: %v", err)
+	}
+
+	const other = "merchant-b"
+	for name, call := range map[string]func() error{
+		"get": func() error {
+			_, err := service.GetSignatureDeviceById(ctx, other, created.ID)
+			return err
+		},
+		"sign": func() error {
+			_, err := service.SignTransaction(ctx, &request.SignTransactionRequest{DeviceID: created.ID, Data: "sample", TenantID: other})
+			return err
+		},
+		"update": func() error {
+			_, err := service.UpdateSignatureDevice(ctx, other, created.ID, &request.UpdateDeviceRequest{})
+			return err
+		},
+		"delete": func() error {
+			_, err := service.DeleteSignatureDevice(ctx, other, created.ID)
+			return err
+		},
+		"status": func() error {
+			_, err := service.ChangeDeviceStatus(ctx, other, created.ID, &request.DeviceStatusRequest{Action: string(domain.ActionSuspend)})
+			return err
+		},
+		"policy": func() error {
+			_, err := service.GetDevicePolicy(ctx, other, created.ID)
+			return err
+		},
+	} {
+		if err := call(); err == nil || err.Error() != "device not found" {
+			t.Errorf("%s: expected device not found error, but got %v", name, err)
+		}
+	}
+
+	devices, err := service.ListSignatureDevices(ctx, other)
+	if err != nil || len(devices) != 0 {
+		t.Errorf("expected no devices for another tenant, got %d (%v)", len(devices), err)
+	}
+	devices, err = service.ListSignatureDevices(ctx, "merchant-a")
+	if err != nil || len(devices) != 1 || devices[0].SignatureCount != 1 {
+		t.Errorf("expected the signed device for its tenant, got %+v (%v)", devices, err)
+	}
+}
+
+// TestAPIKeyService tests creating, authenticating with and revoking API keys
+func TestAPIKeyService(t *testing.T) {
+	ctx := context.Background()
+	keys := api.NewAPIKeyService(persistence.NewInMemoryDeviceRepository())
+
+	created, err := keys.CreateAPIKey(ctx, &request.APIKeyRequest{TenantID: "merchant-a", ClientID: "pos-1", Name: "Register 1", Scopes: []string{"sign"}})
+	if err != nil {
+		t.Fatalf("unexpected error during key creation: %v", err)
+	}
+	if !strings.HasPrefix(created.Key, "ssk_") || created.TenantID != "merchant-a" || created.RevokedAt != nil {
+		t.Errorf("unexpected key: %+v", created)
+	}
+
+	identity, err := keys.AuthenticateAPIKey(ctx, created.Key)
+	if err != nil {
+		t.Fatalf("unexpected error during authentication: %v", err)
+	}
+	if identity.TenantID != "merchant-a" || identity.ClientID != "pos-1" || !identity.HasScope(domain.ScopeSign) || identity.HasScope(domain.ScopeAdmin) || identity.Operator {
+		t.Errorf("unexpected identity: %+v", identity)
+	}
+
+	// Only the hash is stored, so the secret is not part of the listing
+	listed, err := keys.ListAPIKeys(ctx, "merchant-a")
+	if err != nil || len(listed) != 1 || listed[0].ID != created.ID {
+		t.Errorf("unexpected key listing: %+v (%v)", listed, err)
+	}
+
+	// Keys of other tenants are not found
+	if _, err := keys.RevokeAPIKey(ctx, "merchant-b", created.ID); !errors.Is(err, domain.ErrAPIKeyNotFound) {
+		t.Errorf("expected api key not found error, but got %v", err)
+	}
+	if _, err := keys.RevokeAPIKey(ctx, "merchant-a", created.ID); err != nil {
+		t.Fatalf("unexpected error during revocation: %v", err)
+	}
+	for _, key := range []string{created.Key, created.Key + "x", "not-a-key"} {
+		if _, err := keys.AuthenticateAPIKey(ctx, key); !errors.Is(err, api.ErrUnauthenticated) {
+			t.Errorf("expected unauthenticated error for %q, but got %v", key, err)
+		}
+	}
+
+	for _, scopes := range [][]string{nil, {"devices:delete"}} {
+		if _, err := keys.CreateAPIKey(ctx, &request.APIKeyRequest{Scopes: scopes}); !errors.Is(err, api.ErrInvalidAPIKeyRequest) {
+			t.Errorf("expected invalid api key request error for %v, but got %v", scopes, err)
+		}
+	}
+	if _, err := keys.RevokeAPIKey(ctx, "merchant-a", uuid.New().String()); err == nil || err.Error() != "api key not found" {
+		t.Errorf("expected api key not found error, but got %v", err)
+	}
+}
+
+// TestBootstrapAPIKeyIsOperator tests that the bootstrap key manages the API keys of all tenants, while
+// admin keys of other tenants only manage their own
+func TestBootstrapAPIKeyIsOperator(t *testing.T) {
+	ctx := context.Background()
+	store := persistence.NewInMemoryDeviceRepository()
+	keys := api.NewAPIKeyService(store)
+	bootstrap := "ssk_" + strings.Repeat("b", 40)
+	if err := api.BootstrapAPIKey(ctx, store, bootstrap); err != nil {
+		t.Fatalf("unexpected error during bootstrap: %v", err)
+	}
+
+	identity, err := keys.AuthenticateAPIKey(ctx, bootstrap)
+	if err != nil || !identity.Operator {
+		t.Errorf("expected the bootstrap key to be an operator, got %+v (%v)", identity, err)
+	}
+	created, err := keys.CreateAPIKey(ctx, &request.APIKeyRequest{TenantID: "merchant-a", Scopes: []string{"admin"}})
+	if err != nil {
+		t.Fatalf("unexpected error during key creation: %v", err)
+	}
+	if identity, err = keys.AuthenticateAPIKey(ctx, created.Key); err != nil || identity.Operator {
+		t.Errorf("expected admin keys of other tenants not to be operators, got %+v (%v)", identity, err)
+	}
+}
@@ -0,0 +1,67 @@
+package signeddata
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/signeddata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetFormatter(t *testing.T) {
+	factory := signeddata.NewFormatterFactory()
+
+	formatter, err := factory.GetFormatter("")
+	assert.NoError(t, err)
+	assert.Equal(t, signeddata.DefaultFormat, formatter.Version())
+
+	formatter, err = factory.GetFormatter(signeddata.FormatV2)
+	assert.NoError(t, err)
+	assert.Equal(t, signeddata.FormatV2, formatter.Version())
+
+	_, err = factory.GetFormatter("v99")
+	assert.Error(t, err)
+}
+
+func TestV1Formatter(t *testing.T) {
+	formatter := &signeddata.V1Formatter{}
+
+	signedData, encoding, err := formatter.Format(signeddata.Input{
+		Counter:        3,
+		Data:           "sample-transaction-data",
+		Payload:        []byte("sample-transaction-data"),
+		ChainReference: "c2lnbmF0dXJl",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, signeddata.EncodingUTF8, encoding)
+	assert.Equal(t, "3_sample-transaction-data_c2lnbmF0dXJl", string(signedData))
+
+	signedData, encoding, err = formatter.Format(signeddata.Input{
+		Counter:        3,
+		DataEncoding:   signeddata.EncodingHex,
+		Payload:        []byte{0x01},
+		ChainReference: "c2lnbmF0dXJl",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, signeddata.EncodingBase64, encoding)
+	assert.Equal(t, signeddata.EncodeLengthPrefixed(3, signeddata.ModeData, []byte{0x01}, "c2lnbmF0dXJl"), signedData)
+}
+
+func TestV2Formatter(t *testing.T) {
+	formatter := &signeddata.V2Formatter{}
+
+	signedData, encoding, err := formatter.Format(signeddata.Input{
+		DeviceID:       "123e4567-e89b-12d3-a456-426614174000",
+		Counter:        1,
+		Data:           "<a&b>",
+		Payload:        []byte("<a&b>"),
+		ChainReference: "c2lnbmF0dXJl",
+		Timestamp:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, signeddata.EncodingUTF8, encoding)
+	assert.Equal(t, `{"counter":1,"data":"PGEmYj4=","deviceId":"123e4567-e89b-12d3-a456-426614174000","mode":"data",`+
+		`"previousSignature":"c2lnbmF0dXJl","timestamp":"2024-01-02T03:04:05Z","version":"v2"}`, string(signedData))
+	assert.True(t, json.Valid(signedData))
+}
@@ -0,0 +1,69 @@
+package signeddata
+
+import (
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/signeddata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalizeJSON_RFC8785Example(t *testing.T) {
+	input := `{
+		"numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+		"string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+		"literals": [null, true, false]
+	}`
+
+	canonical, err := signeddata.CanonicalizeJSON([]byte(input))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`, string(canonical))
+}
+
+func TestCanonicalizeJSON_SortsByUTF16CodeUnits(t *testing.T) {
+	input := `{"\u20ac":"Euro Sign","\r":"Carriage Return","\ufb33":"Hebrew Letter Dalet With Dagesh","1":"One",` +
+		`"\ud83d\ude00":"Emoji: Grinning Face","\u0080":"Control","\u00f6":"Latin Small Letter O With Diaeresis"}`
+
+	canonical, err := signeddata.CanonicalizeJSON([]byte(input))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"ö\":\"Latin Small Letter O With Diaeresis\","+
+		"\"\u20ac\":\"Euro Sign\",\"\U0001f600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}", string(canonical))
+}
+
+func TestCanonicalizeJSON_Numbers(t *testing.T) {
+	for input, expected := range map[string]string{
+		"-0":                     "0",
+		"1e21":                   "1e+21",
+		"1e20":                   "100000000000000000000",
+		"0.000001":               "0.000001",
+		"0.0000001":              "1e-7",
+		"-1.5e-10":               "-1.5e-10",
+		"9007199254740993":       "9007199254740992",
+		"5e-324":                 "5e-324",
+		"1.7976931348623157e308": "1.7976931348623157e+308",
+	} {
+		canonical, err := signeddata.CanonicalizeJSON([]byte(input))
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, string(canonical), input)
+	}
+}
+
+func TestCanonicalizeJSON_KeyOrderAndWhitespaceDoNotMatter(t *testing.T) {
+	first, err := signeddata.CanonicalizeJSON([]byte(`{"total": 10.0, "items": [{"sku": "a", "qty": 1}]}`))
+	assert.NoError(t, err)
+	second, err := signeddata.CanonicalizeJSON([]byte(`{"items":[{"qty":1,"sku":"a"}],"total":1e1}`))
+	assert.NoError(t, err)
+	assert.Equal(t, string(first), string(second))
+}
+
+func TestCanonicalizeJSON_Invalid(t *testing.T) {
+	for _, input := range []string{
+		`{"a":1,"a":2}`,
+		`{"a":1} {"b":2}`,
+		`{"a":1e400}`,
+		`{"a":}`,
+		"\"\xff\"",
+	} {
+		_, err := signeddata.CanonicalizeJSON([]byte(input))
+		assert.ErrorIs(t, err, signeddata.ErrInvalidJSON, input)
+	}
+}
@@ -0,0 +1,55 @@
+package signeddata
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/signeddata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodePayload(t *testing.T) {
+	payload, err := signeddata.DecodePayload("a_b", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a_b"), payload)
+
+	payload, err = signeddata.DecodePayload("AP8=", signeddata.EncodingBase64)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0xff}, payload)
+
+	payload, err = signeddata.DecodePayload("00ff", signeddata.EncodingHex)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0xff}, payload)
+}
+
+func TestDecodePayload_Invalid(t *testing.T) {
+	_, err := signeddata.DecodePayload("zz", signeddata.EncodingHex)
+	assert.ErrorIs(t, err, signeddata.ErrMalformedData)
+
+	_, err = signeddata.DecodePayload("data", "latin1")
+	assert.ErrorIs(t, err, signeddata.ErrInvalidEncoding)
+}
+
+func TestValidateMode(t *testing.T) {
+	digest := sha256.Sum256([]byte("transaction"))
+	assert.NoError(t, signeddata.ValidateMode(signeddata.ModeDigest, digest[:]))
+	assert.ErrorIs(t, signeddata.ValidateMode(signeddata.ModeDigest, []byte("short")), signeddata.ErrInvalidDigest)
+	assert.ErrorIs(t, signeddata.ValidateMode("hash", nil), signeddata.ErrInvalidMode)
+}
+
+func TestEncodeLengthPrefixed_Unambiguous(t *testing.T) {
+	// Both values would read "1_a_b_c" in the underscore separated format
+	first := signeddata.EncodeLengthPrefixed(1, signeddata.ModeData, []byte("a_b"), "c")
+	second := signeddata.EncodeLengthPrefixed(1, signeddata.ModeData, []byte("a"), "b_c")
+	assert.NotEqual(t, first, second)
+
+	fields, err := signeddata.DecodeLengthPrefixed(first)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("data"), []byte("a_b"), []byte("c")}, fields)
+}
+
+func TestDecodeLengthPrefixed_Truncated(t *testing.T) {
+	encoded := signeddata.EncodeLengthPrefixed(0, signeddata.ModeData, []byte("payload"), "ref")
+	_, err := signeddata.DecodeLengthPrefixed(encoded[:len(encoded)-1])
+	assert.Error(t, err)
+}
@@ -0,0 +1,138 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a tracer provider recording every span in memory for the duration of a test
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewTracerProvider(sdktrace.WithSyncer(exporter), 1)
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+// attributes returns the attributes of a span by key
+func attributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	values := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes {
+		values[kv.Key] = kv.Value
+	}
+	return values
+}
+
+func TestRepositoryOperationSpans(t *testing.T) {
+	exporter := recordSpans(t)
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+
+	tracing.RepositoryOperation(ctx, "GetDevice", 20*time.Millisecond, nil)
+	tracing.RepositoryOperation(ctx, "GetDevice", time.Millisecond, domain.ErrDeviceNotFound)
+	tracing.RepositoryOperation(ctx, "CreateDevice", time.Millisecond, errors.New("disk full"))
+	parent.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 4)
+	for _, span := range spans[:3] {
+		assert.Equal(t, parent.SpanContext().TraceID(), span.SpanContext.TraceID())
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+	}
+
+	found := spans[0]
+	assert.Equal(t, "DeviceRepository.GetDevice", found.Name)
+	assert.Equal(t, "GetDevice", attributes(found)["db.operation.name"].AsString())
+	assert.InDelta(t, 20*time.Millisecond, found.EndTime.Sub(found.StartTime), float64(time.Millisecond))
+	assert.Equal(t, codes.Unset, found.Status.Code)
+
+	// Domain errors are the expected outcome of bad requests and don't mark the span as failed
+	missing := spans[1]
+	assert.Equal(t, "device_not_found", attributes(missing)["error.type"].AsString())
+	assert.Equal(t, codes.Unset, missing.Status.Code)
+
+	failed := spans[2]
+	assert.Equal(t, codes.Error, failed.Status.Code)
+	assert.Equal(t, "disk full", failed.Status.Description)
+	require.Len(t, failed.Events, 1)
+	assert.Equal(t, "exception", failed.Events[0].Name)
+}
+
+func TestEndRecordsErrors(t *testing.T) {
+	exporter := recordSpans(t)
+	tracer := otel.Tracer("test")
+
+	_, span := tracer.Start(context.Background(), "succeeded")
+	tracing.End(span, nil)
+	_, span = tracer.Start(context.Background(), "failed")
+	tracing.End(span, errors.New("key generation failed"))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, codes.Unset, spans[0].Status.Code)
+	assert.Equal(t, codes.Error, spans[1].Status.Code)
+	serviceName, _ := spans[1].Resource.Set().Value("service.name")
+	assert.Equal(t, tracing.ServiceName, serviceName.AsString())
+}
+
+func TestSetupFileExporter(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	file := filepath.Join(t.TempDir(), "traces.json")
+
+	shutdown, err := tracing.Setup(tracing.Settings{Exporter: tracing.ExporterFile, File: file, SampleRatio: 1})
+	require.NoError(t, err)
+	_, span := otel.Tracer("test").Start(context.Background(), "request")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	content, err := os.ReadFile(file)
+	require.NoError(t, err)
+	var exported struct {
+		Name string
+	}
+	require.NoError(t, json.NewDecoder(strings.NewReader(string(content))).Decode(&exported))
+	assert.Equal(t, "request", exported.Name)
+}
+
+func TestSampleRatioZeroRecordsNoTraces(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewTracerProvider(sdktrace.WithSyncer(exporter), 0)
+	defer provider.Shutdown(context.Background())
+
+	_, span := provider.Tracer("test").Start(context.Background(), "request")
+	span.End()
+	assert.Empty(t, exporter.GetSpans())
+}
+
+func TestSetupExporters(t *testing.T) {
+	shutdown, err := tracing.Setup(tracing.Settings{Exporter: tracing.ExporterNone})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = tracing.Setup(tracing.Settings{Exporter: "jaeger"})
+	assert.Error(t, err)
+
+	_, err = tracing.Setup(tracing.Settings{Exporter: tracing.ExporterFile, File: filepath.Join(t.TempDir(), "missing", "traces.json")})
+	assert.Error(t, err)
+}
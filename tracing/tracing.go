@@ -0,0 +1,134 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/buildinfo"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"os"
+	"time"
+)
+
+// ServiceName is the name of the service in the resource of its spans
+const ServiceName = "signing-service"
+
+// Exporters of the spans
+const (
+	// ExporterNone records no spans; incoming trace context is still propagated
+	ExporterNone = "none"
+	// ExporterStdout writes the spans as JSON to stdout
+	ExporterStdout = "stdout"
+	// ExporterFile appends the spans as JSON lines to a file
+	ExporterFile = "file"
+)
+
+// Settings select the exporter of the spans and how many traces are sampled
+type Settings struct {
+	// Exporter is ExporterNone, ExporterStdout or ExporterFile
+	Exporter string
+	// File is the file ExporterFile appends to
+	File string
+	// SampleRatio is the share of traces started by the service that are recorded, from 0 to 1. Traces
+	// started by a client are recorded if the client sampled them.
+	SampleRatio float64
+}
+
+// repositoryTracer creates the spans of repository operations
+var repositoryTracer = otel.Tracer("github.com/fiskaly/coding-challenges/signing-service-challenge/persistence")
+
+// Setup installs the W3C trace context and baggage propagators and a tracer provider exporting spans as
+// described by the settings. It returns a function flushing the spans that have not been exported yet and
+// stopping the provider, which must be called before the service exits.
+func Setup(settings Settings) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var output io.Writer
+	var file *os.File
+	switch settings.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		output = os.Stdout
+	case ExporterFile:
+		var err error
+		if file, err = os.OpenFile(settings.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600); err != nil {
+			return nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		output = file
+	default:
+		return nil, fmt.Errorf("unsupported trace exporter %q", settings.Exporter)
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(output))
+	if err != nil {
+		return nil, err
+	}
+	provider := NewTracerProvider(sdktrace.WithBatcher(exporter), settings.SampleRatio)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			err = errors.Join(err, file.Close())
+		}
+		return err
+	}, nil
+}
+
+// NewTracerProvider creates a tracer provider for the service that hands its spans to a span processor,
+// e.g. sdktrace.WithBatcher(exporter), and samples the share sampleRatio of the traces the service starts
+func NewTracerProvider(processor sdktrace.TracerProviderOption, sampleRatio float64) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		processor,
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(ServiceName),
+			semconv.ServiceVersion(buildinfo.Version()),
+			attribute.String("vcs.revision", buildinfo.Revision()),
+		)),
+	)
+}
+
+// End ends a span, recording err as its error if it is not nil
+func End(span trace.Span, err error) {
+	recordError(span, err)
+	span.End()
+}
+
+// recordError records the error of a span. Errors of the domain, e.g. a missing device, are the expected
+// outcome of bad requests; only their code is recorded and the span is not marked as failed.
+func recordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	if code := domain.CodeOf(err); code != "" {
+		span.SetAttributes(attribute.String("error.type", code))
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// RepositoryOperation records a repository operation as a span of the trace of ctx that ended now. Its
+// signature matches persistence.OperationObserver.
+func RepositoryOperation(ctx context.Context, operation string, duration time.Duration, err error) {
+	ended := time.Now()
+	_, span := repositoryTracer.Start(ctx, "DeviceRepository."+operation,
+		trace.WithTimestamp(ended.Add(-duration)),
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(semconv.DBOperationName(operation)),
+	)
+	recordError(span, err)
+	span.End(trace.WithTimestamp(ended))
+}